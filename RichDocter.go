@@ -7,13 +7,12 @@ import (
 	ctxkey "RichDocter/ctxkeys"
 	"RichDocter/daos"
 	"RichDocter/models"
+	"RichDocter/payments"
 	"RichDocter/sessions"
 	"context"
 	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -26,13 +25,19 @@ import (
 )
 
 const (
+	entitlementTTL = 5 * time.Minute
 	staticFilesDir = "static/rd-ui/dist"
 	servicePath    = "/api"
 	billingPath    = "/billing"
 	authPath       = "/auth"
+	sharedPath     = "/shared"
 )
 
-var dao daos.DaoInterface
+var (
+	dao           daos.DaoInterface
+	billingClient payments.Client
+	entitlements  *payments.EntitlementCache
+)
 
 func looseMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -43,6 +48,8 @@ func looseMiddleware(next http.Handler) http.Handler {
 		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(time.Second*5))
 		defer cancel()
 		ctx = context.WithValue(ctx, ctxkey.DAO, dao)
+		ctx = context.WithValue(ctx, ctxkey.Billing, billingClient)
+		ctx = context.WithValue(ctx, ctxkey.Entitlements, entitlements)
 		r = r.WithContext(ctx)
 		next.ServeHTTP(w, r)
 	})
@@ -67,6 +74,8 @@ func billingMiddleware(next http.Handler) http.Handler {
 		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(time.Second*5))
 		defer cancel()
 		ctx = context.WithValue(ctx, ctxkey.DAO, dao)
+		ctx = context.WithValue(ctx, ctxkey.Billing, billingClient)
+		ctx = context.WithValue(ctx, ctxkey.Entitlements, entitlements)
 		r = r.WithContext(ctx)
 		next.ServeHTTP(w, r)
 	})
@@ -88,109 +97,15 @@ func accessControlMiddleware(next http.Handler) http.Handler {
 			api.RespondWithError(w, http.StatusBadRequest, err.Error())
 			return
 		}
-		userDetails, err := dao.GetUserDetails(user.Email)
+		// subscription state is kept in step with Stripe by the billing webhook, plan limits are
+		// enforced by api.EntitlementMiddleware
+		entitlement, err := entitlements.Get(user.Email, func() (*models.UserInfo, error) {
+			return dao.GetUserDetails(user.Email)
+		})
 		if err != nil {
 			api.RespondWithError(w, http.StatusBadRequest, err.Error())
 			return
 		}
-		if userDetails.SubscriptionID != "" || userDetails.Expired {
-			isActive, stripeErr := billing.CheckSubscriptionIsActive(*userDetails)
-			if stripeErr != nil {
-				if stripeErr.HTTPStatusCode == http.StatusNotFound {
-					isActive = false
-				}
-			}
-			if !isActive {
-				// blank out the user's previous subscription/customer id
-				user.Expired = true
-				// account is no longer active
-				user.SubscriptionID = ""
-				err = dao.UpdateUser(user)
-				if err != nil {
-					api.RespondWithError(w, http.StatusInternalServerError, err.Error())
-					return
-				}
-
-				storiesCount, err := dao.GetTotalCreatedStories(user.Email)
-				if err != nil {
-					api.RespondWithError(w, http.StatusInternalServerError, err.Error())
-					return
-				}
-				if storiesCount > 1 {
-					// soft delete all but the earliest created story
-					stories, err := dao.GetAllStories(user.Email)
-					if err != nil {
-						api.RespondWithError(w, http.StatusInternalServerError, err.Error())
-						return
-					}
-					go func() {
-						for idx, story := range stories {
-							if idx > 0 {
-								err = dao.SoftDeleteStory(user.Email, story.ID, true)
-								if err != nil {
-									fmt.Println(err.Error())
-								}
-							}
-
-						}
-					}()
-				}
-				// I need to somehow notify the client here
-			} else {
-				suspended, err := dao.CheckForSuspendedStories(user.Email)
-				if err != nil {
-					api.RespondWithError(w, http.StatusInternalServerError, err.Error())
-					return
-				}
-				if suspended {
-					userDetails.Expired = false
-					err = dao.RestoreAutomaticallyDeletedStories(user.Email)
-					if err != nil {
-						api.RespondWithError(w, http.StatusInternalServerError, err.Error())
-						return
-					}
-				}
-			}
-		}
-
-		if userDetails.SubscriptionID == "" {
-			if r.Method == "POST" && (strings.HasSuffix(r.URL.Path, "/stories") ||
-				strings.HasSuffix(r.URL.Path, "/analyze") ||
-				strings.HasSuffix(r.URL.Path, "/propose")) ||
-				r.Method == "PUT" && strings.HasSuffix(r.URL.Path, "/export") {
-
-				stories, err := dao.GetTotalCreatedStories(user.Email)
-				if err != nil {
-					api.RespondWithError(w, http.StatusInternalServerError, err.Error())
-					return
-				}
-				if stories >= 1 {
-					api.RespondWithError(w, http.StatusUnauthorized, "insufficient subscription")
-					return
-				}
-			}
-			if r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/associations") {
-				var storyID string
-				if storyID, err = url.PathUnescape(mux.Vars(r)["story"]); err != nil {
-					api.RespondWithError(w, http.StatusInternalServerError, err.Error())
-					return
-				}
-				storyOrSeriesID := storyID
-				if storyOrSeriesID, err = dao.IsStoryInASeries(user.Email, storyID); err != nil {
-					api.RespondWithError(w, http.StatusInternalServerError, err.Error())
-					return
-				}
-				associations, err := dao.GetStoryOrSeriesAssociationThumbnails(user.Email, storyOrSeriesID, false)
-				if err != nil {
-					api.RespondWithError(w, http.StatusInternalServerError, err.Error())
-					return
-				}
-				if len(associations) >= api.MAX_UNSUBSCRIBED_ASSOCIATION_LIMIT {
-					api.RespondWithError(w, http.StatusUnauthorized, "insufficient subscription")
-					return
-				}
-			}
-		}
 		if err = dao.UpsertUser(user.Email); err != nil {
 			api.RespondWithError(w, http.StatusInternalServerError, err.Error())
 			return
@@ -199,7 +114,9 @@ func accessControlMiddleware(next http.Handler) http.Handler {
 		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(time.Second*5))
 		defer cancel()
 		ctx = context.WithValue(ctx, ctxkey.DAO, dao)
-		ctx = context.WithValue(ctx, ctxkey.IsSuspended, user.Expired)
+		ctx = context.WithValue(ctx, ctxkey.Billing, billingClient)
+		ctx = context.WithValue(ctx, ctxkey.Entitlements, entitlements)
+		ctx = context.WithValue(ctx, ctxkey.IsSuspended, entitlement.Expired)
 		r = r.WithContext(ctx)
 		next.ServeHTTP(w, r)
 	})
@@ -217,6 +134,8 @@ func main() {
 	log.Println("Listening for http on " + port)
 
 	dao = daos.NewDAO()
+	billingClient = payments.NewStripeClient(os.Getenv("STRIPE_SECRET"), os.Getenv("STRIPE_WEBHOOK_SECRET"))
+	entitlements = payments.NewEntitlementCache(payments.NewPlans(billingClient, entitlementTTL), entitlementTTL)
 	auth.New()
 
 	rtr := mux.NewRouter()
@@ -232,17 +151,32 @@ func main() {
 	authRtr.HandleFunc("/{provider}", auth.Login).Methods("GET", "PUT", "OPTIONS")
 	authRtr.HandleFunc("/{provider}/callback", auth.Callback).Methods("POST", "GET", "OPTIONS")
 
+	// Stripe signs its webhook deliveries rather than holding a session
+	rtr.Handle(billingPath+"/webhook", looseMiddleware(http.HandlerFunc(billing.WebhookEndpoint))).Methods("POST", "OPTIONS")
+
 	billingRtr := rtr.PathPrefix(billingPath).Subrouter()
-	billingRtr.Use(billingMiddleware)
+	billingRtr.Use(billingMiddleware, api.IdempotencyMiddleware)
 	billingRtr.HandleFunc("/products", billing.GetProductsEndpoint).Methods("GET", "OPTIONS")
 	billingRtr.HandleFunc("/customer", billing.GetCustomerEndpoint).Methods("GET", "OPTIONS")
 	billingRtr.HandleFunc("/customer", billing.CreateCustomerEndpoint).Methods("POST", "OPTIONS")
 	billingRtr.HandleFunc("/customer", billing.UpdateCustomerPaymentMethodEndpoint).Methods("PUT", "OPTIONS")
 	billingRtr.HandleFunc("/card", billing.CreateCardIntentEndpoint).Methods("POST", "OPTIONS")
 	billingRtr.HandleFunc("/subscribe", billing.SubscribeCustomerEndpoint).Methods("POST", "OPTIONS")
+	billingRtr.HandleFunc("/plan/preview", billing.PreviewPlanChangeEndpoint).Methods("POST", "OPTIONS")
+	billingRtr.HandleFunc("/plan", billing.ChangePlanEndpoint).Methods("PUT", "OPTIONS")
+	billingRtr.HandleFunc("/invoices", billing.ListInvoicesEndpoint).Methods("GET", "OPTIONS")
+	billingRtr.HandleFunc("/promo", billing.ApplyPromotionCodeEndpoint).Methods("POST", "OPTIONS")
+	billingRtr.HandleFunc("/portal", billing.CreatePortalSessionEndpoint).Methods("POST", "OPTIONS")
+
+	// beta readers holding a share link don't have accounts
+	sharedRtr := rtr.PathPrefix(sharedPath).Subrouter()
+	sharedRtr.Use(looseMiddleware)
+	sharedRtr.HandleFunc("/{token}", api.SharedStoryEndPoint).Methods("GET", "OPTIONS")
+	sharedRtr.HandleFunc("/{token}/chapters/{chapterID}/comments", api.SharedChapterCommentsEndPoint).Methods("GET", "OPTIONS")
+	sharedRtr.HandleFunc("/{token}/chapters/{chapterID}/comments", api.CreateSharedCommentEndpoint).Methods("POST", "OPTIONS")
 
 	apiRtr := rtr.PathPrefix(servicePath).Subrouter()
-	apiRtr.Use(accessControlMiddleware)
+	apiRtr.Use(accessControlMiddleware, api.PermissionMiddleware, api.IdempotencyMiddleware, api.EntitlementMiddleware)
 
 	// GETs
 	apiRtr.HandleFunc("/user", api.GetUserData).Methods("GET", "OPTIONS")
@@ -251,17 +185,50 @@ func main() {
 	apiRtr.HandleFunc("/stories/{storyID}/full", api.FullStoryEndPoint).Methods("GET", "OPTIONS")
 	apiRtr.HandleFunc("/stories/{storyID}/content", api.StoryBlocksEndPoint).Methods("GET", "OPTIONS")
 	apiRtr.HandleFunc("/stories/{storyID}/associations/thumbs", api.AllAssociationThumbnailsByStoryEndPoint).Methods("GET", "OPTIONS")
+	apiRtr.HandleFunc("/stories/{storyID}/associations/types", api.AllAssociationTypesEndPoint).Methods("GET", "OPTIONS")
+	apiRtr.HandleFunc("/stories/{storyID}/associations/export", api.ExportAssociationsEndPoint).Methods("GET", "OPTIONS")
 	apiRtr.HandleFunc("/stories/{storyID}/associations/{associationID}", api.AssociationDetailsEndpoint).Methods("GET", "OPTIONS")
+	apiRtr.HandleFunc("/stories/{storyID}/relationships", api.AllRelationshipsByStoryEndPoint).Methods("GET", "OPTIONS")
+	apiRtr.HandleFunc("/stories/{storyID}/shares", api.AllShareLinksByStoryEndPoint).Methods("GET", "OPTIONS")
+	apiRtr.HandleFunc("/stories/{storyID}/relationships/graph", api.RelationshipGraphEndPoint).Methods("GET", "OPTIONS")
 	apiRtr.HandleFunc("/series", api.AllSeriesEndPoint).Methods("GET", "OPTIONS")
 	apiRtr.HandleFunc("/series/{series}", api.SingleSeriesEndPoint).Methods("GET", "OPTIONS")
 	apiRtr.HandleFunc("/series/{series}/volumes", api.AllSeriesVolumesEndPoint).Methods("GET", "OPTIONS")
+	apiRtr.HandleFunc("/series/{series}/associations/{associationID}/evolution", api.AssociationEvolutionEndPoint).Methods("GET", "OPTIONS")
 	apiRtr.HandleFunc("/stories/{storyID}/chapters/{chapterID}", api.ChapterDetailsEndpoint).Methods("GET", "OPTIONS")
+	apiRtr.HandleFunc("/stories/{storyID}/chapters/{chapterID}/comments", api.ChapterCommentsEndPoint).Methods("GET", "OPTIONS")
+	apiRtr.HandleFunc("/stories/{storyID}/chapters/{chapterID}/live", api.LiveChapterEndpoint).Methods("GET", "OPTIONS")
+	apiRtr.HandleFunc("/stories/{storyID}/collaborators", api.CollaboratorsEndPoint).Methods("GET", "OPTIONS")
+	apiRtr.HandleFunc("/series/{series}/collaborators", api.CollaboratorsEndPoint).Methods("GET", "OPTIONS")
+	apiRtr.HandleFunc("/collaborations", api.CollaborationsEndPoint).Methods("GET", "OPTIONS")
+	apiRtr.HandleFunc("/stories/{storyID}/changes", api.ChangesEndPoint).Methods("GET", "OPTIONS")
+	apiRtr.HandleFunc("/series/{series}/changes", api.ChangesEndPoint).Methods("GET", "OPTIONS")
+	apiRtr.HandleFunc("/trash", api.TrashEndPoint).Methods("GET", "OPTIONS")
+	apiRtr.HandleFunc("/templates", api.StoryTemplatesEndPoint).Methods("GET", "OPTIONS")
+	apiRtr.HandleFunc("/user/erasure", api.AccountErasureEndPoint).Methods("GET", "OPTIONS")
+	apiRtr.HandleFunc("/user/entitlements", api.GetUserEntitlementsEndpoint).Methods("GET", "OPTIONS")
 
 	// POSTs
 	apiRtr.HandleFunc("/stories", api.CreateStoryEndpoint).Methods("POST", "OPTIONS")
 	apiRtr.HandleFunc("/stories/{storyID}/chapter", api.CreateStoryChapterEndpoint).Methods("POST", "OPTIONS")
 	apiRtr.HandleFunc("/stories/{storyID}/chapter/{chapterID}/analyze/{type}", api.AnalyzeChapterEndpoint).Methods("POST", "OPTIONS")
 	apiRtr.HandleFunc("/stories/{storyID}/associations", api.CreateAssociationsEndpoint).Methods("POST", "OPTIONS")
+	apiRtr.HandleFunc("/stories/{storyID}/associations/import", api.ImportAssociationsEndpoint).Methods("POST", "OPTIONS")
+	apiRtr.HandleFunc("/stories/{storyID}/shares", api.CreateShareLinkEndpoint).Methods("POST", "OPTIONS")
+	apiRtr.HandleFunc("/stories/{storyID}/chapters/{chapterID}/comments", api.CreateCommentEndpoint).Methods("POST", "OPTIONS")
+	apiRtr.HandleFunc("/associations/transfer", api.TransferAssociationsEndpoint).Methods("POST", "OPTIONS")
+	apiRtr.HandleFunc("/stories/{storyID}/collaborators", api.InviteCollaboratorEndpoint).Methods("POST", "OPTIONS")
+	apiRtr.HandleFunc("/series/{series}/collaborators", api.InviteCollaboratorEndpoint).Methods("POST", "OPTIONS")
+	apiRtr.HandleFunc("/stories/{storyID}/sync", api.SyncStoryEndpoint).Methods("POST", "OPTIONS")
+	apiRtr.HandleFunc("/stories/{storyID}/chapters/{chapterID}/split", api.SplitChapterEndpoint).Methods("POST", "OPTIONS")
+	apiRtr.HandleFunc("/stories/{storyID}/chapters/{chapterID}/merge", api.MergeChaptersEndpoint).Methods("POST", "OPTIONS")
+	apiRtr.HandleFunc("/stories/{storyID}/chapters/{chapterID}/move", api.MoveChapterEndpoint).Methods("POST", "OPTIONS")
+	apiRtr.HandleFunc("/trash/{type}/{id}/restore", api.RestoreFromTrashEndpoint).Methods("POST", "OPTIONS")
+	apiRtr.HandleFunc("/stories/{storyID}/duplicate", api.DuplicateStoryEndpoint).Methods("POST", "OPTIONS")
+	apiRtr.HandleFunc("/series/{series}/duplicate", api.DuplicateSeriesEndpoint).Methods("POST", "OPTIONS")
+	apiRtr.HandleFunc("/stories/{storyID}/template", api.SaveStoryTemplateEndpoint).Methods("POST", "OPTIONS")
+	apiRtr.HandleFunc("/account/export", api.ExportAccountEndpoint).Methods("POST", "OPTIONS")
+	apiRtr.HandleFunc("/account/import", api.ImportAccountEndpoint).Methods("POST", "OPTIONS")
 
 	// PUTs
 	apiRtr.HandleFunc("/stories/{story}", api.WriteBlocksToStoryEndpoint).Methods("PUT", "OPTIONS")
@@ -269,19 +236,40 @@ func main() {
 	apiRtr.HandleFunc("/stories/{story}/orderMap", api.RewriteBlockOrderEndpoint).Methods("PUT", "OPTIONS")
 	apiRtr.HandleFunc("/stories/{story}/associations", api.WriteAssocationsEndpoint).Methods("PUT", "OPTIONS")
 	apiRtr.HandleFunc("/stories/{story}/associations/{association}/upload", api.UploadPortraitEndpoint).Methods("PUT", "OPTIONS")
+	apiRtr.HandleFunc("/stories/{story}/relationships", api.WriteRelationshipsEndpoint).Methods("PUT", "OPTIONS")
+	apiRtr.HandleFunc("/stories/{story}/associations/types", api.WriteAssociationTypeEndpoint).Methods("PUT", "OPTIONS")
+	apiRtr.HandleFunc("/stories/{story}/associations/{association}/override", api.WriteAssociationOverrideEndpoint).Methods("PUT", "OPTIONS")
 	apiRtr.HandleFunc("/stories/{storyID}/chapters", api.UpdateChaptersEndpoint).Methods("PUT", "OPTIONS")
 	apiRtr.HandleFunc("/stories/{storyID}/chapters/{chapterID}", api.EditChapterEndpoint).Methods("PUT", "OPTIONS")
 	apiRtr.HandleFunc("/stories/{storyID}/export", api.ExportStoryEndpoint).Methods("PUT", "OPTIONS")
 	apiRtr.HandleFunc("/series/{seriesID}", api.EditSeriesEndpoint).Methods("PUT", "OPTIONS")
 	apiRtr.HandleFunc("/series/{seriesID}/story/{storyID}", api.RemoveStoryFromSeriesEndpoint).Methods("PUT", "OPTIONS")
+	apiRtr.HandleFunc("/stories/{storyID}/comments/{commentID}/resolve", api.ResolveCommentEndpoint).Methods("PUT", "OPTIONS")
+	apiRtr.HandleFunc("/stories/{story}/owner", api.TransferOwnershipEndpoint).Methods("PUT", "OPTIONS")
+	apiRtr.HandleFunc("/series/{seriesID}/owner", api.TransferOwnershipEndpoint).Methods("PUT", "OPTIONS")
+	apiRtr.HandleFunc("/collaborations/{resourceID}", api.RespondToInvitationEndpoint).Methods("PUT", "OPTIONS")
 	apiRtr.HandleFunc("/user", api.UpdateUserEndpoint).Methods("PUT", "OPTIONS")
 
+	// PATCHes
+	apiRtr.HandleFunc("/stories/{storyID}/chapters/{chapterID}/blocks", api.PatchChapterBlocksEndpoint).Methods("PATCH", "OPTIONS")
+
 	// DELETEs
 	apiRtr.HandleFunc("/stories/{storyID}/block", api.DeleteBlocksFromStoryEndpoint).Methods("DELETE", "OPTIONS")
 	apiRtr.HandleFunc("/stories/{story}/associations", api.DeleteAssociationsEndpoint).Methods("DELETE", "OPTIONS")
+	apiRtr.HandleFunc("/stories/{story}/relationships", api.DeleteRelationshipsEndpoint).Methods("DELETE", "OPTIONS")
+	apiRtr.HandleFunc("/stories/{story}/associations/types/{typeName}", api.DeleteAssociationTypeEndpoint).Methods("DELETE", "OPTIONS")
+	apiRtr.HandleFunc("/stories/{story}/associations/{association}/override", api.DeleteAssociationOverrideEndpoint).Methods("DELETE", "OPTIONS")
+	apiRtr.HandleFunc("/stories/{story}/shares/{token}", api.DeleteShareLinkEndpoint).Methods("DELETE", "OPTIONS")
+	apiRtr.HandleFunc("/stories/{story}/comments/{commentID}", api.DeleteCommentEndpoint).Methods("DELETE", "OPTIONS")
+	apiRtr.HandleFunc("/stories/{story}/collaborators/{email}", api.RemoveCollaboratorEndpoint).Methods("DELETE", "OPTIONS")
+	apiRtr.HandleFunc("/series/{seriesID}/collaborators/{email}", api.RemoveCollaboratorEndpoint).Methods("DELETE", "OPTIONS")
 	apiRtr.HandleFunc("/stories/{storyID}/chapter/{chapterID}", api.DeleteChaptersEndpoint).Methods("DELETE", "OPTIONS")
 	apiRtr.HandleFunc("/stories/{story}", api.DeleteStoryEndpoint).Methods("DELETE", "OPTIONS")
 	apiRtr.HandleFunc("/series/{seriesID}", api.DeleteSeriesEndpoint).Methods("DELETE", "OPTIONS")
+	apiRtr.HandleFunc("/trash/{type}/{id}", api.PurgeFromTrashEndpoint).Methods("DELETE", "OPTIONS")
+	apiRtr.HandleFunc("/templates/{templateID}", api.DeleteStoryTemplateEndpoint).Methods("DELETE", "OPTIONS")
+	apiRtr.HandleFunc("/user", api.DeleteUserEndpoint).Methods("DELETE", "OPTIONS")
+	apiRtr.HandleFunc("/user/erasure", api.CancelAccountErasureEndpoint).Methods("DELETE", "OPTIONS")
 
 	rtr.PathPrefix("/").Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Build the absolute path to the requested file.
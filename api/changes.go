@@ -0,0 +1,160 @@
+package api
+
+import (
+	"RichDocter/daos"
+	"RichDocter/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// recordChanges appends to a story's change log once a write has been saved. The write can't be
+// undone at that point, so a failure is logged rather than reported to the client.
+func recordChanges(dao daos.DaoInterface, storyOrSeriesID, author string, changes []*models.StoryChange) {
+	for _, change := range changes {
+		change.Author = author
+	}
+	if err := dao.RecordChanges(storyOrSeriesID, changes); err != nil {
+		fmt.Println("unable to record changes for", storyOrSeriesID, err)
+	}
+}
+
+func blockChanges(action, chapterID, opID string, blocks []models.StoryBlock) []*models.StoryChange {
+	changes := make([]*models.StoryChange, 0, len(blocks))
+	for _, block := range blocks {
+		change := &models.StoryChange{
+			Entity:    models.ChangeEntityBlock,
+			Action:    action,
+			EntityID:  block.KeyID,
+			ChapterID: chapterID,
+			OpID:      opID,
+		}
+		switch action {
+		case models.ChangeUpsert:
+			change.Version = block.Version
+			change.Data, _ = json.Marshal(block)
+		case models.ChangeReorder:
+			change.Data, _ = json.Marshal(models.StoryBlock{KeyID: block.KeyID, Place: block.Place, Rank: block.Rank})
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+func chapterChange(action, opID string, chapter models.Chapter) *models.StoryChange {
+	change := &models.StoryChange{
+		Entity:    models.ChangeEntityChapter,
+		Action:    action,
+		EntityID:  chapter.ID,
+		ChapterID: chapter.ID,
+		OpID:      opID,
+	}
+	if action == models.ChangeUpsert {
+		change.Version = chapter.Version
+		change.Data, _ = json.Marshal(chapter)
+	}
+	return change
+}
+
+// associationChanges only notes which associations changed, clients fetch the details they need
+func associationChanges(action string, associations []*models.Association) []*models.StoryChange {
+	changes := make([]*models.StoryChange, 0, len(associations))
+	for _, association := range associations {
+		changes = append(changes, &models.StoryChange{
+			Entity:   models.ChangeEntityAssociation,
+			Action:   action,
+			EntityID: association.ID,
+		})
+	}
+	return changes
+}
+
+// applySyncOperations replays operations a client queued while offline, in order. Each one succeeds
+// or fails on its own; stale ones are reported with the stored copy so the client can reconcile.
+func applySyncOperations(dao daos.DaoInterface, owner, author, storyID string, operations []models.SyncOperation) ([]models.SyncApplied, []models.SyncConflict) {
+	applied := []models.SyncApplied{}
+	conflicts := []models.SyncConflict{}
+	chapterStories := make(map[string]string)
+	chapterInStory := func(chapterID string) bool {
+		if _, ok := chapterStories[chapterID]; !ok {
+			if chapter, err := dao.GetChapterByID(chapterID); err == nil {
+				chapterStories[chapterID] = chapter.StoryID
+			} else {
+				chapterStories[chapterID] = ""
+			}
+		}
+		return chapterStories[chapterID] == storyID
+	}
+
+	for _, op := range operations {
+		var (
+			version int64
+			changes []*models.StoryChange
+			current interface{}
+			err     error
+		)
+		switch {
+		case op.Entity == models.ChangeEntityBlock && op.Block != nil && op.ChapterID != "":
+			if !chapterInStory(op.ChapterID) {
+				err = fmt.Errorf("chapter %s does not belong to story %s", op.ChapterID, storyID)
+				break
+			}
+			storyBlocks := &models.StoryBlocks{StoryID: storyID, ChapterID: op.ChapterID, Blocks: []models.StoryBlock{*op.Block}}
+			switch op.Action {
+			case models.ChangeUpsert:
+				err = dao.WriteBlocks(storyID, storyBlocks)
+			case models.ChangeDelete:
+				err = dao.DeleteChapterParagraphs(storyID, storyBlocks)
+			default:
+				err = fmt.Errorf("unknown action: %s", op.Action)
+			}
+			if errors.Is(err, daos.ErrVersionConflict) {
+				current, _ = dao.GetBlocksByKeys(storyID, op.ChapterID, []string{op.Block.KeyID})
+			}
+			if err == nil {
+				version = storyBlocks.Blocks[0].Version
+				changes = blockChanges(op.Action, op.ChapterID, op.OpID, storyBlocks.Blocks)
+			}
+		case op.Entity == models.ChangeEntityChapter && op.Chapter != nil:
+			chapter := *op.Chapter
+			switch op.Action {
+			case models.ChangeUpsert:
+				if chapterInStory(chapter.ID) {
+					chapter, err = dao.EditChapter(storyID, chapter)
+				} else if chapterStories[chapter.ID] == "" && chapter.Version == 0 {
+					// written offline, the chapter doesn't exist yet
+					if chapter, err = dao.CreateChapter(storyID, chapter, owner); err == nil {
+						chapterStories[chapter.ID] = storyID
+					}
+				} else {
+					err = fmt.Errorf("chapter %s does not belong to story %s", chapter.ID, storyID)
+				}
+			case models.ChangeDelete:
+				if !chapterInStory(chapter.ID) {
+					err = fmt.Errorf("chapter %s does not belong to story %s", chapter.ID, storyID)
+					break
+				}
+				err = dao.DeleteChapters(owner, storyID, []models.Chapter{chapter})
+			default:
+				err = fmt.Errorf("unknown action: %s", op.Action)
+			}
+			if errors.Is(err, daos.ErrVersionConflict) {
+				current, _ = dao.GetChapterByID(chapter.ID)
+			}
+			if err == nil {
+				version = chapter.Version
+				changes = []*models.StoryChange{chapterChange(op.Action, op.OpID, chapter)}
+			}
+		default:
+			err = fmt.Errorf("operations must be a block with its chapter_id or a chapter")
+		}
+
+		if err != nil {
+			conflicts = append(conflicts, models.SyncConflict{OpID: op.OpID, Error: err.Error(), Current: current})
+			continue
+		}
+		recordChanges(dao, storyID, author, changes)
+		applied = append(applied, models.SyncApplied{OpID: op.OpID, Version: version})
+	}
+	return applied, conflicts
+}
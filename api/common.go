@@ -14,27 +14,21 @@ import (
 	"image/png"
 	"io"
 	"net/http"
-	"os"
 
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/aws/smithy-go"
 	"github.com/nfnt/resize"
-	stripe "github.com/stripe/stripe-go/v72"
-	"github.com/stripe/stripe-go/v72/customer"
-	"github.com/stripe/stripe-go/v72/paymentmethod"
-	"github.com/stripe/stripe-go/v72/sub"
 )
 
 const (
-	associationTypeCharacter           = "character"
-	associationTypePlace               = "place"
-	associationTypeEvent               = "event"
-	S3_CUSTOM_PORTRAIT_BUCKET          = "richdocter-custom-portraits"
-	S3_EXPORTS_BUCKET                  = "richdocter-document-exports"
-	S3_STORY_IMAGE_BUCKET              = "richdocter-story-portraits"
-	S3_SERIES_IMAGE_BUCKET             = "richdocter-series-portraits"
-	TMP_EXPORT_DIR                     = "./tmp"
-	MAX_UNSUBSCRIBED_ASSOCIATION_LIMIT = 10
+	associationTypeCharacter  = "character"
+	associationTypePlace      = "place"
+	associationTypeEvent      = "event"
+	S3_CUSTOM_PORTRAIT_BUCKET = "richdocter-custom-portraits"
+	S3_EXPORTS_BUCKET         = "richdocter-document-exports"
+	S3_STORY_IMAGE_BUCKET     = "richdocter-story-portraits"
+	S3_SERIES_IMAGE_BUCKET    = "richdocter-series-portraits"
+	TMP_EXPORT_DIR            = "./tmp"
 )
 
 func getUserEmail(r *http.Request) (string, error) {
@@ -85,6 +79,13 @@ func processAWSError(opErr *smithy.OperationError) (err models.AwsStatusResponse
 		return
 	}
 
+	var mismatchErr *types.IdempotentParameterMismatchException
+	if errors.As(opErr.Unwrap(), &mismatchErr) {
+		err.Message = "Idempotency-Key was already used for a different request"
+		err.Code = http.StatusUnprocessableEntity
+		return
+	}
+
 	var txnErr *types.TransactionCanceledException
 	if errors.As(opErr.Unwrap(), &txnErr) && txnErr.CancellationReasons != nil {
 		for _, reason := range txnErr.CancellationReasons {
@@ -110,60 +111,25 @@ func processAWSError(opErr *smithy.OperationError) (err models.AwsStatusResponse
 	return err
 }
 
-func createSubscription(customerID string, priceID string, paymentMethodID string) (*stripe.Subscription, error) {
-	stripe.Key = os.Getenv("STRIPE_SECRET")
-	if stripe.Key == "" {
-		return &stripe.Subscription{}, fmt.Errorf("missing stripe secret")
-	}
-	subscriptionParams := &stripe.SubscriptionParams{
-		Customer: &customerID,
-		Items: []*stripe.SubscriptionItemsParams{
-			{
-				Plan: &priceID,
-			},
-		},
-		DefaultPaymentMethod: &paymentMethodID,
-	}
-	return sub.New(subscriptionParams)
-}
-
-func getPaymentMethodsForCustomer(customerID string) ([]models.PaymentMethod, error) {
-	stripe.Key = os.Getenv("STRIPE_SECRET")
-	if stripe.Key == "" {
-		return nil, fmt.Errorf("missing stripe secret")
-	}
-
-	c, err := customer.Get(customerID, nil)
-	if err != nil {
-		return nil, err
-	}
-	var defaultPaymentMethodID *string
-	if c.InvoiceSettings.DefaultPaymentMethod != nil {
-		defaultPaymentMethodID = &c.InvoiceSettings.DefaultPaymentMethod.ID
-	}
-	listParams := &stripe.PaymentMethodListParams{
-		Customer: stripe.String(customerID),
-		Type:     stripe.String("card"), // Filter to retrieve only card payment methods
-	}
-
-	// List payment methods associated with the customer
-	iter := paymentmethod.List(listParams)
-
-	var methods []models.PaymentMethod
-	for iter.Next() {
-		pm := iter.PaymentMethod()
-		localPM := models.PaymentMethod{}
-		localPM.Id = pm.ID
-		localPM.Brand = pm.Card.Brand
-		localPM.LastFour = pm.Card.Last4
-		localPM.ExpirationMonth = pm.Card.ExpMonth
-		localPM.ExpirationYear = pm.Card.ExpYear
-		if defaultPaymentMethodID != nil && *defaultPaymentMethodID == pm.ID {
-			localPM.IsDefault = true
+// respondWithShareLinkError answers unauthenticated share link requests without leaking storage errors
+func respondWithShareLinkError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, daos.ErrShareLinkNotFound):
+		RespondWithError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, daos.ErrShareLinkExpired):
+		RespondWithError(w, http.StatusGone, err.Error())
+	case errors.Is(err, daos.ErrShareLinkBadPassword):
+		RespondWithError(w, http.StatusUnauthorized, err.Error())
+	case errors.Is(err, daos.ErrShareLinkCommentsDisabled):
+		RespondWithError(w, http.StatusForbidden, err.Error())
+	default:
+		var opErr *smithy.OperationError
+		if errors.As(err, &opErr) {
+			RespondWithError(w, http.StatusInternalServerError, "unable to process share link request")
+			return
 		}
-		methods = append(methods, localPM)
+		RespondWithError(w, http.StatusBadRequest, err.Error())
 	}
-	return methods, nil
 }
 
 func staggeredStoryBlockRetrieval(dao daos.DaoInterface, storyID string, chapterID string, key *map[string]types.AttributeValue, accumulatedBlocks *models.BlocksData) (*models.BlocksData, error) {
@@ -217,19 +183,19 @@ func scaleDownImage(file io.Reader, maxWidth uint) (*bytes.Buffer, string, error
 	return buf, format, err
 }
 
-func cancelSubscription(subscriptionID string) error {
-	stripe.Key = os.Getenv("STRIPE_SECRET")
-	if stripe.Key == "" {
-		return fmt.Errorf("missing stripe secret")
-	}
-	cancel := true
-	subscriptionParams := &stripe.SubscriptionParams{
-		CancelAtPeriodEnd: &cancel,
-	}
+// respondWithConflict answers a write made against a stale version with the copy currently stored,
+// so the client can merge or reapply its change
+func respondWithConflict(w http.ResponseWriter, current interface{}) {
+	RespondWithJson(w, http.StatusConflict, map[string]interface{}{
+		"error":   daos.ErrVersionConflict.Error(),
+		"current": current,
+	})
+}
 
-	_, err := sub.Update(subscriptionID, subscriptionParams)
-	if err != nil {
-		return err
+func storyBlockKeys(blocks []models.StoryBlock) []string {
+	keys := make([]string, 0, len(blocks))
+	for _, block := range blocks {
+		keys = append(keys, block.KeyID)
 	}
-	return nil
+	return keys
 }
@@ -5,8 +5,10 @@ import (
 	"RichDocter/daos"
 	"RichDocter/models"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/aws/smithy-go"
 	"github.com/gorilla/mux"
@@ -39,6 +41,11 @@ func DeleteBlocksFromStoryEndpoint(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if err = dao.DeleteChapterParagraphs(storyID, &storyBlocks); err != nil {
+		if errors.Is(err, daos.ErrVersionConflict) {
+			current, _ := dao.GetBlocksByKeys(storyID, storyBlocks.ChapterID, storyBlockKeys(storyBlocks.Blocks))
+			respondWithConflict(w, current)
+			return
+		}
 		if opErr, ok := err.(*smithy.OperationError); ok {
 			awsResponse := processAWSError(opErr)
 			if awsResponse.Code == 0 {
@@ -51,6 +58,8 @@ func DeleteBlocksFromStoryEndpoint(w http.ResponseWriter, r *http.Request) {
 		RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	author, _ := getUserEmail(r)
+	recordChanges(dao, storyID, author, blockChanges(models.ChangeDelete, storyBlocks.ChapterID, "", storyBlocks.Blocks))
 	RespondWithJson(w, http.StatusOK, nil)
 }
 
@@ -62,7 +71,7 @@ func DeleteAssociationsEndpoint(w http.ResponseWriter, r *http.Request) {
 		dao     daos.DaoInterface
 		ok      bool
 	)
-	if email, err = getUserEmail(r); err != nil {
+	if email, err = getOwnerEmail(r); err != nil {
 		RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -99,17 +108,30 @@ func DeleteAssociationsEndpoint(w http.ResponseWriter, r *http.Request) {
 		RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	// associations of a volume live on its series, and so do their changes
+	if storyOrSeriesID, err := dao.IsStoryInASeries(email, storyID); err == nil {
+		if storyOrSeriesID == "" {
+			storyOrSeriesID = storyID
+		}
+		author, _ := getUserEmail(r)
+		recordChanges(dao, storyOrSeriesID, author, associationChanges(models.ChangeDelete, associations))
+	}
 	RespondWithJson(w, http.StatusOK, nil)
 }
 
 func DeleteChaptersEndpoint(w http.ResponseWriter, r *http.Request) {
 	var (
+		email     string
 		err       error
 		storyID   string
 		chapterID string
 		dao       daos.DaoInterface
 		ok        bool
 	)
+	if email, err = getOwnerEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
 	if storyID, err = url.PathUnescape(mux.Vars(r)["storyID"]); err != nil {
 		RespondWithError(w, http.StatusInternalServerError, "Error parsing story ID")
 		return
@@ -135,7 +157,7 @@ func DeleteChaptersEndpoint(w http.ResponseWriter, r *http.Request) {
 	chapter := models.Chapter{}
 	chapter.ID = chapterID
 	chapters = append(chapters, chapter)
-	if err = dao.DeleteChapters(storyID, chapters); err != nil {
+	if err = dao.DeleteChapters(email, storyID, chapters); err != nil {
 		if opErr, ok := err.(*smithy.OperationError); ok {
 			awsResponse := processAWSError(opErr)
 			if awsResponse.Code == 0 {
@@ -148,6 +170,8 @@ func DeleteChaptersEndpoint(w http.ResponseWriter, r *http.Request) {
 		RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	author, _ := getUserEmail(r)
+	recordChanges(dao, storyID, author, []*models.StoryChange{chapterChange(models.ChangeDelete, "", chapter)})
 	RespondWithJson(w, http.StatusOK, nil)
 }
 
@@ -159,7 +183,7 @@ func DeleteStoryEndpoint(w http.ResponseWriter, r *http.Request) {
 		dao     daos.DaoInterface
 		ok      bool
 	)
-	if email, err = getUserEmail(r); err != nil {
+	if email, err = getOwnerEmail(r); err != nil {
 		RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -201,7 +225,7 @@ func DeleteSeriesEndpoint(w http.ResponseWriter, r *http.Request) {
 		ok       bool
 		series   *models.Series
 	)
-	if email, err = getUserEmail(r); err != nil {
+	if email, err = getOwnerEmail(r); err != nil {
 		RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -239,3 +263,304 @@ func DeleteSeriesEndpoint(w http.ResponseWriter, r *http.Request) {
 	}
 	RespondWithJson(w, http.StatusOK, nil)
 }
+
+func DeleteRelationshipsEndpoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email   string
+		err     error
+		storyID string
+		dao     daos.DaoInterface
+		ok      bool
+	)
+	if email, err = getOwnerEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if storyID, err = url.PathUnescape(mux.Vars(r)["story"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing story name")
+		return
+	}
+	if storyID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing story ID")
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	relationships := []*models.AssociationRelationship{}
+	if err := decoder.Decode(&relationships); err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	var storyOrSeriesID string
+	if storyOrSeriesID, err = dao.IsStoryInASeries(email, storyID); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "unable to check series membership of story")
+		return
+	}
+	if storyOrSeriesID == "" {
+		storyOrSeriesID = storyID
+	}
+	if err = dao.DeleteAssociationRelationships(email, storyOrSeriesID, relationships); err != nil {
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			awsResponse := processAWSError(opErr)
+			if awsResponse.Code == 0 {
+				RespondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			RespondWithError(w, awsResponse.Code, awsResponse.Message)
+			return
+		}
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	RespondWithJson(w, http.StatusOK, nil)
+}
+
+func DeleteAssociationTypeEndpoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email    string
+		err      error
+		storyID  string
+		typeName string
+		dao      daos.DaoInterface
+		ok       bool
+	)
+	if email, err = getOwnerEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if storyID, err = url.PathUnescape(mux.Vars(r)["story"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing story name")
+		return
+	}
+	if storyID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing story ID")
+		return
+	}
+	if typeName, err = url.PathUnescape(mux.Vars(r)["typeName"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing association type")
+		return
+	}
+	if typeName == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing association type")
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	var storyOrSeriesID string
+	if storyOrSeriesID, err = dao.IsStoryInASeries(email, storyID); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "unable to check series membership of story")
+		return
+	}
+	if storyOrSeriesID == "" {
+		storyOrSeriesID = storyID
+	}
+	if err = dao.DeleteAssociationType(email, storyOrSeriesID, typeName); err != nil {
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			awsResponse := processAWSError(opErr)
+			if awsResponse.Code == 0 {
+				RespondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			RespondWithError(w, awsResponse.Code, awsResponse.Message)
+			return
+		}
+		RespondWithError(w, http.StatusConflict, err.Error())
+		return
+	}
+	RespondWithJson(w, http.StatusOK, nil)
+}
+
+func DeleteAssociationOverrideEndpoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email         string
+		err           error
+		storyID       string
+		associationID string
+		dao           daos.DaoInterface
+		ok            bool
+	)
+	if email, err = getOwnerEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if storyID, err = url.PathUnescape(mux.Vars(r)["story"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing story name")
+		return
+	}
+	if storyID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing story ID")
+		return
+	}
+	if associationID, err = url.PathUnescape(mux.Vars(r)["association"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing association name")
+		return
+	}
+	if associationID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing association ID")
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	if err = dao.DeleteAssociationOverride(email, storyID, associationID); err != nil {
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			awsResponse := processAWSError(opErr)
+			if awsResponse.Code == 0 {
+				RespondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			RespondWithError(w, awsResponse.Code, awsResponse.Message)
+			return
+		}
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	RespondWithJson(w, http.StatusOK, nil)
+}
+
+func DeleteShareLinkEndpoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email string
+		err   error
+		token string
+		dao   daos.DaoInterface
+		ok    bool
+	)
+	if email, err = getOwnerEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if token, err = url.PathUnescape(mux.Vars(r)["token"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing share token")
+		return
+	}
+	if token == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing share token")
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	if err = dao.DeleteShareLink(email, token); err != nil {
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			awsResponse := processAWSError(opErr)
+			if awsResponse.Code == 0 {
+				RespondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			RespondWithError(w, awsResponse.Code, awsResponse.Message)
+			return
+		}
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	RespondWithJson(w, http.StatusOK, nil)
+}
+
+func DeleteCommentEndpoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email     string
+		err       error
+		storyID   string
+		commentID string
+		dao       daos.DaoInterface
+		ok        bool
+	)
+	if email, err = getOwnerEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if storyID, err = url.PathUnescape(mux.Vars(r)["story"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing story ID")
+		return
+	}
+	if commentID, err = url.PathUnescape(mux.Vars(r)["commentID"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing comment ID")
+		return
+	}
+	if storyID == "" || commentID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing story or comment ID")
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	if err = dao.DeleteComment(email, storyID, commentID); err != nil {
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			awsResponse := processAWSError(opErr)
+			if awsResponse.Code == 0 {
+				RespondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			RespondWithError(w, awsResponse.Code, awsResponse.Message)
+			return
+		}
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	RespondWithJson(w, http.StatusOK, nil)
+}
+
+// RemoveCollaboratorEndpoint lets the owner remove a collaborator, or a collaborator leave
+func RemoveCollaboratorEndpoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email           string
+		owner           string
+		err             error
+		storyOrSeriesID string
+		collaborator    string
+		dao             daos.DaoInterface
+		ok              bool
+	)
+	if email, err = getUserEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if owner, err = getOwnerEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if storyOrSeriesID, err = resourceIDFromVars(mux.Vars(r)); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing story or series ID")
+		return
+	}
+	if collaborator, err = url.PathUnescape(mux.Vars(r)["email"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing collaborator email")
+		return
+	}
+	if storyOrSeriesID == "" || collaborator == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing story or series ID or collaborator email")
+		return
+	}
+	if role, _ := r.Context().Value(ctxkey.Role).(models.CollaboratorRole); role != models.RoleOwner && !strings.EqualFold(email, collaborator) {
+		RespondWithError(w, http.StatusForbidden, errForbidden.Error())
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	if err = dao.RemoveCollaborator(owner, storyOrSeriesID, collaborator); err != nil {
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			awsResponse := processAWSError(opErr)
+			if awsResponse.Code == 0 {
+				RespondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			RespondWithError(w, awsResponse.Code, awsResponse.Message)
+			return
+		}
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	RespondWithJson(w, http.StatusOK, nil)
+}
@@ -0,0 +1,481 @@
+package api
+
+import (
+	ctxkey "RichDocter/ctxkeys"
+	"RichDocter/daos"
+	"RichDocter/models"
+	"RichDocter/payments"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/gorilla/mux"
+)
+
+// plan limits, named as they are reported to the client
+const (
+	limitStories       = "stories"
+	limitAssociations  = "associations"
+	limitAICalls       = "ai_calls"
+	limitExportFormats = "export_formats"
+	limitCollaborators = "collaborators"
+	limitStorage       = "storage_bytes"
+)
+
+// routeLimits lists the routes that draw on a plan limit. Uploads draw on storage whatever the route.
+// The stories and associations limits are checked here for room for one more, handlers that add
+// several at once, or that only add some of what they write, check the rest with haveRoom.
+var routeLimits = []struct {
+	method string
+	suffix string
+	limit  string
+}{
+	{"POST", "/api/stories", limitStories},
+	{"POST", "/duplicate", limitStories},
+	{"POST", "/account/import", limitStories},
+	{"POST", "/trash/{type}/{id}/restore", limitStories},
+	{"POST", "/associations", limitAssociations},
+	{"POST", "/associations/import", limitAssociations},
+	{"POST", "/analyze/{type}", limitAICalls},
+	{"PUT", "/export", limitExportFormats},
+	{"POST", "/collaborators", limitCollaborators},
+}
+
+// limitReachedError is returned when a request would take an account past its plan
+type limitReachedError struct {
+	limit string
+}
+
+func (e *limitReachedError) Error() string {
+	return "insufficient subscription"
+}
+
+func routeLimit(method, pathTemplate string) string {
+	for _, rule := range routeLimits {
+		if rule.method == method && strings.HasSuffix(pathTemplate, rule.suffix) {
+			return rule.limit
+		}
+	}
+	return ""
+}
+
+// EntitlementMiddleware holds each request to the plan of the account that owns the story or series
+// it acts on, or of the signed in user on routes without one.
+func EntitlementMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var limit string
+		if route := mux.CurrentRoute(r); route != nil {
+			if template, err := route.GetPathTemplate(); err == nil {
+				limit = routeLimit(r.Method, template)
+			}
+		}
+		upload := strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data")
+		if limit == "" && !upload {
+			next.ServeHTTP(w, r)
+			return
+		}
+		owner, err := getOwnerEmail(r)
+		if err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		dao, ok := r.Context().Value(ctxkey.DAO).(daos.DaoInterface)
+		if !ok {
+			RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+			return
+		}
+		entitlement, err := getEntitlement(r, dao, owner)
+		if err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if limit != "" {
+			err = checkLimit(r, dao, owner, entitlement.Limits, limit)
+		}
+		if err == nil && upload {
+			err = checkStorage(r, dao, owner, entitlement.Limits)
+		}
+		if err != nil {
+			respondWithEntitlementError(w, entitlement, err)
+			return
+		}
+		if limit != limitAICalls {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// the call is counted before it runs so concurrent calls can't overrun the allowance, and
+		// handed back if it doesn't succeed
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		if rec.status < 200 || rec.status >= 300 {
+			if err = dao.RefundAICall(owner, aiCallMonth()); err != nil {
+				fmt.Println("unable to refund AI call", err)
+			}
+		}
+	})
+}
+
+// statusRecorder notes the status of the response on its way to the client
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// getEntitlement returns what a user's plan lets them do, through the entitlement cache
+func getEntitlement(r *http.Request, dao daos.DaoInterface, email string) (models.Entitlement, error) {
+	entitlements, ok := r.Context().Value(ctxkey.Entitlements).(*payments.EntitlementCache)
+	if !ok {
+		return models.Entitlement{}, errors.New("unable to parse or retrieve entitlements from context")
+	}
+	return entitlements.Get(email, func() (*models.UserInfo, error) {
+		return dao.GetUserDetails(email)
+	})
+}
+
+func checkLimit(r *http.Request, dao daos.DaoInterface, owner string, limits models.Limits, limit string) error {
+	switch limit {
+	case limitStories:
+		if strings.Contains(r.URL.Path, "/trash/") {
+			// only restoring a story or series brings a story back
+			itemType := mux.Vars(r)["type"]
+			if itemType != models.TrashStory && itemType != models.TrashSeries {
+				return nil
+			}
+		}
+		return storyRoom(dao, owner, limits, 1)
+	case limitAssociations:
+		if limits.Associations == models.Unlimited {
+			return nil
+		}
+		storyID, err := resourceIDFromVars(mux.Vars(r))
+		if err != nil {
+			return err
+		}
+		// associations belong to the series when the story is part of one
+		storyOrSeriesID, err := dao.IsStoryInASeries(owner, storyID)
+		if err != nil {
+			return err
+		}
+		if storyOrSeriesID == "" {
+			storyOrSeriesID = storyID
+		}
+		return associationRoom(dao, owner, storyOrSeriesID, limits, []string{""})
+	case limitAICalls:
+		if err := dao.RecordAICall(owner, aiCallMonth(), limits.AICalls); err != nil {
+			if errors.Is(err, daos.ErrAICallLimitReached) {
+				return &limitReachedError{limit}
+			}
+			return err
+		}
+	case limitExportFormats:
+		format := r.URL.Query().Get("type")
+		for _, allowed := range limits.ExportFormats {
+			if allowed == format {
+				return nil
+			}
+		}
+		return &limitReachedError{limit}
+	case limitCollaborators:
+		if limits.Collaborators == models.Unlimited {
+			return nil
+		}
+		resourceID, err := resourceIDFromVars(mux.Vars(r))
+		if err != nil {
+			return err
+		}
+		collaborators, err := dao.GetCollaborators(owner, resourceID)
+		if err != nil {
+			return err
+		}
+		if len(collaborators) >= limits.Collaborators {
+			return &limitReachedError{limit}
+		}
+	}
+	return nil
+}
+
+// storyRoom returns a limitReachedError if adding stories would take the account past its plan
+func storyRoom(dao daos.DaoInterface, owner string, limits models.Limits, adding int) error {
+	if limits.Stories == models.Unlimited {
+		return nil
+	}
+	stories, err := dao.GetTotalCreatedStories(owner)
+	if err != nil {
+		return err
+	}
+	if stories+adding > limits.Stories {
+		return &limitReachedError{limitStories}
+	}
+	return nil
+}
+
+// associationRoom returns a limitReachedError if writing the associations with the given ids would take
+// the story or series past its plan. Ids it already has don't count, an empty id is always a new one.
+func associationRoom(dao daos.DaoInterface, owner, storyOrSeriesID string, limits models.Limits, ids []string) error {
+	if limits.Associations == models.Unlimited {
+		return nil
+	}
+	associations, err := dao.GetStoryOrSeriesAssociationThumbnails(owner, storyOrSeriesID, false)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool, len(associations))
+	for _, association := range associations {
+		existing[association.ID] = true
+	}
+	adding := 0
+	for _, id := range ids {
+		if id == "" || !existing[id] {
+			adding++
+		}
+	}
+	if len(associations)+adding > limits.Associations {
+		return &limitReachedError{limitAssociations}
+	}
+	return nil
+}
+
+func associationIDs(associations []*models.Association) []string {
+	ids := make([]string, 0, len(associations))
+	for _, association := range associations {
+		ids = append(ids, association.ID)
+	}
+	return ids
+}
+
+// haveRoom holds a handler to the owner's plan once it knows how much the request adds, responding
+// with the limit reached when room fails
+func haveRoom(w http.ResponseWriter, r *http.Request, dao daos.DaoInterface, owner string, room func(limits models.Limits) error) bool {
+	entitlement, err := getEntitlement(r, dao, owner)
+	if err == nil {
+		err = room(entitlement.Limits)
+	}
+	if err != nil {
+		respondWithEntitlementError(w, entitlement, err)
+		return false
+	}
+	return true
+}
+
+// checkStorage refuses an upload that could take the account past its storage allowance. The whole
+// request body is counted, as images are only scaled down once received.
+func checkStorage(r *http.Request, dao daos.DaoInterface, owner string, limits models.Limits) error {
+	if limits.StorageBytes == models.Unlimited {
+		return nil
+	}
+	used, err := storageUsed(dao, owner)
+	if err != nil {
+		return err
+	}
+	incoming := r.ContentLength
+	if incoming < 0 {
+		incoming = 0
+	}
+	if used+incoming > limits.StorageBytes {
+		return &limitReachedError{limitStorage}
+	}
+	return nil
+}
+
+// storageUsed returns the running total of the images the account has uploaded, adding up what it
+// uploaded before the total was kept the first time it is asked for
+func storageUsed(dao daos.DaoInterface, owner string) (int64, error) {
+	user, err := dao.GetUserDetails(owner)
+	if err != nil {
+		return 0, err
+	}
+	if user.StorageBytes != nil {
+		return *user.StorageBytes, nil
+	}
+	total, err := accountStorageBytes(dao, owner)
+	if err != nil {
+		return 0, err
+	}
+	return total, dao.InitStorageBytes(owner, total)
+}
+
+// putUploadedImage stores an image the account uploaded, replacing whatever was stored under the key,
+// and adds the difference to the account's storage total
+func putUploadedImage(s3Client *s3.Client, dao daos.DaoInterface, owner, bucket, key string, contents []byte, contentType string) error {
+	var replaced int64
+	if head, err := s3Client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}); err == nil {
+		replaced = head.ContentLength
+	}
+	if _, err := s3Client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(contents),
+		ContentType: aws.String(contentType),
+	}); err != nil {
+		return err
+	}
+	return dao.AddStorageBytes(owner, int64(len(contents))-replaced)
+}
+
+// releaseUnusedImages deletes the uploaded images among candidates that nothing in the account refers
+// to any more, taking them off its storage total. Duplicates share their original's images, so an
+// image outlives the story it was uploaded for while a copy still uses it.
+func releaseUnusedImages(dao daos.DaoInterface, owner string, candidates []string) error {
+	images, err := dao.GetAccountImages(owner)
+	if err != nil {
+		return err
+	}
+	kept := map[string]bool{}
+	for _, imageURL := range images {
+		if bucket, key, ok := uploadedImageLocation(imageURL); ok {
+			kept[bucket+"/"+key] = true
+		}
+	}
+	var awsCfg aws.Config
+	if awsCfg, err = config.LoadDefaultConfig(context.TODO(), func(opts *config.LoadOptions) error {
+		opts.Region = os.Getenv("AWS_REGION")
+		return nil
+	}); err != nil {
+		return err
+	}
+	s3Client := s3.NewFromConfig(awsCfg)
+	var released int64
+	for _, imageURL := range candidates {
+		bucket, key, ok := uploadedImageLocation(imageURL)
+		if !ok || kept[bucket+"/"+key] {
+			continue
+		}
+		kept[bucket+"/"+key] = true
+		head, err := s3Client.HeadObject(context.Background(), &s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			// already gone from the bucket
+			continue
+		}
+		if _, err = s3Client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		}); err != nil {
+			return err
+		}
+		released += head.ContentLength
+	}
+	return dao.AddStorageBytes(owner, -released)
+}
+
+// accountStorageBytes adds up the size of every image the account's stories, series and associations
+// use, to start the running total of accounts that uploaded images before it was kept
+func accountStorageBytes(dao daos.DaoInterface, email string) (int64, error) {
+	images, err := dao.GetAccountImages(email)
+	if err != nil {
+		return 0, err
+	}
+	if len(images) == 0 {
+		return 0, nil
+	}
+	var awsCfg aws.Config
+	if awsCfg, err = config.LoadDefaultConfig(context.TODO(), func(opts *config.LoadOptions) error {
+		opts.Region = os.Getenv("AWS_REGION")
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+	s3Client := s3.NewFromConfig(awsCfg)
+	var total int64
+	counted := map[string]bool{}
+	for _, imageURL := range images {
+		bucket, key, ok := uploadedImageLocation(imageURL)
+		if !ok || counted[bucket+"/"+key] {
+			continue
+		}
+		counted[bucket+"/"+key] = true
+		head, err := s3Client.HeadObject(context.Background(), &s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			// an image that has gone from the bucket takes no space
+			continue
+		}
+		total += head.ContentLength
+	}
+	return total, nil
+}
+
+func aiCallMonth() string {
+	return time.Now().UTC().Format("2006-01")
+}
+
+func respondWithEntitlementError(w http.ResponseWriter, entitlement models.Entitlement, err error) {
+	var limitErr *limitReachedError
+	if errors.As(err, &limitErr) {
+		RespondWithJson(w, http.StatusUnauthorized, map[string]string{
+			"error": limitErr.Error(),
+			"limit": limitErr.limit,
+			"tier":  entitlement.Tier,
+		})
+		return
+	}
+	if opErr, ok := err.(*smithy.OperationError); ok {
+		if awsResponse := processAWSError(opErr); awsResponse.Code != 0 {
+			RespondWithError(w, awsResponse.Code, awsResponse.Message)
+			return
+		}
+	}
+	RespondWithError(w, http.StatusInternalServerError, err.Error())
+}
+
+// GetUserEntitlementsEndpoint reports the signed in user's plan, its limits and how much of them is used
+func GetUserEntitlementsEndpoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email string
+		err   error
+		dao   daos.DaoInterface
+		ok    bool
+	)
+	if email, err = getUserEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	entitlement, err := getEntitlement(r, dao, email)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	report := models.EntitlementReport{Entitlement: entitlement}
+	user, err := dao.GetUserDetails(email)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if user.AICallsMonth == aiCallMonth() {
+		report.Usage.AICalls = user.AICalls
+	}
+	if report.Usage.Stories, err = dao.GetTotalCreatedStories(email); err != nil {
+		respondWithEntitlementError(w, entitlement, err)
+		return
+	}
+	if report.Usage.StorageBytes, err = storageUsed(dao, email); err != nil {
+		respondWithEntitlementError(w, entitlement, err)
+		return
+	}
+	RespondWithJson(w, http.StatusOK, report)
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	ctxkey "RichDocter/ctxkeys"
+	"RichDocter/daos"
+	"RichDocter/models"
+	"RichDocter/payments"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+// DeleteUserEndpoint schedules the signed in user's account to be erased. The user confirms by
+// repeating their email and can cancel until the grace period runs out.
+func DeleteUserEndpoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email string
+		err   error
+		dao   daos.DaoInterface
+		ok    bool
+	)
+	if email, err = getUserEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	request := models.AccountErasureRequest{}
+	if err = json.NewDecoder(r.Body).Decode(&request); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if !strings.EqualFold(strings.TrimSpace(request.Confirm), email) {
+		RespondWithError(w, http.StatusBadRequest, "Confirm with the account's email to erase it")
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	erasure, err := dao.RequestAccountErasure(email)
+	if err != nil {
+		respondWithErasureError(w, err)
+		return
+	}
+	RespondWithJson(w, http.StatusAccepted, erasure)
+}
+
+// AccountErasureEndPoint shows the erasure scheduled for the signed in user's account
+func AccountErasureEndPoint(w http.ResponseWriter, r *http.Request) {
+	handleAccountErasure(w, r, func(dao daos.DaoInterface, email string) (*models.AccountErasure, error) {
+		return dao.GetAccountErasure(email)
+	})
+}
+
+// CancelAccountErasureEndpoint calls off the erasure scheduled for the signed in user's account
+func CancelAccountErasureEndpoint(w http.ResponseWriter, r *http.Request) {
+	handleAccountErasure(w, r, func(dao daos.DaoInterface, email string) (*models.AccountErasure, error) {
+		return dao.CancelAccountErasure(email)
+	})
+}
+
+func handleAccountErasure(w http.ResponseWriter, r *http.Request, fn func(dao daos.DaoInterface, email string) (*models.AccountErasure, error)) {
+	var (
+		email string
+		err   error
+		dao   daos.DaoInterface
+		ok    bool
+	)
+	if email, err = getUserEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	erasure, err := fn(dao, email)
+	if err != nil {
+		respondWithErasureError(w, err)
+		return
+	}
+	RespondWithJson(w, http.StatusOK, erasure)
+}
+
+// EraseAccount carries out a user's due erasure. Their Stripe customer goes first so nothing more is
+// billed, then the images they uploaded and their account exports, then everything stored about them.
+// If any step fails the erasure stays scheduled and can be run again.
+func EraseAccount(dao daos.DaoInterface, billingClient payments.Client, user *models.UserInfo) (*models.AccountErasure, error) {
+	erased := map[string]int{}
+	if user.SubscriptionID != "" || user.CustomerID != "" {
+		if err := payments.EraseCustomer(billingClient, user.SubscriptionID, user.CustomerID); err != nil {
+			return nil, err
+		}
+		erased["stripe_customers"] = 1
+	}
+
+	images, err := dao.GetAccountImages(user.Email)
+	if err != nil {
+		return nil, err
+	}
+	var awsCfg aws.Config
+	if awsCfg, err = config.LoadDefaultConfig(context.TODO(), func(opts *config.LoadOptions) error {
+		opts.Region = os.Getenv("AWS_REGION")
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	s3Client := s3.NewFromConfig(awsCfg)
+	objects := map[string]map[string]bool{}
+	for _, imageURL := range images {
+		if bucket, key, ok := uploadedImageLocation(imageURL); ok {
+			if objects[bucket] == nil {
+				objects[bucket] = map[string]bool{}
+			}
+			objects[bucket][key] = true
+		}
+	}
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(S3_EXPORTS_BUCKET),
+		Prefix: aws.String(accountExportPrefix(user.Email)),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			if objects[S3_EXPORTS_BUCKET] == nil {
+				objects[S3_EXPORTS_BUCKET] = map[string]bool{}
+			}
+			objects[S3_EXPORTS_BUCKET][aws.ToString(obj.Key)] = true
+		}
+	}
+	for bucket, keys := range objects {
+		for key := range keys {
+			if _, err = s3Client.DeleteObject(context.Background(), &s3.DeleteObjectInput{
+				Bucket: aws.String(bucket),
+				Key:    aws.String(key),
+			}); err != nil {
+				return nil, err
+			}
+			if bucket == S3_EXPORTS_BUCKET {
+				erased["exports"]++
+			} else {
+				erased["images"]++
+			}
+		}
+	}
+	return dao.EraseAccount(user.Email, erased)
+}
+
+// accountExportPrefix starts the name of every account export, so they can be found when erasing the account
+func accountExportPrefix(email string) string {
+	return strings.ToLower(strings.ReplaceAll(email, "@", "-")) + "_takeout_"
+}
+
+func respondWithErasureError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, daos.ErrErasurePending):
+		RespondWithError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, daos.ErrNoErasurePending):
+		RespondWithError(w, http.StatusNotFound, err.Error())
+	default:
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			if awsResponse := processAWSError(opErr); awsResponse.Code != 0 {
+				RespondWithError(w, awsResponse.Code, awsResponse.Message)
+				return
+			}
+		}
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+	}
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"RichDocter/converters"
 	ctxkey "RichDocter/ctxkeys"
 	"RichDocter/daos"
 	"RichDocter/models"
@@ -9,6 +10,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/url"
+	"strconv"
 
 	"github.com/aws/smithy-go"
 	"github.com/gorilla/mux"
@@ -60,7 +62,7 @@ func StoryBlocksEndPoint(w http.ResponseWriter, r *http.Request) {
 		dao     daos.DaoInterface
 		ok      bool
 	)
-	if email, err = getUserEmail(r); err != nil {
+	if email, err = getOwnerEmail(r); err != nil {
 		RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -117,7 +119,7 @@ func FullStoryEndPoint(w http.ResponseWriter, r *http.Request) {
 		dao     daos.DaoInterface
 		ok      bool
 	)
-	if email, err = getUserEmail(r); err != nil {
+	if email, err = getOwnerEmail(r); err != nil {
 		RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -175,7 +177,7 @@ func StoryEndPoint(w http.ResponseWriter, r *http.Request) {
 		dao     daos.DaoInterface
 		ok      bool
 	)
-	if email, err = getUserEmail(r); err != nil {
+	if email, err = getOwnerEmail(r); err != nil {
 		RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -269,7 +271,7 @@ func AssociationDetailsEndpoint(w http.ResponseWriter, r *http.Request) {
 		dao           daos.DaoInterface
 		ok            bool
 	)
-	if email, err = getUserEmail(r); err != nil {
+	if email, err = getOwnerEmail(r); err != nil {
 		RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -318,7 +320,7 @@ func AllAssociationThumbnailsByStoryEndPoint(w http.ResponseWriter, r *http.Requ
 		dao     daos.DaoInterface
 		ok      bool
 	)
-	if email, err = getUserEmail(r); err != nil {
+	if email, err = getOwnerEmail(r); err != nil {
 		RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -363,7 +365,7 @@ func SingleSeriesEndPoint(w http.ResponseWriter, r *http.Request) {
 		ok       bool
 		seriesID string
 	)
-	if email, err = getUserEmail(r); err != nil {
+	if email, err = getOwnerEmail(r); err != nil {
 		RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -441,7 +443,7 @@ func AllSeriesVolumesEndPoint(w http.ResponseWriter, r *http.Request) {
 		dao         daos.DaoInterface
 		ok          bool
 	)
-	if email, err = getUserEmail(r); err != nil {
+	if email, err = getOwnerEmail(r); err != nil {
 		RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -520,5 +522,562 @@ func GetUserData(w http.ResponseWriter, r *http.Request) {
 	user.Admin = details.Admin
 	user.Renewing = details.Renewing
 	user.SubscriptionID = details.SubscriptionID
+	user.ErasureID = details.ErasureID
+	user.ErasureDueAt = details.ErasureDueAt
 	RespondWithJson(w, http.StatusOK, user)
 }
+
+func AllRelationshipsByStoryEndPoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email   string
+		err     error
+		storyID string
+		dao     daos.DaoInterface
+		ok      bool
+	)
+	if email, err = getOwnerEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if storyID, err = url.PathUnescape(mux.Vars(r)["storyID"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing story id")
+		return
+	}
+	if storyID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing story id")
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	var storyOrSeriesID string
+	if storyOrSeriesID, err = dao.IsStoryInASeries(email, storyID); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "unable to check series membership of story")
+		return
+	}
+	if storyOrSeriesID == "" {
+		storyOrSeriesID = storyID
+	}
+	relationships, err := dao.GetAssociationRelationships(email, storyOrSeriesID)
+	if err != nil {
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			awsResponse := processAWSError(opErr)
+			if awsResponse.Code == 0 {
+				RespondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			RespondWithError(w, awsResponse.Code, awsResponse.Message)
+			return
+		}
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	RespondWithJson(w, http.StatusOK, relationships)
+}
+
+func RelationshipGraphEndPoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email   string
+		err     error
+		storyID string
+		dao     daos.DaoInterface
+		ok      bool
+	)
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "dot" {
+		RespondWithError(w, http.StatusBadRequest, "format must be json or dot")
+		return
+	}
+	if email, err = getOwnerEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if storyID, err = url.PathUnescape(mux.Vars(r)["storyID"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing story id")
+		return
+	}
+	if storyID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing story id")
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	story, err := dao.GetStoryByID(email, storyID)
+	if err != nil {
+		RespondWithError(w, http.StatusNotFound, "story not found")
+		return
+	}
+	storyOrSeriesID := story.ID
+	if story.SeriesID != "" {
+		storyOrSeriesID = story.SeriesID
+	}
+	graph, err := dao.GetRelationshipGraph(email, storyOrSeriesID)
+	if err != nil {
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			awsResponse := processAWSError(opErr)
+			if awsResponse.Code == 0 {
+				RespondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			RespondWithError(w, awsResponse.Code, awsResponse.Message)
+			return
+		}
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if format == "dot" {
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(converters.RelationshipsToDOT(story.Title, *graph)))
+		return
+	}
+	RespondWithJson(w, http.StatusOK, graph)
+}
+
+func AllAssociationTypesEndPoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email   string
+		err     error
+		storyID string
+		dao     daos.DaoInterface
+		ok      bool
+	)
+	if email, err = getOwnerEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if storyID, err = url.PathUnescape(mux.Vars(r)["storyID"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing story id")
+		return
+	}
+	if storyID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing story id")
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	var storyOrSeriesID string
+	if storyOrSeriesID, err = dao.IsStoryInASeries(email, storyID); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "unable to check series membership of story")
+		return
+	}
+	if storyOrSeriesID == "" {
+		storyOrSeriesID = storyID
+	}
+	associationTypes, err := dao.GetAssociationTypes(email, storyOrSeriesID)
+	if err != nil {
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			awsResponse := processAWSError(opErr)
+			if awsResponse.Code == 0 {
+				RespondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			RespondWithError(w, awsResponse.Code, awsResponse.Message)
+			return
+		}
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	RespondWithJson(w, http.StatusOK, associationTypes)
+}
+
+func AssociationEvolutionEndPoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email         string
+		err           error
+		seriesID      string
+		associationID string
+		dao           daos.DaoInterface
+		ok            bool
+	)
+	if email, err = getOwnerEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if seriesID, err = url.PathUnescape(mux.Vars(r)["series"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing series id")
+		return
+	}
+	if seriesID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing series id")
+		return
+	}
+	if associationID, err = url.PathUnescape(mux.Vars(r)["associationID"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing association id")
+		return
+	}
+	if associationID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing association id")
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	evolution, err := dao.GetAssociationEvolution(email, seriesID, associationID)
+	if err != nil {
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			awsResponse := processAWSError(opErr)
+			if awsResponse.Code == 0 {
+				RespondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			RespondWithError(w, awsResponse.Code, awsResponse.Message)
+			return
+		}
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	RespondWithJson(w, http.StatusOK, evolution)
+}
+
+func ExportAssociationsEndPoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email   string
+		err     error
+		storyID string
+		dao     daos.DaoInterface
+		ok      bool
+	)
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		RespondWithError(w, http.StatusBadRequest, "format must be json or csv")
+		return
+	}
+	if email, err = getOwnerEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if storyID, err = url.PathUnescape(mux.Vars(r)["storyID"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing story id")
+		return
+	}
+	if storyID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing story id")
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	story, err := dao.GetStoryByID(email, storyID)
+	if err != nil {
+		RespondWithError(w, http.StatusNotFound, "story not found")
+		return
+	}
+	storyOrSeriesID := story.ID
+	if story.SeriesID != "" {
+		storyOrSeriesID = story.SeriesID
+	}
+	associations, err := dao.GetAllAssociations(email, storyOrSeriesID)
+	if err != nil {
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			awsResponse := processAWSError(opErr)
+			if awsResponse.Code == 0 {
+				RespondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			RespondWithError(w, awsResponse.Code, awsResponse.Message)
+			return
+		}
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if format == "csv" {
+		csvData, err := converters.AssociationsToCSV(associations)
+		if err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", "attachment; filename=\"associations.csv\"")
+		w.WriteHeader(http.StatusOK)
+		w.Write(csvData)
+		return
+	}
+	RespondWithJson(w, http.StatusOK, associations)
+}
+
+func AllShareLinksByStoryEndPoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email   string
+		err     error
+		storyID string
+		dao     daos.DaoInterface
+		ok      bool
+	)
+	if email, err = getOwnerEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if storyID, err = url.PathUnescape(mux.Vars(r)["storyID"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing story id")
+		return
+	}
+	if storyID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing story id")
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	links, err := dao.GetShareLinksByStory(email, storyID)
+	if err != nil {
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			awsResponse := processAWSError(opErr)
+			if awsResponse.Code == 0 {
+				RespondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			RespondWithError(w, awsResponse.Code, awsResponse.Message)
+			return
+		}
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	RespondWithJson(w, http.StatusOK, links)
+}
+
+// SharedStoryEndPoint serves beta readers holding a share link; it runs without a user session
+func SharedStoryEndPoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		err   error
+		token string
+		dao   daos.DaoInterface
+		ok    bool
+	)
+	if token, err = url.PathUnescape(mux.Vars(r)["token"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing share token")
+		return
+	}
+	if token == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing share token")
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	shared, err := dao.GetSharedStory(token, r.Header.Get("X-Share-Password"))
+	if err != nil {
+		respondWithShareLinkError(w, err)
+		return
+	}
+	for i := range shared.Chapters {
+		shared.Chapters[i].HTML = converters.LexicalChunksToHTML(shared.Chapters[i].Chunks)
+	}
+	RespondWithJson(w, http.StatusOK, shared)
+}
+
+func ChapterCommentsEndPoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email     string
+		err       error
+		storyID   string
+		chapterID string
+		dao       daos.DaoInterface
+		ok        bool
+	)
+	includeResolved := r.URL.Query().Get("include_resolved") == "true"
+	if email, err = getOwnerEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if storyID, err = url.PathUnescape(mux.Vars(r)["storyID"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing story id")
+		return
+	}
+	if storyID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing story id")
+		return
+	}
+	if chapterID, err = url.PathUnescape(mux.Vars(r)["chapterID"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing chapter id")
+		return
+	}
+	if chapterID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing chapter id")
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	comments, err := dao.GetChapterComments(email, storyID, chapterID, includeResolved)
+	if err != nil {
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			awsResponse := processAWSError(opErr)
+			if awsResponse.Code == 0 {
+				RespondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			RespondWithError(w, awsResponse.Code, awsResponse.Message)
+			return
+		}
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	RespondWithJson(w, http.StatusOK, comments)
+}
+
+func SharedChapterCommentsEndPoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		err       error
+		token     string
+		chapterID string
+		dao       daos.DaoInterface
+		ok        bool
+	)
+	if token, err = url.PathUnescape(mux.Vars(r)["token"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing share token")
+		return
+	}
+	if chapterID, err = url.PathUnescape(mux.Vars(r)["chapterID"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing chapter id")
+		return
+	}
+	if token == "" || chapterID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing share token or chapter id")
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	comments, err := dao.GetSharedChapterComments(token, r.Header.Get("X-Share-Password"), chapterID)
+	if err != nil {
+		respondWithShareLinkError(w, err)
+		return
+	}
+	RespondWithJson(w, http.StatusOK, comments)
+}
+
+func CollaboratorsEndPoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email           string
+		err             error
+		storyOrSeriesID string
+		dao             daos.DaoInterface
+		ok              bool
+	)
+	if email, err = getOwnerEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if storyOrSeriesID, err = resourceIDFromVars(mux.Vars(r)); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing story or series ID")
+		return
+	}
+	if storyOrSeriesID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing story or series ID")
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	collaborators, err := dao.GetCollaborators(email, storyOrSeriesID)
+	if err != nil {
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			awsResponse := processAWSError(opErr)
+			if awsResponse.Code == 0 {
+				RespondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			RespondWithError(w, awsResponse.Code, awsResponse.Message)
+			return
+		}
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	RespondWithJson(w, http.StatusOK, collaborators)
+}
+
+func CollaborationsEndPoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email string
+		err   error
+		dao   daos.DaoInterface
+		ok    bool
+	)
+	if email, err = getUserEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	collaborations, err := dao.GetCollaborations(email)
+	if err != nil {
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			awsResponse := processAWSError(opErr)
+			if awsResponse.Code == 0 {
+				RespondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			RespondWithError(w, awsResponse.Code, awsResponse.Message)
+			return
+		}
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	RespondWithJson(w, http.StatusOK, collaborations)
+}
+
+// ChangesEndPoint pages through a story or series change log. Pass the seq from the previous
+// response as since to pick up where it left off.
+func ChangesEndPoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		err             error
+		storyOrSeriesID string
+		since           int64
+		dao             daos.DaoInterface
+		ok              bool
+	)
+	if storyOrSeriesID, err = resourceIDFromVars(mux.Vars(r)); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing story or series ID")
+		return
+	}
+	if storyOrSeriesID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing story or series ID")
+		return
+	}
+	if sinceParam := r.URL.Query().Get("since"); sinceParam != "" {
+		if since, err = strconv.ParseInt(sinceParam, 10, 64); err != nil {
+			RespondWithError(w, http.StatusBadRequest, "since must be a sequence number")
+			return
+		}
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	feed, err := dao.GetChanges(storyOrSeriesID, since)
+	if err != nil {
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			awsResponse := processAWSError(opErr)
+			if awsResponse.Code == 0 {
+				RespondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			RespondWithError(w, awsResponse.Code, awsResponse.Message)
+			return
+		}
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	RespondWithJson(w, http.StatusOK, feed)
+}
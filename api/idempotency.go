@@ -0,0 +1,142 @@
+package api
+
+import (
+	ctxkey "RichDocter/ctxkeys"
+	"RichDocter/daos"
+	"RichDocter/models"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// how long a response is kept for retries
+	idempotencyWindow = 24 * time.Hour
+	// how long a retry waits on the first attempt before taking over, in case it died part way
+	idempotencyLease = time.Minute
+	// responses larger than this aren't kept, DynamoDB items max out at 400KB
+	maxIdempotentResponseSize = 300 * 1024
+	maxIdempotencyKeyLength   = 255
+	// request bodies are read in full to fingerprint them, uploads are left to their handler's limit
+	maxIdempotentRequestSize = 10 << 20
+)
+
+// idempotencyRecorder keeps a copy of the response on its way to the client
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// IdempotencyMiddleware makes writes sent with an Idempotency-Key safe to retry. The first response
+// is stored and replayed for any retry with the same key, and the DAO given to the handler derives
+// its transaction tokens from the claim, so a transaction the SDK retries isn't applied twice.
+func IdempotencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			RespondWithError(w, http.StatusBadRequest, fmt.Sprintf("Idempotency-Key must be at most %d characters", maxIdempotencyKeyLength))
+			return
+		}
+		email, err := getUserEmail(r)
+		if err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		dao, ok := r.Context().Value(ctxkey.DAO).(daos.DaoInterface)
+		if !ok {
+			RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+			return
+		}
+		// a key reused for a different request is a client bug, not a retry. Uploads are fingerprinted
+		// by route alone, as they can be far larger than is worth buffering and each attempt at one is
+		// sent with a new multipart boundary.
+		var body []byte
+		if !strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			if body, err = io.ReadAll(http.MaxBytesReader(w, r.Body, maxIdempotentRequestSize)); err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					RespondWithError(w, http.StatusRequestEntityTooLarge, "request body too large")
+					return
+				}
+				RespondWithError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		fingerprint := sha256.Sum256(append([]byte(r.Method+" "+r.URL.RequestURI()+"\n"), body...))
+		now := time.Now()
+		record := models.IdempotencyRecord{
+			ID:          email + " " + key,
+			Fingerprint: hex.EncodeToString(fingerprint[:]),
+			LockedUntil: now.Add(idempotencyLease).Unix(),
+			ExpiresAt:   now.Add(idempotencyWindow).Unix(),
+			Attempt:     uuid.New().String(),
+		}
+		previous, err := dao.ClaimIdempotencyKey(record)
+		if err != nil {
+			if errors.Is(err, daos.ErrIdempotencyKeyInUse) {
+				RespondWithError(w, http.StatusConflict, err.Error())
+				return
+			}
+			RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if previous != nil {
+			if previous.Fingerprint != record.Fingerprint {
+				RespondWithError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+				return
+			}
+			if previous.ContentType != "" {
+				w.Header().Set("Content-Type", previous.ContentType)
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(previous.StatusCode)
+			w.Write(previous.Body)
+			return
+		}
+
+		rec := &idempotencyRecorder{ResponseWriter: w, status: http.StatusOK}
+		ctx := context.WithValue(r.Context(), ctxkey.DAO, dao.WithIdempotencyKey(record.ID+" "+record.Attempt))
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		// plan limit refusals are answered with a 401 and stop applying once the user upgrades or
+		// makes room, so like server errors and oversized responses they aren't worth replaying
+		if rec.status >= http.StatusInternalServerError || rec.status == http.StatusUnauthorized || rec.body.Len() > maxIdempotentResponseSize {
+			// let a retry run the request again
+			if err = dao.ReleaseIdempotencyKey(record.ID); err != nil {
+				fmt.Println("unable to release idempotency key", err)
+			}
+			return
+		}
+		record.StatusCode = rec.status
+		record.ContentType = rec.Header().Get("Content-Type")
+		record.Body = rec.body.Bytes()
+		if err = dao.SaveIdempotentResponse(record); err != nil {
+			fmt.Println("unable to save idempotent response", err)
+		}
+	})
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	ctxkey "RichDocter/ctxkeys"
+	"RichDocter/daos"
+	"RichDocter/models"
+	"RichDocter/sessions"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeIdempotencyDAO keeps idempotency records in memory
+type fakeIdempotencyDAO struct {
+	daos.DaoInterface
+	mu       sync.Mutex
+	records  map[string]models.IdempotencyRecord
+	released []string
+	scoped   []string
+}
+
+func newFakeIdempotencyDAO() *fakeIdempotencyDAO {
+	return &fakeIdempotencyDAO{records: map[string]models.IdempotencyRecord{}}
+}
+
+func (d *fakeIdempotencyDAO) ClaimIdempotencyKey(record models.IdempotencyRecord) (*models.IdempotencyRecord, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if existing, ok := d.records[record.ID]; ok {
+		if existing.Status != models.IdempotencyComplete {
+			return nil, daos.ErrIdempotencyKeyInUse
+		}
+		return &existing, nil
+	}
+	record.Status = models.IdempotencyPending
+	d.records[record.ID] = record
+	return nil, nil
+}
+
+func (d *fakeIdempotencyDAO) SaveIdempotentResponse(record models.IdempotencyRecord) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	record.Status = models.IdempotencyComplete
+	d.records[record.ID] = record
+	return nil
+}
+
+func (d *fakeIdempotencyDAO) ReleaseIdempotencyKey(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.records, id)
+	d.released = append(d.released, id)
+	return nil
+}
+
+func (d *fakeIdempotencyDAO) WithIdempotencyKey(key string) daos.DaoInterface {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.scoped = append(d.scoped, key)
+	return d
+}
+
+// signedIn gives a request the session of a signed in user
+func signedIn(t *testing.T, req *http.Request, email string) *http.Request {
+	t.Helper()
+	token, err := sessions.Get(req, "token")
+	if err != nil && token == nil {
+		t.Fatal(err)
+	}
+	tokenData, err := json.Marshal(models.UserInfo{Email: email})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token.IsNew = false
+	token.Values["token_data"] = tokenData
+	return req
+}
+
+func TestIdempotencyMiddleware(t *testing.T) {
+	testCases := []struct {
+		name         string
+		key          string
+		status       int
+		retryBody    string
+		pending      bool
+		wantCalls    int
+		wantStatus   int
+		wantReplayed bool
+		wantReleased bool
+	}{
+		{name: "Replay", key: "k1", status: http.StatusCreated, wantCalls: 1, wantStatus: http.StatusCreated, wantReplayed: true},
+		{name: "ReplayClientError", key: "k1", status: http.StatusBadRequest, wantCalls: 1, wantStatus: http.StatusBadRequest, wantReplayed: true},
+		{name: "FingerprintMismatch", key: "k1", status: http.StatusCreated, retryBody: `{"title":"Other"}`, wantCalls: 1, wantStatus: http.StatusUnprocessableEntity},
+		{name: "ReleasedOnServerError", key: "k1", status: http.StatusInternalServerError, wantCalls: 2, wantStatus: http.StatusInternalServerError, wantReleased: true},
+		{name: "ReleasedOnLimitRefusal", key: "k1", status: http.StatusUnauthorized, wantCalls: 2, wantStatus: http.StatusUnauthorized, wantReleased: true},
+		{name: "FirstAttemptStillRunning", key: "k1", status: http.StatusCreated, pending: true, wantCalls: 0, wantStatus: http.StatusConflict},
+		{name: "WithoutKey", status: http.StatusCreated, wantCalls: 2, wantStatus: http.StatusCreated},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			dao := newFakeIdempotencyDAO()
+			if tc.pending {
+				dao.records["owner@example.com "+tc.key] = models.IdempotencyRecord{ID: "owner@example.com " + tc.key, Status: models.IdempotencyPending}
+			}
+			calls := 0
+			handler := IdempotencyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if scoped, ok := r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok || (tc.key != "" && scoped != dao) {
+					t.Errorf("expected the handler to be given the scoped dao")
+				}
+				RespondWithJson(w, tc.status, map[string]int{"call": calls})
+			}))
+			send := func(body string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodPost, "/api/stories/s1", strings.NewReader(body))
+				if tc.key != "" {
+					req.Header.Set("Idempotency-Key", tc.key)
+				}
+				req = signedIn(t, req, "owner@example.com")
+				req = req.WithContext(context.WithValue(req.Context(), ctxkey.DAO, dao))
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+				return rec
+			}
+
+			body := `{"title":"First"}`
+			first := send(body)
+			if tc.retryBody != "" {
+				body = tc.retryBody
+			}
+			retry := send(body)
+
+			if calls != tc.wantCalls {
+				t.Errorf("expected the handler to run %d times, got %d", tc.wantCalls, calls)
+			}
+			if retry.Code != tc.wantStatus {
+				t.Errorf("expected status %d on retry, got %d: %s", tc.wantStatus, retry.Code, retry.Body.String())
+			}
+			replayed := retry.Header().Get("Idempotent-Replayed") == "true"
+			if replayed != tc.wantReplayed {
+				t.Errorf("expected replayed=%v, got %v", tc.wantReplayed, replayed)
+			}
+			if tc.wantReplayed && retry.Body.String() != first.Body.String() {
+				t.Errorf("expected the first response %q to be replayed, got %q", first.Body.String(), retry.Body.String())
+			}
+			if released := len(dao.released) > 0; released != tc.wantReleased {
+				t.Errorf("expected released=%v, got %v", tc.wantReleased, dao.released)
+			}
+			if tc.wantCalls > 0 && tc.key != "" && len(dao.scoped) == 0 {
+				t.Errorf("expected the claim to scope the dao's transaction tokens")
+			}
+		})
+	}
+}
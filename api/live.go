@@ -0,0 +1,361 @@
+package api
+
+import (
+	ctxkey "RichDocter/ctxkeys"
+	"RichDocter/daos"
+	"RichDocter/models"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	// how many recent operations a chapter keeps so reconnecting clients can catch up
+	liveHistorySize = 200
+	// how many outgoing messages may queue for a client before it is considered too slow and dropped
+	liveSendBuffer = 64
+	// how long a server holds a chapter's room without renewing it, renewed every third of that
+	liveLease = 30 * time.Second
+)
+
+// liveHost tells this server apart from the others when claiming rooms
+var liveHost = func() string {
+	name, _ := os.Hostname()
+	return name + "-" + uuid.New().String()
+}()
+
+type liveClient struct {
+	conn  *websocket.Conn
+	email string
+	role  models.CollaboratorRole
+	keyID string
+	send  chan models.LiveMessage
+}
+
+// chapterRoom sequences and fans out the edits made to a single chapter. Operations are persisted
+// and numbered one at a time while the room is locked, so every client sees them in the same order.
+// Rooms live in this server's memory, so a chapter is only ever open on the server holding its lease.
+type chapterRoom struct {
+	mu      sync.Mutex
+	seq     int64
+	history []models.LiveMessage
+	clients map[*liveClient]struct{}
+	done    chan struct{}
+}
+
+var liveRooms = struct {
+	sync.Mutex
+	rooms map[string]*chapterRoom
+}{rooms: make(map[string]*chapterRoom)}
+
+func joinChapterRoom(dao daos.DaoInterface, chapterID string, client *liveClient) *chapterRoom {
+	liveRooms.Lock()
+	defer liveRooms.Unlock()
+	room, ok := liveRooms.rooms[chapterID]
+	if !ok {
+		room = &chapterRoom{clients: make(map[*liveClient]struct{}), done: make(chan struct{})}
+		liveRooms.rooms[chapterID] = room
+		go room.keepLease(dao, chapterID)
+	}
+	room.mu.Lock()
+	room.clients[client] = struct{}{}
+	room.mu.Unlock()
+	return room
+}
+
+func leaveChapterRoom(dao daos.DaoInterface, chapterID string, room *chapterRoom, client *liveClient) {
+	liveRooms.Lock()
+	defer liveRooms.Unlock()
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	if _, ok := room.clients[client]; !ok {
+		return
+	}
+	delete(room.clients, client)
+	close(client.send)
+	if len(room.clients) == 0 {
+		delete(liveRooms.rooms, chapterID)
+		close(room.done)
+		// released while rooms are locked, so a room opened straight after claims the lease afresh
+		if err := dao.ReleaseLiveRoom(chapterID, liveHost); err != nil {
+			fmt.Println("unable to release live room", chapterID, err)
+		}
+		return
+	}
+	room.broadcastPresence()
+}
+
+// keepLease renews this server's hold on a chapter while anyone has it open. Should another server
+// have taken the chapter over, everyone is disconnected so they reconnect to it and resync.
+func (room *chapterRoom) keepLease(dao daos.DaoInterface, chapterID string) {
+	ticker := time.NewTicker(liveLease / 3)
+	defer ticker.Stop()
+	for {
+		err := dao.ClaimLiveRoom(chapterID, liveHost, liveLease)
+		if errors.Is(err, daos.ErrLiveRoomElsewhere) {
+			room.mu.Lock()
+			for client := range room.clients {
+				client.conn.Close()
+			}
+			room.mu.Unlock()
+			return
+		}
+		if err != nil {
+			fmt.Println("unable to renew live room", chapterID, err)
+		}
+		select {
+		case <-room.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (room *chapterRoom) setRole(client *liveClient, role models.CollaboratorRole) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	if client.role == role {
+		return
+	}
+	client.role = role
+	room.broadcastPresence()
+}
+
+// deliver queues a message for a client without blocking the room. Must be called with the room locked.
+func (room *chapterRoom) deliver(client *liveClient, msg models.LiveMessage) {
+	select {
+	case client.send <- msg:
+	default:
+		// the client isn't keeping up, closing the socket makes it reconnect and resync
+		client.conn.Close()
+	}
+}
+
+func (room *chapterRoom) broadcast(msg models.LiveMessage) {
+	for client := range room.clients {
+		room.deliver(client, msg)
+	}
+}
+
+func (room *chapterRoom) broadcastPresence() {
+	editors := make([]models.LiveEditor, 0, len(room.clients))
+	for client := range room.clients {
+		editors = append(editors, models.LiveEditor{Email: client.email, Role: client.role, KeyID: client.keyID})
+	}
+	room.broadcast(models.LiveMessage{Type: models.LivePresence, Seq: room.seq, Editors: editors})
+}
+
+// catchUp greets a newly connected client, replaying the operations it missed since the given
+// sequence number or asking it to reload the chapter when they are no longer held
+func (room *chapterRoom) catchUp(client *liveClient, since int64) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	room.deliver(client, models.LiveMessage{Type: models.LiveHello, Seq: room.seq})
+	if since > 0 {
+		oldest := room.seq - int64(len(room.history))
+		if since < oldest || since > room.seq {
+			room.deliver(client, models.LiveMessage{Type: models.LiveResync, Seq: room.seq})
+		} else {
+			for _, op := range room.history[since-oldest:] {
+				room.deliver(client, op)
+			}
+		}
+	}
+	room.broadcastPresence()
+}
+
+// apply persists an edit, then stamps it with the next sequence number and sends it to everyone in the room.
+// Written blocks go out carrying their new versions.
+func (room *chapterRoom) apply(dao daos.DaoInterface, storyID, chapterID string, sender *liveClient, msg models.LiveMessage) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	storyBlocks := &models.StoryBlocks{StoryID: storyID, ChapterID: chapterID, Blocks: msg.Blocks}
+	var err error
+	if msg.Type == models.LiveDelete {
+		err = dao.DeleteChapterParagraphs(storyID, storyBlocks)
+	} else {
+		err = dao.WriteBlocks(storyID, storyBlocks)
+	}
+	if err != nil {
+		reply := models.LiveMessage{Type: models.LiveError, OpID: msg.OpID, Error: err.Error()}
+		if errors.Is(err, daos.ErrVersionConflict) {
+			// hand back the stored blocks so the client can rebase its edit
+			reply.Blocks, _ = dao.GetBlocksByKeys(storyID, chapterID, storyBlockKeys(msg.Blocks))
+		}
+		room.deliver(sender, reply)
+		return
+	}
+	room.seq++
+	op := models.LiveMessage{
+		Type:   models.LiveOp,
+		OpID:   msg.OpID,
+		Seq:    room.seq,
+		Author: sender.email,
+		Action: msg.Type,
+		Blocks: msg.Blocks,
+	}
+	action := models.ChangeUpsert
+	if msg.Type == models.LiveDelete {
+		action = models.ChangeDelete
+	}
+	recordChanges(dao, storyID, sender.email, blockChanges(action, chapterID, msg.OpID, msg.Blocks))
+	room.history = append(room.history, op)
+	if len(room.history) > liveHistorySize {
+		room.history = room.history[len(room.history)-liveHistorySize:]
+	}
+	room.broadcast(op)
+}
+
+func (room *chapterRoom) moveCursor(client *liveClient, keyID string) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	client.keyID = keyID
+	room.broadcastPresence()
+}
+
+// LiveChapterEndpoint upgrades to a WebSocket carrying a chapter's edits between everyone who has it open.
+// Pass ?since=<seq> when reconnecting to receive the operations missed in between.
+//
+// A chapter's edits are sequenced in the memory of one server, which holds the chapter through a
+// lease in the live_rooms table. Load balancers must route each chapter's /live path to the same
+// server; a socket reaching any other server while the lease is held is refused with a 409.
+func LiveChapterEndpoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email     string
+		err       error
+		storyID   string
+		chapterID string
+		since     int64
+		dao       daos.DaoInterface
+		ok        bool
+	)
+	if email, err = getUserEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if storyID, err = url.PathUnescape(mux.Vars(r)["storyID"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing story ID")
+		return
+	}
+	if chapterID, err = url.PathUnescape(mux.Vars(r)["chapterID"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing chapter ID")
+		return
+	}
+	if storyID == "" || chapterID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing story or chapter ID")
+		return
+	}
+	if sinceParam := r.URL.Query().Get("since"); sinceParam != "" {
+		if since, err = strconv.ParseInt(sinceParam, 10, 64); err != nil || since < 0 {
+			RespondWithError(w, http.StatusBadRequest, "since must be a sequence number")
+			return
+		}
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	chapter, err := dao.GetChapterByID(chapterID)
+	if err != nil || chapter.StoryID != storyID {
+		RespondWithError(w, http.StatusNotFound, "chapter not found")
+		return
+	}
+	role, _ := r.Context().Value(ctxkey.Role).(models.CollaboratorRole)
+	if err = dao.ClaimLiveRoom(chapterID, liveHost, liveLease); err != nil {
+		if errors.Is(err, daos.ErrLiveRoomElsewhere) {
+			RespondWithError(w, http.StatusConflict, err.Error())
+			return
+		}
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	server := websocket.Server{
+		Handshake: checkLiveOrigin,
+		Handler: func(conn *websocket.Conn) {
+			serveLiveChapter(conn, dao, storyID, chapterID, email, role, since)
+		},
+	}
+	server.ServeHTTP(w, r)
+}
+
+// checkLiveOrigin rejects sockets opened by pages on other sites, which would otherwise ride on the session cookie
+func checkLiveOrigin(config *websocket.Config, r *http.Request) error {
+	origin, err := websocket.Origin(config, r)
+	if err != nil {
+		return err
+	}
+	if origin == nil || !strings.EqualFold(origin.Host, r.Host) {
+		return fmt.Errorf("cross origin websocket request rejected")
+	}
+	return nil
+}
+
+func serveLiveChapter(conn *websocket.Conn, dao daos.DaoInterface, storyID, chapterID, email string, role models.CollaboratorRole, since int64) {
+	defer conn.Close()
+	client := &liveClient{
+		conn:  conn,
+		email: email,
+		role:  role,
+		send:  make(chan models.LiveMessage, liveSendBuffer),
+	}
+	room := joinChapterRoom(dao, chapterID, client)
+	defer leaveChapterRoom(dao, chapterID, room, client)
+
+	go func() {
+		for msg := range client.send {
+			if err := websocket.JSON.Send(conn, msg); err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}()
+	room.catchUp(client, since)
+
+	for {
+		msg := models.LiveMessage{}
+		if err := websocket.JSON.Receive(conn, &msg); err != nil {
+			return
+		}
+		switch msg.Type {
+		case models.LiveWrite, models.LiveDelete:
+			// collaborators can be removed or demoted while connected, so access is checked again
+			// for every edit rather than trusted from when the socket opened
+			access, err := dao.GetResourceAccess(email, storyID)
+			if err != nil {
+				room.mu.Lock()
+				room.deliver(client, models.LiveMessage{Type: models.LiveError, OpID: msg.OpID, Error: err.Error()})
+				room.mu.Unlock()
+				continue
+			}
+			if access.Role == "" {
+				// no longer shared with them at all
+				return
+			}
+			role = access.Role
+			room.setRole(client, role)
+			if !role.Allows(models.RoleEditor) {
+				room.mu.Lock()
+				room.deliver(client, models.LiveMessage{Type: models.LiveError, OpID: msg.OpID, Error: errForbidden.Error()})
+				room.mu.Unlock()
+				continue
+			}
+			if len(msg.Blocks) == 0 {
+				continue
+			}
+			room.apply(dao, storyID, chapterID, client, msg)
+		case models.LiveCursor:
+			room.moveCursor(client, msg.KeyID)
+		}
+	}
+}
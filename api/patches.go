@@ -0,0 +1,91 @@
+package api
+
+import (
+	ctxkey "RichDocter/ctxkeys"
+	"RichDocter/daos"
+	"RichDocter/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/aws/smithy-go"
+	"github.com/gorilla/mux"
+)
+
+// PatchChapterBlocksEndpoint saves a chapter's inserts, updates, deletes and reorders in one request.
+// Send an Idempotency-Key header to make retrying a save that may have gone through safe.
+func PatchChapterBlocksEndpoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		storyID   string
+		chapterID string
+		err       error
+		dao       daos.DaoInterface
+		ok        bool
+	)
+	if storyID, err = url.PathUnescape(mux.Vars(r)["storyID"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing story ID")
+		return
+	}
+	if chapterID, err = url.PathUnescape(mux.Vars(r)["chapterID"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing chapter ID")
+		return
+	}
+	if storyID == "" || chapterID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing story or chapter ID")
+		return
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	patch := models.BlockPatch{}
+	if err = decoder.Decode(&patch); err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	chapter, err := dao.GetChapterByID(chapterID)
+	if err != nil || chapter.StoryID != storyID {
+		RespondWithError(w, http.StatusNotFound, "chapter not found")
+		return
+	}
+
+	if err = dao.PatchBlocks(storyID, chapterID, &patch); err != nil {
+		if errors.Is(err, daos.ErrInvalidBlockPatch) {
+			RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, daos.ErrVersionConflict) {
+			keys := storyBlockKeys(patch.Deletes)
+			keys = append(keys, storyBlockKeys(patch.Inserts)...)
+			keys = append(keys, storyBlockKeys(patch.Updates)...)
+			keys = append(keys, storyBlockKeys(patch.Reorders)...)
+			current, _ := dao.GetBlocksByKeys(storyID, chapterID, keys)
+			respondWithConflict(w, current)
+			return
+		}
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			awsResponse := processAWSError(opErr)
+			if awsResponse.Code == 0 {
+				RespondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			RespondWithError(w, awsResponse.Code, awsResponse.Message)
+			return
+		}
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	changes := blockChanges(models.ChangeDelete, chapterID, "", patch.Deletes)
+	changes = append(changes, blockChanges(models.ChangeUpsert, chapterID, "", patch.Inserts)...)
+	changes = append(changes, blockChanges(models.ChangeUpsert, chapterID, "", patch.Updates)...)
+	changes = append(changes, blockChanges(models.ChangeReorder, chapterID, "", patch.Reorders)...)
+	author, _ := getUserEmail(r)
+	recordChanges(dao, storyID, author, changes)
+	// the blocks now carry the versions the next save must supply
+	RespondWithJson(w, http.StatusOK, patch)
+}
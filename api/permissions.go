@@ -0,0 +1,144 @@
+package api
+
+import (
+	ctxkey "RichDocter/ctxkeys"
+	"RichDocter/daos"
+	"RichDocter/models"
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aws/smithy-go"
+	"github.com/gorilla/mux"
+)
+
+// routeRoles lists the routes needing something other than the default of viewer for reads and editor for writes
+var routeRoles = []struct {
+	method string
+	suffix string
+	role   models.CollaboratorRole
+}{
+	{"POST", "/chapters/{chapterID}/comments", models.RoleCommenter},
+	{"PUT", "/comments/{commentID}/resolve", models.RoleCommenter},
+	{"PUT", "/export", models.RoleViewer},
+	{"GET", "/shares", models.RoleOwner},
+	{"POST", "/shares", models.RoleOwner},
+	{"DELETE", "/shares/{token}", models.RoleOwner},
+	{"POST", "/collaborators", models.RoleOwner},
+	{"DELETE", "/collaborators/{email}", models.RoleViewer},
+	{"PUT", "/owner", models.RoleOwner},
+	{"POST", "/chapters/{chapterID}/move", models.RoleOwner},
+	{"POST", "/duplicate", models.RoleOwner},
+	{"POST", "/template", models.RoleOwner},
+	{"DELETE", "/stories/{story}", models.RoleOwner},
+	{"DELETE", "/series/{seriesID}", models.RoleOwner},
+}
+
+// RequiredRole returns the role a user must hold on a story or series to call the route
+func RequiredRole(method, pathTemplate string) models.CollaboratorRole {
+	for _, rule := range routeRoles {
+		if rule.method == method && strings.HasSuffix(pathTemplate, rule.suffix) {
+			return rule.role
+		}
+	}
+	if method == "GET" {
+		return models.RoleViewer
+	}
+	return models.RoleEditor
+}
+
+// resourceIDFromVars picks the story or series a route acts on. Routes naming both act on the series.
+func resourceIDFromVars(vars map[string]string) (string, error) {
+	for _, name := range []string{"series", "seriesID", "story", "storyID"} {
+		if raw, ok := vars[name]; ok && raw != "" {
+			return url.PathUnescape(raw)
+		}
+	}
+	return "", nil
+}
+
+// PermissionMiddleware checks the caller's role on the story or series addressed by the route and
+// records the resource owner, whose email scopes all storage lookups, in the request context
+func PermissionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resourceID, err := resourceIDFromVars(mux.Vars(r))
+		if err != nil {
+			RespondWithError(w, http.StatusBadRequest, "Error parsing story or series id")
+			return
+		}
+		if resourceID == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		email, err := getUserEmail(r)
+		if err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		dao, ok := r.Context().Value(ctxkey.DAO).(daos.DaoInterface)
+		if !ok {
+			RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+			return
+		}
+		required := models.RoleEditor
+		if route := mux.CurrentRoute(r); route != nil {
+			if template, err := route.GetPathTemplate(); err == nil {
+				required = RequiredRole(r.Method, template)
+			}
+		}
+		access, err := authorizeResource(dao, email, resourceID, required)
+		if err != nil {
+			respondWithAccessError(w, err)
+			return
+		}
+		ctx := context.WithValue(r.Context(), ctxkey.ResourceOwner, access.Owner)
+		ctx = context.WithValue(ctx, ctxkey.Role, access.Role)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+var errForbidden = errors.New("you do not have permission to do that")
+
+// authorizeResource resolves a user's access to a story or series and checks it covers the required role
+func authorizeResource(dao daos.DaoInterface, email, storyOrSeriesID string, required models.CollaboratorRole) (*models.ResourceAccess, error) {
+	access, err := dao.GetResourceAccess(email, storyOrSeriesID)
+	if err != nil {
+		return nil, err
+	}
+	if access.Role == "" {
+		// don't reveal that the story exists to users it was never shared with
+		return nil, daos.ErrResourceNotFound
+	}
+	if !access.Role.Allows(required) {
+		return nil, errForbidden
+	}
+	return access, nil
+}
+
+func respondWithAccessError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, daos.ErrResourceNotFound):
+		RespondWithError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, errForbidden):
+		RespondWithError(w, http.StatusForbidden, err.Error())
+	default:
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			if awsResponse := processAWSError(opErr); awsResponse.Code != 0 {
+				RespondWithError(w, awsResponse.Code, awsResponse.Message)
+				return
+			}
+		}
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// getOwnerEmail returns the email of the owner of the story or series the request acts on, falling
+// back to the signed in user on routes without one
+func getOwnerEmail(r *http.Request) (string, error) {
+	if owner, ok := r.Context().Value(ctxkey.ResourceOwner).(string); ok && owner != "" {
+		return owner, nil
+	}
+	return getUserEmail(r)
+}
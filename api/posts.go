@@ -1,12 +1,14 @@
 package api
 
 import (
+	"RichDocter/converters"
 	ctxkey "RichDocter/ctxkeys"
 	"RichDocter/daos"
 	"RichDocter/models"
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -175,7 +177,7 @@ func CreateStoryChapterEndpoint(w http.ResponseWriter, r *http.Request) {
 		newChapter models.Chapter
 		email      string
 	)
-	if email, err = getUserEmail(r); err != nil {
+	if email, err = getOwnerEmail(r); err != nil {
 		RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -216,6 +218,8 @@ func CreateStoryChapterEndpoint(w http.ResponseWriter, r *http.Request) {
 		RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	author, _ := getUserEmail(r)
+	recordChanges(dao, storyID, author, []*models.StoryChange{chapterChange(models.ChangeUpsert, "", newChapter)})
 	RespondWithJson(w, http.StatusOK, newChapter)
 }
 
@@ -227,7 +231,7 @@ func CreateAssociationsEndpoint(w http.ResponseWriter, r *http.Request) {
 		dao     daos.DaoInterface
 		ok      bool
 	)
-	if email, err = getUserEmail(r); err != nil {
+	if email, err = getOwnerEmail(r); err != nil {
 		RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -264,6 +268,11 @@ func CreateAssociationsEndpoint(w http.ResponseWriter, r *http.Request) {
 	if storyOrSeriesID == "" {
 		storyOrSeriesID = storyID
 	}
+	if !haveRoom(w, r, dao, email, func(limits models.Limits) error {
+		return associationRoom(dao, email, storyOrSeriesID, limits, associationIDs(associations))
+	}) {
+		return
+	}
 	if err = dao.WriteAssociations(email, storyOrSeriesID, associations); err != nil {
 		if opErr, ok := err.(*smithy.OperationError); ok {
 			awsResponse := processAWSError(opErr)
@@ -277,6 +286,8 @@ func CreateAssociationsEndpoint(w http.ResponseWriter, r *http.Request) {
 		RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	author, _ := getUserEmail(r)
+	recordChanges(dao, storyOrSeriesID, author, associationChanges(models.ChangeUpsert, associations))
 	RespondWithJson(w, http.StatusOK, associations)
 }
 
@@ -359,6 +370,13 @@ func CreateStoryEndpoint(w http.ResponseWriter, r *http.Request) {
 		RespondWithError(w, http.StatusBadRequest, "Missing story description")
 		return
 	}
+	var template *models.StoryTemplate
+	if templateID := strings.TrimSpace(r.FormValue("template_id")); templateID != "" {
+		if template, err = dao.GetStoryTemplate(email, templateID); err != nil {
+			respondWithTemplateError(w, err)
+			return
+		}
+	}
 	story.SeriesID = strings.TrimSpace(r.FormValue("series_id"))
 	seriesTitle := strings.TrimSpace(r.FormValue("series_title"))
 	if story.SeriesID == "" && len(seriesTitle) > 0 {
@@ -378,12 +396,7 @@ func CreateStoryEndpoint(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	s3Client := s3.NewFromConfig(awsCfg)
-	if _, err = s3Client.PutObject(context.Background(), &s3.PutObjectInput{
-		Bucket:      aws.String(S3_STORY_IMAGE_BUCKET),
-		Key:         aws.String(filename),
-		Body:        bytes.NewReader(scaledImageBuf.Bytes()),
-		ContentType: aws.String(fileType),
-	}); err != nil {
+	if err = putUploadedImage(s3Client, dao, email, S3_STORY_IMAGE_BUCKET, filename, scaledImageBuf.Bytes(), fileType); err != nil {
 		RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -402,6 +415,15 @@ func CreateStoryEndpoint(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if template != nil && len(template.Chapters) > 0 {
+		if story.Chapters, err = dao.ApplyStoryTemplate(email, story, template); err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		RespondWithJson(w, http.StatusOK, story)
+		return
+	}
+
 	firstChapterID := uuid.New().String()
 	chap := models.Chapter{}
 	chap.ID = firstChapterID
@@ -415,3 +437,619 @@ func CreateStoryEndpoint(w http.ResponseWriter, r *http.Request) {
 	story.Chapters = append(story.Chapters, newChapter)
 	RespondWithJson(w, http.StatusOK, story)
 }
+
+func TransferAssociationsEndpoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email string
+		err   error
+		dao   daos.DaoInterface
+		ok    bool
+	)
+	if email, err = getUserEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	transfer := models.AssociationTransfer{}
+	if err = decoder.Decode(&transfer); err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	source, err := authorizeResource(dao, email, transfer.SourceID, models.RoleEditor)
+	if err != nil {
+		respondWithAccessError(w, err)
+		return
+	}
+	target, err := authorizeResource(dao, email, transfer.TargetID, models.RoleEditor)
+	if err != nil {
+		respondWithAccessError(w, err)
+		return
+	}
+	if source.Owner != target.Owner {
+		RespondWithError(w, http.StatusBadRequest, "associations can only be transferred between stories and series with the same owner")
+		return
+	}
+	// moved and copied associations both count against the target, whether or not they merge there
+	if !haveRoom(w, r, dao, source.Owner, func(limits models.Limits) error {
+		transferring := len(transfer.AssociationIDs)
+		if transferring == 0 && limits.Associations != models.Unlimited {
+			associations, err := dao.GetStoryOrSeriesAssociationThumbnails(source.Owner, transfer.SourceID, false)
+			if err != nil {
+				return err
+			}
+			transferring = len(associations)
+		}
+		return associationRoom(dao, source.Owner, transfer.TargetID, limits, make([]string, transferring))
+	}) {
+		return
+	}
+	result, err := dao.TransferAssociations(source.Owner, transfer)
+	if err != nil {
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			awsResponse := processAWSError(opErr)
+			if awsResponse.Code == 0 {
+				RespondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			RespondWithError(w, awsResponse.Code, awsResponse.Message)
+			return
+		}
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	RespondWithJson(w, http.StatusOK, result)
+}
+
+func ImportAssociationsEndpoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email   string
+		err     error
+		storyID string
+		dao     daos.DaoInterface
+		ok      bool
+	)
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		RespondWithError(w, http.StatusBadRequest, "format must be json or csv")
+		return
+	}
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+	if email, err = getOwnerEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if storyID, err = url.PathUnescape(mux.Vars(r)["storyID"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing story ID")
+		return
+	}
+	if storyID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing story ID")
+		return
+	}
+
+	associations := []*models.Association{}
+	if format == "csv" {
+		if associations, err = converters.CSVToAssociations(r.Body); err != nil {
+			RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	} else {
+		decoder := json.NewDecoder(r.Body)
+		if err = decoder.Decode(&associations); err != nil {
+			RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+
+	var storyOrSeriesID string
+	if storyOrSeriesID, err = dao.IsStoryInASeries(email, storyID); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "unable to check series membership of story")
+		return
+	}
+	if storyOrSeriesID == "" {
+		storyOrSeriesID = storyID
+	}
+	// only the associations the import creates count against the plan, a dry run tells which they are
+	if !dryRun && !haveRoom(w, r, dao, email, func(limits models.Limits) error {
+		if limits.Associations == models.Unlimited {
+			return nil
+		}
+		preview, err := dao.ImportAssociations(email, storyOrSeriesID, associations, true)
+		if err != nil {
+			// the import itself reports what is wrong with the associations
+			return nil
+		}
+		return associationRoom(dao, email, storyOrSeriesID, limits, make([]string, len(preview.Created)))
+	}) {
+		return
+	}
+	report, err := dao.ImportAssociations(email, storyOrSeriesID, associations, dryRun)
+	if err != nil {
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			awsResponse := processAWSError(opErr)
+			if awsResponse.Code == 0 {
+				RespondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			RespondWithError(w, awsResponse.Code, awsResponse.Message)
+			return
+		}
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !report.DryRun {
+		imported := append([]*models.Association{}, report.Created...)
+		for _, change := range report.Updated {
+			imported = append(imported, change.Association)
+		}
+		author, _ := getUserEmail(r)
+		recordChanges(dao, storyOrSeriesID, author, associationChanges(models.ChangeUpsert, imported))
+	}
+	RespondWithJson(w, http.StatusOK, report)
+}
+
+func CreateShareLinkEndpoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email   string
+		err     error
+		storyID string
+		dao     daos.DaoInterface
+		ok      bool
+	)
+	if email, err = getOwnerEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if storyID, err = url.PathUnescape(mux.Vars(r)["storyID"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing story ID")
+		return
+	}
+	if storyID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing story ID")
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	link := models.ShareLink{}
+	if err = decoder.Decode(&link); err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	link.StoryID = storyID
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	created, err := dao.CreateShareLink(email, link)
+	if err != nil {
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			awsResponse := processAWSError(opErr)
+			if awsResponse.Code == 0 {
+				RespondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			RespondWithError(w, awsResponse.Code, awsResponse.Message)
+			return
+		}
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	RespondWithJson(w, http.StatusCreated, created)
+}
+
+func CreateCommentEndpoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		owner     string
+		email     string
+		err       error
+		storyID   string
+		chapterID string
+		dao       daos.DaoInterface
+		ok        bool
+	)
+	if email, err = getUserEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if owner, err = getOwnerEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if storyID, err = url.PathUnescape(mux.Vars(r)["storyID"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing story ID")
+		return
+	}
+	if chapterID, err = url.PathUnescape(mux.Vars(r)["chapterID"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing chapter ID")
+		return
+	}
+	if storyID == "" || chapterID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing story or chapter ID")
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	comment := models.Comment{}
+	if err = decoder.Decode(&comment); err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	comment.StoryID = storyID
+	comment.ChapterID = chapterID
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	created, err := dao.CreateComment(owner, email, comment)
+	if err != nil {
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			awsResponse := processAWSError(opErr)
+			if awsResponse.Code == 0 {
+				RespondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			RespondWithError(w, awsResponse.Code, awsResponse.Message)
+			return
+		}
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	RespondWithJson(w, http.StatusCreated, created)
+}
+
+func CreateSharedCommentEndpoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		err       error
+		token     string
+		chapterID string
+		dao       daos.DaoInterface
+		ok        bool
+	)
+	if token, err = url.PathUnescape(mux.Vars(r)["token"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing share token")
+		return
+	}
+	if chapterID, err = url.PathUnescape(mux.Vars(r)["chapterID"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing chapter ID")
+		return
+	}
+	if token == "" || chapterID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing share token or chapter ID")
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	comment := models.Comment{}
+	if err = decoder.Decode(&comment); err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	comment.ChapterID = chapterID
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	created, err := dao.CreateSharedComment(token, r.Header.Get("X-Share-Password"), comment)
+	if err != nil {
+		respondWithShareLinkError(w, err)
+		return
+	}
+	RespondWithJson(w, http.StatusCreated, created)
+}
+
+func InviteCollaboratorEndpoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email           string
+		err             error
+		storyOrSeriesID string
+		dao             daos.DaoInterface
+		ok              bool
+	)
+	if email, err = getOwnerEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if storyOrSeriesID, err = resourceIDFromVars(mux.Vars(r)); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing story or series ID")
+		return
+	}
+	if storyOrSeriesID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing story or series ID")
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	collaborator := models.Collaborator{}
+	if err = decoder.Decode(&collaborator); err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	collaborator.StoryOrSeriesID = storyOrSeriesID
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	invited, err := dao.InviteCollaborator(email, collaborator)
+	if err != nil {
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			awsResponse := processAWSError(opErr)
+			if awsResponse.Code == 0 {
+				RespondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			RespondWithError(w, awsResponse.Code, awsResponse.Message)
+			return
+		}
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	RespondWithJson(w, http.StatusCreated, invited)
+}
+
+// SyncStoryEndpoint applies the operations a client queued while offline and answers with what
+// was applied, what conflicted and every change recorded since the client last synced
+func SyncStoryEndpoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email   string
+		owner   string
+		err     error
+		storyID string
+		dao     daos.DaoInterface
+		ok      bool
+	)
+	if email, err = getUserEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if owner, err = getOwnerEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if storyID, err = url.PathUnescape(mux.Vars(r)["storyID"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing story ID")
+		return
+	}
+	if storyID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing story ID")
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	sync := models.SyncRequest{}
+	if err = decoder.Decode(&sync); err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	result := models.SyncResult{}
+	result.Applied, result.Conflicts = applySyncOperations(dao, owner, email, storyID, sync.Operations)
+	feed, err := dao.GetChanges(storyID, sync.Since)
+	if err != nil {
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			awsResponse := processAWSError(opErr)
+			if awsResponse.Code == 0 {
+				RespondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			RespondWithError(w, awsResponse.Code, awsResponse.Message)
+			return
+		}
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	result.ChangeFeed = *feed
+	RespondWithJson(w, http.StatusOK, result)
+}
+
+// SplitChapterEndpoint splits a chapter in two at the block named by key_id
+func SplitChapterEndpoint(w http.ResponseWriter, r *http.Request) {
+	request := struct {
+		KeyID string `json:"key_id"`
+		Title string `json:"title"`
+	}{}
+	restructureChapter(w, r, &request, func(dao daos.DaoInterface, owner, storyID, chapterID string) (*models.ChapterRestructure, error) {
+		if request.KeyID == "" {
+			return nil, fmt.Errorf("%w: key_id is required", daos.ErrInvalidRestructure)
+		}
+		return dao.SplitChapter(owner, storyID, chapterID, request.KeyID, request.Title)
+	})
+}
+
+// MergeChaptersEndpoint merges the chapter straight after this one, named by chapter_id, into it
+func MergeChaptersEndpoint(w http.ResponseWriter, r *http.Request) {
+	request := struct {
+		ChapterID string `json:"chapter_id"`
+	}{}
+	restructureChapter(w, r, &request, func(dao daos.DaoInterface, owner, storyID, chapterID string) (*models.ChapterRestructure, error) {
+		if request.ChapterID == "" {
+			return nil, fmt.Errorf("%w: chapter_id is required", daos.ErrInvalidRestructure)
+		}
+		return dao.MergeChapters(owner, storyID, chapterID, request.ChapterID)
+	})
+}
+
+// MoveChapterEndpoint moves a chapter to the end of another story, named by story_id, that the caller owns
+func MoveChapterEndpoint(w http.ResponseWriter, r *http.Request) {
+	request := struct {
+		StoryID string `json:"story_id"`
+	}{}
+	restructureChapter(w, r, &request, func(dao daos.DaoInterface, owner, storyID, chapterID string) (*models.ChapterRestructure, error) {
+		if request.StoryID == "" {
+			return nil, fmt.Errorf("%w: story_id is required", daos.ErrInvalidRestructure)
+		}
+		access, err := dao.GetResourceAccess(owner, request.StoryID)
+		if err != nil && !errors.Is(err, daos.ErrResourceNotFound) {
+			return nil, err
+		}
+		if err != nil || access.Role != models.RoleOwner || access.IsSeries {
+			return nil, fmt.Errorf("%w: chapters can only be moved to another of your stories", daos.ErrInvalidRestructure)
+		}
+		return dao.MoveChapter(owner, storyID, chapterID, request.StoryID)
+	})
+}
+
+// restructureChapter decodes the request into body, runs the split, merge or move and records what changed
+func restructureChapter(w http.ResponseWriter, r *http.Request, body interface{}, restructure func(dao daos.DaoInterface, owner, storyID, chapterID string) (*models.ChapterRestructure, error)) {
+	var (
+		err       error
+		email     string
+		storyID   string
+		chapterID string
+		dao       daos.DaoInterface
+		ok        bool
+	)
+	if email, err = getOwnerEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if storyID, err = url.PathUnescape(mux.Vars(r)["storyID"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing story ID")
+		return
+	}
+	if chapterID, err = url.PathUnescape(mux.Vars(r)["chapterID"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing chapter ID")
+		return
+	}
+	if storyID == "" || chapterID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing story or chapter ID")
+		return
+	}
+	if err = json.NewDecoder(r.Body).Decode(body); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+
+	result, err := restructure(dao, email, storyID, chapterID)
+	if err != nil {
+		if errors.Is(err, daos.ErrInvalidRestructure) {
+			RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			awsResponse := processAWSError(opErr)
+			if awsResponse.Code == 0 {
+				RespondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			RespondWithError(w, awsResponse.Code, awsResponse.Message)
+			return
+		}
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	author, _ := getUserEmail(r)
+	changes := []*models.StoryChange{}
+	for _, chapter := range result.Chapters {
+		changes = append(changes, chapterChange(models.ChangeUpsert, "", chapter))
+	}
+	switch {
+	case result.Removed != nil && result.Created != nil:
+		// moved to another story, which gets the chapter and its blocks
+		changes = append(changes, chapterChange(models.ChangeDelete, "", *result.Removed))
+		moved := []*models.StoryChange{chapterChange(models.ChangeUpsert, "", *result.Created)}
+		moved = append(moved, blockChanges(models.ChangeUpsert, result.Created.ID, "", result.Blocks)...)
+		recordChanges(dao, result.Created.StoryID, author, moved)
+	case result.Removed != nil:
+		changes = append(changes, chapterChange(models.ChangeDelete, "", *result.Removed))
+		changes = append(changes, blockChanges(models.ChangeUpsert, chapterID, "", result.Blocks)...)
+	case result.Created != nil:
+		changes = append(changes, blockChanges(models.ChangeDelete, chapterID, "", result.Blocks)...)
+		changes = append(changes, blockChanges(models.ChangeUpsert, result.Created.ID, "", result.Blocks)...)
+	}
+	recordChanges(dao, storyID, author, changes)
+	RespondWithJson(w, http.StatusOK, result)
+}
+
+// DuplicateStoryEndpoint copies a story, its chapters, blocks and associations into a new draft
+func DuplicateStoryEndpoint(w http.ResponseWriter, r *http.Request) {
+	duplicateResource(w, r, "storyID", func(dao daos.DaoInterface, email, id string) (int, error) {
+		return 1, nil
+	}, func(dao daos.DaoInterface, email, id string, duplicate models.StoryDuplicate) (interface{}, error) {
+		return dao.DuplicateStory(email, id, duplicate)
+	})
+}
+
+// DuplicateSeriesEndpoint copies a series, its associations and all of its volumes into a new series
+func DuplicateSeriesEndpoint(w http.ResponseWriter, r *http.Request) {
+	duplicateResource(w, r, "series", func(dao daos.DaoInterface, email, id string) (int, error) {
+		volumes, err := dao.GetSeriesVolumes(email, id)
+		return len(volumes), err
+	}, func(dao daos.DaoInterface, email, id string, duplicate models.StoryDuplicate) (interface{}, error) {
+		return dao.DuplicateSeries(email, id, duplicate)
+	})
+}
+
+// duplicateResource copies a story or series once the owner's plan has room for the stories copyCount
+// says the copy adds
+func duplicateResource(w http.ResponseWriter, r *http.Request, idVar string, copyCount func(dao daos.DaoInterface, email, id string) (int, error), duplicateFn func(dao daos.DaoInterface, email, id string, duplicate models.StoryDuplicate) (interface{}, error)) {
+	var (
+		email     string
+		id        string
+		err       error
+		dao       daos.DaoInterface
+		ok        bool
+		duplicate models.StoryDuplicate
+	)
+	if email, err = getOwnerEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if id, err = url.PathUnescape(mux.Vars(r)[idVar]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing story or series ID")
+		return
+	}
+	if id == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing story or series ID")
+		return
+	}
+	// the body is optional, the copy is named after the original when there isn't one
+	if err = json.NewDecoder(r.Body).Decode(&duplicate); err != nil && !errors.Is(err, io.EOF) {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	if !haveRoom(w, r, dao, email, func(limits models.Limits) error {
+		if limits.Stories == models.Unlimited {
+			return nil
+		}
+		copies, err := copyCount(dao, email, id)
+		if err != nil {
+			return err
+		}
+		return storyRoom(dao, email, limits, copies)
+	}) {
+		return
+	}
+	copied, err := duplicateFn(dao, email, id, duplicate)
+	if err != nil {
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			awsResponse := processAWSError(opErr)
+			if awsResponse.Code == 0 {
+				RespondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			RespondWithError(w, awsResponse.Code, awsResponse.Message)
+			return
+		}
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	RespondWithJson(w, http.StatusCreated, copied)
+}
@@ -5,14 +5,17 @@ import (
 	ctxkey "RichDocter/ctxkeys"
 	"RichDocter/daos"
 	"RichDocter/models"
+	"RichDocter/payments"
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -21,7 +24,6 @@ import (
 	"github.com/aws/smithy-go"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
-	"github.com/stripe/stripe-go/v72/sub"
 )
 
 func UpdateUserEndpoint(w http.ResponseWriter, r *http.Request) {
@@ -34,6 +36,16 @@ func UpdateUserEndpoint(w http.ResponseWriter, r *http.Request) {
 		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
 		return
 	}
+	billingClient, ok := r.Context().Value(ctxkey.Billing).(payments.Client)
+	if !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve billing client from context")
+		return
+	}
+	entitlements, ok := r.Context().Value(ctxkey.Entitlements).(*payments.EntitlementCache)
+	if !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve entitlements from context")
+		return
+	}
 	email, err := getUserEmail(r)
 	if err != nil {
 		RespondWithError(w, http.StatusInternalServerError, err.Error())
@@ -58,42 +70,12 @@ func UpdateUserEndpoint(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !user.Renewing && passedUser.Renewing {
-		subscription, err := sub.Get(user.SubscriptionID, nil)
-		if err != nil {
-			RespondWithError(w, http.StatusInternalServerError, err.Error())
-			return
-		}
-		if len(subscription.Items.Data) == 0 {
-			RespondWithError(w, http.StatusInternalServerError, "error retrieving subscription details")
-			return
-		}
-		priceID := subscription.Items.Data[0].Price.ID
-		methods, err := getPaymentMethodsForCustomer(user.CustomerID)
-		if err != nil {
-			RespondWithError(w, http.StatusInternalServerError, err.Error())
-			return
-		}
-		// TODO provide a way to update payment method
-		var defaultPaymentID string
-		for _, method := range methods {
-			if method.IsDefault {
-				defaultPaymentID = method.Id
-				break
-			}
-		}
-		sub, err := createSubscription(user.CustomerID, priceID, defaultPaymentID)
-		if err != nil {
-			RespondWithError(w, http.StatusInternalServerError, err.Error())
-			return
-		}
-		user.SubscriptionID = sub.ID
-	} else if user.Renewing && !passedUser.Renewing {
-		err = cancelSubscription(user.SubscriptionID)
-		if err != nil {
+	if user.Renewing != passedUser.Renewing {
+		if err = payments.SetRenewing(billingClient, user, passedUser.Renewing); err != nil {
 			RespondWithError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
+		entitlements.Invalidate(email)
 	}
 	user.Renewing = passedUser.Renewing
 	if err = dao.UpdateUser(*user); err != nil {
@@ -120,7 +102,7 @@ func EditSeriesEndpoint(w http.ResponseWriter, r *http.Request) {
 		dao      daos.DaoInterface
 		ok       bool
 	)
-	if email, err = getUserEmail(r); err != nil {
+	if email, err = getOwnerEmail(r); err != nil {
 		RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -154,6 +136,13 @@ func EditSeriesEndpoint(w http.ResponseWriter, r *http.Request) {
 	if len(strings.TrimSpace(r.FormValue("series_description"))) > 0 {
 		series.Description = strings.TrimSpace(r.FormValue("series_description"))
 	}
+	// the version the client last saw, when supplied, guards against overwriting a newer edit
+	if version := strings.TrimSpace(r.FormValue("version")); version != "" {
+		if series.Version, err = strconv.ParseInt(version, 10, 64); err != nil {
+			RespondWithError(w, http.StatusBadRequest, "version must be a number")
+			return
+		}
+	}
 
 	storiesJSON := r.FormValue("stories")
 	if storiesJSON != "" {
@@ -241,12 +230,7 @@ func EditSeriesEndpoint(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		s3Client := s3.NewFromConfig(awsCfg)
-		if _, err = s3Client.PutObject(context.Background(), &s3.PutObjectInput{
-			Bucket:      aws.String(S3_SERIES_IMAGE_BUCKET),
-			Key:         aws.String(filename),
-			Body:        scaledImageBuf,
-			ContentType: aws.String(fileType),
-		}); err != nil {
+		if err = putUploadedImage(s3Client, dao, email, S3_SERIES_IMAGE_BUCKET, filename, scaledImageBuf.Bytes(), fileType); err != nil {
 			RespondWithError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
@@ -255,6 +239,11 @@ func EditSeriesEndpoint(w http.ResponseWriter, r *http.Request) {
 
 	var updatedSeries models.Series
 	if updatedSeries, err = dao.EditSeries(email, *series); err != nil {
+		if errors.Is(err, daos.ErrVersionConflict) {
+			current, _ := dao.GetSeriesByID(email, seriesID)
+			respondWithConflict(w, current)
+			return
+		}
 		if opErr, ok := err.(*smithy.OperationError); ok {
 			awsResponse := processAWSError(opErr)
 			if awsResponse.Code == 0 {
@@ -280,7 +269,7 @@ func RemoveStoryFromSeriesEndpoint(w http.ResponseWriter, r *http.Request) {
 		ok       bool
 	)
 
-	if email, err = getUserEmail(r); err != nil {
+	if email, err = getOwnerEmail(r); err != nil {
 		RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -360,8 +349,13 @@ func UpdateChaptersEndpoint(w http.ResponseWriter, r *http.Request) {
 		RespondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	for _, chapter := range newChapters {
-		if _, err = dao.EditChapter(storyID, chapter); err != nil {
+	for idx, chapter := range newChapters {
+		if newChapters[idx], err = dao.EditChapter(storyID, chapter); err != nil {
+			if errors.Is(err, daos.ErrVersionConflict) {
+				current, _ := dao.GetChapterByID(chapter.ID)
+				respondWithConflict(w, current)
+				return
+			}
 			if opErr, ok := err.(*smithy.OperationError); ok {
 				awsResponse := processAWSError(opErr)
 				if awsResponse.Code == 0 {
@@ -375,6 +369,12 @@ func UpdateChaptersEndpoint(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	changes := make([]*models.StoryChange, 0, len(newChapters))
+	for _, chapter := range newChapters {
+		changes = append(changes, chapterChange(models.ChangeUpsert, "", chapter))
+	}
+	author, _ := getUserEmail(r)
+	recordChanges(dao, storyID, author, changes)
 	RespondWithJson(w, http.StatusOK, newChapters)
 }
 
@@ -417,6 +417,11 @@ func EditChapterEndpoint(w http.ResponseWriter, r *http.Request) {
 
 	var updatedChapter models.Chapter
 	if updatedChapter, err = dao.EditChapter(storyID, newChapter); err != nil {
+		if errors.Is(err, daos.ErrVersionConflict) {
+			current, _ := dao.GetChapterByID(newChapter.ID)
+			respondWithConflict(w, current)
+			return
+		}
 		if opErr, ok := err.(*smithy.OperationError); ok {
 			awsResponse := processAWSError(opErr)
 			if awsResponse.Code == 0 {
@@ -429,6 +434,8 @@ func EditChapterEndpoint(w http.ResponseWriter, r *http.Request) {
 		RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	author, _ := getUserEmail(r)
+	recordChanges(dao, storyID, author, []*models.StoryChange{chapterChange(models.ChangeUpsert, "", updatedChapter)})
 	RespondWithJson(w, http.StatusOK, updatedChapter)
 }
 
@@ -440,7 +447,7 @@ func EditStoryEndpoint(w http.ResponseWriter, r *http.Request) {
 		dao     daos.DaoInterface
 		ok      bool
 	)
-	if email, err = getUserEmail(r); err != nil {
+	if email, err = getOwnerEmail(r); err != nil {
 		RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -479,6 +486,14 @@ func EditStoryEndpoint(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// the version the client last saw, when supplied, guards against overwriting a newer edit
+	if version := strings.TrimSpace(r.FormValue("version")); version != "" {
+		if story.Version, err = strconv.ParseInt(version, 10, 64); err != nil {
+			RespondWithError(w, http.StatusBadRequest, "version must be a number")
+			return
+		}
+	}
+
 	if len(strings.TrimSpace(r.FormValue("series_id"))) > 0 {
 		story.SeriesID = strings.TrimSpace(r.FormValue("series_id"))
 	} else if len(strings.TrimSpace(r.FormValue("series_title"))) > 0 {
@@ -555,12 +570,7 @@ func EditStoryEndpoint(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		s3Client := s3.NewFromConfig(awsCfg)
-		if _, err = s3Client.PutObject(context.Background(), &s3.PutObjectInput{
-			Bucket:      aws.String(S3_STORY_IMAGE_BUCKET),
-			Key:         aws.String(filename),
-			Body:        scaledImageBuf,
-			ContentType: aws.String(fileType),
-		}); err != nil {
+		if err = putUploadedImage(s3Client, dao, email, S3_STORY_IMAGE_BUCKET, filename, scaledImageBuf.Bytes(), fileType); err != nil {
 			RespondWithError(w, http.StatusInternalServerError, err.Error())
 			return
 		}
@@ -569,6 +579,11 @@ func EditStoryEndpoint(w http.ResponseWriter, r *http.Request) {
 
 	var updatedStory models.Story
 	if updatedStory, err = dao.EditStory(email, *story); err != nil {
+		if errors.Is(err, daos.ErrVersionConflict) {
+			current, _ := dao.GetStoryByID(email, storyID)
+			respondWithConflict(w, current)
+			return
+		}
 		if opErr, ok := err.(*smithy.OperationError); ok {
 			awsResponse := processAWSError(opErr)
 			if awsResponse.Code == 0 {
@@ -614,6 +629,10 @@ func RewriteBlockOrderEndpoint(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err = dao.ResetBlockOrder(storyID, &storyBlocks); err != nil {
+		if errors.Is(err, daos.ErrInvalidBlockRank) {
+			RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 		if opErr, ok := err.(*smithy.OperationError); ok {
 			awsResponse := processAWSError(opErr)
 			if awsResponse.Code == 0 {
@@ -626,6 +645,8 @@ func RewriteBlockOrderEndpoint(w http.ResponseWriter, r *http.Request) {
 		RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	author, _ := getUserEmail(r)
+	recordChanges(dao, storyID, author, blockChanges(models.ChangeReorder, storyBlocks.ChapterID, "", storyBlocks.Blocks))
 	RespondWithJson(w, http.StatusOK, nil)
 }
 
@@ -673,6 +694,15 @@ func WriteBlocksToStoryEndpoint(w http.ResponseWriter, r *http.Request) {
 	// }
 
 	if err = dao.WriteBlocks(storyID, &storyBlocks); err != nil {
+		if errors.Is(err, daos.ErrInvalidBlockRank) {
+			RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if errors.Is(err, daos.ErrVersionConflict) {
+			current, _ := dao.GetBlocksByKeys(storyID, storyBlocks.ChapterID, storyBlockKeys(storyBlocks.Blocks))
+			respondWithConflict(w, current)
+			return
+		}
 		if opErr, ok := err.(*smithy.OperationError); ok {
 			awsResponse := processAWSError(opErr)
 			if awsResponse.Code == 0 {
@@ -685,7 +715,10 @@ func WriteBlocksToStoryEndpoint(w http.ResponseWriter, r *http.Request) {
 		RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	RespondWithJson(w, http.StatusOK, nil)
+	author, _ := getUserEmail(r)
+	recordChanges(dao, storyID, author, blockChanges(models.ChangeUpsert, storyBlocks.ChapterID, "", storyBlocks.Blocks))
+	// the blocks now carry the versions the next write must supply
+	RespondWithJson(w, http.StatusOK, storyBlocks)
 }
 
 func WriteAssocationsEndpoint(w http.ResponseWriter, r *http.Request) {
@@ -696,7 +729,7 @@ func WriteAssocationsEndpoint(w http.ResponseWriter, r *http.Request) {
 		dao     daos.DaoInterface
 		ok      bool
 	)
-	if email, err = getUserEmail(r); err != nil {
+	if email, err = getOwnerEmail(r); err != nil {
 		RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -732,6 +765,11 @@ func WriteAssocationsEndpoint(w http.ResponseWriter, r *http.Request) {
 	if storyOrSeriesID == "" {
 		storyOrSeriesID = storyID
 	}
+	if !haveRoom(w, r, dao, email, func(limits models.Limits) error {
+		return associationRoom(dao, email, storyOrSeriesID, limits, associationIDs(associations))
+	}) {
+		return
+	}
 
 	if err = dao.WriteAssociations(email, storyOrSeriesID, associations); err != nil {
 		if opErr, ok := err.(*smithy.OperationError); ok {
@@ -746,6 +784,8 @@ func WriteAssocationsEndpoint(w http.ResponseWriter, r *http.Request) {
 		RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	author, _ := getUserEmail(r)
+	recordChanges(dao, storyOrSeriesID, author, associationChanges(models.ChangeUpsert, associations))
 	RespondWithJson(w, http.StatusOK, associations)
 }
 
@@ -761,7 +801,7 @@ func UploadPortraitEndpoint(w http.ResponseWriter, r *http.Request) {
 		awsCfg          aws.Config
 	)
 	const maxFileSize = 1024 * 1024 // 1 MB
-	if email, err = getUserEmail(r); err != nil {
+	if email, err = getOwnerEmail(r); err != nil {
 		RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -861,12 +901,7 @@ func UploadPortraitEndpoint(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	s3Client := s3.NewFromConfig(awsCfg)
-	if _, err = s3Client.PutObject(context.Background(), &s3.PutObjectInput{
-		Bucket:      aws.String(S3_CUSTOM_PORTRAIT_BUCKET),
-		Key:         aws.String(filename),
-		Body:        scaledImageBuf,
-		ContentType: aws.String(fileType),
-	}); err != nil {
+	if err = putUploadedImage(s3Client, dao, email, S3_CUSTOM_PORTRAIT_BUCKET, filename, scaledImageBuf.Bytes(), fileType); err != nil {
 		RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -893,7 +928,7 @@ func ExportStoryEndpoint(w http.ResponseWriter, r *http.Request) {
 		RespondWithError(w, http.StatusBadRequest, "no type provided")
 		return
 	}
-	if email, err = getUserEmail(r); err != nil {
+	if email, err = getOwnerEmail(r); err != nil {
 		RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -973,3 +1008,352 @@ func ExportStoryEndpoint(w http.ResponseWriter, r *http.Request) {
 	docURL := "https://" + S3_EXPORTS_BUCKET + ".s3." + os.Getenv("AWS_REGION") + ".amazonaws.com/" + generatedFile
 	RespondWithJson(w, http.StatusCreated, models.Answer{Success: true, URL: docURL})
 }
+
+func WriteRelationshipsEndpoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email   string
+		err     error
+		storyID string
+		dao     daos.DaoInterface
+		ok      bool
+	)
+	if email, err = getOwnerEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if storyID, err = url.PathUnescape(mux.Vars(r)["story"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing story name")
+		return
+	}
+	if storyID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing story ID")
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	relationships := []*models.AssociationRelationship{}
+	if err = decoder.Decode(&relationships); err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	for idx, rel := range relationships {
+		if rel.ID == "" {
+			relationships[idx].ID = uuid.New().String()
+		}
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+
+	var storyOrSeriesID string
+	if storyOrSeriesID, err = dao.IsStoryInASeries(email, storyID); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "unable to check series membership of story")
+		return
+	}
+	if storyOrSeriesID == "" {
+		storyOrSeriesID = storyID
+	}
+
+	if err = dao.WriteAssociationRelationships(email, storyOrSeriesID, relationships); err != nil {
+		if errors.Is(err, daos.ErrInvalidRelationship) {
+			RespondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			awsResponse := processAWSError(opErr)
+			if awsResponse.Code == 0 {
+				RespondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			RespondWithError(w, awsResponse.Code, awsResponse.Message)
+			return
+		}
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	RespondWithJson(w, http.StatusOK, relationships)
+}
+
+func WriteAssociationTypeEndpoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email   string
+		err     error
+		storyID string
+		dao     daos.DaoInterface
+		ok      bool
+	)
+	if email, err = getOwnerEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if storyID, err = url.PathUnescape(mux.Vars(r)["story"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing story name")
+		return
+	}
+	if storyID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing story ID")
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	associationType := models.AssociationType{}
+	if err = decoder.Decode(&associationType); err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+
+	var storyOrSeriesID string
+	if storyOrSeriesID, err = dao.IsStoryInASeries(email, storyID); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "unable to check series membership of story")
+		return
+	}
+	if storyOrSeriesID == "" {
+		storyOrSeriesID = storyID
+	}
+	associationType.StoryOrSeriesID = storyOrSeriesID
+
+	if err = dao.WriteAssociationType(email, storyOrSeriesID, associationType); err != nil {
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			awsResponse := processAWSError(opErr)
+			if awsResponse.Code == 0 {
+				RespondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			RespondWithError(w, awsResponse.Code, awsResponse.Message)
+			return
+		}
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	RespondWithJson(w, http.StatusOK, associationType)
+}
+
+func WriteAssociationOverrideEndpoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email         string
+		err           error
+		storyID       string
+		associationID string
+		dao           daos.DaoInterface
+		ok            bool
+	)
+	if email, err = getOwnerEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if storyID, err = url.PathUnescape(mux.Vars(r)["story"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing story name")
+		return
+	}
+	if storyID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing story ID")
+		return
+	}
+	if associationID, err = url.PathUnescape(mux.Vars(r)["association"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing association name")
+		return
+	}
+	if associationID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing association ID")
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	override := models.AssociationOverride{}
+	if err = decoder.Decode(&override); err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+
+	var seriesID string
+	if seriesID, err = dao.IsStoryInASeries(email, storyID); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "unable to check series membership of story")
+		return
+	}
+	if seriesID == "" {
+		RespondWithError(w, http.StatusBadRequest, "only series volumes can override associations")
+		return
+	}
+	override.AssociationID = associationID
+	override.StoryID = storyID
+	override.SeriesID = seriesID
+
+	if err = dao.WriteAssociationOverride(email, override); err != nil {
+		if errors.Is(err, daos.ErrAssociationNotFound) {
+			RespondWithError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			awsResponse := processAWSError(opErr)
+			if awsResponse.Code == 0 {
+				RespondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			RespondWithError(w, awsResponse.Code, awsResponse.Message)
+			return
+		}
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	association, err := dao.GetAssociationDetails(email, storyID, associationID)
+	if err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	RespondWithJson(w, http.StatusOK, association)
+}
+
+func ResolveCommentEndpoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email     string
+		err       error
+		storyID   string
+		commentID string
+		dao       daos.DaoInterface
+		ok        bool
+	)
+	if email, err = getOwnerEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if storyID, err = url.PathUnescape(mux.Vars(r)["storyID"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing story ID")
+		return
+	}
+	if commentID, err = url.PathUnescape(mux.Vars(r)["commentID"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing comment ID")
+		return
+	}
+	if storyID == "" || commentID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing story or comment ID")
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	body := struct {
+		Resolved bool `json:"resolved"`
+	}{}
+	if err = decoder.Decode(&body); err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	if err = dao.ResolveComment(email, storyID, commentID, body.Resolved); err != nil {
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			awsResponse := processAWSError(opErr)
+			if awsResponse.Code == 0 {
+				RespondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			RespondWithError(w, awsResponse.Code, awsResponse.Message)
+			return
+		}
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	RespondWithJson(w, http.StatusOK, nil)
+}
+
+func RespondToInvitationEndpoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email      string
+		err        error
+		resourceID string
+		dao        daos.DaoInterface
+		ok         bool
+	)
+	if email, err = getUserEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if resourceID, err = url.PathUnescape(mux.Vars(r)["resourceID"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing story or series ID")
+		return
+	}
+	if resourceID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing story or series ID")
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	body := struct {
+		Accept bool `json:"accept"`
+	}{}
+	if err = decoder.Decode(&body); err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	if err = dao.RespondToInvitation(email, resourceID, body.Accept); err != nil {
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			awsResponse := processAWSError(opErr)
+			if awsResponse.Code == 0 {
+				RespondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			RespondWithError(w, awsResponse.Code, awsResponse.Message)
+			return
+		}
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	RespondWithJson(w, http.StatusOK, nil)
+}
+
+func TransferOwnershipEndpoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email           string
+		err             error
+		storyOrSeriesID string
+		dao             daos.DaoInterface
+		ok              bool
+	)
+	if email, err = getOwnerEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if storyOrSeriesID, err = resourceIDFromVars(mux.Vars(r)); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing story or series ID")
+		return
+	}
+	if storyOrSeriesID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing story or series ID")
+		return
+	}
+	decoder := json.NewDecoder(r.Body)
+	body := struct {
+		Email string `json:"email"`
+	}{}
+	if err = decoder.Decode(&body); err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	if err = dao.TransferOwnership(email, storyOrSeriesID, body.Email); err != nil {
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			awsResponse := processAWSError(opErr)
+			if awsResponse.Code == 0 {
+				RespondWithError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			RespondWithError(w, awsResponse.Code, awsResponse.Message)
+			return
+		}
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	RespondWithJson(w, http.StatusOK, nil)
+}
@@ -0,0 +1,251 @@
+package api
+
+import (
+	"RichDocter/converters"
+	ctxkey "RichDocter/ctxkeys"
+	"RichDocter/daos"
+	"RichDocter/models"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/google/uuid"
+)
+
+const maxAccountImportSize = 512 << 20
+
+// ExportAccountEndpoint packages everything the signed in user owns, including uploaded images, into
+// a zip archive and responds with where to download it
+func ExportAccountEndpoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email string
+		err   error
+		dao   daos.DaoInterface
+		ok    bool
+	)
+	if email, err = getUserEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	archive, err := dao.ExportAccount(email)
+	if err != nil {
+		respondWithTakeoutError(w, err)
+		return
+	}
+
+	var awsCfg aws.Config
+	if awsCfg, err = config.LoadDefaultConfig(context.TODO(), func(opts *config.LoadOptions) error {
+		opts.Region = os.Getenv("AWS_REGION")
+		return nil
+	}); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s3Client := s3.NewFromConfig(awsCfg)
+	eachArchiveImage(archive, func(imageURL *string, bucket string) {
+		if _, done := archive.Images[*imageURL]; done {
+			return
+		}
+		srcBucket, key, ok := uploadedImageLocation(*imageURL)
+		if !ok {
+			return
+		}
+		obj, err := s3Client.GetObject(context.Background(), &s3.GetObjectInput{
+			Bucket: aws.String(srcBucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			// the archive still holds the url, a missing image shouldn't lose the rest of the export
+			fmt.Println("unable to fetch image for export", *imageURL, err)
+			return
+		}
+		defer obj.Body.Close()
+		contents, err := io.ReadAll(obj.Body)
+		if err != nil {
+			fmt.Println("unable to read image for export", *imageURL, err)
+			return
+		}
+		archive.Images[*imageURL] = contents
+	})
+
+	buf := &bytes.Buffer{}
+	if err = converters.WriteAccountArchive(buf, archive); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	filename := accountExportPrefix(email) + uuid.New().String() + ".zip"
+	if _, err = s3Client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket:      aws.String(S3_EXPORTS_BUCKET),
+		Key:         aws.String(filename),
+		Body:        bytes.NewReader(buf.Bytes()),
+		ContentType: aws.String("application/zip"),
+	}); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	archiveURL := "https://" + S3_EXPORTS_BUCKET + ".s3." + os.Getenv("AWS_REGION") + ".amazonaws.com/" + filename
+	RespondWithJson(w, http.StatusCreated, models.Answer{Success: true, URL: archiveURL})
+}
+
+// ImportAccountEndpoint recreates the contents of an account export, uploaded as file, under the
+// signed in user. Everything is given new ids, so nothing already in the account is touched.
+func ImportAccountEndpoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email string
+		err   error
+		dao   daos.DaoInterface
+		ok    bool
+	)
+	if email, err = getUserEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxAccountImportSize)
+	if err = r.ParseMultipartForm(32 << 20); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Unable to parse file")
+		return
+	}
+	file, handler, err := r.FormFile("file")
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer file.Close()
+	archive, err := converters.ReadAccountArchive(file, handler.Size)
+	if err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid account archive: "+err.Error())
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	if !haveRoom(w, r, dao, email, func(limits models.Limits) error {
+		return archiveRoom(dao, email, limits, archive)
+	}) {
+		return
+	}
+
+	if len(archive.Images) > 0 {
+		var awsCfg aws.Config
+		if awsCfg, err = config.LoadDefaultConfig(context.TODO(), func(opts *config.LoadOptions) error {
+			opts.Region = os.Getenv("AWS_REGION")
+			return nil
+		}); err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		s3Client := s3.NewFromConfig(awsCfg)
+		uploaded := make(map[string]string, len(archive.Images))
+		eachArchiveImage(archive, func(imageURL *string, bucket string) {
+			if err != nil {
+				return
+			}
+			if newURL, done := uploaded[bucket+" "+*imageURL]; done {
+				*imageURL = newURL
+				return
+			}
+			contents, carried := archive.Images[*imageURL]
+			if !carried {
+				return
+			}
+			filename := uuid.New().String() + "_import" + path.Ext(*imageURL)
+			if err = putUploadedImage(s3Client, dao, email, bucket, filename, contents, http.DetectContentType(contents)); err != nil {
+				return
+			}
+			newURL := "https://" + bucket + ".s3." + os.Getenv("AWS_REGION") + ".amazonaws.com/" + filename
+			uploaded[bucket+" "+*imageURL] = newURL
+			*imageURL = newURL
+		})
+		if err != nil {
+			RespondWithError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	report, err := dao.ImportAccount(email, archive)
+	if err != nil {
+		respondWithTakeoutError(w, err)
+		return
+	}
+	RespondWithJson(w, http.StatusCreated, report)
+}
+
+// eachArchiveImage calls fn with every image url in the archive along with the bucket images of its kind are uploaded to
+func eachArchiveImage(archive *models.AccountArchive, fn func(imageURL *string, bucket string)) {
+	portraits := func(associations []*models.Association, overrides []*models.AssociationOverride) {
+		for _, assoc := range associations {
+			fn(&assoc.Portrait, S3_CUSTOM_PORTRAIT_BUCKET)
+		}
+		for _, override := range overrides {
+			if override.Portrait != "" {
+				fn(&override.Portrait, S3_CUSTOM_PORTRAIT_BUCKET)
+			}
+		}
+	}
+	for _, series := range archive.Series {
+		fn(&series.Series.ImageURL, S3_SERIES_IMAGE_BUCKET)
+		portraits(series.Associations, nil)
+	}
+	for _, story := range archive.Stories {
+		fn(&story.Story.ImageURL, S3_STORY_IMAGE_BUCKET)
+		portraits(story.Associations, story.Overrides)
+	}
+}
+
+// uploadedImageLocation finds the bucket and key of an image a user uploaded. Shared default images
+// live elsewhere and aren't carried in archives.
+func uploadedImageLocation(imageURL string) (bucket, key string, ok bool) {
+	parsed, err := url.Parse(imageURL)
+	if err != nil || parsed.Host == "" {
+		return "", "", false
+	}
+	bucket = strings.SplitN(parsed.Host, ".s3.", 2)[0]
+	switch bucket {
+	case S3_STORY_IMAGE_BUCKET, S3_SERIES_IMAGE_BUCKET, S3_CUSTOM_PORTRAIT_BUCKET:
+		return bucket, strings.TrimPrefix(parsed.Path, "/"), true
+	}
+	return "", "", false
+}
+
+func respondWithTakeoutError(w http.ResponseWriter, err error) {
+	if opErr, ok := err.(*smithy.OperationError); ok {
+		if awsResponse := processAWSError(opErr); awsResponse.Code != 0 {
+			RespondWithError(w, awsResponse.Code, awsResponse.Message)
+			return
+		}
+	}
+	RespondWithError(w, http.StatusInternalServerError, err.Error())
+}
+
+// archiveRoom returns a limitReachedError if importing the archive would take the account past its
+// plan's stories, or any imported story or series past its plan's associations
+func archiveRoom(dao daos.DaoInterface, email string, limits models.Limits, archive *models.AccountArchive) error {
+	if limits.Associations != models.Unlimited {
+		for _, series := range archive.Series {
+			if len(series.Associations) > limits.Associations {
+				return &limitReachedError{limitAssociations}
+			}
+		}
+		for _, story := range archive.Stories {
+			if len(story.Associations) > limits.Associations {
+				return &limitReachedError{limitAssociations}
+			}
+		}
+	}
+	return storyRoom(dao, email, limits, len(archive.Stories))
+}
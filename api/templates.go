@@ -0,0 +1,129 @@
+package api
+
+import (
+	ctxkey "RichDocter/ctxkeys"
+	"RichDocter/daos"
+	"RichDocter/models"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/aws/smithy-go"
+	"github.com/gorilla/mux"
+)
+
+// StoryTemplatesEndPoint lists the built-in story templates and the ones the signed in user saved
+func StoryTemplatesEndPoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email string
+		err   error
+		dao   daos.DaoInterface
+		ok    bool
+	)
+	if email, err = getUserEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	templates, err := dao.GetStoryTemplates(email)
+	if err != nil {
+		respondWithTemplateError(w, err)
+		return
+	}
+	RespondWithJson(w, http.StatusOK, templates)
+}
+
+// SaveStoryTemplateEndpoint saves the shape of a story, its chapters and association types, as a template
+func SaveStoryTemplateEndpoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email   string
+		storyID string
+		err     error
+		dao     daos.DaoInterface
+		ok      bool
+	)
+	if email, err = getOwnerEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if storyID, err = url.PathUnescape(mux.Vars(r)["storyID"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing story ID")
+		return
+	}
+	if storyID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing story ID")
+		return
+	}
+	template := models.StoryTemplate{}
+	if err = json.NewDecoder(r.Body).Decode(&template); err != nil {
+		RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if template.Name == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing template name")
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	saved, err := dao.SaveStoryTemplate(email, storyID, template)
+	if err != nil {
+		respondWithTemplateError(w, err)
+		return
+	}
+	RespondWithJson(w, http.StatusCreated, saved)
+}
+
+// DeleteStoryTemplateEndpoint removes one of the signed in user's saved templates
+func DeleteStoryTemplateEndpoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email      string
+		templateID string
+		err        error
+		dao        daos.DaoInterface
+		ok         bool
+	)
+	if email, err = getUserEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if templateID, err = url.PathUnescape(mux.Vars(r)["templateID"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing template ID")
+		return
+	}
+	if templateID == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing template ID")
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	if err = dao.DeleteStoryTemplate(email, templateID); err != nil {
+		respondWithTemplateError(w, err)
+		return
+	}
+	RespondWithJson(w, http.StatusOK, nil)
+}
+
+func respondWithTemplateError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, daos.ErrTemplateNotFound):
+		RespondWithError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, daos.ErrBuiltInTemplate):
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+	default:
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			if awsResponse := processAWSError(opErr); awsResponse.Code != 0 {
+				RespondWithError(w, awsResponse.Code, awsResponse.Message)
+				return
+			}
+		}
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+	}
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	ctxkey "RichDocter/ctxkeys"
+	"RichDocter/daos"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/aws/smithy-go"
+	"github.com/gorilla/mux"
+)
+
+// TrashEndPoint lists the signed in user's deleted stories, series, chapters and associations
+func TrashEndPoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		email string
+		err   error
+		dao   daos.DaoInterface
+		ok    bool
+	)
+	if email, err = getUserEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	trash, err := dao.GetTrash(email)
+	if err != nil {
+		respondWithTrashError(w, err)
+		return
+	}
+	RespondWithJson(w, http.StatusOK, trash)
+}
+
+// RestoreFromTrashEndpoint brings back a deleted item along with everything deleted with it
+func RestoreFromTrashEndpoint(w http.ResponseWriter, r *http.Request) {
+	handleTrashItem(w, r, func(dao daos.DaoInterface, email, itemType, id string) error {
+		return dao.RestoreFromTrash(email, itemType, id)
+	})
+}
+
+// PurgeFromTrashEndpoint permanently deletes an item in the trash rather than waiting for it to expire,
+// along with the images only it used
+func PurgeFromTrashEndpoint(w http.ResponseWriter, r *http.Request) {
+	handleTrashItem(w, r, func(dao daos.DaoInterface, email, itemType, id string) error {
+		// the storage total has to be counted before the images go, or they'd never have been on it
+		if _, err := storageUsed(dao, email); err != nil {
+			return err
+		}
+		images, err := dao.GetAccountImages(email)
+		if err != nil {
+			return err
+		}
+		if err = dao.PurgeFromTrash(email, itemType, id); err != nil {
+			return err
+		}
+		return releaseUnusedImages(dao, email, images)
+	})
+}
+
+func handleTrashItem(w http.ResponseWriter, r *http.Request, fn func(dao daos.DaoInterface, email, itemType, id string) error) {
+	var (
+		email    string
+		itemType string
+		id       string
+		err      error
+		dao      daos.DaoInterface
+		ok       bool
+	)
+	if email, err = getUserEmail(r); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if itemType, err = url.PathUnescape(mux.Vars(r)["type"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing item type")
+		return
+	}
+	if id, err = url.PathUnescape(mux.Vars(r)["id"]); err != nil {
+		RespondWithError(w, http.StatusInternalServerError, "Error parsing item ID")
+		return
+	}
+	if id == "" {
+		RespondWithError(w, http.StatusBadRequest, "Missing item ID")
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	if err = fn(dao, email, itemType, id); err != nil {
+		respondWithTrashError(w, err)
+		return
+	}
+	RespondWithJson(w, http.StatusOK, nil)
+}
+
+func respondWithTrashError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, daos.ErrUnknownTrashType):
+		RespondWithError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, daos.ErrNotInTrash):
+		RespondWithError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, daos.ErrParentInTrash), errors.Is(err, daos.ErrTypeNotDefined):
+		RespondWithError(w, http.StatusConflict, err.Error())
+	default:
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			if awsResponse := processAWSError(opErr); awsResponse.Code != 0 {
+				RespondWithError(w, awsResponse.Code, awsResponse.Message)
+				return
+			}
+		}
+		RespondWithError(w, http.StatusInternalServerError, err.Error())
+	}
+}
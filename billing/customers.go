@@ -5,23 +5,25 @@ import (
 	ctxkey "RichDocter/ctxkeys"
 	"RichDocter/daos"
 	"RichDocter/models"
+	"RichDocter/payments"
 	"RichDocter/sessions"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
-
-	stripe "github.com/stripe/stripe-go/v72"
-	"github.com/stripe/stripe-go/v72/customer"
-	"github.com/stripe/stripe-go/v72/paymentmethod"
-	"github.com/stripe/stripe-go/v72/setupintent"
+	"strings"
 )
 
+var ErrNoCustomer = errors.New("no billing customer")
+
 func GetCustomerEndpoint(w http.ResponseWriter, r *http.Request) {
-	stripe.Key = os.Getenv("STRIPE_SECRET")
-	if stripe.Key == "" {
-		api.RespondWithError(w, http.StatusNoContent, "missing stripe secret")
+	var (
+		client payments.Client
+		ok     bool
+	)
+	if client, ok = r.Context().Value(ctxkey.Billing).(payments.Client); !ok {
+		api.RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve billing client from context")
 		return
 	}
 	token, err := sessions.Get(r, "token")
@@ -34,7 +36,7 @@ func GetCustomerEndpoint(w http.ResponseWriter, r *http.Request) {
 		api.RespondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	c, err := customer.Get(user.CustomerID, nil)
+	c, err := client.GetCustomer(user.CustomerID)
 	if err != nil {
 		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -43,9 +45,12 @@ func GetCustomerEndpoint(w http.ResponseWriter, r *http.Request) {
 }
 
 func CreateCardIntentEndpoint(w http.ResponseWriter, r *http.Request) {
-	stripe.Key = os.Getenv("STRIPE_SECRET")
-	if stripe.Key == "" {
-		api.RespondWithError(w, http.StatusNoContent, "missing stripe secret")
+	var (
+		client payments.Client
+		ok     bool
+	)
+	if client, ok = r.Context().Value(ctxkey.Billing).(payments.Client); !ok {
+		api.RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve billing client from context")
 		return
 	}
 	decoder := json.NewDecoder(r.Body)
@@ -54,36 +59,25 @@ func CreateCardIntentEndpoint(w http.ResponseWriter, r *http.Request) {
 		api.RespondWithError(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
-	secret, err := createCardIntent(customer.Id)
+	secret, err := client.CreateCardIntent(customer.Id)
 	if err != nil {
 		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	customer.Cards = append(customer.Cards, secret)
+	customer.Cards = append(customer.Cards, &secret)
 	api.RespondWithJson(w, http.StatusOK, customer)
 }
 
-func createCardIntent(customerID string) (secret *string, err error) {
-	params := &stripe.SetupIntentParams{
-		PaymentMethodTypes: []*string{
-			stripe.String("card"),
-		},
-		Customer: &customerID,
-	}
-	si, err := setupintent.New(params)
-	if err != nil {
-		return nil, err
-	}
-	return &si.ClientSecret, nil
-}
-
 func CreateCustomerEndpoint(w http.ResponseWriter, r *http.Request) {
-	stripe.Key = os.Getenv("STRIPE_SECRET")
-	if stripe.Key == "" {
-		api.RespondWithError(w, http.StatusNoContent, "missing stripe secret")
+	var (
+		client payments.Client
+		dao    daos.DaoInterface
+		ok     bool
+	)
+	if client, ok = r.Context().Value(ctxkey.Billing).(payments.Client); !ok {
+		api.RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve billing client from context")
 		return
 	}
-
 	token, err := sessions.Get(r, "token")
 	if err != nil || token.IsNew {
 		api.RespondWithError(w, http.StatusNotFound, "cannot find token")
@@ -94,10 +88,6 @@ func CreateCustomerEndpoint(w http.ResponseWriter, r *http.Request) {
 		api.RespondWithError(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	var (
-		dao daos.DaoInterface
-		ok  bool
-	)
 	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
 		api.RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
 		return
@@ -113,7 +103,7 @@ func CreateCustomerEndpoint(w http.ResponseWriter, r *http.Request) {
 	if userWithDetails.CustomerID != "" {
 		customerID = userWithDetails.CustomerID
 	} else {
-		customerID, err = createCustomer(user.Email)
+		customerID, err = client.CreateCustomer(user.Email)
 		if err != nil {
 			api.RespondWithError(w, http.StatusInternalServerError, err.Error())
 			return
@@ -139,7 +129,7 @@ func CreateCustomerEndpoint(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	sources, err := getPaymentMethodsForCustomer(customerID)
+	sources, err := client.PaymentMethods(customerID)
 	if err != nil {
 		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
@@ -152,49 +142,13 @@ func CreateCustomerEndpoint(w http.ResponseWriter, r *http.Request) {
 	api.RespondWithJson(w, http.StatusOK, customer)
 }
 
-func getPaymentMethodsForCustomer(customerID string) ([]models.PaymentMethod, error) {
-	stripe.Key = os.Getenv("STRIPE_SECRET")
-	if stripe.Key == "" {
-		return nil, fmt.Errorf("missing stripe secret")
-	}
-
-	c, err := customer.Get(customerID, nil)
-	if err != nil {
-		return nil, err
-	}
-	var defaultPaymentMethodID *string
-	if c.InvoiceSettings.DefaultPaymentMethod != nil {
-		defaultPaymentMethodID = &c.InvoiceSettings.DefaultPaymentMethod.ID
-	}
-	listParams := &stripe.PaymentMethodListParams{
-		Customer: stripe.String(customerID),
-		Type:     stripe.String("card"), // Filter to retrieve only card payment methods
-	}
-
-	// List payment methods associated with the customer
-	iter := paymentmethod.List(listParams)
-
-	var methods []models.PaymentMethod
-	for iter.Next() {
-		pm := iter.PaymentMethod()
-		localPM := models.PaymentMethod{}
-		localPM.Id = pm.ID
-		localPM.Brand = pm.Card.Brand
-		localPM.LastFour = pm.Card.Last4
-		localPM.ExpirationMonth = pm.Card.ExpMonth
-		localPM.ExpirationYear = pm.Card.ExpYear
-		if defaultPaymentMethodID != nil && *defaultPaymentMethodID == pm.ID {
-			localPM.IsDefault = true
-		}
-		methods = append(methods, localPM)
-	}
-	return methods, nil
-}
-
 func UpdateCustomerPaymentMethodEndpoint(w http.ResponseWriter, r *http.Request) {
-	stripe.Key = os.Getenv("STRIPE_SECRET")
-	if stripe.Key == "" {
-		api.RespondWithError(w, http.StatusNoContent, "missing stripe secret")
+	var (
+		client payments.Client
+		ok     bool
+	)
+	if client, ok = r.Context().Value(ctxkey.Billing).(payments.Client); !ok {
+		api.RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve billing client from context")
 		return
 	}
 	var requestBody map[string]string
@@ -206,67 +160,123 @@ func UpdateCustomerPaymentMethodEndpoint(w http.ResponseWriter, r *http.Request)
 	paymentMethodID := requestBody["payment_method_id"]
 	customerID := requestBody["customer_id"]
 
-	// Retrieve the list of payment methods for the customer
-	listParams := &stripe.PaymentMethodListParams{
-		Customer: stripe.String(customerID),
-		Type:     stripe.String("card"), // Filter to retrieve only card payment methods
+	if err := client.SetDefaultPaymentMethod(customerID, paymentMethodID); err != nil {
+		fmt.Println("Error updating customer:", err)
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
 	}
+	api.RespondWithJson(w, http.StatusOK, nil)
+}
 
-	pmList := paymentmethod.List(listParams)
-
-	// Check if the payment method is already attached to the customer
-	attached := false
-
-	for pmList.Next() {
-		pm := pmList.PaymentMethod()
-		if pm.ID == paymentMethodID {
-			attached = true
-			break
-		}
+// ApplyPromotionCodeEndpoint redeems a promotion code against the signed in user's
+// subscription, or against their next one when they have none
+func ApplyPromotionCodeEndpoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		client payments.Client
+		dao    daos.DaoInterface
+		ok     bool
+	)
+	if client, ok = r.Context().Value(ctxkey.Billing).(payments.Client); !ok {
+		api.RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve billing client from context")
+		return
 	}
-
-	if err := pmList.Err(); err != nil {
-		fmt.Println("Error listing payment methods:", err)
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		api.RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	var requestBody map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&requestBody); err != nil || strings.TrimSpace(requestBody["code"]) == "" {
+		api.RespondWithError(w, http.StatusBadRequest, "missing or invalid promotion code")
+		return
+	}
+	email, err := signedInEmail(r)
+	if err != nil {
+		api.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	user, err := dao.GetUserDetails(email)
+	if err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	discount, err := applyPromotionCode(client, *user, strings.TrimSpace(requestBody["code"]))
+	switch {
+	case errors.Is(err, ErrNoCustomer):
+		api.RespondWithError(w, http.StatusConflict, err.Error())
+		return
+	case errors.Is(err, payments.ErrInvalidCode):
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	case err != nil:
 		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	api.RespondWithJson(w, http.StatusOK, discount)
+}
 
-	if !attached {
-		// Payment method is not attached to the specified customer, so attach it
-		attachParams := &stripe.PaymentMethodAttachParams{
-			Customer: stripe.String(customerID),
-		}
-		_, err := paymentmethod.Attach(paymentMethodID, attachParams)
-		if err != nil {
-			fmt.Println("Error attaching payment method:", err)
-			api.RespondWithError(w, http.StatusInternalServerError, err.Error())
-			return
-		}
+func applyPromotionCode(client payments.Client, user models.UserInfo, code string) (*models.Discount, error) {
+	if user.CustomerID == "" {
+		return nil, ErrNoCustomer
+	}
+	subscriptionID := user.SubscriptionID
+	if user.Expired {
+		subscriptionID = ""
 	}
+	return client.ApplyPromotionCode(user.CustomerID, subscriptionID, code)
+}
 
-	params := &stripe.CustomerParams{
-		InvoiceSettings: &stripe.CustomerInvoiceSettingsParams{
-			DefaultPaymentMethod: stripe.String(paymentMethodID),
-		},
+// CreatePortalSessionEndpoint opens a Stripe customer portal session for the signed in user, which
+// returns them to the app when they are done
+func CreatePortalSessionEndpoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		client payments.Client
+		dao    daos.DaoInterface
+		ok     bool
+	)
+	if client, ok = r.Context().Value(ctxkey.Billing).(payments.Client); !ok {
+		api.RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve billing client from context")
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		api.RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
 	}
-	_, err := customer.Update(customerID, params)
+	email, err := signedInEmail(r)
+	if err != nil {
+		api.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	user, err := dao.GetUserDetails(email)
 	if err != nil {
-		fmt.Println("Error updating customer:", err)
 		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	api.RespondWithJson(w, http.StatusOK, nil)
+	if user.CustomerID == "" {
+		api.RespondWithError(w, http.StatusConflict, ErrNoCustomer.Error())
+		return
+	}
+	url, err := client.CreatePortalSession(user.CustomerID, os.Getenv("ROOT_URL"))
+	if err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	api.RespondWithJson(w, http.StatusOK, models.PortalSession{URL: url})
 }
 
-func createCustomer(email string) (string, error) {
-	params := &stripe.CustomerParams{
-		Email: &email,
-		//DefaultSource: "",
+// signedInEmail reads the signed in user's email from their session. Their billing state is read
+// from the database, as the session's copy can be out of date.
+func signedInEmail(r *http.Request) (string, error) {
+	token, err := sessions.Get(r, "token")
+	if err != nil || token.IsNew {
+		return "", errors.New("cannot find token")
 	}
-	c, err := customer.New(params)
-	if err != nil {
+	tokenData, ok := token.Values["token_data"].([]byte)
+	if !ok {
+		return "", errors.New("cannot find token")
+	}
+	var user models.UserInfo
+	if err := json.Unmarshal(tokenData, &user); err != nil {
 		return "", err
 	}
-	log.Println("created new customer", c)
-	return c.ID, nil
+	return user.Email, nil
 }
@@ -0,0 +1,49 @@
+package billing
+
+import (
+	"RichDocter/api"
+	ctxkey "RichDocter/ctxkeys"
+	"RichDocter/daos"
+	"RichDocter/models"
+	"RichDocter/payments"
+	"net/http"
+)
+
+// ListInvoicesEndpoint lists the signed in user's invoices, newest first, with links to each
+// receipt's PDF
+func ListInvoicesEndpoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		client payments.Client
+		dao    daos.DaoInterface
+		ok     bool
+	)
+	if client, ok = r.Context().Value(ctxkey.Billing).(payments.Client); !ok {
+		api.RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve billing client from context")
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		api.RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	email, err := signedInEmail(r)
+	if err != nil {
+		api.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	user, err := dao.GetUserDetails(email)
+	if err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if user.CustomerID == "" {
+		// never been billed
+		api.RespondWithJson(w, http.StatusOK, []models.Invoice{})
+		return
+	}
+	invoices, err := client.Invoices(user.CustomerID)
+	if err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	api.RespondWithJson(w, http.StatusOK, invoices)
+}
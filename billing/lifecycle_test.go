@@ -0,0 +1,362 @@
+package billing
+
+import (
+	ctxkey "RichDocter/ctxkeys"
+	"RichDocter/daos"
+	"RichDocter/models"
+	"RichDocter/payments"
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBillingDAO keeps the users and stories the billing flows touch in memory
+type fakeBillingDAO struct {
+	daos.DaoInterface
+	mu          sync.Mutex
+	users       map[string]*models.UserInfo
+	eventAt     map[string]int64
+	stories     []*models.Story
+	suspended   map[string]bool
+	softDeletes chan string
+}
+
+func newFakeBillingDAO(email string) *fakeBillingDAO {
+	return &fakeBillingDAO{
+		users:       map[string]*models.UserInfo{email: {Email: email}},
+		eventAt:     map[string]int64{},
+		stories:     []*models.Story{{ID: "s1"}, {ID: "s2"}},
+		suspended:   map[string]bool{},
+		softDeletes: make(chan string, 10),
+	}
+}
+
+func (d *fakeBillingDAO) GetUserDetails(email string) (*models.UserInfo, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	user := *d.users[email]
+	return &user, nil
+}
+
+func (d *fakeBillingDAO) GetUserByCustomerID(customerID string) (*models.UserInfo, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, user := range d.users {
+		if user.CustomerID == customerID {
+			found := *user
+			return &found, nil
+		}
+	}
+	return nil, daos.ErrUnknownCustomer
+}
+
+func (d *fakeBillingDAO) UpdateUser(user models.UserInfo) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.users[user.Email] = &user
+	return nil
+}
+
+func (d *fakeBillingDAO) UpdateSubscriptionState(email string, state models.SubscriptionState) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if state.EventAt < d.eventAt[email] {
+		return daos.ErrStaleSubscriptionEvent
+	}
+	d.eventAt[email] = state.EventAt
+	user := d.users[email]
+	user.SubscriptionID = state.SubscriptionID
+	if state.PriceID != "" {
+		user.PriceID = state.PriceID
+	}
+	user.Expired = state.Expired
+	user.Renewing = state.Renewing
+	return nil
+}
+
+func (d *fakeBillingDAO) GetAllStories(email string) ([]*models.Story, error) {
+	return d.stories, nil
+}
+
+func (d *fakeBillingDAO) SoftDeleteStory(email, storyID string, automated bool) error {
+	d.mu.Lock()
+	d.suspended[storyID] = automated
+	d.mu.Unlock()
+	d.softDeletes <- storyID
+	return nil
+}
+
+func (d *fakeBillingDAO) CheckForSuspendedStories(email string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.suspended) > 0, nil
+}
+
+func (d *fakeBillingDAO) RestoreAutomaticallyDeletedStories(email string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.suspended = map[string]bool{}
+	return nil
+}
+
+// deliver posts every event the fake provider has queued to the webhook
+func deliver(t *testing.T, client *payments.FakeClient, dao *fakeBillingDAO, entitlements *payments.EntitlementCache) {
+	t.Helper()
+	for _, payload := range client.Deliveries() {
+		req := httptest.NewRequest(http.MethodPost, "/billing/webhook", bytes.NewReader(payload))
+		req.Header.Set("Stripe-Signature", payments.FakeSignature)
+		ctx := context.WithValue(req.Context(), ctxkey.DAO, dao)
+		ctx = context.WithValue(ctx, ctxkey.Billing, client)
+		ctx = context.WithValue(ctx, ctxkey.Entitlements, entitlements)
+		rec := httptest.NewRecorder()
+		WebhookEndpoint(rec, req.WithContext(ctx))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected the webhook to accept %s, got %d: %s", payload, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestSubscriptionLifecycle(t *testing.T) {
+	const (
+		email = "owner@example.com"
+		month = 31 * 24 * time.Hour
+	)
+	testCases := []struct {
+		name            string
+		run             func(t *testing.T, client *payments.FakeClient, dao *fakeBillingDAO, user *models.UserInfo, deliver func())
+		priceID         string
+		wantEntitlement models.Entitlement
+		wantSuspended   int
+	}{
+		{
+			name: "Subscribe",
+			run: func(t *testing.T, client *payments.FakeClient, dao *fakeBillingDAO, user *models.UserInfo, deliver func()) {
+			},
+			wantEntitlement: models.Entitlement{Tier: models.TierWriter, Subscribed: true, Renewing: true},
+		},
+		{
+			name: "SubscribeToStudio",
+			run: func(t *testing.T, client *payments.FakeClient, dao *fakeBillingDAO, user *models.UserInfo, deliver func()) {
+			},
+			priceID:         "price_studio",
+			wantEntitlement: models.Entitlement{Tier: models.TierStudio, Subscribed: true, Renewing: true},
+		},
+		{
+			name: "Renew",
+			run: func(t *testing.T, client *payments.FakeClient, dao *fakeBillingDAO, user *models.UserInfo, deliver func()) {
+				client.Advance(month)
+			},
+			wantEntitlement: models.Entitlement{Tier: models.TierWriter, Subscribed: true, Renewing: true},
+		},
+		{
+			name: "Cancel",
+			run: func(t *testing.T, client *payments.FakeClient, dao *fakeBillingDAO, user *models.UserInfo, deliver func()) {
+				if err := payments.SetRenewing(client, user, false); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantEntitlement: models.Entitlement{Tier: models.TierWriter, Subscribed: true},
+		},
+		{
+			name: "ResumeBeforePeriodEnd",
+			run: func(t *testing.T, client *payments.FakeClient, dao *fakeBillingDAO, user *models.UserInfo, deliver func()) {
+				subscriptionID := user.SubscriptionID
+				if err := payments.SetRenewing(client, user, false); err != nil {
+					t.Fatal(err)
+				}
+				if err := payments.SetRenewing(client, user, true); err != nil {
+					t.Fatal(err)
+				}
+				if user.SubscriptionID != subscriptionID {
+					t.Errorf("expected subscription %s to be resumed, got %s", subscriptionID, user.SubscriptionID)
+				}
+				client.Advance(month)
+			},
+			wantEntitlement: models.Entitlement{Tier: models.TierWriter, Subscribed: true, Renewing: true},
+		},
+		{
+			name: "LapseAfterCancel",
+			run: func(t *testing.T, client *payments.FakeClient, dao *fakeBillingDAO, user *models.UserInfo, deliver func()) {
+				if err := payments.SetRenewing(client, user, false); err != nil {
+					t.Fatal(err)
+				}
+				client.Advance(month)
+			},
+			wantEntitlement: models.Entitlement{Tier: models.TierFree, Expired: true},
+			wantSuspended:   1,
+		},
+		{
+			name: "LapseAfterFailedPayment",
+			run: func(t *testing.T, client *payments.FakeClient, dao *fakeBillingDAO, user *models.UserInfo, deliver func()) {
+				client.FailPayments = true
+				client.Advance(month)
+			},
+			wantEntitlement: models.Entitlement{Tier: models.TierFree, Expired: true},
+			wantSuspended:   1,
+		},
+		{
+			name: "ResubscribeAfterLapse",
+			run: func(t *testing.T, client *payments.FakeClient, dao *fakeBillingDAO, user *models.UserInfo, deliver func()) {
+				if _, err := client.CancelSubscription(user.SubscriptionID, false); err != nil {
+					t.Fatal(err)
+				}
+				deliver()
+				<-dao.softDeletes
+				paymentMethodID, err := client.AddPaymentMethod(user.CustomerID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err = subscribe(client, dao, *user, user.CustomerID, "price_writer", paymentMethodID); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantEntitlement: models.Entitlement{Tier: models.TierWriter, Subscribed: true, Renewing: true},
+		},
+		{
+			name: "Refund",
+			run: func(t *testing.T, client *payments.FakeClient, dao *fakeBillingDAO, user *models.UserInfo, deliver func()) {
+				invoices, err := client.Invoices(user.CustomerID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err = client.RefundInvoice(invoices[0].ID); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantEntitlement: models.Entitlement{Tier: models.TierFree, Expired: true},
+			wantSuspended:   1,
+		},
+		{
+			name: "RefundOfReplacedSubscription",
+			run: func(t *testing.T, client *payments.FakeClient, dao *fakeBillingDAO, user *models.UserInfo, deliver func()) {
+				invoices, err := client.Invoices(user.CustomerID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err = client.CancelSubscription(user.SubscriptionID, false); err != nil {
+					t.Fatal(err)
+				}
+				deliver()
+				<-dao.softDeletes
+				paymentMethodID, err := client.AddPaymentMethod(user.CustomerID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err = subscribe(client, dao, *user, user.CustomerID, "price_writer", paymentMethodID); err != nil {
+					t.Fatal(err)
+				}
+				deliver()
+				if err = client.RefundInvoice(invoices[0].ID); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantEntitlement: models.Entitlement{Tier: models.TierWriter, Subscribed: true, Renewing: true},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			client := payments.NewFakeClient(time.Unix(1700000000, 0))
+			client.ProductList = []models.Product{
+				{PriceID: "price_writer", Tier: models.TierWriter},
+				{PriceID: "price_studio", Tier: models.TierStudio},
+			}
+			dao := newFakeBillingDAO(email)
+			entitlements := payments.NewEntitlementCache(payments.NewPlans(client, time.Hour), time.Hour)
+			entitlement := func() models.Entitlement {
+				got, err := entitlements.Get(email, func() (*models.UserInfo, error) {
+					return dao.GetUserDetails(email)
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return got
+			}
+			if got := entitlement(); got.Subscribed || got.Tier != models.TierFree {
+				t.Fatalf("expected the free plan before subscribing, got %+v", got)
+			}
+			priceID := tc.priceID
+			if priceID == "" {
+				priceID = "price_writer"
+			}
+
+			customerID, err := client.CreateCustomer(email)
+			if err != nil {
+				t.Fatal(err)
+			}
+			paymentMethodID, err := client.AddPaymentMethod(customerID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			user, _ := dao.GetUserDetails(email)
+			user.CustomerID = customerID
+			if _, err = subscribe(client, dao, *user, customerID, priceID, paymentMethodID); err != nil {
+				t.Fatal(err)
+			}
+			if _, err = subscribe(client, dao, *user, customerID, priceID, paymentMethodID); err != ErrDuplicateSubscription {
+				t.Fatalf("expected a second subscription to be refused, got %v", err)
+			}
+			deliver(t, client, dao, entitlements)
+			if !entitlement().Subscribed {
+				t.Fatalf("expected a subscription after subscribing")
+			}
+
+			user, _ = dao.GetUserDetails(email)
+			tc.run(t, client, dao, user, func() { deliver(t, client, dao, entitlements) })
+			deliver(t, client, dao, entitlements)
+
+			// the cached entitlement outlives the test unless the webhook invalidates it
+			got := entitlement()
+			want := tc.wantEntitlement
+			if got.Tier != want.Tier || got.Subscribed != want.Subscribed || got.Expired != want.Expired || got.Renewing != want.Renewing {
+				t.Errorf("expected entitlement %+v, got %+v", want, got)
+			}
+			if got.Limits.Stories != payments.LimitsFor(want.Tier).Stories {
+				t.Errorf("expected the %s plan's limits, got %+v", want.Tier, got.Limits)
+			}
+			for i := 0; i < tc.wantSuspended; i++ {
+				select {
+				case <-dao.softDeletes:
+				case <-time.After(time.Second):
+					t.Fatalf("expected %d stories suspended", tc.wantSuspended)
+				}
+			}
+			dao.mu.Lock()
+			suspended := len(dao.suspended)
+			dao.mu.Unlock()
+			if suspended != tc.wantSuspended {
+				t.Errorf("expected %d stories suspended, got %d", tc.wantSuspended, suspended)
+			}
+		})
+	}
+}
+
+func TestWebhookRejectsUnsignedDeliveries(t *testing.T) {
+	client := payments.NewFakeClient(time.Unix(1700000000, 0))
+	dao := newFakeBillingDAO("owner@example.com")
+	customerID, _ := client.CreateCustomer("owner@example.com")
+	paymentMethodID, _ := client.AddPaymentMethod(customerID)
+	if _, err := client.CreateSubscription(customerID, "price_writer", paymentMethodID); err != nil {
+		t.Fatal(err)
+	}
+	for _, payload := range client.Deliveries() {
+		req := httptest.NewRequest(http.MethodPost, "/billing/webhook", bytes.NewReader(payload))
+		req.Header.Set("Stripe-Signature", "forged")
+		ctx := context.WithValue(req.Context(), ctxkey.DAO, dao)
+		ctx = context.WithValue(ctx, ctxkey.Billing, client)
+		ctx = context.WithValue(ctx, ctxkey.Entitlements, payments.NewEntitlementCache(payments.NewPlans(client, time.Hour), time.Hour))
+		rec := httptest.NewRecorder()
+		WebhookEndpoint(rec, req.WithContext(ctx))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("expected a forged delivery to be refused, got %d", rec.Code)
+		}
+	}
+	if user, _ := dao.GetUserDetails("owner@example.com"); user.SubscriptionID != "" {
+		t.Errorf("expected a forged delivery to change nothing, got subscription %s", user.SubscriptionID)
+	}
+}
@@ -0,0 +1,187 @@
+package billing
+
+import (
+	"RichDocter/api"
+	ctxkey "RichDocter/ctxkeys"
+	"RichDocter/daos"
+	"RichDocter/models"
+	"RichDocter/payments"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	stripe "github.com/stripe/stripe-go/v72"
+)
+
+var (
+	ErrNoSubscription   = errors.New("no live subscription to change")
+	ErrSamePlan         = errors.New("already subscribed at that price")
+	ErrUnknownPrice     = errors.New("price is not on offer")
+	ErrExpiredProration = errors.New("plan change preview has expired, preview it again")
+)
+
+// a plan change can be applied as previewed for this long. Prorating from an earlier date would
+// credit time already used on the old plan.
+const prorationWindow = 10 * time.Minute
+
+// PreviewPlanChangeEndpoint prices an upgrade or downgrade as of now without making it. The proration
+// date in the response can be passed to ChangePlanEndpoint, within a few minutes, to be billed exactly
+// what was previewed.
+func PreviewPlanChangeEndpoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		client payments.Client
+		dao    daos.DaoInterface
+		ok     bool
+	)
+	if client, ok = r.Context().Value(ctxkey.Billing).(payments.Client); !ok {
+		api.RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve billing client from context")
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		api.RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	change := models.PlanChange{}
+	if err := json.NewDecoder(r.Body).Decode(&change); err != nil || change.PriceID == "" {
+		api.RespondWithError(w, http.StatusBadRequest, "missing or invalid price id")
+		return
+	}
+	email, err := signedInEmail(r)
+	if err != nil {
+		api.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	user, err := dao.GetUserDetails(email)
+	if err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	preview, err := previewPlanChange(client, *user, change.PriceID, time.Now())
+	if err != nil {
+		respondWithPlanChangeError(w, err)
+		return
+	}
+	api.RespondWithJson(w, http.StatusOK, preview)
+}
+
+// ChangePlanEndpoint moves the signed in user's subscription to another price. The proration is
+// billed with the next invoice and the new plan's limits apply straight away.
+func ChangePlanEndpoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		client       payments.Client
+		dao          daos.DaoInterface
+		entitlements *payments.EntitlementCache
+		ok           bool
+	)
+	if client, ok = r.Context().Value(ctxkey.Billing).(payments.Client); !ok {
+		api.RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve billing client from context")
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		api.RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	if entitlements, ok = r.Context().Value(ctxkey.Entitlements).(*payments.EntitlementCache); !ok {
+		api.RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve entitlements from context")
+		return
+	}
+	change := models.PlanChange{}
+	if err := json.NewDecoder(r.Body).Decode(&change); err != nil || change.PriceID == "" {
+		api.RespondWithError(w, http.StatusBadRequest, "missing or invalid price id")
+		return
+	}
+	email, err := signedInEmail(r)
+	if err != nil {
+		api.RespondWithError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	user, err := dao.GetUserDetails(email)
+	if err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	sb, err := changePlan(client, dao, *user, change, time.Now())
+	if err != nil {
+		respondWithPlanChangeError(w, err)
+		return
+	}
+	entitlements.Invalidate(user.Email)
+
+	results := models.SubscriptionResults{}
+	results.SubscriptionID = sb.ID
+	results.PeriodStart = time.Unix(sb.CurrentPeriodStart, 0)
+	results.PeriodEnd = time.Unix(sb.CurrentPeriodEnd, 0)
+	api.RespondWithJson(w, http.StatusOK, results)
+}
+
+func previewPlanChange(client payments.Client, user models.UserInfo, priceID string, now time.Time) (*models.PlanChangePreview, error) {
+	product, err := planChangeProduct(client, user, priceID)
+	if err != nil {
+		return nil, err
+	}
+	preview, err := client.PreviewPlanChange(user.SubscriptionID, priceID, now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	preview.Tier = product.Tier
+	return preview, nil
+}
+
+// changePlan moves the user's subscription to another price and records it against the user straight
+// away rather than waiting on the webhook. Without a proration date from a recent preview the change
+// is prorated from now.
+func changePlan(client payments.Client, dao daos.DaoInterface, user models.UserInfo, change models.PlanChange, now time.Time) (*stripe.Subscription, error) {
+	if change.ProrationDate == 0 {
+		change.ProrationDate = now.Unix()
+	}
+	if change.ProrationDate > now.Unix() || change.ProrationDate < now.Add(-prorationWindow).Unix() {
+		return nil, ErrExpiredProration
+	}
+	if _, err := planChangeProduct(client, user, change.PriceID); err != nil {
+		return nil, err
+	}
+	sb, err := client.ChangePlan(user.SubscriptionID, change.PriceID, change.ProrationDate)
+	if err != nil {
+		return nil, err
+	}
+	user.PriceID = change.PriceID
+	if err = dao.UpdateUser(user); err != nil {
+		return nil, err
+	}
+	return sb, nil
+}
+
+// planChangeProduct checks the user has a subscription that can move to the price, and returns the
+// product the price is for
+func planChangeProduct(client payments.Client, user models.UserInfo, priceID string) (*models.Product, error) {
+	if user.SubscriptionID == "" || user.Expired {
+		return nil, ErrNoSubscription
+	}
+	if user.PriceID == priceID {
+		return nil, ErrSamePlan
+	}
+	products, err := client.Products()
+	if err != nil {
+		return nil, err
+	}
+	for _, product := range products {
+		if product.PriceID == priceID {
+			return &product, nil
+		}
+	}
+	return nil, ErrUnknownPrice
+}
+
+func respondWithPlanChangeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNoSubscription), errors.Is(err, ErrSamePlan):
+		api.RespondWithError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, ErrUnknownPrice), errors.Is(err, ErrExpiredProration):
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, payments.ErrNotFound):
+		api.RespondWithError(w, http.StatusNotFound, err.Error())
+	default:
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+	}
+}
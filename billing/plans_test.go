@@ -0,0 +1,204 @@
+package billing
+
+import (
+	"RichDocter/models"
+	"RichDocter/payments"
+	"errors"
+	"testing"
+	"time"
+
+	stripe "github.com/stripe/stripe-go/v72"
+)
+
+// newSubscriber signs a user up at a price with the fake provider, with a month at $10 for the writer
+// plan and $30 for studio
+func newSubscriber(t *testing.T, email, priceID string) (*payments.FakeClient, *fakeBillingDAO, *payments.EntitlementCache) {
+	t.Helper()
+	client := payments.NewFakeClient(time.Unix(1700000000, 0))
+	client.ProductList = []models.Product{
+		{PriceID: "price_writer", Tier: models.TierWriter, BillingAmount: "10.00"},
+		{PriceID: "price_studio", Tier: models.TierStudio, BillingAmount: "30.00"},
+	}
+	dao := newFakeBillingDAO(email)
+	entitlements := payments.NewEntitlementCache(payments.NewPlans(client, time.Hour), time.Hour)
+	customerID, err := client.CreateCustomer(email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, _ := dao.GetUserDetails(email)
+	user.CustomerID = customerID
+	if err = dao.UpdateUser(*user); err != nil {
+		t.Fatal(err)
+	}
+	if priceID == "" {
+		return client, dao, entitlements
+	}
+	paymentMethodID, err := client.AddPaymentMethod(customerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = subscribe(client, dao, *user, customerID, priceID, paymentMethodID); err != nil {
+		t.Fatal(err)
+	}
+	deliver(t, client, dao, entitlements)
+	return client, dao, entitlements
+}
+
+func TestPlanChange(t *testing.T) {
+	const email = "owner@example.com"
+	testCases := []struct {
+		name          string
+		from          string
+		to            string
+		lapse         bool
+		backdate      time.Duration
+		wantErr       error
+		wantChangeErr error
+		wantProration int64
+		wantAmountDue int64
+		wantTier      string
+	}{
+		{name: "Upgrade", from: "price_writer", to: "price_studio", wantProration: 1000, wantAmountDue: 4000, wantTier: models.TierStudio},
+		{name: "Downgrade", from: "price_studio", to: "price_writer", wantProration: -1000, wantAmountDue: 0, wantTier: models.TierWriter},
+		{name: "SamePlan", from: "price_writer", to: "price_writer", wantErr: ErrSamePlan},
+		{name: "UnknownPrice", from: "price_writer", to: "price_retired", wantErr: ErrUnknownPrice},
+		{name: "AfterLapse", from: "price_writer", to: "price_studio", lapse: true, wantErr: ErrNoSubscription},
+		{name: "BackdatedDowngrade", from: "price_studio", to: "price_writer", backdate: 15 * 24 * time.Hour, wantChangeErr: ErrExpiredProration},
+		{name: "FutureProrationDate", from: "price_writer", to: "price_studio", backdate: -time.Hour, wantChangeErr: ErrExpiredProration},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			client, dao, entitlements := newSubscriber(t, email, tc.from)
+			if tc.lapse {
+				user, _ := dao.GetUserDetails(email)
+				if _, err := client.CancelSubscription(user.SubscriptionID, false); err != nil {
+					t.Fatal(err)
+				}
+				deliver(t, client, dao, entitlements)
+				<-dao.softDeletes
+			}
+			// half way through the period
+			client.Advance(15 * 24 * time.Hour)
+			user, _ := dao.GetUserDetails(email)
+
+			preview, err := previewPlanChange(client, *user, tc.to, client.Now())
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected preview error %v, got %v", tc.wantErr, err)
+			}
+			// apply the change as previewed, or with the date moved by backdate
+			prorationDate := client.Now().Unix()
+			if preview != nil {
+				prorationDate = preview.ProrationDate
+			}
+			change := models.PlanChange{PriceID: tc.to, ProrationDate: prorationDate - int64(tc.backdate.Seconds())}
+			wantChangeErr := tc.wantChangeErr
+			if wantChangeErr == nil {
+				wantChangeErr = tc.wantErr
+			}
+			if _, err = changePlan(client, dao, *user, change, client.Now()); !errors.Is(err, wantChangeErr) {
+				t.Fatalf("expected change error %v, got %v", wantChangeErr, err)
+			}
+			if wantChangeErr != nil {
+				return
+			}
+			if preview.Proration != tc.wantProration || preview.AmountDue != tc.wantAmountDue || preview.Tier != tc.wantTier {
+				t.Errorf("expected proration %d, amount due %d and tier %s, got %+v", tc.wantProration, tc.wantAmountDue, tc.wantTier, preview)
+			}
+
+			entitlements.Invalidate(email)
+			if got, _ := entitlements.Get(email, func() (*models.UserInfo, error) { return dao.GetUserDetails(email) }); got.Tier != tc.wantTier {
+				t.Errorf("expected the %s plan straight after changing, got %s", tc.wantTier, got.Tier)
+			}
+			deliver(t, client, dao, entitlements)
+			if user, _ = dao.GetUserDetails(email); user.PriceID != tc.to {
+				t.Errorf("expected price %s after the webhook, got %s", tc.to, user.PriceID)
+			}
+
+			// the renewal bills what the preview showed
+			client.Advance(15 * 24 * time.Hour)
+			deliver(t, client, dao, entitlements)
+			invoices, err := client.Invoices(user.CustomerID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(invoices) != 2 {
+				t.Fatalf("expected the first and renewal invoices, got %+v", invoices)
+			}
+			if invoices[0].AmountDue != preview.AmountDue {
+				t.Errorf("expected the renewal to bill the previewed %d, got %d", preview.AmountDue, invoices[0].AmountDue)
+			}
+			if invoices[0].PDFURL == "" || invoices[0].Status != string(stripe.InvoiceStatusPaid) {
+				t.Errorf("expected a paid invoice with a receipt, got %+v", invoices[0])
+			}
+		})
+	}
+}
+
+func TestApplyPromotionCode(t *testing.T) {
+	const email = "owner@example.com"
+	testCases := []struct {
+		name          string
+		priceID       string
+		code          string
+		noCustomer    bool
+		wantErr       error
+		wantAmountDue int64
+	}{
+		{name: "Subscription", priceID: "price_writer", code: "HALFOFF", wantAmountDue: 500},
+		{name: "UnpublishedCouponID", priceID: "price_writer", code: "coupon_half", wantErr: payments.ErrInvalidCode},
+		{name: "NextSubscription", code: "FIVEOFF", wantAmountDue: 500},
+		{name: "OnceOnly", priceID: "price_writer", code: "FIVEOFF", wantAmountDue: 500},
+		{name: "InvalidCode", priceID: "price_writer", code: "NOTACODE", wantErr: payments.ErrInvalidCode},
+		{name: "NoCustomer", code: "HALFOFF", noCustomer: true, wantErr: ErrNoCustomer},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			client, dao, entitlements := newSubscriber(t, email, tc.priceID)
+			client.AddPromotionCode("HALFOFF", stripe.Coupon{ID: "coupon_half", PercentOff: 50, Duration: stripe.CouponDurationForever})
+			client.AddPromotionCode("FIVEOFF", stripe.Coupon{AmountOff: 500, Currency: stripe.CurrencyUSD, Duration: stripe.CouponDurationOnce})
+			user, _ := dao.GetUserDetails(email)
+			if tc.noCustomer {
+				user.CustomerID = ""
+			}
+
+			discount, err := applyPromotionCode(client, *user, tc.code)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if tc.wantErr != nil {
+				return
+			}
+			if discount.Code != tc.code || discount.CouponID == "" {
+				t.Errorf("expected the discount for %s, got %+v", tc.code, discount)
+			}
+
+			if tc.priceID == "" {
+				paymentMethodID, _ := client.AddPaymentMethod(user.CustomerID)
+				if _, err = subscribe(client, dao, *user, user.CustomerID, "price_writer", paymentMethodID); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				client.Advance(31 * 24 * time.Hour)
+			}
+			deliver(t, client, dao, entitlements)
+			invoices, err := client.Invoices(user.CustomerID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if invoices[0].AmountDue != tc.wantAmountDue {
+				t.Errorf("expected the discounted %d billed, got %d", tc.wantAmountDue, invoices[0].AmountDue)
+			}
+
+			// a coupon for one invoice is gone by the next
+			client.Advance(31 * 24 * time.Hour)
+			invoices, _ = client.Invoices(user.CustomerID)
+			if tc.code == "FIVEOFF" && invoices[0].AmountDue != 1000 {
+				t.Errorf("expected the full price once the coupon was used, got %d", invoices[0].AmountDue)
+			}
+		})
+	}
+}
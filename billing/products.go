@@ -2,46 +2,24 @@ package billing
 
 import (
 	"RichDocter/api"
-	"RichDocter/models"
-	"fmt"
+	ctxkey "RichDocter/ctxkeys"
+	"RichDocter/payments"
 	"net/http"
-	"os"
-
-	stripe "github.com/stripe/stripe-go/v72"
-	"github.com/stripe/stripe-go/v72/price"
-	"github.com/stripe/stripe-go/v72/product"
 )
 
 func GetProductsEndpoint(w http.ResponseWriter, r *http.Request) {
-	stripe.Key = os.Getenv("STRIPE_SECRET")
-	if stripe.Key == "" {
-		api.RespondWithError(w, http.StatusNoContent, "missing stripe secret")
+	var (
+		client payments.Client
+		ok     bool
+	)
+	if client, ok = r.Context().Value(ctxkey.Billing).(payments.Client); !ok {
+		api.RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve billing client from context")
 		return
 	}
-	var products []models.Product
-
-	productListParams := &stripe.ProductListParams{}
-	prodIterator := product.List(productListParams)
-	var product models.Product
-	for prodIterator.Next() {
-
-		prod := prodIterator.Product()
-		if prod.DefaultPrice != nil {
-			product.ProductID = prod.ID
-			product.Name = prod.Name
-			product.Description = prod.Description
-			p, err := price.Get(prod.DefaultPrice.ID, nil)
-			if err != nil {
-				api.RespondWithError(w, http.StatusInternalServerError, err.Error())
-				return
-			}
-			product.BillingAmount = fmt.Sprintf("%.2f", float64(p.UnitAmount)/100)
-			product.BillingFrequency = string(p.Recurring.Interval)
-			product.PriceID = p.ID
-
-			products = append(products, product)
-		}
-
+	products, err := client.Products()
+	if err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
 	}
 	api.RespondWithJson(w, http.StatusOK, products)
 }
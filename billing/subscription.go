@@ -5,23 +5,34 @@ import (
 	ctxkey "RichDocter/ctxkeys"
 	"RichDocter/daos"
 	"RichDocter/models"
+	"RichDocter/payments"
 	"RichDocter/sessions"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"net/http"
-	"os"
 	"time"
 
 	stripe "github.com/stripe/stripe-go/v72"
-	"github.com/stripe/stripe-go/v72/customer"
-	"github.com/stripe/stripe-go/v72/sub"
-	"github.com/stripe/stripe-go/v72/subitem"
+)
+
+var (
+	ErrDuplicateSubscription = errors.New("duplicate subscription")
+	ErrPaymentFailed         = errors.New("subscription payment failed")
 )
 
 func SubscribeCustomerEndpoint(w http.ResponseWriter, r *http.Request) {
-	stripe.Key = os.Getenv("STRIPE_SECRET")
-	if stripe.Key == "" {
-		api.RespondWithError(w, http.StatusNoContent, "missing stripe secret")
+	var (
+		client       payments.Client
+		dao          daos.DaoInterface
+		entitlements *payments.EntitlementCache
+		ok           bool
+	)
+	if client, ok = r.Context().Value(ctxkey.Billing).(payments.Client); !ok {
+		api.RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve billing client from context")
+		return
+	}
+	if entitlements, ok = r.Context().Value(ctxkey.Entitlements).(*payments.EntitlementCache); !ok {
+		api.RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve entitlements from context")
 		return
 	}
 	token, err := sessions.Get(r, "token")
@@ -40,126 +51,59 @@ func SubscribeCustomerEndpoint(w http.ResponseWriter, r *http.Request) {
 		api.RespondWithError(w, http.StatusBadRequest, "missing or invalid payment id or customer id")
 		return
 	}
-	paymentMethodID := requestBody["payment_method_id"]
-	customerID := requestBody["customer_id"]
-	priceID := requestBody["price_id"]
-
-	// make sure we don't have a subscription already
-	isActive, stripeErr := CheckSubscriptionIsActive(user)
-	if stripeErr != nil {
-		api.RespondWithError(w, http.StatusInternalServerError, stripeErr.Msg)
-		return
-	}
-	if isActive {
-		api.RespondWithError(w, http.StatusConflict, "duplicate subscription")
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		api.RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
 		return
 	}
 
-	sb, err := CreateSubscription(customerID, priceID, paymentMethodID)
-	if err != nil {
-		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+	sb, err := subscribe(client, dao, user, requestBody["customer_id"], requestBody["price_id"], requestBody["payment_method_id"])
+	switch {
+	case errors.Is(err, ErrDuplicateSubscription):
+		api.RespondWithError(w, http.StatusConflict, err.Error())
 		return
-	}
-
-	var (
-		dao daos.DaoInterface
-		ok  bool
-	)
-	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
-		api.RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+	case errors.Is(err, ErrPaymentFailed):
+		api.RespondWithError(w, http.StatusPaymentRequired, err.Error())
+		return
+	case err != nil:
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	entitlements.Invalidate(user.Email)
 
 	results := models.SubscriptionResults{}
 	results.SubscriptionID = sb.ID
 	results.PeriodStart = time.Unix(sb.CurrentPeriodStart, 0)
 	results.PeriodEnd = time.Unix(sb.CurrentPeriodEnd, 0)
-	user.SubscriptionID = sb.ID
-	user.CustomerID = sb.Customer.ID
-	user.Expired = false
-	user.Renewing = true
-
-	err = dao.UpdateUser(user)
-	if err != nil {
-		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
-		return
-	}
-
 	api.RespondWithJson(w, http.StatusOK, results)
 }
 
-func CreateSubscription(customerID string, priceID string, paymentMethodID string) (*stripe.Subscription, error) {
-	subscriptionParams := &stripe.SubscriptionParams{
-		Customer: &customerID,
-		Items: []*stripe.SubscriptionItemsParams{
-			{
-				Plan: &priceID,
-			},
-		},
-		DefaultPaymentMethod: &paymentMethodID,
-	}
-	return sub.New(subscriptionParams)
-}
-
-func UpdateSubscription(subscriptionID, paymentMethodID, priceID string) (*string, error) {
-	subItemParams := &stripe.SubscriptionItemListParams{
-		Subscription: &subscriptionID,
-	}
-	i := subitem.List(subItemParams)
-	var si *stripe.SubscriptionItem
-	for i.Next() {
-		si = i.SubscriptionItem()
-		break
-	}
-	if si == nil {
-		return nil, fmt.Errorf("no subscription items found for subscription %v", subscriptionID)
+// subscribe starts a subscription for the customer and records it against the user straight away
+// rather than waiting on the webhook
+func subscribe(client payments.Client, dao daos.DaoInterface, user models.UserInfo, customerID, priceID, paymentMethodID string) (*stripe.Subscription, error) {
+	// make sure we don't have a subscription already
+	isActive, err := client.HasActiveSubscription(customerID)
+	if err != nil {
+		return nil, err
 	}
-
-	subscriptionParams := &stripe.SubscriptionParams{
-		CancelAtPeriodEnd: stripe.Bool(false),
-		ProrationBehavior: stripe.String(string(stripe.SubscriptionProrationBehaviorCreateProrations)),
-		Items: []*stripe.SubscriptionItemsParams{
-			{
-				ID:    &si.ID,
-				Price: &priceID,
-			},
-		},
-		DefaultPaymentMethod: &paymentMethodID,
+	if isActive {
+		return nil, ErrDuplicateSubscription
 	}
 
-	stripeSubscription, err := sub.Update(subscriptionID, subscriptionParams)
+	sb, err := client.CreateSubscription(customerID, priceID, paymentMethodID)
 	if err != nil {
 		return nil, err
 	}
-
-	return &stripeSubscription.ID, nil
-}
-
-func CheckSubscriptionIsActive(user models.UserInfo) (bool, *stripe.Error) {
-	stripe.Key = os.Getenv("STRIPE_SECRET")
-	if stripe.Key == "" {
-		return false, &stripe.Error{HTTPStatusCode: http.StatusInternalServerError, Msg: "unable to load stripe secret"}
-	}
-	var c *stripe.Customer
-	params := &stripe.CustomerParams{}
-	params.AddExpand("subscriptions")
-	c, err := customer.Get(user.CustomerID, params)
-	if err != nil {
-		if _, ok := err.(*stripe.Error); ok {
-			return false, &stripe.Error{HTTPStatusCode: http.StatusBadGateway, Msg: "unable to cast response to stripe.Error"}
-		}
-		return false, err.(*stripe.Error)
+	if !payments.IsLive(sb) {
+		return nil, ErrPaymentFailed
 	}
-	activeSubscription := false
-	if c.Subscriptions != nil {
-		for _, item := range c.Subscriptions.Data {
-			if item.Status == stripe.SubscriptionStatusActive {
-				activeSubscription = true
-				break
-			}
-		}
-	} else {
-		return false, &stripe.Error{HTTPStatusCode: http.StatusNotFound, Msg: "unable to find subscription"}
+
+	user.SubscriptionID = sb.ID
+	user.PriceID = priceID
+	user.CustomerID = sb.Customer.ID
+	user.Expired = false
+	user.Renewing = true
+	if err = dao.UpdateUser(user); err != nil {
+		return nil, err
 	}
-	return activeSubscription, nil
+	return sb, nil
 }
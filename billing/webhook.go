@@ -0,0 +1,221 @@
+package billing
+
+import (
+	"RichDocter/api"
+	ctxkey "RichDocter/ctxkeys"
+	"RichDocter/daos"
+	"RichDocter/models"
+	"RichDocter/payments"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	stripe "github.com/stripe/stripe-go/v72"
+)
+
+const maxWebhookPayloadSize = 64 << 10
+
+// errUnreadableEvent is an event whose object doesn't decode, which no retry will fix
+var errUnreadableEvent = errors.New("unreadable event")
+
+// WebhookEndpoint receives Stripe's events and keeps each user's subscription state in step with
+// Stripe, so requests can be authorized without calling Stripe. Stripe retries any delivery that
+// isn't answered with a 2xx.
+func WebhookEndpoint(w http.ResponseWriter, r *http.Request) {
+	var (
+		client       payments.Client
+		dao          daos.DaoInterface
+		entitlements *payments.EntitlementCache
+		ok           bool
+	)
+	if client, ok = r.Context().Value(ctxkey.Billing).(payments.Client); !ok {
+		api.RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve billing client from context")
+		return
+	}
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookPayloadSize))
+	if err != nil {
+		api.RespondWithError(w, http.StatusRequestEntityTooLarge, "payload too large")
+		return
+	}
+	event, err := client.ParseWebhook(payload, r.Header.Get("Stripe-Signature"))
+	if err != nil {
+		api.RespondWithError(w, http.StatusBadRequest, "invalid webhook signature")
+		return
+	}
+	if dao, ok = r.Context().Value(ctxkey.DAO).(daos.DaoInterface); !ok {
+		api.RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve dao from context")
+		return
+	}
+	if entitlements, ok = r.Context().Value(ctxkey.Entitlements).(*payments.EntitlementCache); !ok {
+		api.RespondWithError(w, http.StatusInternalServerError, "unable to parse or retrieve entitlements from context")
+		return
+	}
+
+	customerID, state, err := subscriptionStateFromEvent(client, event)
+	if errors.Is(err, errUnreadableEvent) {
+		api.RespondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if state == nil {
+		// nothing in this event changes what a user can access
+		api.RespondWithJson(w, http.StatusOK, nil)
+		return
+	}
+	user, err := dao.GetUserByCustomerID(customerID)
+	if errors.Is(err, daos.ErrUnknownCustomer) {
+		// customers deleted along with their account, or made outside the app, have no user to update
+		fmt.Println("webhook", event.ID, "for unknown customer", customerID)
+		api.RespondWithJson(w, http.StatusOK, nil)
+		return
+	}
+	if err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if err = applySubscriptionState(dao, user, *state); err != nil {
+		api.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	entitlements.Invalidate(user.Email)
+	api.RespondWithJson(w, http.StatusOK, nil)
+}
+
+// subscriptionStateFromEvent works out the subscription an event leaves its customer with. A nil
+// state means the event doesn't change anything.
+func subscriptionStateFromEvent(client payments.Client, event stripe.Event) (string, *models.SubscriptionState, error) {
+	switch {
+	case strings.HasPrefix(event.Type, "customer.subscription."):
+		subscription := stripe.Subscription{}
+		if err := json.Unmarshal(event.Data.Raw, &subscription); err != nil {
+			return "", nil, fmt.Errorf("%w: %v", errUnreadableEvent, err)
+		}
+		if subscription.Customer == nil {
+			return "", nil, nil
+		}
+		state := &models.SubscriptionState{EventAt: event.Created}
+		switch {
+		case subscription.Status == stripe.SubscriptionStatusIncomplete:
+			// the first payment hasn't gone through yet, the subscription never started
+			return "", nil, nil
+		case payments.IsLive(&subscription):
+			state.SubscriptionID = subscription.ID
+			state.Renewing = !subscription.CancelAtPeriodEnd
+			if subscription.Items != nil && len(subscription.Items.Data) > 0 && subscription.Items.Data[0].Price != nil {
+				state.PriceID = subscription.Items.Data[0].Price.ID
+			}
+		default:
+			state.SubscriptionID = subscription.ID
+			state.Expired = true
+		}
+		return subscription.Customer.ID, state, nil
+	case event.Type == "invoice.paid" || event.Type == "invoice.payment_failed":
+		invoice := stripe.Invoice{}
+		if err := json.Unmarshal(event.Data.Raw, &invoice); err != nil {
+			return "", nil, fmt.Errorf("%w: %v", errUnreadableEvent, err)
+		}
+		if invoice.Customer == nil || invoice.Subscription == nil {
+			return "", nil, nil
+		}
+		state := &models.SubscriptionState{SubscriptionID: invoice.Subscription.ID, PriceID: invoicePriceID(invoice), EventAt: event.Created, Renewing: true}
+		if event.Type == "invoice.payment_failed" {
+			// Stripe is still retrying unless it has no attempt left
+			if invoice.NextPaymentAttempt != 0 {
+				return "", nil, nil
+			}
+			state.Expired = true
+		}
+		return invoice.Customer.ID, state, nil
+	case event.Type == "charge.refunded":
+		charge := stripe.Charge{}
+		if err := json.Unmarshal(event.Data.Raw, &charge); err != nil {
+			return "", nil, fmt.Errorf("%w: %v", errUnreadableEvent, err)
+		}
+		// a partial refund, or a refund of something other than a subscription, leaves access alone
+		if !charge.Refunded || charge.Invoice == nil || charge.Customer == nil {
+			return "", nil, nil
+		}
+		// the event only names the invoice, which names the subscription the refund is for. Refunds
+		// of a subscription the user has since replaced are then left alone like any other lapse.
+		refunded := charge.Invoice
+		if refunded.Subscription == nil {
+			var err error
+			if refunded, err = client.GetInvoice(charge.Invoice.ID); err != nil {
+				return "", nil, err
+			}
+		}
+		if refunded.Subscription == nil {
+			return "", nil, nil
+		}
+		return charge.Customer.ID, &models.SubscriptionState{SubscriptionID: refunded.Subscription.ID, EventAt: event.Created, Expired: true}, nil
+	}
+	return "", nil, nil
+}
+
+// invoicePriceID is the price an invoice bills the subscription at, leaving out any proration lines
+func invoicePriceID(invoice stripe.Invoice) string {
+	if invoice.Lines == nil {
+		return ""
+	}
+	for _, line := range invoice.Lines.Data {
+		if line.Type == stripe.InvoiceLineTypeSubscription && line.Price != nil {
+			return line.Price.ID
+		}
+	}
+	return ""
+}
+
+// applySubscriptionState records a user's new subscription state. Stories beyond the free allowance
+// are suspended when a subscription lapses and come back when the user subscribes again.
+func applySubscriptionState(dao daos.DaoInterface, user *models.UserInfo, state models.SubscriptionState) error {
+	if state.Expired {
+		// the end of a subscription the user has since replaced doesn't lapse the new one
+		if state.SubscriptionID != "" && user.SubscriptionID != "" && state.SubscriptionID != user.SubscriptionID {
+			return nil
+		}
+		state.SubscriptionID = ""
+		state.Renewing = false
+	}
+	if err := dao.UpdateSubscriptionState(user.Email, state); err != nil {
+		if errors.Is(err, daos.ErrStaleSubscriptionEvent) {
+			return nil
+		}
+		return err
+	}
+
+	if state.Expired {
+		if user.Expired {
+			return nil
+		}
+		stories, err := dao.GetAllStories(user.Email)
+		if err != nil {
+			return err
+		}
+		// backing up block tables is slow, so the earliest created story is kept while the rest are
+		// suspended after Stripe has had its answer
+		go func() {
+			for idx, story := range stories {
+				if idx > 0 {
+					if err := dao.SoftDeleteStory(user.Email, story.ID, true); err != nil {
+						fmt.Println(err.Error())
+					}
+				}
+			}
+		}()
+		return nil
+	}
+	suspended, err := dao.CheckForSuspendedStories(user.Email)
+	if err != nil {
+		return err
+	}
+	if suspended {
+		return dao.RestoreAutomaticallyDeletedStories(user.Email)
+	}
+	return nil
+}
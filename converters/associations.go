@@ -0,0 +1,130 @@
+package converters
+
+import (
+	"RichDocter/models"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var associationCSVHeader = []string{
+	"association_id",
+	"association_name",
+	"association_type",
+	"short_description",
+	"portrait",
+	"extended_description",
+	"aliases",
+	"case_sensitive",
+	"custom_fields",
+}
+
+// AssociationsToCSV writes one row per association; custom fields are stored as a JSON object in the last column
+func AssociationsToCSV(associations []*models.Association) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	if err := writer.Write(associationCSVHeader); err != nil {
+		return nil, err
+	}
+	for _, assoc := range associations {
+		customFields := ""
+		if len(assoc.Details.CustomFields) > 0 {
+			encoded, err := json.Marshal(assoc.Details.CustomFields)
+			if err != nil {
+				return nil, err
+			}
+			customFields = string(encoded)
+		}
+		row := []string{
+			assoc.ID,
+			assoc.Name,
+			assoc.Type,
+			assoc.ShortDescription,
+			assoc.Portrait,
+			assoc.Details.ExtendedDescription,
+			assoc.Details.Aliases,
+			strconv.FormatBool(assoc.Details.CaseSensitive),
+			customFields,
+		}
+		if err := writer.Write(row); err != nil {
+			return nil, err
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// CSVToAssociations reads associations written by AssociationsToCSV. Columns are matched by header
+// name, so spreadsheets may reorder or omit any column except association_name and association_type.
+func CSVToAssociations(r io.Reader) ([]*models.Association, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("csv is empty")
+		}
+		return nil, err
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, required := range []string{"association_name", "association_type"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("csv is missing the %s column", required)
+		}
+	}
+
+	associations := []*models.Association{}
+	line := 1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			return nil, err
+		}
+		value := func(column string) string {
+			if idx, ok := columns[column]; ok && idx < len(record) {
+				return strings.TrimSpace(record[idx])
+			}
+			return ""
+		}
+		assoc := &models.Association{
+			ID:               value("association_id"),
+			Name:             value("association_name"),
+			Type:             value("association_type"),
+			ShortDescription: value("short_description"),
+			Portrait:         value("portrait"),
+			Details: models.AssociationDetails{
+				ExtendedDescription: value("extended_description"),
+				Aliases:             value("aliases"),
+			},
+		}
+		if assoc.Name == "" || assoc.Type == "" {
+			return nil, fmt.Errorf("line %d: association_name and association_type are required", line)
+		}
+		if caseSensitive := value("case_sensitive"); caseSensitive != "" {
+			if assoc.Details.CaseSensitive, err = strconv.ParseBool(caseSensitive); err != nil {
+				return nil, fmt.Errorf("line %d: case_sensitive must be true or false", line)
+			}
+		}
+		if customFields := value("custom_fields"); customFields != "" {
+			if err = json.Unmarshal([]byte(customFields), &assoc.Details.CustomFields); err != nil {
+				return nil, fmt.Errorf("line %d: custom_fields must be a JSON object", line)
+			}
+		}
+		associations = append(associations, assoc)
+	}
+	return associations, nil
+}
@@ -0,0 +1,33 @@
+package converters
+
+import (
+	"RichDocter/models"
+	"fmt"
+	"strings"
+)
+
+// RelationshipsToDOT renders an association relationship graph in Graphviz DOT format
+func RelationshipsToDOT(title string, graph models.RelationshipGraph) string {
+	var sb strings.Builder
+	sb.WriteString("digraph " + dotQuote(title) + " {\n")
+	sb.WriteString("\tnode [shape=box, style=rounded];\n")
+	for _, node := range graph.Nodes {
+		sb.WriteString(fmt.Sprintf("\t%s [label=%s, group=%s];\n", dotQuote(node.ID), dotQuote(node.Name), dotQuote(node.Type)))
+	}
+	for _, edge := range graph.Edges {
+		attrs := "label=" + dotQuote(edge.Type)
+		if !edge.Directional {
+			attrs += ", dir=none"
+		}
+		sb.WriteString(fmt.Sprintf("\t%s -> %s [%s];\n", dotQuote(edge.SourceID), dotQuote(edge.TargetID), attrs))
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
@@ -0,0 +1,92 @@
+package converters
+
+import (
+	"encoding/json"
+	"html"
+	"strings"
+)
+
+// lexical text format bit flags
+const (
+	LEXICAL_FORMAT_BOLD      = 1
+	LEXICAL_FORMAT_ITALIC    = 2
+	LEXICAL_FORMAT_UNDERLINE = 4
+)
+
+type lexicalParagraph struct {
+	Format   string `json:"format"`
+	Children []struct {
+		Format int    `json:"format"`
+		Text   string `json:"text"`
+		Type   string `json:"type"`
+	} `json:"children"`
+}
+
+// LexicalChunksToHTML renders stored paragraph chunks as read-only HTML. Chunks that cannot be
+// parsed are skipped rather than failing the whole chapter.
+func LexicalChunksToHTML(chunks []json.RawMessage) string {
+	var sb strings.Builder
+	for _, chunk := range chunks {
+		paragraph := lexicalParagraph{}
+		if err := json.Unmarshal(chunk, &paragraph); err != nil {
+			continue
+		}
+		sb.WriteString(`<p style="margin:0;padding:0;white-space:pre-wrap;`)
+		switch paragraph.Format {
+		case "center", "right", "justify":
+			sb.WriteString("text-align:" + paragraph.Format + ";")
+		}
+		sb.WriteString(`">`)
+		for _, child := range paragraph.Children {
+			if child.Type == "linebreak" {
+				sb.WriteString("<br/>")
+				continue
+			}
+			text := html.EscapeString(child.Text)
+			if child.Format&LEXICAL_FORMAT_UNDERLINE != 0 {
+				text = "<u>" + text + "</u>"
+			}
+			if child.Format&LEXICAL_FORMAT_ITALIC != 0 {
+				text = "<em>" + text + "</em>"
+			}
+			if child.Format&LEXICAL_FORMAT_BOLD != 0 {
+				text = "<strong>" + text + "</strong>"
+			}
+			sb.WriteString(text)
+		}
+		sb.WriteString("</p>\n")
+	}
+	return sb.String()
+}
+
+// LexicalParagraphChunk builds a stored paragraph chunk holding a single run of text
+func LexicalParagraphChunk(keyID, text string, format int) json.RawMessage {
+	type textNode struct {
+		Detail  int    `json:"detail"`
+		Format  int    `json:"format"`
+		Mode    string `json:"mode"`
+		Style   string `json:"style"`
+		Text    string `json:"text"`
+		Type    string `json:"type"`
+		Version int    `json:"version"`
+	}
+	chunk, _ := json.Marshal(struct {
+		Children   []textNode `json:"children"`
+		Direction  string     `json:"direction"`
+		Format     string     `json:"format"`
+		Indent     int        `json:"indent"`
+		Type       string     `json:"type"`
+		Version    int        `json:"version"`
+		TextFormat int        `json:"textFormat"`
+		TextStyle  string     `json:"textStyle"`
+		KeyID      string     `json:"key_id"`
+	}{
+		Children:   []textNode{{Format: format, Mode: "normal", Text: text, Type: "text", Version: 1}},
+		Direction:  "ltr",
+		Type:       "custom-paragraph",
+		Version:    1,
+		TextFormat: format,
+		KeyID:      keyID,
+	})
+	return chunk
+}
@@ -0,0 +1,188 @@
+package converters
+
+import (
+	"RichDocter/models"
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+)
+
+const (
+	takeoutManifest     = "manifest.json"
+	takeoutMaxFileBytes = 64 << 20
+	// an archive as a whole may inflate to no more than this, however its files are split up
+	takeoutMaxArchiveBytes = 1 << 30
+	takeoutMaxFiles        = 50000
+)
+
+// WriteAccountArchive zips up an account export. The manifest is written last, once it lists every file.
+func WriteAccountArchive(w io.Writer, archive *models.AccountArchive) error {
+	zw := zip.NewWriter(w)
+	manifest := archive.Manifest
+	manifest.Version = models.AccountArchiveVersion
+	manifest.Series = []string{}
+	manifest.Stories = []string{}
+	manifest.Images = map[string]string{}
+
+	for _, series := range archive.Series {
+		name := "series/" + series.Series.ID + ".json"
+		if err := writeArchiveJSON(zw, name, series); err != nil {
+			return err
+		}
+		manifest.Series = append(manifest.Series, name)
+	}
+	for _, story := range archive.Stories {
+		dir := "stories/" + story.Story.ID
+		if err := writeArchiveJSON(zw, dir+"/story.json", story); err != nil {
+			return err
+		}
+		for _, chapter := range story.Story.Chapters {
+			blocks := story.Blocks[chapter.ID]
+			if blocks == nil {
+				blocks = []models.StoryBlock{}
+			}
+			if err := writeArchiveJSON(zw, dir+"/chapters/"+chapter.ID+".json", blocks); err != nil {
+				return err
+			}
+		}
+		manifest.Stories = append(manifest.Stories, dir+"/story.json")
+	}
+	urls := make([]string, 0, len(archive.Images))
+	for url := range archive.Images {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	for i, url := range urls {
+		name := "images/" + strconv.Itoa(i+1) + path.Ext(url)
+		f, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		if _, err = f.Write(archive.Images[url]); err != nil {
+			return err
+		}
+		manifest.Images[url] = name
+	}
+	if err := writeArchiveJSON(zw, takeoutManifest, manifest); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// ReadAccountArchive unpacks an account export, refusing archives written by a newer version
+func ReadAccountArchive(r io.ReaderAt, size int64) (*models.AccountArchive, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if len(zr.File) > takeoutMaxFiles {
+		return nil, fmt.Errorf("archive has more than %d files", takeoutMaxFiles)
+	}
+	files := &archiveFiles{
+		byName:    make(map[string]*zip.File, len(zr.File)),
+		remaining: takeoutMaxArchiveBytes,
+	}
+	for _, f := range zr.File {
+		files.byName[f.Name] = f
+	}
+	archive := &models.AccountArchive{
+		Series:  []*models.ArchivedSeries{},
+		Stories: []*models.ArchivedStory{},
+		Images:  map[string][]byte{},
+	}
+	if err = readArchiveJSON(files, takeoutManifest, &archive.Manifest); err != nil {
+		return nil, err
+	}
+	if archive.Manifest.Version < 1 || archive.Manifest.Version > models.AccountArchiveVersion {
+		return nil, fmt.Errorf("unsupported archive version %d", archive.Manifest.Version)
+	}
+	for _, name := range archive.Manifest.Series {
+		series := &models.ArchivedSeries{}
+		if err = readArchiveJSON(files, name, series); err != nil {
+			return nil, err
+		}
+		archive.Series = append(archive.Series, series)
+	}
+	for _, name := range archive.Manifest.Stories {
+		story := &models.ArchivedStory{}
+		if err = readArchiveJSON(files, name, story); err != nil {
+			return nil, err
+		}
+		story.Blocks = make(map[string][]models.StoryBlock, len(story.Story.Chapters))
+		for _, chapter := range story.Story.Chapters {
+			blocks := []models.StoryBlock{}
+			if err = readArchiveJSON(files, path.Dir(name)+"/chapters/"+chapter.ID+".json", &blocks); err != nil {
+				return nil, err
+			}
+			story.Blocks[chapter.ID] = blocks
+		}
+		archive.Stories = append(archive.Stories, story)
+	}
+	for url, name := range archive.Manifest.Images {
+		if archive.Images[url], err = files.read(name); err != nil {
+			return nil, err
+		}
+	}
+	return archive, nil
+}
+
+func writeArchiveJSON(zw *zip.Writer, name string, v interface{}) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func readArchiveJSON(files *archiveFiles, name string, v interface{}) error {
+	contents, err := files.read(name)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(contents, v); err != nil {
+		return fmt.Errorf("reading %s: %w", name, err)
+	}
+	return nil
+}
+
+// archiveFiles reads an archive's files while keeping count of how much they have inflated to, as
+// the manifest can list any number of files, or the same file many times over
+type archiveFiles struct {
+	byName    map[string]*zip.File
+	remaining int64
+}
+
+func (a *archiveFiles) read(name string) ([]byte, error) {
+	f, ok := a.byName[name]
+	if !ok {
+		return nil, fmt.Errorf("archive is missing %s", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	// guard against archives that inflate to far more than they claim
+	limit := int64(takeoutMaxFileBytes)
+	if a.remaining < limit {
+		limit = a.remaining
+	}
+	contents, err := io.ReadAll(io.LimitReader(rc, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(contents)) > limit {
+		if limit < takeoutMaxFileBytes {
+			return nil, fmt.Errorf("archive is larger than %d bytes once unpacked", int64(takeoutMaxArchiveBytes))
+		}
+		return nil, fmt.Errorf("%s is too large", f.Name)
+	}
+	a.remaining -= int64(len(contents))
+	return contents, nil
+}
@@ -3,6 +3,10 @@ package ctxkey
 type ContextKey string
 
 const (
-	DAO         ContextKey = "dao"
-	IsSuspended ContextKey = "isSuspended"
+	Billing       ContextKey = "billing"
+	DAO           ContextKey = "dao"
+	Entitlements  ContextKey = "entitlements"
+	IsSuspended   ContextKey = "isSuspended"
+	ResourceOwner ContextKey = "resourceOwner"
+	Role          ContextKey = "role"
 )
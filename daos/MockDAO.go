@@ -27,6 +27,7 @@ type MockDynamoClient struct {
 	MockDeleteTable             func(ctx context.Context, input *dynamodb.DeleteTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteTableOutput, error)
 	MockUpdateContinuousBackups func(ctx context.Context, input *dynamodb.UpdateContinuousBackupsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateContinuousBackupsOutput, error)
 	MockRestoreTableFromBackup  func(ctx context.Context, input *dynamodb.RestoreTableFromBackupInput, optFns ...func(*dynamodb.Options)) (*dynamodb.RestoreTableFromBackupOutput, error)
+	MockDeleteBackup            func(ctx context.Context, input *dynamodb.DeleteBackupInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteBackupOutput, error)
 }
 
 // Make sure our MockDynamoClient implements the interface:
@@ -137,6 +138,14 @@ func (m *MockDynamoClient) RestoreTableFromBackup(ctx context.Context, input *dy
 	return &dynamodb.RestoreTableFromBackupOutput{}, nil
 }
 
+// DeleteBackup
+func (m *MockDynamoClient) DeleteBackup(ctx context.Context, input *dynamodb.DeleteBackupInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteBackupOutput, error) {
+	if m.MockDeleteBackup != nil {
+		return m.MockDeleteBackup(ctx, input, optFns...)
+	}
+	return &dynamodb.DeleteBackupOutput{}, nil
+}
+
 func NewMockDAO() *MockDAO {
 	maxAWSRetries := 10
 	blockTableMinWriteCapacity := 10
@@ -0,0 +1,132 @@
+package daos
+
+import (
+	"RichDocter/models"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+// GetAllAssociations returns every association stored against a story or series, including details, ordered by type and name
+func (d *DAO) GetAllAssociations(email, storyOrSeriesID string) ([]*models.Association, error) {
+	associations, err := d.getStoredAssociations(email, storyOrSeriesID)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(associations, func(i, j int) bool {
+		if associations[i].Type != associations[j].Type {
+			return associations[i].Type < associations[j].Type
+		}
+		return associations[i].Name < associations[j].Name
+	})
+	return associations, nil
+}
+
+// ImportAssociations creates or updates associations in bulk. Incoming associations are matched to
+// existing ones by id, then by name and type. With dryRun set nothing is written and the report
+// describes what the import would have done.
+func (d *DAO) ImportAssociations(email, storyOrSeriesID string, associations []*models.Association, dryRun bool) (*models.AssociationImportReport, error) {
+	if len(associations) == 0 {
+		return nil, fmt.Errorf("empty associations array")
+	}
+	existing, err := d.getStoredAssociations(email, storyOrSeriesID)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]*models.Association, len(existing))
+	byName := make(map[string]*models.Association, len(existing))
+	for _, assoc := range existing {
+		byID[assoc.ID] = assoc
+		byName[associationNameKey(assoc.Name, assoc.Type)] = assoc
+	}
+
+	report := &models.AssociationImportReport{
+		DryRun:    dryRun,
+		Created:   []*models.Association{},
+		Updated:   []models.AssociationImportChange{},
+		Unchanged: []string{},
+	}
+	toWrite := []*models.Association{}
+	seen := make(map[string]bool, len(associations))
+	for _, incoming := range associations {
+		if incoming.Name == "" || incoming.Type == "" {
+			return nil, fmt.Errorf("associations require an association_name and association_type")
+		}
+		match, ok := byID[incoming.ID]
+		if !ok {
+			match, ok = byName[associationNameKey(incoming.Name, incoming.Type)]
+		}
+		if !ok {
+			// ids from another story or series are not reused, they may still be in use there
+			incoming.ID = uuid.New().String()
+			seen[incoming.ID] = true
+			byName[associationNameKey(incoming.Name, incoming.Type)] = incoming
+			report.Created = append(report.Created, incoming)
+			toWrite = append(toWrite, incoming)
+			continue
+		}
+		if seen[match.ID] {
+			return nil, fmt.Errorf("association %s appears more than once in the import", incoming.Name)
+		}
+		seen[match.ID] = true
+		incoming.ID = match.ID
+		if incoming.Portrait == "" {
+			incoming.Portrait = match.Portrait
+		}
+		changed := changedAssociationFields(match, incoming)
+		if len(changed) == 0 {
+			report.Unchanged = append(report.Unchanged, match.ID)
+			continue
+		}
+		report.Updated = append(report.Updated, models.AssociationImportChange{
+			Association:   incoming,
+			ChangedFields: changed,
+		})
+		toWrite = append(toWrite, incoming)
+	}
+	if len(toWrite) == 0 {
+		return report, nil
+	}
+	if dryRun {
+		if _, err = d.validateAssociationsAgainstTypes(email, storyOrSeriesID, toWrite); err != nil {
+			return nil, err
+		}
+		return report, nil
+	}
+	if err = d.WriteAssociations(email, storyOrSeriesID, toWrite); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func changedAssociationFields(current, incoming *models.Association) []string {
+	changed := []string{}
+	if current.Name != incoming.Name {
+		changed = append(changed, "association_name")
+	}
+	if current.Type != incoming.Type {
+		changed = append(changed, "association_type")
+	}
+	if current.ShortDescription != incoming.ShortDescription {
+		changed = append(changed, "short_description")
+	}
+	if current.Portrait != incoming.Portrait {
+		changed = append(changed, "portrait")
+	}
+	if current.Details.ExtendedDescription != incoming.Details.ExtendedDescription {
+		changed = append(changed, "extended_description")
+	}
+	if current.Details.Aliases != incoming.Details.Aliases {
+		changed = append(changed, "aliases")
+	}
+	if current.Details.CaseSensitive != incoming.Details.CaseSensitive {
+		changed = append(changed, "case_sensitive")
+	}
+	if (len(current.Details.CustomFields) > 0 || len(incoming.Details.CustomFields) > 0) &&
+		!reflect.DeepEqual(current.Details.CustomFields, incoming.Details.CustomFields) {
+		changed = append(changed, "custom_fields")
+	}
+	return changed
+}
@@ -0,0 +1,66 @@
+package daos
+
+import (
+	"RichDocter/models"
+	"reflect"
+	"testing"
+)
+
+func TestChangedAssociationFields(t *testing.T) {
+	current := &models.Association{
+		ID:               "a1",
+		Name:             "Bob",
+		Type:             "character",
+		ShortDescription: "a baker",
+		Details:          models.AssociationDetails{Aliases: "Bobby"},
+	}
+	testCases := []struct {
+		name     string
+		incoming models.Association
+		want     []string
+	}{
+		{
+			name:     "Unchanged",
+			incoming: *current,
+			want:     []string{},
+		},
+		{
+			name: "DescriptionAndAliases",
+			incoming: models.Association{
+				ID:               "a1",
+				Name:             "Bob",
+				Type:             "character",
+				ShortDescription: "a butcher",
+				Details:          models.AssociationDetails{Aliases: "Bobby,Rob"},
+			},
+			want: []string{"short_description", "aliases"},
+		},
+		{
+			name: "CustomFieldsAdded",
+			incoming: models.Association{
+				ID:               "a1",
+				Name:             "Bob",
+				Type:             "character",
+				ShortDescription: "a baker",
+				Details: models.AssociationDetails{
+					Aliases:      "Bobby",
+					CustomFields: map[string]interface{}{"age": float64(40)},
+				},
+			},
+			want: []string{"custom_fields"},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			got := changedAssociationFields(current, &tc.incoming)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
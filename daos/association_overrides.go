@@ -0,0 +1,176 @@
+package daos
+
+import (
+	"RichDocter/models"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func (d *DAO) GetAssociationOverride(email, storyID, associationID string) (*models.AssociationOverride, error) {
+	out, err := d.DynamoClient.Query(context.TODO(), &dynamodb.QueryInput{
+		TableName:              aws.String("association_overrides" + GetTableSuffix()),
+		KeyConditionExpression: aws.String("association_id = :aid AND story_id = :sid"),
+		FilterExpression:       aws.String("author = :eml"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":aid": &types.AttributeValueMemberS{Value: associationID},
+			":sid": &types.AttributeValueMemberS{Value: storyID},
+			":eml": &types.AttributeValueMemberS{Value: email},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(out.Items) == 0 {
+		return nil, nil
+	}
+	override := models.AssociationOverride{}
+	if err = attributevalue.UnmarshalMap(out.Items[0], &override); err != nil {
+		return nil, err
+	}
+	return &override, nil
+}
+
+func (d *DAO) WriteAssociationOverride(email string, override models.AssociationOverride) error {
+	if override.AssociationID == "" || override.StoryID == "" || override.SeriesID == "" {
+		return fmt.Errorf("overrides require an association_id, story_id and series_id")
+	}
+	// an override of an association the series doesn't have, or has since trashed, would be orphaned
+	base, err := d.getStoredAssociation(override.SeriesID, override.AssociationID)
+	if err != nil {
+		return err
+	}
+	if len(override.CustomFields) > 0 {
+		merged := applyAssociationOverride(base, &override)
+		if _, err = d.validateAssociationsAgainstTypes(email, override.SeriesID, []*models.Association{merged}); err != nil {
+			return err
+		}
+	}
+	item, err := attributevalue.MarshalMap(override)
+	if err != nil {
+		return err
+	}
+	item["author"] = &types.AttributeValueMemberS{Value: email}
+	item["last_updated"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Unix(), 10)}
+	_, err = d.DynamoClient.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName:           aws.String("association_overrides" + GetTableSuffix()),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(author) OR author = :eml"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":eml": &types.AttributeValueMemberS{Value: email},
+		},
+	})
+	return err
+}
+
+func (d *DAO) DeleteAssociationOverride(email, storyID, associationID string) error {
+	_, err := d.DynamoClient.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String("association_overrides" + GetTableSuffix()),
+		Key: map[string]types.AttributeValue{
+			"association_id": &types.AttributeValueMemberS{Value: associationID},
+			"story_id":       &types.AttributeValueMemberS{Value: storyID},
+		},
+		ConditionExpression: aws.String("author = :eml"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":eml": &types.AttributeValueMemberS{Value: email},
+		},
+	})
+	return err
+}
+
+// GetAssociationEvolution returns the merged state of a series association for every volume, in reading order
+func (d *DAO) GetAssociationEvolution(email, seriesID, associationID string) ([]*models.AssociationVolumeState, error) {
+	series, err := d.GetSeriesByID(email, seriesID)
+	if err != nil {
+		return nil, err
+	}
+	base, err := d.getStoredAssociation(series.ID, associationID)
+	if err != nil {
+		return nil, err
+	}
+	out, err := d.DynamoClient.Scan(context.TODO(), &dynamodb.ScanInput{
+		TableName:        aws.String("association_overrides" + GetTableSuffix()),
+		FilterExpression: aws.String("author = :eml AND association_id = :aid AND series_id = :sid"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":eml": &types.AttributeValueMemberS{Value: email},
+			":aid": &types.AttributeValueMemberS{Value: associationID},
+			":sid": &types.AttributeValueMemberS{Value: series.ID},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	overrides := []models.AssociationOverride{}
+	if err = attributevalue.UnmarshalListOfMaps(out.Items, &overrides); err != nil {
+		return nil, err
+	}
+	byStory := make(map[string]*models.AssociationOverride, len(overrides))
+	for i := range overrides {
+		byStory[overrides[i].StoryID] = &overrides[i]
+	}
+
+	volumes := series.Stories
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].Place < volumes[j].Place
+	})
+	evolution := []*models.AssociationVolumeState{}
+	for _, volume := range volumes {
+		evolution = append(evolution, &models.AssociationVolumeState{
+			StoryID:     volume.ID,
+			Title:       volume.Title,
+			Place:       volume.Place,
+			Association: applyAssociationOverride(base, byStory[volume.ID]),
+		})
+	}
+	return evolution, nil
+}
+
+// applyAssociationOverride returns a copy of the base association with any non-blank override values applied
+func applyAssociationOverride(base *models.Association, override *models.AssociationOverride) *models.Association {
+	merged := *base
+	merged.OverriddenFields = nil
+	merged.Details.CustomFields = make(map[string]interface{}, len(base.Details.CustomFields))
+	for k, v := range base.Details.CustomFields {
+		merged.Details.CustomFields[k] = v
+	}
+	if override == nil {
+		return &merged
+	}
+	if override.Name != "" {
+		merged.Name = override.Name
+		merged.OverriddenFields = append(merged.OverriddenFields, "association_name")
+	}
+	if override.Portrait != "" {
+		merged.Portrait = override.Portrait
+		merged.OverriddenFields = append(merged.OverriddenFields, "portrait")
+	}
+	if override.ShortDescription != "" {
+		merged.ShortDescription = override.ShortDescription
+		merged.OverriddenFields = append(merged.OverriddenFields, "short_description")
+	}
+	if override.ExtendedDescription != "" {
+		merged.Details.ExtendedDescription = override.ExtendedDescription
+		merged.OverriddenFields = append(merged.OverriddenFields, "extended_description")
+	}
+	if override.Aliases != "" {
+		merged.Details.Aliases = override.Aliases
+		merged.OverriddenFields = append(merged.OverriddenFields, "aliases")
+	}
+	keys := make([]string, 0, len(override.CustomFields))
+	for k := range override.CustomFields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		merged.Details.CustomFields[k] = override.CustomFields[k]
+		merged.OverriddenFields = append(merged.OverriddenFields, "custom_fields."+k)
+	}
+	return &merged
+}
@@ -0,0 +1,306 @@
+package daos
+
+import (
+	"RichDocter/models"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func overrideTestBase() *models.Association {
+	return &models.Association{
+		ID:               "assoc1",
+		Name:             "Alice",
+		Type:             "character",
+		Portrait:         "https://example.com/alice.png",
+		ShortDescription: "a girl",
+		Details: models.AssociationDetails{
+			ExtendedDescription: "falls down a rabbit hole",
+			Aliases:             "Ally",
+			CustomFields:        map[string]interface{}{"age": float64(7), "home": "Oxford"},
+		},
+	}
+}
+
+func TestApplyAssociationOverride(t *testing.T) {
+	testCases := []struct {
+		name           string
+		override       *models.AssociationOverride
+		wantName       string
+		wantShort      string
+		wantFields     map[string]interface{}
+		wantOverridden []string
+	}{
+		{
+			name:       "NoOverride",
+			wantName:   "Alice",
+			wantShort:  "a girl",
+			wantFields: map[string]interface{}{"age": float64(7), "home": "Oxford"},
+		},
+		{
+			name: "FieldsMerged",
+			override: &models.AssociationOverride{
+				Name:         "Queen Alice",
+				CustomFields: map[string]interface{}{"home": "Looking-Glass", "age": float64(8)},
+			},
+			wantName:       "Queen Alice",
+			wantShort:      "a girl",
+			wantFields:     map[string]interface{}{"age": float64(8), "home": "Looking-Glass"},
+			wantOverridden: []string{"association_name", "custom_fields.age", "custom_fields.home"},
+		},
+		{
+			name:       "BlankValuesInherit",
+			override:   &models.AssociationOverride{Name: "", ShortDescription: ""},
+			wantName:   "Alice",
+			wantShort:  "a girl",
+			wantFields: map[string]interface{}{"age": float64(7), "home": "Oxford"},
+		},
+		{
+			name: "ClearedCustomField",
+			override: &models.AssociationOverride{
+				ShortDescription: "a queen",
+				CustomFields:     map[string]interface{}{"home": nil},
+			},
+			wantName:       "Alice",
+			wantShort:      "a queen",
+			wantFields:     map[string]interface{}{"age": float64(7), "home": nil},
+			wantOverridden: []string{"short_description", "custom_fields.home"},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			base := overrideTestBase()
+			merged := applyAssociationOverride(base, tc.override)
+			if merged.Name != tc.wantName || merged.ShortDescription != tc.wantShort {
+				t.Errorf("expected %q / %q, got %q / %q", tc.wantName, tc.wantShort, merged.Name, merged.ShortDescription)
+			}
+			if !reflect.DeepEqual(merged.Details.CustomFields, tc.wantFields) {
+				t.Errorf("expected custom fields %v, got %v", tc.wantFields, merged.Details.CustomFields)
+			}
+			if !reflect.DeepEqual(merged.OverriddenFields, tc.wantOverridden) {
+				t.Errorf("expected overridden fields %v, got %v", tc.wantOverridden, merged.OverriddenFields)
+			}
+			if !reflect.DeepEqual(base, overrideTestBase()) {
+				t.Errorf("expected the base association left alone, got %+v", base)
+			}
+		})
+	}
+}
+
+func TestGetAssociationEvolution(t *testing.T) {
+	testCases := []struct {
+		name          string
+		overrides     []models.AssociationOverride
+		clear         string
+		wantNames     []string
+		wantOverrides [][]string
+	}{
+		{
+			name:          "NoOverrides",
+			wantNames:     []string{"Alice", "Alice", "Alice"},
+			wantOverrides: [][]string{nil, nil, nil},
+		},
+		{
+			name: "OverridesFollowReadingOrder",
+			overrides: []models.AssociationOverride{
+				{AssociationID: "assoc1", StoryID: "vol3", SeriesID: "series1", Name: "Queen Alice"},
+				{AssociationID: "assoc1", StoryID: "vol2", SeriesID: "series1", CustomFields: map[string]interface{}{"age": float64(8)}},
+			},
+			wantNames:     []string{"Alice", "Alice", "Queen Alice"},
+			wantOverrides: [][]string{nil, {"custom_fields.age"}, {"association_name"}},
+		},
+		{
+			name: "ClearedOverride",
+			overrides: []models.AssociationOverride{
+				{AssociationID: "assoc1", StoryID: "vol3", SeriesID: "series1", Name: "Queen Alice"},
+				{AssociationID: "assoc1", StoryID: "vol2", SeriesID: "series1", Name: "Older Alice"},
+			},
+			clear:         "vol3",
+			wantNames:     []string{"Alice", "Older Alice", "Alice"},
+			wantOverrides: [][]string{nil, {"association_name"}, nil},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			mockDao := NewMockDAO()
+			mockClient := mockDao.DynamoClient.(*MockDynamoClient)
+			base := overrideTestBase()
+			baseItem, _ := attributevalue.MarshalMap(base)
+			detailsItem, _ := attributevalue.MarshalMap(base.Details)
+			seriesItem, _ := attributevalue.MarshalMap(models.Series{ID: "series1", Title: "Alice"})
+			// the volumes come back out of reading order
+			volumes := []map[string]types.AttributeValue{}
+			for _, volume := range []models.Story{
+				{ID: "vol3", Title: "Three", SeriesID: "series1", Place: 3},
+				{ID: "vol1", Title: "One", SeriesID: "series1", Place: 1},
+				{ID: "vol2", Title: "Two", SeriesID: "series1", Place: 2},
+			} {
+				item, _ := attributevalue.MarshalMap(volume)
+				volumes = append(volumes, item)
+			}
+			stored := map[string]map[string]types.AttributeValue{}
+			for _, override := range tc.overrides {
+				item, _ := attributevalue.MarshalMap(override)
+				stored[override.StoryID] = item
+			}
+
+			mockClient.MockQuery = func(ctx context.Context, input *dynamodb.QueryInput, opts ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+				if strings.HasPrefix(*input.TableName, "stories") {
+					return &dynamodb.QueryOutput{Items: volumes}, nil
+				}
+				return &dynamodb.QueryOutput{Items: []map[string]types.AttributeValue{baseItem}}, nil
+			}
+			mockClient.MockScan = func(ctx context.Context, input *dynamodb.ScanInput, opts ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+				switch {
+				case strings.HasPrefix(*input.TableName, "series"):
+					return &dynamodb.ScanOutput{Items: []map[string]types.AttributeValue{seriesItem}}, nil
+				case strings.HasPrefix(*input.TableName, "association_details"):
+					return &dynamodb.ScanOutput{Items: []map[string]types.AttributeValue{detailsItem}}, nil
+				case strings.HasPrefix(*input.TableName, "association_overrides"):
+					items := []map[string]types.AttributeValue{}
+					for _, item := range stored {
+						items = append(items, item)
+					}
+					return &dynamodb.ScanOutput{Items: items}, nil
+				}
+				return &dynamodb.ScanOutput{}, nil
+			}
+			mockClient.MockDeleteItem = func(ctx context.Context, input *dynamodb.DeleteItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+				delete(stored, input.Key["story_id"].(*types.AttributeValueMemberS).Value)
+				return &dynamodb.DeleteItemOutput{}, nil
+			}
+
+			if tc.clear != "" {
+				if err := mockDao.DeleteAssociationOverride("test@example.com", tc.clear, "assoc1"); err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+			}
+			evolution, err := mockDao.GetAssociationEvolution("test@example.com", "series1", "assoc1")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(evolution) != len(tc.wantNames) {
+				t.Fatalf("expected %d volumes, got %d", len(tc.wantNames), len(evolution))
+			}
+			for i, state := range evolution {
+				if state.Place != i+1 {
+					t.Errorf("volume %d: expected place %d, got %d", i, i+1, state.Place)
+				}
+				if state.Association.Name != tc.wantNames[i] {
+					t.Errorf("volume %d: expected name %q, got %q", i, tc.wantNames[i], state.Association.Name)
+				}
+				if !reflect.DeepEqual(state.Association.OverriddenFields, tc.wantOverrides[i]) {
+					t.Errorf("volume %d: expected overridden fields %v, got %v", i, tc.wantOverrides[i], state.Association.OverriddenFields)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteAssociationOverride(t *testing.T) {
+	testCases := []struct {
+		name                string
+		override            models.AssociationOverride
+		wantWrite           bool
+		wantErr             bool
+		expectedErrContains string
+	}{
+		{
+			name:      "NameOnly",
+			override:  models.AssociationOverride{AssociationID: "assoc1", StoryID: "vol2", SeriesID: "series1", Name: "Queen Alice"},
+			wantWrite: true,
+		},
+		{
+			name:                "MissingSeries",
+			override:            models.AssociationOverride{AssociationID: "assoc1", StoryID: "vol2", Name: "Queen Alice"},
+			wantErr:             true,
+			expectedErrContains: "series_id",
+		},
+		{
+			name:      "DefinedCustomField",
+			override:  models.AssociationOverride{AssociationID: "assoc1", StoryID: "vol2", SeriesID: "series1", CustomFields: map[string]interface{}{"age": float64(8)}},
+			wantWrite: true,
+		},
+		{
+			name:                "MissingAssociation",
+			override:            models.AssociationOverride{AssociationID: "gone", StoryID: "vol2", SeriesID: "series1", Name: "Nobody"},
+			wantErr:             true,
+			expectedErrContains: ErrAssociationNotFound.Error(),
+		},
+		{
+			name:                "UndefinedCustomField",
+			override:            models.AssociationOverride{AssociationID: "assoc1", StoryID: "vol2", SeriesID: "series1", CustomFields: map[string]interface{}{"wand": "holly"}},
+			wantErr:             true,
+			expectedErrContains: "not defined",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			mockDao := NewMockDAO()
+			mockClient := mockDao.DynamoClient.(*MockDynamoClient)
+			base := overrideTestBase()
+			base.Details.CustomFields = map[string]interface{}{"age": float64(7)}
+			baseItem, _ := attributevalue.MarshalMap(base)
+			detailsItem, _ := attributevalue.MarshalMap(base.Details)
+			characterType, _ := attributevalue.MarshalMap(models.AssociationType{
+				Name:   "character",
+				Fields: []models.AssociationFieldDefinition{{Key: "age", Type: FIELD_TYPE_NUMBER}},
+			})
+			mockClient.MockQuery = func(ctx context.Context, input *dynamodb.QueryInput, opts ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+				if input.ExpressionAttributeValues[":aid"].(*types.AttributeValueMemberS).Value != base.ID {
+					return &dynamodb.QueryOutput{}, nil
+				}
+				return &dynamodb.QueryOutput{Items: []map[string]types.AttributeValue{baseItem}}, nil
+			}
+			mockClient.MockScan = func(ctx context.Context, input *dynamodb.ScanInput, opts ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+				switch {
+				case strings.HasPrefix(*input.TableName, "association_details"):
+					return &dynamodb.ScanOutput{Items: []map[string]types.AttributeValue{detailsItem}}, nil
+				case strings.HasPrefix(*input.TableName, "association_types"):
+					return &dynamodb.ScanOutput{Items: []map[string]types.AttributeValue{characterType}}, nil
+				}
+				return &dynamodb.ScanOutput{}, nil
+			}
+			written := false
+			mockClient.MockPutItem = func(ctx context.Context, input *dynamodb.PutItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+				written = true
+				if author := input.Item["author"].(*types.AttributeValueMemberS).Value; author != "test@example.com" {
+					t.Errorf("expected the override stamped with its author, got %q", author)
+				}
+				return &dynamodb.PutItemOutput{}, nil
+			}
+
+			err := mockDao.WriteAssociationOverride("test@example.com", tc.override)
+			if tc.wantErr {
+				if err == nil || !contains(err.Error(), tc.expectedErrContains) {
+					t.Errorf("expected an error containing %q, got %v", tc.expectedErrContains, err)
+				}
+			} else if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if written != tc.wantWrite {
+				t.Errorf("expected written=%v, got %v", tc.wantWrite, written)
+			}
+		})
+	}
+}
@@ -0,0 +1,491 @@
+package daos
+
+import (
+	"RichDocter/models"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+)
+
+const (
+	TRANSFER_MODE_MOVE = "move"
+	TRANSFER_MODE_COPY = "copy"
+
+	CONFLICT_MERGE       = "merge"
+	CONFLICT_KEEP_TARGET = "keep_target"
+	CONFLICT_KEEP_SOURCE = "keep_source"
+	CONFLICT_RENAME      = "rename"
+)
+
+// TransferAssociations moves or copies associations between stories and series. Associations whose
+// name and type already exist in the target are resolved according to transfer.OnConflict.
+func (d *DAO) TransferAssociations(email string, transfer models.AssociationTransfer) (*models.AssociationTransferResult, error) {
+	if transfer.SourceID == "" || transfer.TargetID == "" {
+		return nil, fmt.Errorf("a source_id and target_id are required")
+	}
+	if transfer.SourceID == transfer.TargetID {
+		return nil, fmt.Errorf("source and target must differ")
+	}
+	if transfer.Mode != TRANSFER_MODE_MOVE && transfer.Mode != TRANSFER_MODE_COPY {
+		return nil, fmt.Errorf("invalid transfer mode: %s", transfer.Mode)
+	}
+	if transfer.OnConflict == "" {
+		transfer.OnConflict = CONFLICT_MERGE
+	}
+	switch transfer.OnConflict {
+	case CONFLICT_MERGE, CONFLICT_KEEP_TARGET, CONFLICT_KEEP_SOURCE, CONFLICT_RENAME:
+	default:
+		return nil, fmt.Errorf("invalid conflict resolution: %s", transfer.OnConflict)
+	}
+	for _, id := range []string{transfer.SourceID, transfer.TargetID} {
+		if err := d.verifyStoryOrSeriesOwnership(email, id); err != nil {
+			return nil, err
+		}
+	}
+
+	stored, err := d.getStoredAssociations(email, transfer.SourceID)
+	if err != nil {
+		return nil, err
+	}
+	sources := stored
+	if len(transfer.AssociationIDs) > 0 {
+		sources = []*models.Association{}
+		for _, assoc := range stored {
+			if containsString(transfer.AssociationIDs, assoc.ID) {
+				sources = append(sources, assoc)
+			}
+		}
+	}
+	return d.transferAssociations(email, transfer, sources)
+}
+
+// syncAssociationsOnSeriesChange keeps a story's associations reachable after it joins, leaves or
+// switches series. Joining moves the story's own associations into the series bible; leaving
+// copies the series bible, with the volume's overrides applied, back onto the story.
+func (d *DAO) syncAssociationsOnSeriesChange(email, storyID, oldSeriesID, newSeriesID string) error {
+	if oldSeriesID == newSeriesID {
+		return nil
+	}
+	if oldSeriesID == "" {
+		_, err := d.transferAssociationsFrom(email, storyID, newSeriesID, TRANSFER_MODE_MOVE)
+		return err
+	}
+
+	sources, err := d.getStoredAssociations(email, oldSeriesID)
+	if err != nil {
+		return err
+	}
+	for i, assoc := range sources {
+		override, err := d.GetAssociationOverride(email, storyID, assoc.ID)
+		if err != nil {
+			return err
+		}
+		if override != nil {
+			sources[i] = applyAssociationOverride(assoc, override)
+			sources[i].OverriddenFields = nil
+			if err = d.DeleteAssociationOverride(email, storyID, assoc.ID); err != nil {
+				return err
+			}
+		}
+	}
+	target := newSeriesID
+	if target == "" {
+		target = storyID
+	}
+	_, err = d.transferAssociations(email, models.AssociationTransfer{
+		SourceID:   oldSeriesID,
+		TargetID:   target,
+		Mode:       TRANSFER_MODE_COPY,
+		OnConflict: CONFLICT_MERGE,
+	}, sources)
+	return err
+}
+
+func (d *DAO) transferAssociationsFrom(email, sourceID, targetID, mode string) (*models.AssociationTransferResult, error) {
+	sources, err := d.getStoredAssociations(email, sourceID)
+	if err != nil {
+		return nil, err
+	}
+	return d.transferAssociations(email, models.AssociationTransfer{
+		SourceID:   sourceID,
+		TargetID:   targetID,
+		Mode:       mode,
+		OnConflict: CONFLICT_MERGE,
+	}, sources)
+}
+
+func (d *DAO) transferAssociations(email string, transfer models.AssociationTransfer, sources []*models.Association) (*models.AssociationTransferResult, error) {
+	result := &models.AssociationTransferResult{
+		Transferred: []*models.Association{},
+		Conflicts:   []models.AssociationConflict{},
+		IDMap:       map[string]string{},
+	}
+	if len(sources) == 0 {
+		return result, nil
+	}
+	targets, err := d.getStoredAssociations(email, transfer.TargetID)
+	if err != nil {
+		return nil, err
+	}
+	if err = d.copyAssociationTypes(email, transfer.SourceID, transfer.TargetID, sources); err != nil {
+		return nil, err
+	}
+
+	writes, idMap, conflicts := planAssociationTransfer(sources, targets, transfer.Mode, transfer.OnConflict)
+	for _, assoc := range writes {
+		remapReferenceFields(assoc.Details.CustomFields, idMap)
+	}
+	if len(writes) > 0 {
+		if err = d.WriteAssociations(email, transfer.TargetID, writes); err != nil {
+			return nil, err
+		}
+	}
+	if err = d.transferRelationships(email, transfer, idMap); err != nil {
+		return nil, err
+	}
+	if transfer.Mode == TRANSFER_MODE_MOVE {
+		ids := make([]string, len(sources))
+		for i, assoc := range sources {
+			ids[i] = assoc.ID
+		}
+		if err = d.deleteStoredAssociations(email, transfer.SourceID, ids); err != nil {
+			return nil, err
+		}
+	}
+	result.Transferred = writes
+	result.Conflicts = conflicts
+	result.IDMap = idMap
+	return result, nil
+}
+
+// planAssociationTransfer works out which associations to write to the target and maps every
+// source association id onto the id it ends up with in the target
+func planAssociationTransfer(sources, targets []*models.Association, mode, onConflict string) (writes []*models.Association, idMap map[string]string, conflicts []models.AssociationConflict) {
+	writes = []*models.Association{}
+	conflicts = []models.AssociationConflict{}
+	idMap = make(map[string]string, len(sources))
+	byName := make(map[string]*models.Association, len(targets))
+	takenIDs := make(map[string]bool, len(targets))
+	for _, t := range targets {
+		byName[associationNameKey(t.Name, t.Type)] = t
+		takenIDs[t.ID] = true
+	}
+	writeIndex := make(map[string]int)
+	upsert := func(assoc *models.Association) {
+		if idx, ok := writeIndex[assoc.ID]; ok {
+			writes[idx] = assoc
+			return
+		}
+		writeIndex[assoc.ID] = len(writes)
+		writes = append(writes, assoc)
+	}
+	freshID := func(id string) string {
+		if mode == TRANSFER_MODE_COPY || takenIDs[id] {
+			id = uuid.New().String()
+		}
+		takenIDs[id] = true
+		return id
+	}
+
+	for _, src := range sources {
+		key := associationNameKey(src.Name, src.Type)
+		existing, conflict := byName[key]
+		if !conflict {
+			item := cloneAssociation(src)
+			item.ID = freshID(src.ID)
+			idMap[src.ID] = item.ID
+			byName[key] = item
+			upsert(item)
+			continue
+		}
+
+		resolved := models.AssociationConflict{
+			SourceID: src.ID,
+			TargetID: existing.ID,
+			Name:     existing.Name,
+			Type:     existing.Type,
+		}
+		switch onConflict {
+		case CONFLICT_KEEP_TARGET:
+			idMap[src.ID] = existing.ID
+			resolved.Resolution = "kept_target"
+		case CONFLICT_KEEP_SOURCE:
+			item := cloneAssociation(src)
+			item.ID = existing.ID
+			idMap[src.ID] = existing.ID
+			byName[key] = item
+			upsert(item)
+			resolved.Resolution = "kept_source"
+		case CONFLICT_RENAME:
+			item := cloneAssociation(src)
+			item.ID = freshID(src.ID)
+			item.Name = uniqueAssociationName(src.Name, src.Type, byName)
+			idMap[src.ID] = item.ID
+			byName[associationNameKey(item.Name, item.Type)] = item
+			upsert(item)
+			resolved.TargetID = item.ID
+			resolved.Resolution = "renamed"
+		default:
+			item := mergeAssociations(existing, src)
+			idMap[src.ID] = existing.ID
+			byName[key] = item
+			upsert(item)
+			resolved.Resolution = "merged"
+		}
+		conflicts = append(conflicts, resolved)
+	}
+	return writes, idMap, conflicts
+}
+
+func associationNameKey(name, associationType string) string {
+	return strings.ToLower(strings.TrimSpace(name)) + "|" + associationType
+}
+
+func uniqueAssociationName(name, associationType string, byName map[string]*models.Association) string {
+	for n := 2; ; n++ {
+		candidate := fmt.Sprintf("%s (%d)", name, n)
+		if _, taken := byName[associationNameKey(candidate, associationType)]; !taken {
+			return candidate
+		}
+	}
+}
+
+func cloneAssociation(assoc *models.Association) *models.Association {
+	clone := *assoc
+	clone.OverriddenFields = nil
+	if assoc.Details.CustomFields != nil {
+		clone.Details.CustomFields = make(map[string]interface{}, len(assoc.Details.CustomFields))
+		for k, v := range assoc.Details.CustomFields {
+			clone.Details.CustomFields[k] = v
+		}
+	}
+	return &clone
+}
+
+// mergeAssociations keeps the target's values, filling any blanks from the source and
+// combining both sets of aliases
+func mergeAssociations(target, source *models.Association) *models.Association {
+	merged := cloneAssociation(target)
+	if merged.ShortDescription == "" {
+		merged.ShortDescription = source.ShortDescription
+	}
+	if merged.Portrait == "" {
+		merged.Portrait = source.Portrait
+	}
+	if merged.Details.ExtendedDescription == "" {
+		merged.Details.ExtendedDescription = source.Details.ExtendedDescription
+	}
+	aliases := []string{}
+	seen := make(map[string]bool)
+	for _, list := range []string{target.Details.Aliases, source.Details.Aliases} {
+		for _, alias := range strings.Split(list, ",") {
+			alias = strings.TrimSpace(alias)
+			if alias == "" || seen[strings.ToLower(alias)] {
+				continue
+			}
+			seen[strings.ToLower(alias)] = true
+			aliases = append(aliases, alias)
+		}
+	}
+	merged.Details.Aliases = strings.Join(aliases, ",")
+	for k, v := range source.Details.CustomFields {
+		if merged.Details.CustomFields == nil {
+			merged.Details.CustomFields = make(map[string]interface{})
+		}
+		if _, ok := merged.Details.CustomFields[k]; !ok {
+			merged.Details.CustomFields[k] = v
+		}
+	}
+	return merged
+}
+
+// remapReferenceFields points reference custom fields at the ids the associations received in the target
+func remapReferenceFields(fields map[string]interface{}, idMap map[string]string) {
+	for k, v := range fields {
+		switch val := v.(type) {
+		case string:
+			if mapped, ok := idMap[val]; ok {
+				fields[k] = mapped
+			}
+		case []interface{}:
+			for i, item := range val {
+				if s, ok := item.(string); ok {
+					if mapped, ok := idMap[s]; ok {
+						val[i] = mapped
+					}
+				}
+			}
+		}
+	}
+}
+
+// copyAssociationTypes makes sure every custom type schema used by the transferred associations exists on the target
+func (d *DAO) copyAssociationTypes(email, sourceID, targetID string, associations []*models.Association) error {
+	used := make(map[string]bool)
+	for _, assoc := range associations {
+		used[assoc.Type] = true
+	}
+	sourceTypes, err := d.GetAssociationTypes(email, sourceID)
+	if err != nil {
+		return err
+	}
+	targetTypes, err := d.GetAssociationTypes(email, targetID)
+	if err != nil {
+		return err
+	}
+	defined := make(map[string]bool)
+	for _, t := range targetTypes {
+		if !t.BuiltIn || len(t.Fields) > 0 {
+			defined[t.Name] = true
+		}
+	}
+	for _, t := range sourceTypes {
+		if !used[t.Name] || defined[t.Name] || (t.BuiltIn && len(t.Fields) == 0) {
+			continue
+		}
+		if err = d.WriteAssociationType(email, targetID, *t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *DAO) transferRelationships(email string, transfer models.AssociationTransfer, idMap map[string]string) error {
+	relationships, err := d.GetAssociationRelationships(email, transfer.SourceID)
+	if err != nil {
+		return err
+	}
+	carried := []*models.AssociationRelationship{}
+	touched := []*models.AssociationRelationship{}
+	for _, rel := range relationships {
+		newSource, srcOK := idMap[rel.SourceID]
+		newTarget, tgtOK := idMap[rel.TargetID]
+		if srcOK || tgtOK {
+			touched = append(touched, rel)
+		}
+		if !srcOK || !tgtOK || newSource == newTarget {
+			continue
+		}
+		copied := *rel
+		if transfer.Mode == TRANSFER_MODE_COPY {
+			copied.ID = uuid.New().String()
+		}
+		copied.SourceID = newSource
+		copied.TargetID = newTarget
+		carried = append(carried, &copied)
+	}
+	if len(carried) > 0 {
+		if err = d.WriteAssociationRelationships(email, transfer.TargetID, carried); err != nil {
+			return err
+		}
+	}
+	if transfer.Mode == TRANSFER_MODE_MOVE && len(touched) > 0 {
+		return d.DeleteAssociationRelationships(email, transfer.SourceID, touched)
+	}
+	return nil
+}
+
+// getStoredAssociations returns every association, with details, stored directly against a story or series
+func (d *DAO) getStoredAssociations(email, storyOrSeriesID string) ([]*models.Association, error) {
+	expressionValues := map[string]types.AttributeValue{
+		":eml": &types.AttributeValueMemberS{Value: email},
+		":s":   &types.AttributeValueMemberS{Value: storyOrSeriesID},
+	}
+	out, err := d.DynamoClient.Scan(context.TODO(), &dynamodb.ScanInput{
+		TableName:                 aws.String("associations" + GetTableSuffix()),
+		FilterExpression:          aws.String("author=:eml AND story_or_series_id=:s AND attribute_not_exists(deleted_at)"),
+		ExpressionAttributeValues: expressionValues,
+	})
+	if err != nil {
+		return nil, err
+	}
+	associations := []*models.Association{}
+	if err = attributevalue.UnmarshalListOfMaps(out.Items, &associations); err != nil {
+		return nil, err
+	}
+	if len(associations) == 0 {
+		return associations, nil
+	}
+	outDetails, err := d.DynamoClient.Scan(context.TODO(), &dynamodb.ScanInput{
+		TableName:                 aws.String("association_details" + GetTableSuffix()),
+		FilterExpression:          aws.String("author=:eml AND story_or_series_id=:s"),
+		ExpressionAttributeValues: expressionValues,
+	})
+	if err != nil {
+		return nil, err
+	}
+	details := make(map[string]models.AssociationDetails, len(outDetails.Items))
+	for _, item := range outDetails.Items {
+		idAttr, ok := item["association_id"].(*types.AttributeValueMemberS)
+		if !ok {
+			continue
+		}
+		var deets models.AssociationDetails
+		if err = attributevalue.UnmarshalMap(item, &deets); err != nil {
+			return nil, err
+		}
+		details[idAttr.Value] = deets
+	}
+	for _, assoc := range associations {
+		assoc.Details = details[assoc.ID]
+	}
+	return associations, nil
+}
+
+// deleteStoredAssociations removes associations keyed directly to a story or series, without
+// resolving the story's series the way DeleteAssociations does
+func (d *DAO) deleteStoredAssociations(email, storyOrSeriesID string, associationIDs []string) error {
+	for i := 0; i < len(associationIDs); i += d.writeBatchSize {
+		end := i + d.writeBatchSize
+		if end > len(associationIDs) {
+			end = len(associationIDs)
+		}
+		batch := associationIDs[i:end]
+		writeItemsInput := &dynamodb.TransactWriteItemsInput{
+			ClientRequestToken: nil,
+			TransactItems:      make([]types.TransactWriteItem, 0, len(batch)*2),
+		}
+		for _, id := range batch {
+			key := map[string]types.AttributeValue{
+				"association_id":     &types.AttributeValueMemberS{Value: id},
+				"story_or_series_id": &types.AttributeValueMemberS{Value: storyOrSeriesID},
+			}
+			for _, table := range []string{"associations", "association_details"} {
+				writeItemsInput.TransactItems = append(writeItemsInput.TransactItems, types.TransactWriteItem{
+					Delete: &types.Delete{
+						Key:                 key,
+						TableName:           aws.String(table + GetTableSuffix()),
+						ConditionExpression: aws.String("author=:eml"),
+						ExpressionAttributeValues: map[string]types.AttributeValue{
+							":eml": &types.AttributeValueMemberS{Value: email},
+						},
+					},
+				})
+			}
+		}
+		err, awsErr := d.awsWriteTransaction(writeItemsInput)
+		if err != nil {
+			return err
+		}
+		if !awsErr.IsNil() {
+			return fmt.Errorf("--AWSERROR-- Code:%s, Type: %s, Message: %s", awsErr.Code, awsErr.ErrorType, awsErr.Text)
+		}
+	}
+	return nil
+}
+
+func (d *DAO) verifyStoryOrSeriesOwnership(email, storyOrSeriesID string) error {
+	if _, err := d.GetStoryByID(email, storyOrSeriesID); err == nil {
+		return nil
+	}
+	if _, err := d.GetSeriesByID(email, storyOrSeriesID); err == nil {
+		return nil
+	}
+	return fmt.Errorf("no story or series found for id: %s", storyOrSeriesID)
+}
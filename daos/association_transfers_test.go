@@ -0,0 +1,159 @@
+package daos
+
+import (
+	"RichDocter/models"
+	"testing"
+)
+
+func TestPlanAssociationTransfer(t *testing.T) {
+	testCases := []struct {
+		name           string
+		mode           string
+		onConflict     string
+		sources        []*models.Association
+		targets        []*models.Association
+		wantWrites     int
+		wantConflicts  int
+		wantResolution string
+		wantName       string
+		wantMappedTo   string
+	}{
+		{
+			name:       "MoveWithoutConflictKeepsID",
+			mode:       TRANSFER_MODE_MOVE,
+			onConflict: CONFLICT_MERGE,
+			sources: []*models.Association{
+				{ID: "a1", Name: "Bob", Type: "character"},
+			},
+			targets:      []*models.Association{},
+			wantWrites:   1,
+			wantName:     "Bob",
+			wantMappedTo: "a1",
+		},
+		{
+			name:       "SameNameDifferentTypeIsNotAConflict",
+			mode:       TRANSFER_MODE_MOVE,
+			onConflict: CONFLICT_MERGE,
+			sources: []*models.Association{
+				{ID: "a1", Name: "Paris", Type: "character"},
+			},
+			targets: []*models.Association{
+				{ID: "t1", Name: "Paris", Type: "place"},
+			},
+			wantWrites:   1,
+			wantName:     "Paris",
+			wantMappedTo: "a1",
+		},
+		{
+			name:       "MergeIntoExisting",
+			mode:       TRANSFER_MODE_MOVE,
+			onConflict: CONFLICT_MERGE,
+			sources: []*models.Association{
+				{ID: "a1", Name: "bob", Type: "character", ShortDescription: "a baker", Details: models.AssociationDetails{Aliases: "Bobby,Rob"}},
+			},
+			targets: []*models.Association{
+				{ID: "t1", Name: "Bob", Type: "character", Details: models.AssociationDetails{Aliases: "Robert,bobby"}},
+			},
+			wantWrites:     1,
+			wantConflicts:  1,
+			wantResolution: "merged",
+			wantName:       "Bob",
+			wantMappedTo:   "t1",
+		},
+		{
+			name:       "KeepTargetWritesNothing",
+			mode:       TRANSFER_MODE_COPY,
+			onConflict: CONFLICT_KEEP_TARGET,
+			sources: []*models.Association{
+				{ID: "a1", Name: "Bob", Type: "character"},
+			},
+			targets: []*models.Association{
+				{ID: "t1", Name: "Bob", Type: "character"},
+			},
+			wantWrites:     0,
+			wantConflicts:  1,
+			wantResolution: "kept_target",
+			wantMappedTo:   "t1",
+		},
+		{
+			name:       "RenameAvoidsTakenNames",
+			mode:       TRANSFER_MODE_MOVE,
+			onConflict: CONFLICT_RENAME,
+			sources: []*models.Association{
+				{ID: "a1", Name: "Bob", Type: "character"},
+			},
+			targets: []*models.Association{
+				{ID: "t1", Name: "Bob", Type: "character"},
+				{ID: "t2", Name: "Bob (2)", Type: "character"},
+			},
+			wantWrites:     1,
+			wantConflicts:  1,
+			wantResolution: "renamed",
+			wantName:       "Bob (3)",
+			wantMappedTo:   "a1",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			writes, idMap, conflicts := planAssociationTransfer(tc.sources, tc.targets, tc.mode, tc.onConflict)
+			if len(writes) != tc.wantWrites {
+				t.Fatalf("expected %d writes, got %d", tc.wantWrites, len(writes))
+			}
+			if len(conflicts) != tc.wantConflicts {
+				t.Fatalf("expected %d conflicts, got %d", tc.wantConflicts, len(conflicts))
+			}
+			if tc.wantConflicts > 0 && conflicts[0].Resolution != tc.wantResolution {
+				t.Errorf("expected resolution %q, got %q", tc.wantResolution, conflicts[0].Resolution)
+			}
+			if tc.wantWrites > 0 && writes[0].Name != tc.wantName {
+				t.Errorf("expected name %q, got %q", tc.wantName, writes[0].Name)
+			}
+			if idMap["a1"] != tc.wantMappedTo {
+				t.Errorf("expected a1 to map to %q, got %q", tc.wantMappedTo, idMap["a1"])
+			}
+		})
+	}
+}
+
+func TestPlanAssociationTransferCopyUsesNewIDs(t *testing.T) {
+	sources := []*models.Association{{ID: "a1", Name: "Bob", Type: "character"}}
+	writes, idMap, _ := planAssociationTransfer(sources, nil, TRANSFER_MODE_COPY, CONFLICT_MERGE)
+	if len(writes) != 1 {
+		t.Fatalf("expected 1 write, got %d", len(writes))
+	}
+	if writes[0].ID == "a1" || idMap["a1"] != writes[0].ID {
+		t.Errorf("expected copy to receive a fresh id, got %q", writes[0].ID)
+	}
+	if sources[0].ID != "a1" {
+		t.Errorf("source association was modified")
+	}
+}
+
+func TestMergeAssociations(t *testing.T) {
+	target := &models.Association{ID: "t1", Name: "Bob", Details: models.AssociationDetails{Aliases: "Robert, bobby"}}
+	source := &models.Association{ID: "a1", Name: "Bob", ShortDescription: "a baker", Details: models.AssociationDetails{
+		Aliases:      "Bobby,Rob",
+		CustomFields: map[string]interface{}{"age": float64(40)},
+	}}
+	merged := mergeAssociations(target, source)
+	if merged.ID != "t1" {
+		t.Errorf("expected merged association to keep the target id, got %q", merged.ID)
+	}
+	if merged.ShortDescription != "a baker" {
+		t.Errorf("expected blank short description to be filled, got %q", merged.ShortDescription)
+	}
+	if merged.Details.Aliases != "Robert,bobby,Rob" {
+		t.Errorf("unexpected aliases %q", merged.Details.Aliases)
+	}
+	if merged.Details.CustomFields["age"] != float64(40) {
+		t.Errorf("expected custom field to be carried over")
+	}
+	if target.Details.CustomFields != nil {
+		t.Errorf("target association was modified")
+	}
+}
@@ -0,0 +1,294 @@
+package daos
+
+import (
+	"RichDocter/models"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	FIELD_TYPE_TEXT      = "text"
+	FIELD_TYPE_NUMBER    = "number"
+	FIELD_TYPE_DATE      = "date"
+	FIELD_TYPE_LIST      = "list"
+	FIELD_TYPE_REFERENCE = "reference"
+)
+
+var builtInAssociationTypes = []string{"character", "place", "event", "item"}
+
+func isBuiltInAssociationType(typeName string) bool {
+	for _, t := range builtInAssociationTypes {
+		if t == typeName {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *DAO) GetAssociationTypes(email, storyOrSeriesID string) (associationTypes []*models.AssociationType, err error) {
+	out, err := d.DynamoClient.Scan(context.TODO(), &dynamodb.ScanInput{
+		TableName:        aws.String("association_types" + GetTableSuffix()),
+		FilterExpression: aws.String("author=:eml AND story_or_series_id=:s"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":eml": &types.AttributeValueMemberS{Value: email},
+			":s":   &types.AttributeValueMemberS{Value: storyOrSeriesID},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	custom := []*models.AssociationType{}
+	if err = attributevalue.UnmarshalListOfMaps(out.Items, &custom); err != nil {
+		return nil, err
+	}
+	// built-in types are always available, optionally extended with a stored field schema
+	associationTypes = []*models.AssociationType{}
+	for _, name := range builtInAssociationTypes {
+		builtIn := &models.AssociationType{
+			Name:            name,
+			StoryOrSeriesID: storyOrSeriesID,
+			BuiltIn:         true,
+			Fields:          []models.AssociationFieldDefinition{},
+		}
+		for _, c := range custom {
+			if c.Name == name {
+				builtIn.Fields = c.Fields
+				builtIn.DefaultPortrait = c.DefaultPortrait
+			}
+		}
+		associationTypes = append(associationTypes, builtIn)
+	}
+	sort.Slice(custom, func(i, j int) bool {
+		return custom[i].Name < custom[j].Name
+	})
+	for _, c := range custom {
+		if !isBuiltInAssociationType(c.Name) {
+			associationTypes = append(associationTypes, c)
+		}
+	}
+	return associationTypes, nil
+}
+
+func (d *DAO) WriteAssociationType(email, storyOrSeriesID string, associationType models.AssociationType) (err error) {
+	associationType.Name = strings.ToLower(strings.TrimSpace(associationType.Name))
+	if associationType.Name == "" {
+		return fmt.Errorf("association type requires a name")
+	}
+	seen := make(map[string]bool, len(associationType.Fields))
+	for _, field := range associationType.Fields {
+		if field.Key == "" {
+			return fmt.Errorf("field definitions require a key")
+		}
+		if seen[field.Key] {
+			return fmt.Errorf("duplicate field key: %s", field.Key)
+		}
+		seen[field.Key] = true
+		switch field.Type {
+		case FIELD_TYPE_TEXT, FIELD_TYPE_NUMBER, FIELD_TYPE_DATE, FIELD_TYPE_LIST, FIELD_TYPE_REFERENCE:
+		default:
+			return fmt.Errorf("unsupported field type %q for field %s", field.Type, field.Key)
+		}
+	}
+	fields, err := attributevalue.Marshal(associationType.Fields)
+	if err != nil {
+		return err
+	}
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	_, err = d.DynamoClient.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String("association_types" + GetTableSuffix()),
+		Key: map[string]types.AttributeValue{
+			"type_name":          &types.AttributeValueMemberS{Value: associationType.Name},
+			"story_or_series_id": &types.AttributeValueMemberS{Value: storyOrSeriesID},
+		},
+		UpdateExpression:    aws.String("set author=:eml, #fields=:f, default_portrait=:p, created_at=if_not_exists(created_at,:t), last_updated=:t"),
+		ConditionExpression: aws.String("attribute_not_exists(author) OR author=:eml"),
+		ExpressionAttributeNames: map[string]string{
+			"#fields": "fields",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":eml": &types.AttributeValueMemberS{Value: email},
+			":f":   fields,
+			":p":   &types.AttributeValueMemberS{Value: associationType.DefaultPortrait},
+			":t":   &types.AttributeValueMemberN{Value: now},
+		},
+	})
+	return err
+}
+
+// DeleteAssociationType removes a custom association type once no live association uses it. Associations
+// in the trash don't hold the type, restoring one refuses until the type has been recreated.
+func (d *DAO) DeleteAssociationType(email, storyOrSeriesID, typeName string) error {
+	if isBuiltInAssociationType(typeName) {
+		return fmt.Errorf("built-in association types cannot be deleted")
+	}
+	inUse, err := d.DynamoClient.Scan(context.TODO(), &dynamodb.ScanInput{
+		TableName:        aws.String("associations" + GetTableSuffix()),
+		FilterExpression: aws.String("author=:eml AND story_or_series_id=:s AND association_type=:at AND attribute_not_exists(deleted_at)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":eml": &types.AttributeValueMemberS{Value: email},
+			":s":   &types.AttributeValueMemberS{Value: storyOrSeriesID},
+			":at":  &types.AttributeValueMemberS{Value: typeName},
+		},
+		Select: types.SelectCount,
+	})
+	if err != nil {
+		return err
+	}
+	if inUse.Count > 0 {
+		return fmt.Errorf("association type %s is still used by %d associations", typeName, inUse.Count)
+	}
+	_, err = d.DynamoClient.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String("association_types" + GetTableSuffix()),
+		Key: map[string]types.AttributeValue{
+			"type_name":          &types.AttributeValueMemberS{Value: typeName},
+			"story_or_series_id": &types.AttributeValueMemberS{Value: storyOrSeriesID},
+		},
+		ConditionExpression: aws.String("author=:eml"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":eml": &types.AttributeValueMemberS{Value: email},
+		},
+	})
+	return err
+}
+
+// validateAssociationsAgainstTypes checks every association's type and custom fields against the
+// type definitions stored for the story or series, returning the definitions keyed by type name. The
+// definitions are always loaded, as a built-in type can be given required fields.
+func (d *DAO) validateAssociationsAgainstTypes(email, storyOrSeriesID string, associations []*models.Association) (map[string]*models.AssociationType, error) {
+	associationTypes, err := d.GetAssociationTypes(email, storyOrSeriesID)
+	if err != nil {
+		return nil, err
+	}
+	definitions := make(map[string]*models.AssociationType, len(associationTypes))
+	hasReferences := false
+	for _, t := range associationTypes {
+		definitions[t.Name] = t
+		for _, field := range t.Fields {
+			hasReferences = hasReferences || field.Type == FIELD_TYPE_REFERENCE
+		}
+	}
+
+	knownIDs := make(map[string]bool)
+	for _, assoc := range associations {
+		knownIDs[assoc.ID] = true
+	}
+	if hasReferences {
+		if err = d.loadAssociationIDs(email, storyOrSeriesID, knownIDs); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, assoc := range associations {
+		def, ok := definitions[assoc.Type]
+		if !ok {
+			return nil, fmt.Errorf("unknown association type: %s", assoc.Type)
+		}
+		if err = validateCustomFields(def, assoc.Details.CustomFields, knownIDs); err != nil {
+			return nil, fmt.Errorf("association %s: %w", assoc.Name, err)
+		}
+	}
+	return definitions, nil
+}
+
+// loadAssociationIDs adds the ids of the story or series' live associations, which reference fields
+// may point at
+func (d *DAO) loadAssociationIDs(email, storyOrSeriesID string, knownIDs map[string]bool) error {
+	out, err := d.DynamoClient.Scan(context.TODO(), &dynamodb.ScanInput{
+		TableName:            aws.String("associations" + GetTableSuffix()),
+		FilterExpression:     aws.String("author=:eml AND story_or_series_id=:s AND attribute_not_exists(deleted_at)"),
+		ProjectionExpression: aws.String("association_id"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":eml": &types.AttributeValueMemberS{Value: email},
+			":s":   &types.AttributeValueMemberS{Value: storyOrSeriesID},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	for _, item := range out.Items {
+		if idAttr, ok := item["association_id"].(*types.AttributeValueMemberS); ok {
+			knownIDs[idAttr.Value] = true
+		}
+	}
+	return nil
+}
+
+func validateCustomFields(def *models.AssociationType, fields map[string]interface{}, knownIDs map[string]bool) error {
+	schema := make(map[string]models.AssociationFieldDefinition, len(def.Fields))
+	for _, f := range def.Fields {
+		schema[f.Key] = f
+		if _, present := fields[f.Key]; f.Required && !present {
+			return fmt.Errorf("missing required field: %s", f.Key)
+		}
+	}
+	for key, value := range fields {
+		field, ok := schema[key]
+		if !ok {
+			return fmt.Errorf("field %s is not defined for type %s", key, def.Name)
+		}
+		if value == nil {
+			continue
+		}
+		switch field.Type {
+		case FIELD_TYPE_TEXT:
+			if _, ok := value.(string); !ok {
+				return fmt.Errorf("field %s must be text", key)
+			}
+		case FIELD_TYPE_NUMBER:
+			if _, ok := value.(float64); !ok {
+				return fmt.Errorf("field %s must be a number", key)
+			}
+		case FIELD_TYPE_DATE:
+			str, ok := value.(string)
+			if !ok {
+				return fmt.Errorf("field %s must be a date string", key)
+			}
+			if _, err := time.Parse("2006-01-02", str); err != nil {
+				if _, err = time.Parse(time.RFC3339, str); err != nil {
+					return fmt.Errorf("field %s must be formatted as YYYY-MM-DD or RFC3339", key)
+				}
+			}
+		case FIELD_TYPE_LIST:
+			list, ok := value.([]interface{})
+			if !ok {
+				return fmt.Errorf("field %s must be a list", key)
+			}
+			for _, entry := range list {
+				str, ok := entry.(string)
+				if !ok {
+					return fmt.Errorf("field %s must only contain text entries", key)
+				}
+				if len(field.Options) > 0 && !containsString(field.Options, str) {
+					return fmt.Errorf("field %s does not allow the option %q", key, str)
+				}
+			}
+		case FIELD_TYPE_REFERENCE:
+			ref, ok := value.(string)
+			if !ok {
+				return fmt.Errorf("field %s must reference an association id", key)
+			}
+			if !knownIDs[ref] {
+				return fmt.Errorf("field %s references an unknown association: %s", key, ref)
+			}
+		}
+	}
+	return nil
+}
+
+func containsString(haystack []string, needle string) bool {
+	for _, s := range haystack {
+		if s == needle {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,314 @@
+package daos
+
+import (
+	"RichDocter/models"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestValidateCustomFields(t *testing.T) {
+	def := &models.AssociationType{
+		Name: "faction",
+		Fields: []models.AssociationFieldDefinition{
+			{Key: "motto", Type: FIELD_TYPE_TEXT, Required: true},
+			{Key: "members", Type: FIELD_TYPE_NUMBER},
+			{Key: "founded", Type: FIELD_TYPE_DATE},
+			{Key: "alignment", Type: FIELD_TYPE_LIST, Options: []string{"lawful", "chaotic"}},
+			{Key: "leader", Type: FIELD_TYPE_REFERENCE},
+		},
+	}
+	knownIDs := map[string]bool{"assoc1": true}
+	testCases := []struct {
+		name                string
+		fields              map[string]interface{}
+		wantErr             bool
+		expectedErrContains string
+	}{
+		{
+			name: "AllValid",
+			fields: map[string]interface{}{
+				"motto":     "Steel and salt",
+				"members":   float64(40),
+				"founded":   "1203-04-01",
+				"alignment": []interface{}{"lawful"},
+				"leader":    "assoc1",
+			},
+		},
+		{
+			name:                "MissingRequired",
+			fields:              map[string]interface{}{"members": float64(2)},
+			wantErr:             true,
+			expectedErrContains: "missing required field: motto",
+		},
+		{
+			name:                "UndefinedField",
+			fields:              map[string]interface{}{"motto": "x", "colour": "red"},
+			wantErr:             true,
+			expectedErrContains: "field colour is not defined",
+		},
+		{
+			name:                "WrongNumberType",
+			fields:              map[string]interface{}{"motto": "x", "members": "many"},
+			wantErr:             true,
+			expectedErrContains: "must be a number",
+		},
+		{
+			name:                "BadDate",
+			fields:              map[string]interface{}{"motto": "x", "founded": "last tuesday"},
+			wantErr:             true,
+			expectedErrContains: "YYYY-MM-DD",
+		},
+		{
+			name:                "DisallowedOption",
+			fields:              map[string]interface{}{"motto": "x", "alignment": []interface{}{"neutral"}},
+			wantErr:             true,
+			expectedErrContains: "does not allow the option",
+		},
+		{
+			name:                "UnknownReference",
+			fields:              map[string]interface{}{"motto": "x", "leader": "assoc404"},
+			wantErr:             true,
+			expectedErrContains: "references an unknown association",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+			err := validateCustomFields(def, tc.fields, knownIDs)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("Expected error but got nil")
+				} else if !contains(err.Error(), tc.expectedErrContains) {
+					t.Errorf("Error %q does not contain %q", err.Error(), tc.expectedErrContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestWriteAssociationType(t *testing.T) {
+	mockDao := NewMockDAO()
+	testCases := []struct {
+		name                string
+		associationType     models.AssociationType
+		wantWrite           bool
+		wantErr             bool
+		expectedErrContains string
+	}{
+		{
+			name: "CustomType",
+			associationType: models.AssociationType{
+				Name:   " Faction ",
+				Fields: []models.AssociationFieldDefinition{{Key: "motto", Type: FIELD_TYPE_TEXT, Required: true}},
+			},
+			wantWrite: true,
+		},
+		{
+			name:            "ExtendedBuiltIn",
+			associationType: models.AssociationType{Name: "character", DefaultPortrait: "https://example.com/portrait.png"},
+			wantWrite:       true,
+		},
+		{
+			name: "DuplicateField",
+			associationType: models.AssociationType{
+				Name: "faction",
+				Fields: []models.AssociationFieldDefinition{
+					{Key: "motto", Type: FIELD_TYPE_TEXT},
+					{Key: "motto", Type: FIELD_TYPE_NUMBER},
+				},
+			},
+			wantErr:             true,
+			expectedErrContains: "duplicate field key",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			written := false
+			mockClient := mockDao.DynamoClient.(*MockDynamoClient)
+			mockClient.MockUpdateItem = func(ctx context.Context, input *dynamodb.UpdateItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+				written = true
+				assertAliased(t, input.UpdateExpression, input.ExpressionAttributeNames)
+				assertAliased(t, input.ConditionExpression, input.ExpressionAttributeNames)
+				if name := input.Key["type_name"].(*types.AttributeValueMemberS).Value; name != strings.ToLower(strings.TrimSpace(tc.associationType.Name)) {
+					t.Errorf("expected the type name normalised, got %q", name)
+				}
+				return &dynamodb.UpdateItemOutput{}, nil
+			}
+
+			err := mockDao.WriteAssociationType("test@example.com", "story1", tc.associationType)
+			if tc.wantErr {
+				if err == nil || !contains(err.Error(), tc.expectedErrContains) {
+					t.Errorf("expected an error containing %q, got %v", tc.expectedErrContains, err)
+				}
+			} else if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if written != tc.wantWrite {
+				t.Errorf("expected written=%v, got %v", tc.wantWrite, written)
+			}
+		})
+	}
+}
+
+func TestWriteAssociationsAgainstStoredTypes(t *testing.T) {
+	mockDao := NewMockDAO()
+	storedCharacter := map[string]types.AttributeValue{
+		"type_name":          &types.AttributeValueMemberS{Value: "character"},
+		"story_or_series_id": &types.AttributeValueMemberS{Value: "story1"},
+		"author":             &types.AttributeValueMemberS{Value: "test@example.com"},
+		"default_portrait":   &types.AttributeValueMemberS{Value: "https://example.com/portrait.png"},
+		"fields": &types.AttributeValueMemberL{Value: []types.AttributeValue{
+			&types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+				"key":      &types.AttributeValueMemberS{Value: "motto"},
+				"type":     &types.AttributeValueMemberS{Value: FIELD_TYPE_TEXT},
+				"required": &types.AttributeValueMemberBOOL{Value: true},
+			}},
+		}},
+	}
+	testCases := []struct {
+		name                string
+		storedTypes         []map[string]types.AttributeValue
+		association         models.Association
+		wantPortrait        string
+		wantErr             bool
+		expectedErrContains string
+	}{
+		{
+			name:                "RequiredFieldOnBuiltIn",
+			storedTypes:         []map[string]types.AttributeValue{storedCharacter},
+			association:         models.Association{ID: "assoc1", Name: "Alice", Type: "character"},
+			wantErr:             true,
+			expectedErrContains: "motto",
+		},
+		{
+			name:        "DefaultPortraitOnBuiltIn",
+			storedTypes: []map[string]types.AttributeValue{storedCharacter},
+			association: models.Association{ID: "assoc1", Name: "Alice", Type: "character", Details: models.AssociationDetails{
+				CustomFields: map[string]interface{}{"motto": "onwards"},
+			}},
+			wantPortrait: "https://example.com/portrait.png",
+		},
+		{
+			name:         "ExplicitPortrait",
+			storedTypes:  []map[string]types.AttributeValue{storedCharacter},
+			association:  models.Association{ID: "assoc1", Name: "Alice", Type: "character", Portrait: "https://example.com/alice.png", Details: models.AssociationDetails{CustomFields: map[string]interface{}{"motto": "onwards"}}},
+			wantPortrait: "https://example.com/alice.png",
+		},
+		{
+			name:         "UnextendedBuiltIn",
+			association:  models.Association{ID: "assoc1", Name: "Alice", Type: "character"},
+			wantPortrait: S3_PORTRAIT_BASE_URL,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			mockClient := mockDao.DynamoClient.(*MockDynamoClient)
+			typeLookups := 0
+			mockClient.MockScan = func(ctx context.Context, input *dynamodb.ScanInput, opts ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+				if strings.HasPrefix(*input.TableName, "association_types") {
+					typeLookups++
+					return &dynamodb.ScanOutput{Items: tc.storedTypes}, nil
+				}
+				return &dynamodb.ScanOutput{}, nil
+			}
+			portrait := ""
+			mockClient.MockTransactWriteItems = func(ctx context.Context, input *dynamodb.TransactWriteItemsInput, opts ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+				for _, item := range input.TransactItems {
+					if p, ok := item.Update.ExpressionAttributeValues[":p"].(*types.AttributeValueMemberS); ok {
+						portrait = p.Value
+					}
+				}
+				return &dynamodb.TransactWriteItemsOutput{}, nil
+			}
+
+			association := tc.association
+			err := mockDao.WriteAssociations("test@example.com", "story1", []*models.Association{&association})
+			if typeLookups != 1 {
+				t.Errorf("expected the stored types to be loaded once, got %d lookups", typeLookups)
+			}
+			if tc.wantErr {
+				if err == nil || !contains(err.Error(), tc.expectedErrContains) {
+					t.Errorf("expected an error containing %q, got %v", tc.expectedErrContains, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !strings.HasPrefix(portrait, tc.wantPortrait) {
+				t.Errorf("expected portrait %q, got %q", tc.wantPortrait, portrait)
+			}
+		})
+	}
+}
+
+func TestDeleteAssociationType(t *testing.T) {
+	mockDao := NewMockDAO()
+	testCases := []struct {
+		name                string
+		typeName            string
+		liveCount           int32
+		wantDelete          bool
+		wantErr             bool
+		expectedErrContains string
+	}{
+		{name: "Unused", typeName: "faction", wantDelete: true},
+		{name: "InUse", typeName: "faction", liveCount: 2, wantErr: true, expectedErrContains: "still used by 2"},
+		{name: "BuiltIn", typeName: "character", wantErr: true, expectedErrContains: "cannot be deleted"},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			mockClient := mockDao.DynamoClient.(*MockDynamoClient)
+			mockClient.MockScan = func(ctx context.Context, input *dynamodb.ScanInput, opts ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+				// associations in the trash don't keep the type in use
+				if !strings.Contains(*input.FilterExpression, "attribute_not_exists(deleted_at)") {
+					t.Errorf("expected trashed associations excluded, got filter %q", *input.FilterExpression)
+				}
+				return &dynamodb.ScanOutput{Count: tc.liveCount}, nil
+			}
+			deleted := false
+			mockClient.MockDeleteItem = func(ctx context.Context, input *dynamodb.DeleteItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+				deleted = true
+				return &dynamodb.DeleteItemOutput{}, nil
+			}
+
+			err := mockDao.DeleteAssociationType("test@example.com", "story1", tc.typeName)
+			if tc.wantErr {
+				if err == nil || !contains(err.Error(), tc.expectedErrContains) {
+					t.Errorf("expected an error containing %q, got %v", tc.expectedErrContains, err)
+				}
+			} else if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if deleted != tc.wantDelete {
+				t.Errorf("expected deleted=%v, got %v", tc.wantDelete, deleted)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package daos
 import (
 	"RichDocter/models"
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
 	"strconv"
@@ -14,10 +15,16 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
+var ErrAssociationNotFound = errors.New("association not found")
+
 func (d DAO) WriteAssociations(email, storyOrSeriesID string, associations []*models.Association) (err error) {
 	if len(associations) == 0 {
 		return fmt.Errorf("empty associations array")
 	}
+	definitions, err := d.validateAssociationsAgainstTypes(email, storyOrSeriesID, associations)
+	if err != nil {
+		return err
+	}
 	batches := make([][]*models.Association, 0, (len(associations)+(d.writeBatchSize-1))/d.writeBatchSize)
 	for i := 0; i < len(associations); i += d.writeBatchSize {
 		end := i + d.writeBatchSize
@@ -40,6 +47,10 @@ func (d DAO) WriteAssociations(email, storyOrSeriesID string, associations []*mo
 		}
 		for i, item := range batch {
 			imgFile := item.Portrait
+			if def, ok := definitions[item.Type]; ok && imgFile == "" {
+				// a type's own default portrait wins over the built-in stock images
+				imgFile = def.DefaultPortrait
+			}
 			if imgFile == "" {
 				switch item.Type {
 				case "character":
@@ -56,6 +67,13 @@ func (d DAO) WriteAssociations(email, storyOrSeriesID string, associations []*mo
 					imgFile = S3_ITEM_BASE_URL + strconv.Itoa(imageFileName) + ".jpg"
 				}
 			}
+			customFields, err := attributevalue.Marshal(item.Details.CustomFields)
+			if err != nil {
+				return err
+			}
+			if item.Details.CustomFields == nil {
+				customFields = &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{}}
+			}
 			shortDescription := item.ShortDescription
 			extendedDescription := item.Details.ExtendedDescription
 			associations[i].Portrait = imgFile
@@ -82,12 +100,13 @@ func (d DAO) WriteAssociations(email, storyOrSeriesID string, associations []*mo
 			updateDetailsInput := &types.Update{
 				TableName:        aws.String("association_details" + GetTableSuffix()),
 				Key:              key,
-				UpdateExpression: aws.String("set author=:eml, case_sensitive=:c, extended_description=:ed, aliases=:al"),
+				UpdateExpression: aws.String("set author=:eml, case_sensitive=:c, extended_description=:ed, aliases=:al, custom_fields=:cf"),
 				ExpressionAttributeValues: map[string]types.AttributeValue{
 					":eml": &types.AttributeValueMemberS{Value: email},
 					":c":   &types.AttributeValueMemberBOOL{Value: item.Details.CaseSensitive},
 					":ed":  &types.AttributeValueMemberS{Value: extendedDescription},
 					":al":  &types.AttributeValueMemberS{Value: item.Details.Aliases},
+					":cf":  customFields,
 				},
 			}
 
@@ -168,6 +187,7 @@ func (d *DAO) DeleteAssociations(email, storyID string, associations []*models.A
 	if storyOrSeriesID == "" {
 		storyOrSeriesID = storyID
 	}
+	now := strconv.FormatInt(time.Now().Unix(), 10)
 
 	// Loop through the items and create the transaction write items.
 	for _, batch := range batches {
@@ -186,29 +206,35 @@ func (d *DAO) DeleteAssociations(email, storyID string, associations []*models.A
 				"story_or_series_id": &types.AttributeValueMemberS{Value: storyOrSeriesID},
 			}
 
-			// Create a delete input for the item.
-			deleteInput := &types.Delete{
-				Key:       key,
-				TableName: aws.String("associations" + GetTableSuffix()),
+			// Mark the item deleted, keeping it restorable from the trash.
+			deleteInput := &types.Update{
+				Key:                 key,
+				TableName:           aws.String("associations" + GetTableSuffix()),
+				UpdateExpression:    aws.String("set deleted_at = :n, automated_deletion = :a"),
+				ConditionExpression: aws.String("author=:eml"),
 				ExpressionAttributeValues: map[string]types.AttributeValue{
 					":eml": &types.AttributeValueMemberS{Value: email},
-					":t":   &types.AttributeValueMemberS{Value: item.Type},
+					":n":   &types.AttributeValueMemberN{Value: now},
+					":a":   &types.AttributeValueMemberBOOL{Value: false},
 				},
 			}
-			deleteDetailsInput := &types.Delete{
+			deleteDetailsInput := &types.Update{
 				Key:                 key,
 				TableName:           aws.String("association_details" + GetTableSuffix()),
+				UpdateExpression:    aws.String("set deleted_at = :n, automated_deletion = :a"),
 				ConditionExpression: aws.String("author=:eml"),
 				ExpressionAttributeValues: map[string]types.AttributeValue{
 					":eml": &types.AttributeValueMemberS{Value: email},
+					":n":   &types.AttributeValueMemberN{Value: now},
+					":a":   &types.AttributeValueMemberBOOL{Value: false},
 				},
 			}
 			// Create a transaction write item for the update operation.
 			writeItem := types.TransactWriteItem{
-				Delete: deleteInput,
+				Update: deleteInput,
 			}
 			writeDetailsItem := types.TransactWriteItem{
-				Delete: deleteDetailsInput,
+				Update: deleteDetailsInput,
 			}
 
 			// Add the transaction write item to the list of transaction write items.
@@ -265,12 +291,29 @@ func (d *DAO) GetAssociationDetails(email, storyID, associationID string) (*mode
 	if storyOrSeries == "" {
 		storyOrSeries = storyID
 	}
+	if association, err = d.getStoredAssociation(storyOrSeries, associationID); err != nil {
+		return association, err
+	}
+	if storyOrSeries != storyID {
+		// the story is a series volume, so layer its overrides on top of the series bible
+		override, err := d.GetAssociationOverride(email, storyID, associationID)
+		if err != nil {
+			return association, err
+		}
+		association = applyAssociationOverride(association, override)
+	}
+	return association, nil
+}
+
+// getStoredAssociation returns the association and its details exactly as stored for a story or series
+func (d *DAO) getStoredAssociation(storyOrSeriesID, associationID string) (association *models.Association, err error) {
 	outAssociation, err := d.DynamoClient.Query(context.TODO(), &dynamodb.QueryInput{
 		TableName:              aws.String("associations" + GetTableSuffix()),
 		KeyConditionExpression: aws.String("association_id = :aid AND story_or_series_id = :s"),
+		FilterExpression:       aws.String("attribute_not_exists(deleted_at)"),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
 			":aid": &types.AttributeValueMemberS{Value: associationID},
-			":s":   &types.AttributeValueMemberS{Value: storyOrSeries},
+			":s":   &types.AttributeValueMemberS{Value: storyOrSeriesID},
 		},
 		Limit: aws.Int32(1), // Limit to one result
 	})
@@ -278,16 +321,17 @@ func (d *DAO) GetAssociationDetails(email, storyID, associationID string) (*mode
 		return association, err
 	}
 	if len(outAssociation.Items) == 0 {
-		return nil, fmt.Errorf("no association found for id: %s", associationID)
+		return nil, fmt.Errorf("%w: %s", ErrAssociationNotFound, associationID)
 	}
 	if err = attributevalue.UnmarshalMap(outAssociation.Items[0], &association); err != nil {
 		return association, err
 	}
 	outDetails, err := d.DynamoClient.Scan(context.TODO(), &dynamodb.ScanInput{
 		TableName:        aws.String("association_details" + GetTableSuffix()),
-		FilterExpression: aws.String("association_id=:aid"),
+		FilterExpression: aws.String("association_id=:aid AND story_or_series_id=:s"),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
 			":aid": &types.AttributeValueMemberS{Value: associationID},
+			":s":   &types.AttributeValueMemberS{Value: storyOrSeriesID},
 		},
 	})
 	if err != nil {
@@ -332,7 +376,7 @@ func (d *DAO) GetStoryOrSeriesAssociationThumbnails(email, storyID string, needD
 	if storyOrSeries == "" {
 		storyOrSeries = storyID
 	}
-	filterString := "author=:eml AND story_or_series_id=:s"
+	filterString := "author=:eml AND story_or_series_id=:s AND attribute_not_exists(deleted_at)"
 	expressionValues := map[string]types.AttributeValue{
 		":eml": &types.AttributeValueMemberS{Value: email},
 		":s":   &types.AttributeValueMemberS{Value: storyOrSeries},
@@ -0,0 +1,135 @@
+package daos
+
+import (
+	"RichDocter/models"
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	// each story's change log keeps its next sequence number on an item at seq 0
+	changeLogHeadSeq = "0"
+	// most changes handed out per request, clients page through the rest
+	changeFeedPageSize = 500
+)
+
+// RecordChanges appends mutations to a story or series change log, numbering them in order
+func (d *DAO) RecordChanges(storyOrSeriesID string, changes []*models.StoryChange) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	// reserve a run of sequence numbers in one atomic step
+	out, err := d.DynamoClient.UpdateItem(context.Background(), &dynamodb.UpdateItemInput{
+		TableName: aws.String("story_changes" + GetTableSuffix()),
+		Key: map[string]types.AttributeValue{
+			"story_id": &types.AttributeValueMemberS{Value: storyOrSeriesID},
+			"seq":      &types.AttributeValueMemberN{Value: changeLogHeadSeq},
+		},
+		UpdateExpression: aws.String("ADD head :n"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":n": &types.AttributeValueMemberN{Value: strconv.Itoa(len(changes))},
+		},
+		ReturnValues: types.ReturnValueUpdatedNew,
+	})
+	if err != nil {
+		return err
+	}
+	head, err := changeLogHead(out.Attributes)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	first := head - int64(len(changes)) + 1
+	for i, change := range changes {
+		change.StoryID = storyOrSeriesID
+		change.Seq = first + int64(i)
+		change.CreatedAt = now
+	}
+
+	for i := 0; i < len(changes); i += d.writeBatchSize {
+		end := i + d.writeBatchSize
+		if end > len(changes) {
+			end = len(changes)
+		}
+		writeItemsInput := &dynamodb.TransactWriteItemsInput{
+			TransactItems: make([]types.TransactWriteItem, 0, end-i),
+		}
+		for _, change := range changes[i:end] {
+			item, err := attributevalue.MarshalMap(change)
+			if err != nil {
+				return err
+			}
+			writeItemsInput.TransactItems = append(writeItemsInput.TransactItems, types.TransactWriteItem{
+				Put: &types.Put{
+					TableName: aws.String("story_changes" + GetTableSuffix()),
+					Item:      item,
+				},
+			})
+		}
+		err, awsErr := d.awsWriteTransaction(writeItemsInput)
+		if err != nil {
+			return err
+		}
+		if !awsErr.IsNil() {
+			return fmt.Errorf("--AWSERROR-- Code:%s, Type: %s, Message: %s", awsErr.Code, awsErr.ErrorType, awsErr.Text)
+		}
+	}
+	return nil
+}
+
+// GetChanges returns the change log entries recorded after since, oldest first
+func (d *DAO) GetChanges(storyOrSeriesID string, since int64) (*models.ChangeFeed, error) {
+	if since < 0 {
+		return nil, fmt.Errorf("since must not be negative")
+	}
+	feed := &models.ChangeFeed{Changes: []*models.StoryChange{}, Seq: since}
+	var lastKey map[string]types.AttributeValue
+	// read one past the page to learn whether there is more
+	for len(feed.Changes) <= changeFeedPageSize {
+		out, err := d.DynamoClient.Query(context.TODO(), &dynamodb.QueryInput{
+			TableName:              aws.String("story_changes" + GetTableSuffix()),
+			KeyConditionExpression: aws.String("story_id=:s AND seq > :since"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":s":     &types.AttributeValueMemberS{Value: storyOrSeriesID},
+				":since": &types.AttributeValueMemberN{Value: strconv.FormatInt(since, 10)},
+			},
+			ExclusiveStartKey: lastKey,
+			Limit:             aws.Int32(int32(changeFeedPageSize - len(feed.Changes) + 1)),
+		})
+		if err != nil {
+			return nil, err
+		}
+		changes := []*models.StoryChange{}
+		if err = attributevalue.UnmarshalListOfMaps(out.Items, &changes); err != nil {
+			return nil, err
+		}
+		feed.Changes = append(feed.Changes, changes...)
+		lastKey = out.LastEvaluatedKey
+		if lastKey == nil {
+			break
+		}
+	}
+	if len(feed.Changes) > changeFeedPageSize {
+		feed.Changes = feed.Changes[:changeFeedPageSize]
+		feed.HasMore = true
+	}
+	if len(feed.Changes) > 0 {
+		feed.Seq = feed.Changes[len(feed.Changes)-1].Seq
+	}
+	return feed, nil
+}
+
+func changeLogHead(attributes map[string]types.AttributeValue) (int64, error) {
+	head, ok := attributes["head"].(*types.AttributeValueMemberN)
+	if !ok {
+		return 0, fmt.Errorf("unable to reserve change log sequence numbers")
+	}
+	return strconv.ParseInt(head.Value, 10, 64)
+}
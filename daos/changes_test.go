@@ -0,0 +1,125 @@
+package daos
+
+import (
+	"RichDocter/models"
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestRecordChanges(t *testing.T) {
+	testCases := []struct {
+		name      string
+		storedSeq int64
+		changes   int
+		wantSeqs  []int64
+		wantPuts  int
+	}{
+		{name: "FirstChange", storedSeq: 0, changes: 1, wantSeqs: []int64{1}, wantPuts: 1},
+		{name: "AppendsAfterHead", storedSeq: 7, changes: 3, wantSeqs: []int64{8, 9, 10}, wantPuts: 3},
+		{name: "NothingToRecord", storedSeq: 4, changes: 0, wantSeqs: []int64{}, wantPuts: 0},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			mockDao := NewMockDAO()
+			mockClient, ok := mockDao.DynamoClient.(*MockDynamoClient)
+			if !ok {
+				t.Fatalf("mockDao.DynamoClient is not a *MockDynamoClient; got %T", mockDao.DynamoClient)
+			}
+			head := tc.storedSeq
+			mockClient.MockUpdateItem = func(ctx context.Context, input *dynamodb.UpdateItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+				n, _ := strconv.ParseInt(input.ExpressionAttributeValues[":n"].(*types.AttributeValueMemberN).Value, 10, 64)
+				head += n
+				return &dynamodb.UpdateItemOutput{Attributes: map[string]types.AttributeValue{
+					"head": &types.AttributeValueMemberN{Value: strconv.FormatInt(head, 10)},
+				}}, nil
+			}
+			puts := 0
+			mockClient.MockTransactWriteItems = func(ctx context.Context, input *dynamodb.TransactWriteItemsInput, opts ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+				puts += len(input.TransactItems)
+				return &dynamodb.TransactWriteItemsOutput{}, nil
+			}
+
+			changes := make([]*models.StoryChange, tc.changes)
+			for i := range changes {
+				changes[i] = &models.StoryChange{Entity: models.ChangeEntityBlock, Action: models.ChangeUpsert, EntityID: "block" + strconv.Itoa(i)}
+			}
+			if err := mockDao.RecordChanges("story1", changes); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			for i, change := range changes {
+				if change.Seq != tc.wantSeqs[i] {
+					t.Errorf("change %d: expected seq %d, got %d", i, tc.wantSeqs[i], change.Seq)
+				}
+				if change.StoryID != "story1" {
+					t.Errorf("change %d: expected story1, got %q", i, change.StoryID)
+				}
+			}
+			if puts != tc.wantPuts {
+				t.Errorf("expected %d puts, got %d", tc.wantPuts, puts)
+			}
+		})
+	}
+}
+
+func TestGetChangesPaging(t *testing.T) {
+	testCases := []struct {
+		name        string
+		stored      int
+		since       int64
+		wantCount   int
+		wantSeq     int64
+		wantHasMore bool
+	}{
+		{name: "UpToDate", stored: 3, since: 3, wantCount: 0, wantSeq: 3},
+		{name: "SomeNew", stored: 10, since: 4, wantCount: 6, wantSeq: 10},
+		{name: "MorePages", stored: changeFeedPageSize + 20, since: 0, wantCount: changeFeedPageSize, wantSeq: changeFeedPageSize, wantHasMore: true},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			mockDao := NewMockDAO()
+			mockClient, ok := mockDao.DynamoClient.(*MockDynamoClient)
+			if !ok {
+				t.Fatalf("mockDao.DynamoClient is not a *MockDynamoClient; got %T", mockDao.DynamoClient)
+			}
+			mockClient.MockQuery = func(ctx context.Context, input *dynamodb.QueryInput, opts ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+				since, _ := strconv.ParseInt(input.ExpressionAttributeValues[":since"].(*types.AttributeValueMemberN).Value, 10, 64)
+				items := []map[string]types.AttributeValue{}
+				for seq := since + 1; seq <= int64(tc.stored) && len(items) < int(*input.Limit); seq++ {
+					items = append(items, map[string]types.AttributeValue{
+						"story_id": &types.AttributeValueMemberS{Value: "story1"},
+						"seq":      &types.AttributeValueMemberN{Value: strconv.FormatInt(seq, 10)},
+					})
+				}
+				return &dynamodb.QueryOutput{Items: items}, nil
+			}
+
+			feed, err := mockDao.GetChanges("story1", tc.since)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(feed.Changes) != tc.wantCount {
+				t.Errorf("expected %d changes, got %d", tc.wantCount, len(feed.Changes))
+			}
+			if feed.Seq != tc.wantSeq {
+				t.Errorf("expected cursor %d, got %d", tc.wantSeq, feed.Seq)
+			}
+			if feed.HasMore != tc.wantHasMore {
+				t.Errorf("expected has_more=%v, got %v", tc.wantHasMore, feed.HasMore)
+			}
+		})
+	}
+}
@@ -0,0 +1,443 @@
+package daos
+
+import (
+	"RichDocter/models"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+)
+
+var ErrInvalidRestructure = errors.New("invalid chapter restructure")
+
+// how long a split or move waits for the new chapter's block table to be ready
+const blockTableReadyTimeout = 2 * time.Minute
+
+// restructureUndo holds the steps that take back a restructure's writes so far. Restructures are a
+// chain of separate writes rather than one transaction, so a step failing before the old blocks are
+// cleared out undoes the earlier ones, newest first. Once clearing starts the restructure is carried
+// on with instead: a failure from then on leaves blocks in both places, but never loses any.
+type restructureUndo []func() error
+
+func (u *restructureUndo) add(step func() error) {
+	*u = append(*u, step)
+}
+
+// run undoes every step taken and hands back the error that made it necessary
+func (u restructureUndo) run(err error) error {
+	for i := len(u) - 1; i >= 0; i-- {
+		if undoErr := u[i](); undoErr != nil {
+			fmt.Println("unable to undo chapter restructure step", undoErr)
+		}
+	}
+	return err
+}
+
+// SplitChapter moves the blocks from atKeyID onwards into a new chapter placed straight after the
+// one being split, and named title. It isn't atomic, see restructureUndo.
+func (d *DAO) SplitChapter(owner, storyID, chapterID, atKeyID, title string) (*models.ChapterRestructure, error) {
+	chapters, err := d.GetChaptersByStoryID(storyID)
+	if err != nil {
+		return nil, err
+	}
+	idx := chapterIndex(chapters, chapterID)
+	if idx < 0 {
+		return nil, fmt.Errorf("%w: chapter %s is not in story %s", ErrInvalidRestructure, chapterID, storyID)
+	}
+	chapter := chapters[idx]
+	items, err := d.chapterBlockItems(storyID, chapterID)
+	if err != nil {
+		return nil, err
+	}
+	at := -1
+	for i, item := range items {
+		if keyID, ok := item["key_id"].(*types.AttributeValueMemberS); ok && keyID.Value == atKeyID {
+			at = i
+			break
+		}
+	}
+	if at < 0 {
+		return nil, fmt.Errorf("%w: block %s is not in chapter %s", ErrInvalidRestructure, atKeyID, chapterID)
+	}
+	if at == 0 {
+		return nil, fmt.Errorf("%w: splitting at the first block would leave the chapter empty", ErrInvalidRestructure)
+	}
+	if title == "" {
+		title = chapter.Title + " (continued)"
+	}
+
+	// make room straight after the chapter being split
+	undo := restructureUndo{}
+	following := chapters[idx+1:]
+	undo.add(func() error { return d.shiftChapters(following, 0) })
+	if err = d.shiftChapters(following, 1); err != nil {
+		return nil, undo.run(err)
+	}
+	created, err := d.CreateChapter(storyID, models.Chapter{
+		ID:      uuid.New().String(),
+		StoryID: storyID,
+		Title:   title,
+		Place:   chapter.Place + 1,
+	}, owner)
+	if err != nil {
+		return nil, undo.run(err)
+	}
+	created.StoryID = storyID
+	// dropping the new chapter takes the blocks copied into it along too
+	undo.add(func() error { return d.dropChapters(storyID, []models.Chapter{created}) })
+
+	moved := items[at:]
+	if err = d.copyBlockItems(storyID, created.ID, moved); err != nil {
+		return nil, undo.run(err)
+	}
+	keyIDs := make(map[string]bool, len(moved))
+	for _, item := range moved {
+		keyIDs[item["key_id"].(*types.AttributeValueMemberS).Value] = true
+	}
+	undo.add(func() error { return d.reanchorComments(owner, storyID, created.ID, storyID, chapterID, keyIDs) })
+	if err = d.reanchorComments(owner, storyID, chapterID, storyID, created.ID, keyIDs); err != nil {
+		return nil, undo.run(err)
+	}
+	if err = d.deleteBlockItems(storyID, chapterID, keyIDs); err != nil {
+		return nil, err
+	}
+	return d.restructureResult(storyID, &created, nil, moved)
+}
+
+// MergeChapters appends the blocks of the chapter straight after chapterID to it and removes the
+// emptied chapter. It isn't atomic, see restructureUndo.
+func (d *DAO) MergeChapters(owner, storyID, chapterID, nextChapterID string) (*models.ChapterRestructure, error) {
+	chapters, err := d.GetChaptersByStoryID(storyID)
+	if err != nil {
+		return nil, err
+	}
+	idx := chapterIndex(chapters, chapterID)
+	if idx < 0 {
+		return nil, fmt.Errorf("%w: chapter %s is not in story %s", ErrInvalidRestructure, chapterID, storyID)
+	}
+	if idx+1 >= len(chapters) || chapters[idx+1].ID != nextChapterID {
+		return nil, fmt.Errorf("%w: only a chapter and the one straight after it can be merged", ErrInvalidRestructure)
+	}
+	next := chapters[idx+1]
+
+	items, err := d.chapterBlockItems(storyID, chapterID)
+	if err != nil {
+		return nil, err
+	}
+	moved, err := d.chapterBlockItems(storyID, next.ID)
+	if err != nil {
+		return nil, err
+	}
+	// rank the merged blocks after everything already in the chapter
+	last := ""
+	if len(items) > 0 {
+		if last, err = itemRank(items[len(items)-1]); err != nil {
+			return nil, err
+		}
+	}
+	ranks, err := models.RanksBetween(last, "", len(moved))
+	if err != nil {
+		return nil, err
+	}
+	for i, item := range moved {
+		item["rank"] = &types.AttributeValueMemberS{Value: ranks[i]}
+		item["place"] = &types.AttributeValueMemberN{Value: models.PlaceFromRank(ranks[i])}
+	}
+	keyIDs := make(map[string]bool, len(moved))
+	for _, item := range moved {
+		keyIDs[item["key_id"].(*types.AttributeValueMemberS).Value] = true
+	}
+	undo := restructureUndo{}
+	undo.add(func() error { return d.deleteBlockItems(storyID, chapterID, keyIDs) })
+	if err = d.copyBlockItems(storyID, chapterID, moved); err != nil {
+		return nil, undo.run(err)
+	}
+	undo.add(func() error { return d.reanchorComments(owner, storyID, chapterID, storyID, next.ID, keyIDs) })
+	if err = d.reanchorComments(owner, storyID, next.ID, storyID, chapterID, nil); err != nil {
+		return nil, undo.run(err)
+	}
+	if err = d.dropChapters(storyID, []models.Chapter{next}); err != nil {
+		return nil, err
+	}
+	if err = d.shiftChapters(chapters[idx+2:], -1); err != nil {
+		return nil, err
+	}
+	return d.restructureResult(storyID, nil, &next, moved)
+}
+
+// MoveChapter moves a chapter and its blocks to the end of another of the owner's stories. It isn't
+// atomic, see restructureUndo.
+func (d *DAO) MoveChapter(owner, storyID, chapterID, targetStoryID string) (*models.ChapterRestructure, error) {
+	if targetStoryID == storyID {
+		return nil, fmt.Errorf("%w: the chapter is already in story %s", ErrInvalidRestructure, storyID)
+	}
+	chapters, err := d.GetChaptersByStoryID(storyID)
+	if err != nil {
+		return nil, err
+	}
+	idx := chapterIndex(chapters, chapterID)
+	if idx < 0 {
+		return nil, fmt.Errorf("%w: chapter %s is not in story %s", ErrInvalidRestructure, chapterID, storyID)
+	}
+	chapter := chapters[idx]
+	targetChapters, err := d.GetChaptersByStoryID(targetStoryID)
+	if err != nil {
+		return nil, err
+	}
+	place := 1
+	if len(targetChapters) > 0 {
+		place = targetChapters[len(targetChapters)-1].Place + 1
+	}
+	items, err := d.chapterBlockItems(storyID, chapterID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the chapter keeps its ID so links and open editors can follow it
+	created, err := d.CreateChapter(targetStoryID, models.Chapter{
+		ID:      chapter.ID,
+		StoryID: targetStoryID,
+		Title:   chapter.Title,
+		Place:   place,
+	}, owner)
+	if err != nil {
+		return nil, err
+	}
+	created.StoryID = targetStoryID
+	undo := restructureUndo{}
+	undo.add(func() error { return d.dropChapters(targetStoryID, []models.Chapter{created}) })
+	for _, item := range items {
+		item["story_id"] = &types.AttributeValueMemberS{Value: targetStoryID}
+	}
+	if err = d.copyBlockItems(targetStoryID, chapterID, items); err != nil {
+		return nil, undo.run(err)
+	}
+	undo.add(func() error { return d.reanchorComments(owner, targetStoryID, chapterID, storyID, chapterID, nil) })
+	if err = d.reanchorComments(owner, storyID, chapterID, targetStoryID, chapterID, nil); err != nil {
+		return nil, undo.run(err)
+	}
+	if err = d.dropChapters(storyID, []models.Chapter{chapter}); err != nil {
+		return nil, err
+	}
+	if err = d.shiftChapters(chapters[idx+1:], -1); err != nil {
+		return nil, err
+	}
+	return d.restructureResult(storyID, &created, &chapter, items)
+}
+
+func chapterIndex(chapters []models.Chapter, chapterID string) int {
+	for i, chapter := range chapters {
+		if chapter.ID == chapterID {
+			return i
+		}
+	}
+	return -1
+}
+
+// chapterBlockItems returns every block of a chapter, as stored, in order
+func (d *DAO) chapterBlockItems(storyID, chapterID string) ([]map[string]types.AttributeValue, error) {
+	blocks, err := d.GetChapterParagraphs(storyID, chapterID, nil)
+	if err != nil {
+		return nil, err
+	}
+	if blocks == nil {
+		return []map[string]types.AttributeValue{}, nil
+	}
+	return blocks.Items, nil
+}
+
+// itemRank reads a stored block's rank, working it out from its place if it hasn't been migrated yet
+func itemRank(item map[string]types.AttributeValue) (string, error) {
+	if rank, ok := item["rank"].(*types.AttributeValueMemberS); ok {
+		return rank.Value, nil
+	}
+	place, ok := item["place"].(*types.AttributeValueMemberN)
+	if !ok {
+		return "", fmt.Errorf("block has neither a rank nor a place")
+	}
+	return models.RankFromPlace(place.Value)
+}
+
+// shiftChapters moves chapters up or down by delta places, keeping chapter_num in order around an insert or removal
+func (d *DAO) shiftChapters(chapters []models.Chapter, delta int) error {
+	for i := 0; i < len(chapters); i += d.writeBatchSize {
+		end := i + d.writeBatchSize
+		if end > len(chapters) {
+			end = len(chapters)
+		}
+		writeItemsInput := &dynamodb.TransactWriteItemsInput{
+			TransactItems: make([]types.TransactWriteItem, 0, end-i),
+		}
+		for _, chapter := range chapters[i:end] {
+			writeItemsInput.TransactItems = append(writeItemsInput.TransactItems, types.TransactWriteItem{
+				Update: &types.Update{
+					TableName: aws.String("chapters" + GetTableSuffix()),
+					Key: map[string]types.AttributeValue{
+						"story_id":   &types.AttributeValueMemberS{Value: chapter.StoryID},
+						"chapter_id": &types.AttributeValueMemberS{Value: chapter.ID},
+					},
+					UpdateExpression:         aws.String("SET chapter_num = :n ADD #ver :one"),
+					ExpressionAttributeNames: map[string]string{"#ver": "version"},
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":n":   &types.AttributeValueMemberN{Value: strconv.Itoa(chapter.Place + delta)},
+						":one": &types.AttributeValueMemberN{Value: "1"},
+					},
+				},
+			})
+		}
+		err, awsErr := d.awsWriteTransaction(writeItemsInput)
+		if err != nil {
+			return err
+		}
+		if !awsErr.IsNil() {
+			return fmt.Errorf("--AWSERROR-- Code:%s, Type: %s, Message: %s", awsErr.Code, awsErr.ErrorType, awsErr.Text)
+		}
+	}
+	return nil
+}
+
+// copyBlockItems writes stored blocks into a chapter's block table, waiting for the table if it was just created
+func (d *DAO) copyBlockItems(storyID, chapterID string, items []map[string]types.AttributeValue) error {
+	if len(items) == 0 {
+		return nil
+	}
+	tableName := storyID + "_" + chapterID + "_blocks" + GetTableSuffix()
+	if err := d.waitForBlockTable(tableName); err != nil {
+		return err
+	}
+	for i := 0; i < len(items); i += d.writeBatchSize {
+		end := i + d.writeBatchSize
+		if end > len(items) {
+			end = len(items)
+		}
+		writeItemsInput := &dynamodb.TransactWriteItemsInput{
+			TransactItems: make([]types.TransactWriteItem, 0, end-i),
+		}
+		for _, item := range items[i:end] {
+			writeItemsInput.TransactItems = append(writeItemsInput.TransactItems, types.TransactWriteItem{
+				Put: &types.Put{
+					TableName: aws.String(tableName),
+					Item:      item,
+				},
+			})
+		}
+		err, awsErr := d.awsWriteTransaction(writeItemsInput)
+		if err != nil {
+			return err
+		}
+		if !awsErr.IsNil() {
+			return fmt.Errorf("--AWSERROR-- Code:%s, Type: %s, Message: %s", awsErr.Code, awsErr.ErrorType, awsErr.Text)
+		}
+	}
+	return nil
+}
+
+func (d *DAO) deleteBlockItems(storyID, chapterID string, keyIDs map[string]bool) error {
+	tableName := storyID + "_" + chapterID + "_blocks" + GetTableSuffix()
+	keys := make([]string, 0, len(keyIDs))
+	for keyID := range keyIDs {
+		keys = append(keys, keyID)
+	}
+	for i := 0; i < len(keys); i += d.writeBatchSize {
+		end := i + d.writeBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		writeItemsInput := &dynamodb.TransactWriteItemsInput{
+			TransactItems: make([]types.TransactWriteItem, 0, end-i),
+		}
+		for _, keyID := range keys[i:end] {
+			writeItemsInput.TransactItems = append(writeItemsInput.TransactItems, types.TransactWriteItem{
+				Delete: &types.Delete{
+					TableName: aws.String(tableName),
+					Key: map[string]types.AttributeValue{
+						"key_id": &types.AttributeValueMemberS{Value: keyID},
+					},
+				},
+			})
+		}
+		err, awsErr := d.awsWriteTransaction(writeItemsInput)
+		if err != nil {
+			return err
+		}
+		if !awsErr.IsNil() {
+			return fmt.Errorf("--AWSERROR-- Code:%s, Type: %s, Message: %s", awsErr.Code, awsErr.ErrorType, awsErr.Text)
+		}
+	}
+	return nil
+}
+
+// reanchorComments points comments at the chapter, and story, their blocks moved to. Only comments on
+// the given blocks move, or all of the chapter's when keyIDs is nil. Replies share their thread's block.
+func (d *DAO) reanchorComments(owner, storyID, chapterID, toStoryID, toChapterID string, keyIDs map[string]bool) error {
+	comments, err := d.scanChapterComments(owner, storyID, chapterID)
+	if err != nil {
+		return err
+	}
+	for _, comment := range comments {
+		if keyIDs != nil && !keyIDs[comment.KeyID] {
+			continue
+		}
+		comment.StoryID = toStoryID
+		comment.ChapterID = toChapterID
+		item, err := attributevalue.MarshalMap(comment)
+		if err != nil {
+			return err
+		}
+		writeItemsInput := &dynamodb.TransactWriteItemsInput{
+			TransactItems: []types.TransactWriteItem{{
+				Put: &types.Put{
+					TableName: aws.String("comments" + GetTableSuffix()),
+					Item:      item,
+				},
+			}},
+		}
+		if toStoryID != storyID {
+			// the story is part of the key, so the old row has to go
+			writeItemsInput.TransactItems = append(writeItemsInput.TransactItems, types.TransactWriteItem{
+				Delete: &types.Delete{
+					TableName: aws.String("comments" + GetTableSuffix()),
+					Key: map[string]types.AttributeValue{
+						"comment_id": &types.AttributeValueMemberS{Value: comment.ID},
+						"story_id":   &types.AttributeValueMemberS{Value: storyID},
+					},
+				},
+			})
+		}
+		err, awsErr := d.awsWriteTransaction(writeItemsInput)
+		if err != nil {
+			return err
+		}
+		if !awsErr.IsNil() {
+			return fmt.Errorf("--AWSERROR-- Code:%s, Type: %s, Message: %s", awsErr.Code, awsErr.ErrorType, awsErr.Text)
+		}
+	}
+	return nil
+}
+
+func (d *DAO) restructureResult(storyID string, created, removed *models.Chapter, items []map[string]types.AttributeValue) (*models.ChapterRestructure, error) {
+	chapters, err := d.GetChaptersByStoryID(storyID)
+	if err != nil {
+		return nil, err
+	}
+	result := &models.ChapterRestructure{
+		Chapters: chapters,
+		Created:  created,
+		Removed:  removed,
+		Blocks:   make([]models.StoryBlock, 0, len(items)),
+	}
+	for _, item := range items {
+		block, err := storyBlockFromItem(item)
+		if err != nil {
+			return nil, err
+		}
+		result.Blocks = append(result.Blocks, block)
+	}
+	return result, nil
+}
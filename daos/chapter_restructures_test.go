@@ -0,0 +1,132 @@
+package daos
+
+import (
+	"RichDocter/models"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+var errCopyFailed = errors.New("copy failed")
+
+func TestMergeChapters(t *testing.T) {
+	chapter := func(id string, place string) map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{
+			"story_id":    &types.AttributeValueMemberS{Value: "story1"},
+			"chapter_id":  &types.AttributeValueMemberS{Value: id},
+			"chapter_num": &types.AttributeValueMemberN{Value: place},
+			"title":       &types.AttributeValueMemberS{Value: id},
+		}
+	}
+	block := func(keyID, rank string) map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{
+			"key_id":   &types.AttributeValueMemberS{Value: keyID},
+			"story_id": &types.AttributeValueMemberS{Value: "story1"},
+			"chunk":    &types.AttributeValueMemberS{Value: `{}`},
+			"rank":     &types.AttributeValueMemberS{Value: rank},
+		}
+	}
+	blockTables := map[string][]map[string]types.AttributeValue{
+		"story1_c1_blocks" + GetTableSuffix(): {block("a", "000000V"), block("b", "000001V")},
+		"story1_c2_blocks" + GetTableSuffix(): {block("c", "000000V"), block("d", "000001V"), block("e", "000002V")},
+	}
+
+	testCases := []struct {
+		name          string
+		chapterID     string
+		nextChapterID string
+		failCopy      bool
+		wantErr       error
+	}{
+		{name: "AdjacentChapters", chapterID: "c1", nextChapterID: "c2"},
+		{name: "CopyFails", chapterID: "c1", nextChapterID: "c2", failCopy: true, wantErr: errCopyFailed},
+		{name: "NotAdjacent", chapterID: "c1", nextChapterID: "c3", wantErr: ErrInvalidRestructure},
+		{name: "WrongOrder", chapterID: "c2", nextChapterID: "c1", wantErr: ErrInvalidRestructure},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			mockDao := NewMockDAO()
+			mockClient := mockDao.DynamoClient.(*MockDynamoClient)
+			mockClient.MockScan = func(ctx context.Context, input *dynamodb.ScanInput, opts ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+				if *input.TableName == "chapters"+GetTableSuffix() {
+					return &dynamodb.ScanOutput{Items: []map[string]types.AttributeValue{chapter("c3", "3"), chapter("c1", "1"), chapter("c2", "2")}}, nil
+				}
+				return &dynamodb.ScanOutput{}, nil
+			}
+			mockClient.MockQuery = func(ctx context.Context, input *dynamodb.QueryInput, opts ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+				return &dynamodb.QueryOutput{Items: blockTables[*input.TableName]}, nil
+			}
+			mockClient.MockDescribeTable = func(ctx context.Context, input *dynamodb.DescribeTableInput, opts ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+				return &dynamodb.DescribeTableOutput{Table: &types.TableDescription{TableStatus: types.TableStatusActive}}, nil
+			}
+			copied := []map[string]types.AttributeValue{}
+			undone := map[string]bool{}
+			shifted := map[string]string{}
+			mockClient.MockTransactWriteItems = func(ctx context.Context, input *dynamodb.TransactWriteItemsInput, opts ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+				for _, item := range input.TransactItems {
+					if item.Put != nil && *item.Put.TableName == "story1_c1_blocks"+GetTableSuffix() {
+						if tc.failCopy {
+							return nil, errCopyFailed
+						}
+						copied = append(copied, item.Put.Item)
+					}
+					if item.Delete != nil && *item.Delete.TableName == "story1_c1_blocks"+GetTableSuffix() {
+						undone[item.Delete.Key["key_id"].(*types.AttributeValueMemberS).Value] = true
+					}
+					if item.Update != nil && *item.Update.TableName == "chapters"+GetTableSuffix() {
+						id := item.Update.Key["chapter_id"].(*types.AttributeValueMemberS).Value
+						shifted[id] = item.Update.ExpressionAttributeValues[":n"].(*types.AttributeValueMemberN).Value
+					}
+				}
+				return &dynamodb.TransactWriteItemsOutput{}, nil
+			}
+			dropped := []string{}
+			mockClient.MockDeleteTable = func(ctx context.Context, input *dynamodb.DeleteTableInput, opts ...func(*dynamodb.Options)) (*dynamodb.DeleteTableOutput, error) {
+				dropped = append(dropped, *input.TableName)
+				return &dynamodb.DeleteTableOutput{}, nil
+			}
+
+			result, err := mockDao.MergeChapters("owner@example.com", "story1", tc.chapterID, tc.nextChapterID)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+				}
+				if len(copied) != 0 || len(dropped) != 0 || len(shifted) != 0 {
+					t.Errorf("expected nothing kept, got %d copies, %d dropped tables and %d shifts", len(copied), len(dropped), len(shifted))
+				}
+				if tc.failCopy && (len(undone) != 3 || !undone["c"] || !undone["d"] || !undone["e"]) {
+					t.Errorf("expected the blocks copied so far to be taken back out, got %v", undone)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(copied) != 3 || len(result.Blocks) != 3 {
+				t.Fatalf("expected 3 blocks merged, got %d copied and %d reported", len(copied), len(result.Blocks))
+			}
+			last := "000001V"
+			for _, item := range copied {
+				rank := item["rank"].(*types.AttributeValueMemberS).Value
+				if !models.ValidRank(rank) || rank <= last {
+					t.Errorf("expected merged ranks in order after %q, got %q", last, rank)
+				}
+				last = rank
+			}
+			if len(dropped) != 1 || dropped[0] != "story1_c2_blocks"+GetTableSuffix() {
+				t.Errorf("expected the merged chapter's blocks dropped, got %v", dropped)
+			}
+			if shifted["c3"] != "2" || len(shifted) != 1 {
+				t.Errorf("expected c3 moved up to place 2, got %v", shifted)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package daos
 import (
 	"RichDocter/models"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"sort"
@@ -67,15 +68,9 @@ func (d *DAO) GetChapterParagraphs(storyID, chapterID string, startKey *map[stri
 	tableName := storyID + "_" + chapterID + "_blocks" + GetTableSuffix()
 	queryInput := &dynamodb.QueryInput{
 		TableName:              aws.String(tableName),
-		IndexName:              aws.String("story_id-place-index"),
-		KeyConditionExpression: aws.String("#place>:p AND story_id=:sid"),
-		ExpressionAttributeNames: map[string]string{
-			"#place": "place",
-		},
+		IndexName:              aws.String("story_id-rank-index"),
+		KeyConditionExpression: aws.String("story_id=:sid"),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":p": &types.AttributeValueMemberN{
-				Value: "-1",
-			},
 			":sid": &types.AttributeValueMemberS{
 				Value: storyID,
 			},
@@ -86,32 +81,45 @@ func (d *DAO) GetChapterParagraphs(storyID, chapterID string, startKey *map[stri
 		queryInput.ExclusiveStartKey = *startKey
 	}
 
-	var items []map[string]types.AttributeValue
-	paginator := dynamodb.NewQueryPaginator(d.DynamoClient, queryInput)
+	items, lastKey, err := d.queryChapterBlocks(queryInput)
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "ValidationException" {
+		// the table predates ranks, either not migrated yet or restored from an older backup, so fall
+		// back to its place index. Every block write keeps place in step with rank for this.
+		queryInput.IndexName = aws.String("story_id-place-index")
+		queryInput.KeyConditionExpression = aws.String("#place>:p AND story_id=:sid")
+		queryInput.ExpressionAttributeNames = map[string]string{
+			"#place": "place",
+		}
+		queryInput.ExpressionAttributeValues[":p"] = &types.AttributeValueMemberN{
+			Value: "-1",
+		}
+		items, lastKey, err = d.queryChapterBlocks(queryInput)
+	}
+	if err != nil {
+		return &blocks, err
+	}
+	if len(items) == 0 {
+		return nil, nil
+	}
+	blocks.Items = items
+	blocks.LastEvaluated = lastKey
+	return &blocks, nil
+}
 
-	var lastKey map[string]types.AttributeValue
+func (d *DAO) queryChapterBlocks(queryInput *dynamodb.QueryInput) (items []map[string]types.AttributeValue, lastKey map[string]types.AttributeValue, err error) {
+	paginator := dynamodb.NewQueryPaginator(d.DynamoClient, queryInput)
 	for paginator.HasMorePages() {
 		page, err := paginator.NextPage(context.Background())
 		if err != nil {
-			if opErr, ok := err.(*smithy.OperationError); ok {
-				var notFoundErr *types.ResourceNotFoundException
-				if errors.As(opErr.Unwrap(), &notFoundErr) {
-					return &blocks, err
-				}
-			}
-			return &blocks, err
+			return nil, nil, err
 		}
 		if page.LastEvaluatedKey != nil {
 			lastKey = page.LastEvaluatedKey
 		}
 		items = append(items, page.Items...)
 	}
-	if len(items) == 0 {
-		return nil, nil
-	}
-	blocks.Items = items
-	blocks.LastEvaluated = lastKey
-	return &blocks, nil
+	return items, lastKey, nil
 }
 
 func (d *DAO) CreateChapter(storyID string, chapter models.Chapter, email string) (newChapter models.Chapter, err error) {
@@ -176,16 +184,25 @@ func (d *DAO) EditChapter(storyID string, chapter models.Chapter) (updatedChapte
 		"chapter_num": &types.AttributeValueMemberN{Value: strconv.Itoa(chapter.Place)},
 		"title":       &types.AttributeValueMemberS{Value: chapter.Title},
 		"modified_at": &types.AttributeValueMemberN{Value: modifiedAtStr},
+		"version":     &types.AttributeValueMemberN{Value: strconv.FormatInt(chapter.Version+1, 10)},
 	}
 	updatedChapter = chapter
+	condition, values := versionCondition(chapter.Version)
 	chapterUpdateInput := &dynamodb.PutItemInput{
-		TableName: aws.String("chapters" + GetTableSuffix()),
-		Item:      item,
+		TableName:                 aws.String("chapters" + GetTableSuffix()),
+		Item:                      item,
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeNames:  map[string]string{"#ver": "version"},
+		ExpressionAttributeValues: nilIfEmpty(values),
 	}
 	_, err = d.DynamoClient.PutItem(context.Background(), chapterUpdateInput)
 	if err != nil {
+		if isConditionalCheckFailure(err) {
+			return updatedChapter, ErrVersionConflict
+		}
 		return updatedChapter, err
 	}
+	updatedChapter.Version = chapter.Version + 1
 	return updatedChapter, nil
 }
 
@@ -214,9 +231,13 @@ func (d *DAO) DeleteChapterParagraphs(storyID string, storyBlocks *models.StoryB
 			}
 
 			// Create a delete input for the item.
+			condition, values := versionCondition(item.Version)
 			deleteInput := &types.Delete{
-				Key:       key,
-				TableName: aws.String(tableName + GetTableSuffix()),
+				Key:                       key,
+				TableName:                 aws.String(tableName + GetTableSuffix()),
+				ConditionExpression:       aws.String(condition),
+				ExpressionAttributeNames:  map[string]string{"#ver": "version"},
+				ExpressionAttributeValues: nilIfEmpty(values),
 			}
 			// Create a transaction write item for the update operation.
 			writeItem := types.TransactWriteItem{
@@ -231,6 +252,9 @@ func (d *DAO) DeleteChapterParagraphs(storyID string, storyBlocks *models.StoryB
 		if err != nil {
 			return err
 		}
+		if awsErr.ErrorType == "ConditionalCheckFailed" {
+			return ErrVersionConflict
+		}
 		if !awsErr.IsNil() {
 			return fmt.Errorf("--AWSERROR-- Code:%s, Type: %s, Message: %s", awsErr.Code, awsErr.ErrorType, awsErr.Text)
 		}
@@ -238,7 +262,160 @@ func (d *DAO) DeleteChapterParagraphs(storyID string, storyBlocks *models.StoryB
 	return
 }
 
-func (d *DAO) DeleteChapters(storyID string, chapters []models.Chapter) (err error) {
+var ErrInvalidBlockPatch = errors.New("invalid block patch")
+
+// PatchBlocks applies a chapter's inserts, updates, deletes and reorders together. Each transaction
+// batch commits or fails as a whole; when the DAO carries an idempotency key, batches already
+// committed by an earlier attempt are skipped by DynamoDB when the same patch is sent again.
+func (d *DAO) PatchBlocks(storyID, chapterID string, patch *models.BlockPatch) error {
+	if err := validateBlockPatch(patch); err != nil {
+		return err
+	}
+	tableName := aws.String(storyID + "_" + chapterID + "_blocks" + GetTableSuffix())
+	items := []types.TransactWriteItem{}
+	// the blocks whose version each item moves on, nil where it doesn't
+	versioned := []*models.StoryBlock{}
+
+	for i := range patch.Deletes {
+		block := &patch.Deletes[i]
+		condition, values := versionCondition(block.Version)
+		items = append(items, types.TransactWriteItem{Delete: &types.Delete{
+			TableName:                 tableName,
+			Key:                       map[string]types.AttributeValue{"key_id": &types.AttributeValueMemberS{Value: block.KeyID}},
+			ConditionExpression:       aws.String(condition),
+			ExpressionAttributeNames:  map[string]string{"#ver": "version"},
+			ExpressionAttributeValues: nilIfEmpty(values),
+		}})
+		versioned = append(versioned, nil)
+	}
+	for i := range patch.Inserts {
+		block := &patch.Inserts[i]
+		block.Version = 0
+		update := blockUpdate(*tableName, storyID, *block)
+		// an insert must not land on a block that is already there
+		update.ConditionExpression = aws.String("attribute_not_exists(key_id)")
+		items = append(items, types.TransactWriteItem{Update: update})
+		versioned = append(versioned, block)
+	}
+	for i := range patch.Updates {
+		block := &patch.Updates[i]
+		items = append(items, types.TransactWriteItem{Update: blockUpdate(*tableName, storyID, *block)})
+		versioned = append(versioned, block)
+	}
+	for _, block := range patch.Reorders {
+		update := blockRankUpdate(*tableName, block)
+		update.ConditionExpression = aws.String("attribute_exists(key_id)")
+		items = append(items, types.TransactWriteItem{Update: update})
+		versioned = append(versioned, nil)
+	}
+
+	for i := 0; i < len(items); i += d.writeBatchSize {
+		end := i + d.writeBatchSize
+		if end > len(items) {
+			end = len(items)
+		}
+		writeItemsInput := &dynamodb.TransactWriteItemsInput{
+			TransactItems: items[i:end],
+		}
+		err, awsErr := d.awsWriteTransaction(writeItemsInput)
+		if err != nil {
+			return err
+		}
+		if awsErr.ErrorType == "ConditionalCheckFailed" {
+			return ErrVersionConflict
+		}
+		if !awsErr.IsNil() {
+			return fmt.Errorf("--AWSERROR-- Code:%s, Type: %s, Message: %s", awsErr.Code, awsErr.ErrorType, awsErr.Text)
+		}
+		for _, block := range versioned[i:end] {
+			if block != nil {
+				block.Version++
+			}
+		}
+	}
+	return nil
+}
+
+// validateBlockPatch rejects patches DynamoDB would refuse part way through, like a block touched twice
+func validateBlockPatch(patch *models.BlockPatch) error {
+	seen := make(map[string]bool)
+	check := func(kind string, blocks []models.StoryBlock, needsRank bool) error {
+		for i := range blocks {
+			block := &blocks[i]
+			if block.KeyID == "" {
+				return fmt.Errorf("%w: %s are missing a key_id", ErrInvalidBlockPatch, kind)
+			}
+			if seen[block.KeyID] {
+				return fmt.Errorf("%w: block %s appears more than once", ErrInvalidBlockPatch, block.KeyID)
+			}
+			seen[block.KeyID] = true
+			if needsRank {
+				if err := rankBlock(block); err != nil {
+					return fmt.Errorf("%w: %v", ErrInvalidBlockPatch, err)
+				}
+			}
+		}
+		return nil
+	}
+	if err := check("deletes", patch.Deletes, false); err != nil {
+		return err
+	}
+	if err := check("inserts", patch.Inserts, true); err != nil {
+		return err
+	}
+	if err := check("updates", patch.Updates, true); err != nil {
+		return err
+	}
+	if err := check("reorders", patch.Reorders, true); err != nil {
+		return err
+	}
+	if len(seen) == 0 {
+		return fmt.Errorf("%w: nothing to change", ErrInvalidBlockPatch)
+	}
+	return nil
+}
+
+// DeleteChapters moves chapters to the trash. Their block tables are backed up and dropped, to be
+// restored from the backups if the chapters are. The story's owner is recorded on the chapters so
+// the trash can find them.
+func (d *DAO) DeleteChapters(email, storyID string, chapters []models.Chapter) (err error) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	for _, chapter := range chapters {
+		backupARN, err := d.backupBlockTable(storyID, chapter.ID)
+		if err != nil {
+			return err
+		}
+		// bumping the version turns edits still in flight for the chapter into conflicts
+		_, err = d.DynamoClient.UpdateItem(context.Background(), &dynamodb.UpdateItemInput{
+			TableName: aws.String("chapters" + GetTableSuffix()),
+			Key: map[string]types.AttributeValue{
+				"chapter_id": &types.AttributeValueMemberS{Value: chapter.ID},
+				"story_id":   &types.AttributeValueMemberS{Value: storyID},
+			},
+			UpdateExpression:    aws.String("SET deleted_at = :n, automated_deletion = :a, bup_arn = :barn, author = :eml ADD #ver :one"),
+			ConditionExpression: aws.String("attribute_exists(chapter_id) AND attribute_not_exists(deleted_at)"),
+			ExpressionAttributeNames: map[string]string{
+				"#ver": "version",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":n":    &types.AttributeValueMemberN{Value: now},
+				":a":    &types.AttributeValueMemberBOOL{Value: false},
+				":barn": &types.AttributeValueMemberS{Value: backupARN},
+				":eml":  &types.AttributeValueMemberS{Value: email},
+				":one":  &types.AttributeValueMemberN{Value: "1"},
+			},
+		})
+		// already deleted, or never existed
+		if err != nil && !isConditionalCheckFailure(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropChapters deletes chapters and their block tables outright, for chapters whose blocks now live
+// elsewhere
+func (d *DAO) dropChapters(storyID string, chapters []models.Chapter) (err error) {
 	batches := make([][]models.Chapter, 0, (len(chapters)+(d.writeBatchSize-1))/d.writeBatchSize)
 	for i := 0; i < len(chapters); i += d.writeBatchSize {
 		end := i + d.writeBatchSize
@@ -313,3 +490,53 @@ func (d *DAO) GetBlockCountByChapter(email, storyID, chapterID string) (count in
 	}
 	return len(blocksOut.Items), nil
 }
+
+// GetBlocksByKeys returns the stored copies of the given blocks, leaving out any that no longer exist
+func (d *DAO) GetBlocksByKeys(storyID, chapterID string, keyIDs []string) ([]models.StoryBlock, error) {
+	tableName := storyID + "_" + chapterID + "_blocks" + GetTableSuffix()
+	blocks := []models.StoryBlock{}
+	for _, keyID := range keyIDs {
+		out, err := d.DynamoClient.Query(context.TODO(), &dynamodb.QueryInput{
+			TableName:              aws.String(tableName),
+			KeyConditionExpression: aws.String("key_id=:k"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":k": &types.AttributeValueMemberS{Value: keyID},
+			},
+			Limit: aws.Int32(1),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(out.Items) == 0 {
+			continue
+		}
+		block, err := storyBlockFromItem(out.Items[0])
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+// storyBlockFromItem reads a block as stored in a chapter's block table, where the chunk is kept as a string
+func storyBlockFromItem(item map[string]types.AttributeValue) (block models.StoryBlock, err error) {
+	if keyID, ok := item["key_id"].(*types.AttributeValueMemberS); ok {
+		block.KeyID = keyID.Value
+	}
+	if chunk, ok := item["chunk"].(*types.AttributeValueMemberS); ok {
+		block.Chunk = json.RawMessage(chunk.Value)
+	}
+	if place, ok := item["place"].(*types.AttributeValueMemberN); ok {
+		block.Place = place.Value
+	}
+	if rank, ok := item["rank"].(*types.AttributeValueMemberS); ok {
+		block.Rank = rank.Value
+	}
+	if version, ok := item["version"].(*types.AttributeValueMemberN); ok {
+		if block.Version, err = strconv.ParseInt(version.Value, 10, 64); err != nil {
+			return block, err
+		}
+	}
+	return block, nil
+}
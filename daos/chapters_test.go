@@ -0,0 +1,313 @@
+package daos
+
+import (
+	"RichDocter/models"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+)
+
+func TestEditChapterVersioning(t *testing.T) {
+	mockDao := NewMockDAO()
+	testCases := []struct {
+		name          string
+		chapter       models.Chapter
+		storedVersion int64
+		wantCondition string
+		wantVersion   int64
+		wantErr       error
+	}{
+		{name: "FirstVersionedWrite", chapter: models.Chapter{ID: "c1", Title: "One"}, storedVersion: 0, wantCondition: "attribute_not_exists(#ver)", wantVersion: 1},
+		{name: "CurrentVersion", chapter: models.Chapter{ID: "c1", Title: "One", Version: 3}, storedVersion: 3, wantCondition: "#ver = :ver", wantVersion: 4},
+		{name: "StaleVersion", chapter: models.Chapter{ID: "c1", Title: "One", Version: 2}, storedVersion: 3, wantCondition: "#ver = :ver", wantErr: ErrVersionConflict},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			mockClient := mockDao.DynamoClient.(*MockDynamoClient)
+			mockClient.MockPutItem = func(ctx context.Context, input *dynamodb.PutItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+				if got := aws.ToString(input.ConditionExpression); got != tc.wantCondition {
+					t.Errorf("expected condition %q, got %q", tc.wantCondition, got)
+				}
+				if tc.chapter.Version != tc.storedVersion {
+					return nil, &smithy.OperationError{
+						ServiceID:     "DynamoDB",
+						OperationName: "PutItem",
+						Err:           &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")},
+					}
+				}
+				return &dynamodb.PutItemOutput{}, nil
+			}
+
+			updated, err := mockDao.EditChapter("story1", tc.chapter)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if updated.Version != tc.wantVersion {
+				t.Errorf("expected version %d, got %d", tc.wantVersion, updated.Version)
+			}
+		})
+	}
+}
+
+func TestWriteBlocksVersioning(t *testing.T) {
+	mockDao := NewMockDAO()
+	testCases := []struct {
+		name         string
+		blocks       []models.StoryBlock
+		conflict     bool
+		wantVersions []int64
+		wantErr      error
+	}{
+		{
+			name: "BumpsVersions",
+			blocks: []models.StoryBlock{
+				{KeyID: "b1", Chunk: []byte(`{}`), Place: "0", Version: 0},
+				{KeyID: "b2", Chunk: []byte(`{}`), Place: "1", Version: 4},
+				{KeyID: "b3", Chunk: []byte(`{}`), Place: "2", Version: 1},
+			},
+			wantVersions: []int64{1, 5, 2},
+		},
+		{
+			name:     "StaleBlock",
+			blocks:   []models.StoryBlock{{KeyID: "b1", Chunk: []byte(`{}`), Place: "0", Version: 1}},
+			conflict: true,
+			wantErr:  ErrVersionConflict,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			mockClient := mockDao.DynamoClient.(*MockDynamoClient)
+			mockClient.MockTransactWriteItems = func(ctx context.Context, input *dynamodb.TransactWriteItemsInput, opts ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+				for _, item := range input.TransactItems {
+					if item.Update.ConditionExpression == nil {
+						t.Errorf("block %v written without a version condition", item.Update.Key)
+					}
+				}
+				if tc.conflict {
+					return nil, &smithy.OperationError{
+						ServiceID:     "DynamoDB",
+						OperationName: "TransactWriteItems",
+						Err: &types.TransactionCanceledException{
+							Message: aws.String("Transaction cancelled"),
+							CancellationReasons: []types.CancellationReason{
+								{Code: aws.String("ConditionalCheckFailed"), Message: aws.String("The conditional request failed")},
+							},
+						},
+					}
+				}
+				return &dynamodb.TransactWriteItemsOutput{}, nil
+			}
+
+			storyBlocks := &models.StoryBlocks{StoryID: "story1", ChapterID: "chapter1", Blocks: tc.blocks}
+			err := mockDao.WriteBlocks("story1", storyBlocks)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			for i, want := range tc.wantVersions {
+				if storyBlocks.Blocks[i].Version != want {
+					t.Errorf("block %s: expected version %d, got %d", storyBlocks.Blocks[i].KeyID, want, storyBlocks.Blocks[i].Version)
+				}
+			}
+		})
+	}
+}
+
+func TestPatchBlocks(t *testing.T) {
+	mockDao := NewMockDAO()
+	testCases := []struct {
+		name         string
+		patch        models.BlockPatch
+		key          string
+		wantErr      error
+		wantBatches  int
+		wantVersions map[string]int64
+	}{
+		{
+			name: "MixedPatch",
+			patch: models.BlockPatch{
+				Inserts:  []models.StoryBlock{{KeyID: "new", Chunk: []byte(`{}`), Rank: "000001a"}},
+				Updates:  []models.StoryBlock{{KeyID: "b1", Chunk: []byte(`{}`), Place: "1", Version: 3}},
+				Deletes:  []models.StoryBlock{{KeyID: "b2", Version: 2}},
+				Reorders: []models.StoryBlock{{KeyID: "b3", Rank: "000000k"}},
+			},
+			key:          "save-1",
+			wantBatches:  2,
+			wantVersions: map[string]int64{"new": 1, "b1": 4},
+		},
+		{
+			name: "BlockTouchedTwice",
+			patch: models.BlockPatch{
+				Updates:  []models.StoryBlock{{KeyID: "b1", Chunk: []byte(`{}`), Place: "1", Version: 3}},
+				Reorders: []models.StoryBlock{{KeyID: "b1", Place: "2"}},
+			},
+			wantErr: ErrInvalidBlockPatch,
+		},
+		{
+			name:    "InvalidRank",
+			patch:   models.BlockPatch{Reorders: []models.StoryBlock{{KeyID: "b3", Rank: "ab0"}}},
+			wantErr: ErrInvalidBlockPatch,
+		},
+		{
+			name:    "MissingRank",
+			patch:   models.BlockPatch{Inserts: []models.StoryBlock{{KeyID: "new", Chunk: []byte(`{}`)}}},
+			wantErr: ErrInvalidBlockPatch,
+		},
+		{
+			name:    "EmptyPatch",
+			wantErr: ErrInvalidBlockPatch,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			tokens := map[string]bool{}
+			batches := 0
+			mockClient := mockDao.DynamoClient.(*MockDynamoClient)
+			mockClient.MockTransactWriteItems = func(ctx context.Context, input *dynamodb.TransactWriteItemsInput, opts ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+				batches++
+				if input.ClientRequestToken == nil || len(*input.ClientRequestToken) > 36 {
+					t.Errorf("batch %d: expected a client request token of at most 36 characters, got %v", batches, input.ClientRequestToken)
+				} else {
+					tokens[*input.ClientRequestToken] = true
+				}
+				return &dynamodb.TransactWriteItemsOutput{}, nil
+			}
+
+			err := mockDao.WithIdempotencyKey(tc.key).PatchBlocks("story1", "chapter1", &tc.patch)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+				}
+				if batches != 0 {
+					t.Errorf("expected nothing written, got %d batches", batches)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if batches != tc.wantBatches || len(tokens) != tc.wantBatches {
+				t.Errorf("expected %d batches with distinct tokens, got %d batches and %d tokens", tc.wantBatches, batches, len(tokens))
+			}
+			for _, block := range append(tc.patch.Inserts, tc.patch.Updates...) {
+				if block.Version != tc.wantVersions[block.KeyID] {
+					t.Errorf("block %s: expected version %d, got %d", block.KeyID, tc.wantVersions[block.KeyID], block.Version)
+				}
+				if !models.ValidRank(block.Rank) {
+					t.Errorf("block %s: expected a rank, got %q", block.KeyID, block.Rank)
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteChaptersSoftDeletes(t *testing.T) {
+	testCases := []struct {
+		name           string
+		hasBlockTable  bool
+		alreadyDeleted bool
+		wantBackupARN  string
+		wantDropped    int
+	}{
+		{name: "BacksUpBlocks", hasBlockTable: true, wantBackupARN: "arn:backup/c1", wantDropped: 1},
+		{name: "NoBlockTable", hasBlockTable: false, wantBackupARN: "", wantDropped: 0},
+		{name: "AlreadyDeleted", hasBlockTable: false, alreadyDeleted: true, wantBackupARN: "", wantDropped: 0},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			mockDao := NewMockDAO()
+			mockClient := mockDao.DynamoClient.(*MockDynamoClient)
+			mockClient.MockCreateBackup = func(ctx context.Context, input *dynamodb.CreateBackupInput, opts ...func(*dynamodb.Options)) (*dynamodb.CreateBackupOutput, error) {
+				if !tc.hasBlockTable {
+					return nil, &smithy.OperationError{
+						ServiceID:     "DynamoDB",
+						OperationName: "CreateBackup",
+						Err:           &types.TableNotFoundException{Message: aws.String("Table not found")},
+					}
+				}
+				return &dynamodb.CreateBackupOutput{BackupDetails: &types.BackupDetails{BackupArn: aws.String("arn:backup/c1")}}, nil
+			}
+			mockClient.MockDescribeBackup = func(ctx context.Context, input *dynamodb.DescribeBackupInput, opts ...func(*dynamodb.Options)) (*dynamodb.DescribeBackupOutput, error) {
+				return &dynamodb.DescribeBackupOutput{BackupDescription: &types.BackupDescription{
+					BackupDetails: &types.BackupDetails{BackupStatus: types.BackupStatusAvailable},
+				}}, nil
+			}
+			dropped := 0
+			mockClient.MockDeleteTable = func(ctx context.Context, input *dynamodb.DeleteTableInput, opts ...func(*dynamodb.Options)) (*dynamodb.DeleteTableOutput, error) {
+				dropped++
+				return &dynamodb.DeleteTableOutput{}, nil
+			}
+			var marked *dynamodb.UpdateItemInput
+			mockClient.MockUpdateItem = func(ctx context.Context, input *dynamodb.UpdateItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+				marked = input
+				if tc.alreadyDeleted {
+					return nil, &smithy.OperationError{
+						ServiceID:     "DynamoDB",
+						OperationName: "UpdateItem",
+						Err:           &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")},
+					}
+				}
+				return &dynamodb.UpdateItemOutput{}, nil
+			}
+			mockClient.MockTransactWriteItems = func(ctx context.Context, input *dynamodb.TransactWriteItemsInput, opts ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+				t.Errorf("expected the chapter row kept, got a transaction of %d items", len(input.TransactItems))
+				return &dynamodb.TransactWriteItemsOutput{}, nil
+			}
+
+			if err := mockDao.DeleteChapters("owner@example.com", "story1", []models.Chapter{{ID: "c1"}}); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if dropped != tc.wantDropped {
+				t.Errorf("expected %d block tables dropped, got %d", tc.wantDropped, dropped)
+			}
+			if marked == nil {
+				t.Fatalf("expected the chapter marked deleted")
+			}
+			if got := marked.ExpressionAttributeValues[":barn"].(*types.AttributeValueMemberS).Value; got != tc.wantBackupARN {
+				t.Errorf("expected backup %q recorded, got %q", tc.wantBackupARN, got)
+			}
+			if _, ok := marked.ExpressionAttributeValues[":n"]; !ok {
+				t.Errorf("expected deleted_at set")
+			}
+			if got := marked.ExpressionAttributeValues[":eml"].(*types.AttributeValueMemberS).Value; got != "owner@example.com" {
+				t.Errorf("expected the owner recorded on the deleted chapter, got %q", got)
+			}
+		})
+	}
+}
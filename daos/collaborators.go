@@ -0,0 +1,457 @@
+package daos
+
+import (
+	"RichDocter/models"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+var ErrResourceNotFound = errors.New("no story or series found")
+
+// authoredTables lists the tables whose rows carry the owner's email in a non-key author attribute,
+// the attribute tying each row to a story or series, and the row's key attributes
+var authoredTables = []struct {
+	table     string
+	matchAttr string
+	keyAttrs  []string
+}{
+	{"associations", "story_or_series_id", []string{"association_id", "story_or_series_id"}},
+	{"association_details", "story_or_series_id", []string{"association_id", "story_or_series_id"}},
+	{"association_relationships", "story_or_series_id", []string{"relationship_id", "story_or_series_id"}},
+	{"association_types", "story_or_series_id", []string{"type_name", "story_or_series_id"}},
+	{"association_overrides", "story_id", []string{"association_id", "story_id"}},
+	{"share_links", "story_id", []string{"share_token"}},
+	{"comments", "story_id", []string{"comment_id", "story_id"}},
+	{"collaborators", "story_or_series_id", []string{"story_or_series_id", "email"}},
+}
+
+// GetResourceAccess works out who owns a story or series and which role the given user holds on it.
+// Collaborators on a series hold the same role on each of its volumes. Role is blank when the user has no access.
+func (d *DAO) GetResourceAccess(email, storyOrSeriesID string) (*models.ResourceAccess, error) {
+	access := &models.ResourceAccess{}
+	storyOut, err := d.DynamoClient.Scan(context.TODO(), &dynamodb.ScanInput{
+		TableName:        aws.String("stories" + GetTableSuffix()),
+		FilterExpression: aws.String("story_id=:s AND attribute_not_exists(deleted_at)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":s": &types.AttributeValueMemberS{Value: storyOrSeriesID},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	item := map[string]types.AttributeValue{}
+	if len(storyOut.Items) > 0 {
+		item = storyOut.Items[0]
+		if seriesAttr, ok := item["series_id"].(*types.AttributeValueMemberS); ok {
+			access.SeriesID = seriesAttr.Value
+		}
+	} else {
+		seriesOut, err := d.DynamoClient.Scan(context.TODO(), &dynamodb.ScanInput{
+			TableName:        aws.String("series" + GetTableSuffix()),
+			FilterExpression: aws.String("series_id=:s AND attribute_not_exists(deleted_at)"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":s": &types.AttributeValueMemberS{Value: storyOrSeriesID},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(seriesOut.Items) == 0 {
+			return nil, ErrResourceNotFound
+		}
+		item = seriesOut.Items[0]
+		access.IsSeries = true
+	}
+	authorAttr, ok := item["author"].(*types.AttributeValueMemberS)
+	if !ok {
+		return nil, ErrResourceNotFound
+	}
+	access.Owner = authorAttr.Value
+	if strings.EqualFold(access.Owner, email) {
+		access.Role = models.RoleOwner
+		return access, nil
+	}
+
+	ids := []string{storyOrSeriesID}
+	if access.SeriesID != "" {
+		ids = append(ids, access.SeriesID)
+	}
+	for _, id := range ids {
+		collaborator, err := d.getCollaborator(id, email)
+		if err != nil {
+			return nil, err
+		}
+		if collaborator != nil && collaborator.Status == models.InvitationAccepted {
+			access.Role = collaborator.Role
+			break
+		}
+	}
+	return access, nil
+}
+
+func (d *DAO) getCollaborator(storyOrSeriesID, email string) (*models.Collaborator, error) {
+	out, err := d.DynamoClient.Query(context.TODO(), &dynamodb.QueryInput{
+		TableName:              aws.String("collaborators" + GetTableSuffix()),
+		KeyConditionExpression: aws.String("story_or_series_id=:s AND email=:e"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":s": &types.AttributeValueMemberS{Value: storyOrSeriesID},
+			":e": &types.AttributeValueMemberS{Value: strings.ToLower(email)},
+		},
+		Limit: aws.Int32(1),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(out.Items) == 0 {
+		return nil, nil
+	}
+	collaborator := models.Collaborator{}
+	if err = attributevalue.UnmarshalMap(out.Items[0], &collaborator); err != nil {
+		return nil, err
+	}
+	return &collaborator, nil
+}
+
+func (d *DAO) GetCollaborators(owner, storyOrSeriesID string) ([]*models.Collaborator, error) {
+	out, err := d.DynamoClient.Scan(context.TODO(), &dynamodb.ScanInput{
+		TableName:        aws.String("collaborators" + GetTableSuffix()),
+		FilterExpression: aws.String("author=:eml AND story_or_series_id=:s"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":eml": &types.AttributeValueMemberS{Value: owner},
+			":s":   &types.AttributeValueMemberS{Value: storyOrSeriesID},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	collaborators := []*models.Collaborator{}
+	if err = attributevalue.UnmarshalListOfMaps(out.Items, &collaborators); err != nil {
+		return nil, err
+	}
+	return collaborators, nil
+}
+
+// GetCollaborations lists the stories and series shared with a user, including pending invitations
+func (d *DAO) GetCollaborations(email string) ([]*models.Collaborator, error) {
+	out, err := d.DynamoClient.Scan(context.TODO(), &dynamodb.ScanInput{
+		TableName:        aws.String("collaborators" + GetTableSuffix()),
+		FilterExpression: aws.String("email=:e"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":e": &types.AttributeValueMemberS{Value: strings.ToLower(email)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	collaborations := []*models.Collaborator{}
+	if err = attributevalue.UnmarshalListOfMaps(out.Items, &collaborations); err != nil {
+		return nil, err
+	}
+	return collaborations, nil
+}
+
+// InviteCollaborator records a pending invitation for the email address. Inviting someone who is
+// already a collaborator changes their role.
+func (d *DAO) InviteCollaborator(owner string, collaborator models.Collaborator) (*models.Collaborator, error) {
+	collaborator.Email = strings.ToLower(strings.TrimSpace(collaborator.Email))
+	if collaborator.Email == "" || !strings.Contains(collaborator.Email, "@") {
+		return nil, fmt.Errorf("a valid email is required")
+	}
+	if strings.EqualFold(collaborator.Email, owner) {
+		return nil, fmt.Errorf("the owner cannot be invited as a collaborator")
+	}
+	if !collaborator.Role.IsValid() || collaborator.Role == models.RoleOwner {
+		return nil, fmt.Errorf("invalid role: %s", collaborator.Role)
+	}
+	if story, err := d.GetStoryByID(owner, collaborator.StoryOrSeriesID); err == nil {
+		collaborator.Title = story.Title
+		collaborator.IsSeries = false
+	} else if series, err := d.GetSeriesByID(owner, collaborator.StoryOrSeriesID); err == nil {
+		collaborator.Title = series.Title
+		collaborator.IsSeries = true
+	} else {
+		return nil, ErrResourceNotFound
+	}
+
+	existing, err := d.getCollaborator(collaborator.StoryOrSeriesID, collaborator.Email)
+	if err != nil {
+		return nil, err
+	}
+	collaborator.Owner = owner
+	collaborator.InvitedBy = owner
+	collaborator.Status = models.InvitationPending
+	collaborator.CreatedAt = time.Now().Unix()
+	collaborator.AcceptedAt = 0
+	if existing != nil {
+		collaborator.Status = existing.Status
+		collaborator.CreatedAt = existing.CreatedAt
+		collaborator.AcceptedAt = existing.AcceptedAt
+	}
+	item, err := attributevalue.MarshalMap(collaborator)
+	if err != nil {
+		return nil, err
+	}
+	_, err = d.DynamoClient.PutItem(context.Background(), &dynamodb.PutItemInput{
+		TableName:           aws.String("collaborators" + GetTableSuffix()),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(email) OR author=:eml"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":eml": &types.AttributeValueMemberS{Value: owner},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &collaborator, nil
+}
+
+// RespondToInvitation accepts or declines a pending invitation addressed to the user
+func (d *DAO) RespondToInvitation(email, storyOrSeriesID string, accept bool) error {
+	key := map[string]types.AttributeValue{
+		"story_or_series_id": &types.AttributeValueMemberS{Value: storyOrSeriesID},
+		"email":              &types.AttributeValueMemberS{Value: strings.ToLower(email)},
+	}
+	if !accept {
+		_, err := d.DynamoClient.DeleteItem(context.Background(), &dynamodb.DeleteItemInput{
+			TableName:           aws.String("collaborators" + GetTableSuffix()),
+			Key:                 key,
+			ConditionExpression: aws.String("#st=:p"),
+			ExpressionAttributeNames: map[string]string{
+				"#st": "status",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":p": &types.AttributeValueMemberS{Value: models.InvitationPending},
+			},
+		})
+		return err
+	}
+	_, err := d.DynamoClient.UpdateItem(context.Background(), &dynamodb.UpdateItemInput{
+		TableName:           aws.String("collaborators" + GetTableSuffix()),
+		Key:                 key,
+		UpdateExpression:    aws.String("set #st=:a, accepted_at=:t"),
+		ConditionExpression: aws.String("#st=:p"),
+		ExpressionAttributeNames: map[string]string{
+			"#st": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":a": &types.AttributeValueMemberS{Value: models.InvitationAccepted},
+			":p": &types.AttributeValueMemberS{Value: models.InvitationPending},
+			":t": &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Unix(), 10)},
+		},
+	})
+	return err
+}
+
+func (d *DAO) RemoveCollaborator(owner, storyOrSeriesID, email string) error {
+	_, err := d.DynamoClient.DeleteItem(context.Background(), &dynamodb.DeleteItemInput{
+		TableName: aws.String("collaborators" + GetTableSuffix()),
+		Key: map[string]types.AttributeValue{
+			"story_or_series_id": &types.AttributeValueMemberS{Value: storyOrSeriesID},
+			"email":              &types.AttributeValueMemberS{Value: strings.ToLower(email)},
+		},
+		ConditionExpression: aws.String("author=:eml"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":eml": &types.AttributeValueMemberS{Value: owner},
+		},
+	})
+	return err
+}
+
+// TransferOwnership hands a standalone story, or a series with all of its volumes, to an accepted
+// collaborator. The previous owner stays on as an editor.
+func (d *DAO) TransferOwnership(owner, storyOrSeriesID, newOwner string) error {
+	access, err := d.GetResourceAccess(owner, storyOrSeriesID)
+	if err != nil {
+		return err
+	}
+	if access.Role != models.RoleOwner {
+		return fmt.Errorf("only the owner can transfer ownership")
+	}
+	if access.SeriesID != "" {
+		return fmt.Errorf("volumes belong to their series; transfer the series instead")
+	}
+	collaborator, err := d.getCollaborator(storyOrSeriesID, newOwner)
+	if err != nil {
+		return err
+	}
+	if collaborator == nil || collaborator.Status != models.InvitationAccepted {
+		return fmt.Errorf("ownership can only be transferred to an accepted collaborator")
+	}
+	newOwner = collaborator.Email
+
+	storyIDs := []string{storyOrSeriesID}
+	if access.IsSeries {
+		if err = d.transferKeyedRow("series", "series_id", storyOrSeriesID, owner, newOwner); err != nil {
+			return err
+		}
+		volumes, err := d.GetSeriesVolumes(owner, storyOrSeriesID)
+		if err != nil {
+			return err
+		}
+		storyIDs = []string{}
+		for _, volume := range volumes {
+			storyIDs = append(storyIDs, volume.ID)
+		}
+	}
+	for _, storyID := range storyIDs {
+		if err = d.transferKeyedRow("stories", "story_id", storyID, owner, newOwner); err != nil {
+			return err
+		}
+	}
+	for _, id := range append([]string{storyOrSeriesID}, storyIDs...) {
+		if err = d.reassignAuthor(id, owner, newOwner); err != nil {
+			return err
+		}
+	}
+	for _, storyID := range storyIDs {
+		if err = d.reassignDeletedChapters(storyID, owner, newOwner); err != nil {
+			return err
+		}
+	}
+
+	// the new owner no longer needs a collaborator entry, the old owner keeps editing rights
+	if err = d.RemoveCollaborator(newOwner, storyOrSeriesID, newOwner); err != nil {
+		return err
+	}
+	previous := models.Collaborator{
+		StoryOrSeriesID: storyOrSeriesID,
+		Email:           strings.ToLower(owner),
+		Role:            models.RoleEditor,
+		Owner:           newOwner,
+		InvitedBy:       newOwner,
+		Status:          models.InvitationAccepted,
+		IsSeries:        access.IsSeries,
+		Title:           collaborator.Title,
+		CreatedAt:       time.Now().Unix(),
+		AcceptedAt:      time.Now().Unix(),
+	}
+	item, err := attributevalue.MarshalMap(previous)
+	if err != nil {
+		return err
+	}
+	_, err = d.DynamoClient.PutItem(context.Background(), &dynamodb.PutItemInput{
+		TableName: aws.String("collaborators" + GetTableSuffix()),
+		Item:      item,
+	})
+	return err
+}
+
+// transferKeyedRow moves a row whose key includes the author, such as a story or series, to a new author
+func (d *DAO) transferKeyedRow(table, idAttr, id, owner, newOwner string) error {
+	out, err := d.DynamoClient.Query(context.TODO(), &dynamodb.QueryInput{
+		TableName:              aws.String(table + GetTableSuffix()),
+		KeyConditionExpression: aws.String(idAttr + "=:id AND author=:eml"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":id":  &types.AttributeValueMemberS{Value: id},
+			":eml": &types.AttributeValueMemberS{Value: owner},
+		},
+		Limit: aws.Int32(1),
+	})
+	if err != nil {
+		return err
+	}
+	if len(out.Items) == 0 {
+		return ErrResourceNotFound
+	}
+	item := out.Items[0]
+	item["author"] = &types.AttributeValueMemberS{Value: newOwner}
+	writeItemsInput := &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Delete: &types.Delete{
+					TableName: aws.String(table + GetTableSuffix()),
+					Key: map[string]types.AttributeValue{
+						idAttr:   &types.AttributeValueMemberS{Value: id},
+						"author": &types.AttributeValueMemberS{Value: owner},
+					},
+				},
+			},
+			{
+				Put: &types.Put{
+					TableName:           aws.String(table + GetTableSuffix()),
+					Item:                item,
+					ConditionExpression: aws.String("attribute_not_exists(" + idAttr + ")"),
+				},
+			},
+		},
+	}
+	err, awsErr := d.awsWriteTransaction(writeItemsInput)
+	if err != nil {
+		return err
+	}
+	if !awsErr.IsNil() {
+		return fmt.Errorf("--AWSERROR-- Code:%s, Type: %s, Message: %s", awsErr.Code, awsErr.ErrorType, awsErr.Text)
+	}
+	return nil
+}
+
+// reassignDeletedChapters hands a story's chapters in the trash to its new owner, only deleted chapters
+// record who owns them
+func (d *DAO) reassignDeletedChapters(storyID, owner, newOwner string) error {
+	chapters, err := d.scanRows("chapters", "story_id=:sid AND author=:eml AND attribute_exists(deleted_at)", map[string]types.AttributeValue{
+		":sid": &types.AttributeValueMemberS{Value: storyID},
+		":eml": &types.AttributeValueMemberS{Value: owner},
+	})
+	if err != nil {
+		return err
+	}
+	for _, chapter := range chapters {
+		if _, err = d.DynamoClient.UpdateItem(context.Background(), &dynamodb.UpdateItemInput{
+			TableName:        aws.String("chapters" + GetTableSuffix()),
+			Key:              chapterRowKey(chapter),
+			UpdateExpression: aws.String("set author=:new"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":new": &types.AttributeValueMemberS{Value: newOwner},
+			},
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reassignAuthor points every authored row belonging to a story or series at its new owner
+func (d *DAO) reassignAuthor(storyOrSeriesID, owner, newOwner string) error {
+	for _, t := range authoredTables {
+		out, err := d.DynamoClient.Scan(context.TODO(), &dynamodb.ScanInput{
+			TableName:        aws.String(t.table + GetTableSuffix()),
+			FilterExpression: aws.String("author=:eml AND #m=:id"),
+			ExpressionAttributeNames: map[string]string{
+				"#m": t.matchAttr,
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":eml": &types.AttributeValueMemberS{Value: owner},
+				":id":  &types.AttributeValueMemberS{Value: storyOrSeriesID},
+			},
+		})
+		if err != nil {
+			return err
+		}
+		for _, item := range out.Items {
+			key := make(map[string]types.AttributeValue, len(t.keyAttrs))
+			for _, attr := range t.keyAttrs {
+				key[attr] = item[attr]
+			}
+			if _, err = d.DynamoClient.UpdateItem(context.Background(), &dynamodb.UpdateItemInput{
+				TableName:        aws.String(t.table + GetTableSuffix()),
+				Key:              key,
+				UpdateExpression: aws.String("set author=:new"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":new": &types.AttributeValueMemberS{Value: newOwner},
+				},
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
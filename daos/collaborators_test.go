@@ -0,0 +1,96 @@
+package daos
+
+import (
+	"RichDocter/models"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestGetResourceAccess(t *testing.T) {
+	mockDao := NewMockDAO()
+	story := map[string]types.AttributeValue{
+		"story_id":  &types.AttributeValueMemberS{Value: "volume1"},
+		"author":    &types.AttributeValueMemberS{Value: "owner@example.com"},
+		"series_id": &types.AttributeValueMemberS{Value: "series1"},
+	}
+	collaborator := func(id, email string, role models.CollaboratorRole, status string) map[string]types.AttributeValue {
+		return map[string]types.AttributeValue{
+			"story_or_series_id": &types.AttributeValueMemberS{Value: id},
+			"email":              &types.AttributeValueMemberS{Value: email},
+			"role":               &types.AttributeValueMemberS{Value: string(role)},
+			"status":             &types.AttributeValueMemberS{Value: status},
+		}
+	}
+	collaborators := []map[string]types.AttributeValue{
+		collaborator("volume1", "editor@example.com", models.RoleEditor, models.InvitationAccepted),
+		collaborator("series1", "reader@example.com", models.RoleViewer, models.InvitationAccepted),
+		collaborator("volume1", "invited@example.com", models.RoleEditor, models.InvitationPending),
+	}
+
+	testCases := []struct {
+		name     string
+		email    string
+		id       string
+		wantRole models.CollaboratorRole
+		wantErr  error
+	}{
+		{name: "Owner", email: "owner@example.com", id: "volume1", wantRole: models.RoleOwner},
+		{name: "StoryCollaborator", email: "editor@example.com", id: "volume1", wantRole: models.RoleEditor},
+		{name: "InheritedFromSeries", email: "reader@example.com", id: "volume1", wantRole: models.RoleViewer},
+		{name: "PendingInvitation", email: "invited@example.com", id: "volume1", wantRole: ""},
+		{name: "Stranger", email: "stranger@example.com", id: "volume1", wantRole: ""},
+		{name: "MissingStory", email: "owner@example.com", id: "missing", wantErr: ErrResourceNotFound},
+	}
+
+	mockClient, ok := mockDao.DynamoClient.(*MockDynamoClient)
+	if !ok {
+		t.Fatalf("mockDao.DynamoClient is not a *MockDynamoClient; got %T", mockDao.DynamoClient)
+	}
+	mockClient.MockScan = func(ctx context.Context, input *dynamodb.ScanInput, opts ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+		id := input.ExpressionAttributeValues[":s"].(*types.AttributeValueMemberS).Value
+		if *input.TableName == "stories"+GetTableSuffix() && id == "volume1" {
+			return &dynamodb.ScanOutput{Items: []map[string]types.AttributeValue{story}}, nil
+		}
+		return &dynamodb.ScanOutput{}, nil
+	}
+	mockClient.MockQuery = func(ctx context.Context, input *dynamodb.QueryInput, opts ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+		id := input.ExpressionAttributeValues[":s"].(*types.AttributeValueMemberS).Value
+		email := input.ExpressionAttributeValues[":e"].(*types.AttributeValueMemberS).Value
+		for _, item := range collaborators {
+			if item["story_or_series_id"].(*types.AttributeValueMemberS).Value == id &&
+				item["email"].(*types.AttributeValueMemberS).Value == email {
+				return &dynamodb.QueryOutput{Items: []map[string]types.AttributeValue{item}}, nil
+			}
+		}
+		return &dynamodb.QueryOutput{}, nil
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			access, err := mockDao.GetResourceAccess(tc.email, tc.id)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if access.Role != tc.wantRole {
+				t.Errorf("expected role %q, got %q", tc.wantRole, access.Role)
+			}
+			if access.Owner != "owner@example.com" || access.SeriesID != "series1" {
+				t.Errorf("unexpected access %+v", access)
+			}
+		})
+	}
+}
@@ -0,0 +1,305 @@
+package daos
+
+import (
+	"RichDocter/models"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+)
+
+// CreateComment adds a comment written by the story's owner or one of its collaborators
+func (d *DAO) CreateComment(owner, email string, comment models.Comment) (*models.Comment, error) {
+	story, err := d.GetStoryByID(owner, comment.StoryID)
+	if err != nil {
+		return nil, err
+	}
+	found := false
+	for _, chapter := range story.Chapters {
+		if chapter.ID == comment.ChapterID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("chapter %s does not belong to story %s", comment.ChapterID, comment.StoryID)
+	}
+	comment.Owner = owner
+	comment.CommenterEmail = email
+	comment.ShareToken = ""
+	if comment.CommenterName == "" {
+		comment.CommenterName = email
+	}
+	return d.writeComment(comment)
+}
+
+// CreateSharedComment adds a comment from a beta reader holding a share link which allows comments
+func (d *DAO) CreateSharedComment(token, password string, comment models.Comment) (*models.Comment, error) {
+	link, err := d.resolveShareLink(token, password)
+	if err != nil {
+		return nil, err
+	}
+	if !link.AllowComments {
+		return nil, ErrShareLinkCommentsDisabled
+	}
+	if !linkSharesChapter(link, comment.ChapterID) {
+		return nil, ErrShareLinkNotFound
+	}
+	if strings.TrimSpace(comment.CommenterName) == "" {
+		return nil, fmt.Errorf("a commenter_name is required")
+	}
+	comment.StoryID = link.StoryID
+	comment.Owner = link.Author
+	comment.CommenterEmail = ""
+	comment.ShareToken = token
+	return d.writeComment(comment)
+}
+
+func (d *DAO) writeComment(comment models.Comment) (*models.Comment, error) {
+	if strings.TrimSpace(comment.Body) == "" {
+		return nil, fmt.Errorf("comment body cannot be empty")
+	}
+	if comment.ParentID != "" {
+		parent, err := d.getComment(comment.StoryID, comment.ParentID)
+		if err != nil {
+			return nil, err
+		}
+		if parent.ParentID != "" {
+			return nil, fmt.Errorf("replies must be made to the comment that started the thread")
+		}
+		if parent.ChapterID != comment.ChapterID {
+			return nil, fmt.Errorf("reply must belong to the same chapter as its thread")
+		}
+		comment.KeyID = parent.KeyID
+		comment.Start = parent.Start
+		comment.End = parent.End
+	}
+	if err := validateCommentAnchor(comment); err != nil {
+		return nil, err
+	}
+	comment.ID = uuid.New().String()
+	comment.CreatedAt = time.Now().Unix()
+	comment.Resolved = false
+	comment.ResolvedAt = 0
+	comment.Replies = nil
+
+	item, err := attributevalue.MarshalMap(comment)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = d.DynamoClient.PutItem(context.Background(), &dynamodb.PutItemInput{
+		TableName:           aws.String("comments" + GetTableSuffix()),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(comment_id)"),
+	}); err != nil {
+		return nil, err
+	}
+	return &comment, nil
+}
+
+func validateCommentAnchor(comment models.Comment) error {
+	if comment.ChapterID == "" || comment.KeyID == "" {
+		return fmt.Errorf("comments must be anchored to a chapter_id and key_id")
+	}
+	if comment.Start < 0 || comment.End < comment.Start {
+		return fmt.Errorf("invalid character range %d-%d", comment.Start, comment.End)
+	}
+	return nil
+}
+
+func (d *DAO) getComment(storyID, commentID string) (*models.Comment, error) {
+	out, err := d.DynamoClient.Query(context.TODO(), &dynamodb.QueryInput{
+		TableName:              aws.String("comments" + GetTableSuffix()),
+		KeyConditionExpression: aws.String("comment_id=:cid AND story_id=:sid"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":cid": &types.AttributeValueMemberS{Value: commentID},
+			":sid": &types.AttributeValueMemberS{Value: storyID},
+		},
+		Limit: aws.Int32(1),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(out.Items) == 0 {
+		return nil, fmt.Errorf("no comment found for id: %s", commentID)
+	}
+	comment := models.Comment{}
+	if err = attributevalue.UnmarshalMap(out.Items[0], &comment); err != nil {
+		return nil, err
+	}
+	return &comment, nil
+}
+
+// GetChapterComments returns the chapter's comment threads, oldest first. Resolved threads are
+// left out unless includeResolved is set.
+func (d *DAO) GetChapterComments(email, storyID, chapterID string, includeResolved bool) ([]*models.Comment, error) {
+	comments, err := d.scanChapterComments(email, storyID, chapterID)
+	if err != nil {
+		return nil, err
+	}
+	return threadComments(comments, includeResolved), nil
+}
+
+// GetSharedChapterComments returns the open threads started through the given share link
+func (d *DAO) GetSharedChapterComments(token, password, chapterID string) ([]*models.Comment, error) {
+	link, err := d.resolveShareLink(token, password)
+	if err != nil {
+		return nil, err
+	}
+	if !link.AllowComments {
+		return nil, ErrShareLinkCommentsDisabled
+	}
+	if !linkSharesChapter(link, chapterID) {
+		return nil, ErrShareLinkNotFound
+	}
+	comments, err := d.scanChapterComments(link.Author, link.StoryID, chapterID)
+	if err != nil {
+		return nil, err
+	}
+	threads := []*models.Comment{}
+	for _, thread := range threadComments(comments, false) {
+		if thread.ShareToken != token {
+			continue
+		}
+		// readers never see the owner's account email
+		thread.CommenterEmail = ""
+		for _, reply := range thread.Replies {
+			reply.CommenterEmail = ""
+		}
+		threads = append(threads, thread)
+	}
+	return threads, nil
+}
+
+func (d *DAO) scanChapterComments(email, storyID, chapterID string) ([]*models.Comment, error) {
+	out, err := d.DynamoClient.Scan(context.TODO(), &dynamodb.ScanInput{
+		TableName:        aws.String("comments" + GetTableSuffix()),
+		FilterExpression: aws.String("author=:eml AND story_id=:sid AND chapter_id=:cid"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":eml": &types.AttributeValueMemberS{Value: email},
+			":sid": &types.AttributeValueMemberS{Value: storyID},
+			":cid": &types.AttributeValueMemberS{Value: chapterID},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	comments := []*models.Comment{}
+	if err = attributevalue.UnmarshalListOfMaps(out.Items, &comments); err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+// threadComments nests replies beneath the comment that started their thread
+func threadComments(comments []*models.Comment, includeResolved bool) []*models.Comment {
+	sort.Slice(comments, func(i, j int) bool {
+		return comments[i].CreatedAt < comments[j].CreatedAt
+	})
+	threads := []*models.Comment{}
+	byID := make(map[string]*models.Comment)
+	for _, comment := range comments {
+		if comment.ParentID == "" {
+			comment.Replies = []*models.Comment{}
+			byID[comment.ID] = comment
+		}
+	}
+	for _, comment := range comments {
+		if comment.ParentID == "" {
+			if includeResolved || !comment.Resolved {
+				threads = append(threads, comment)
+			}
+			continue
+		}
+		if parent, ok := byID[comment.ParentID]; ok {
+			parent.Replies = append(parent.Replies, comment)
+		}
+	}
+	return threads
+}
+
+// ResolveComment marks a thread resolved, or reopens it
+func (d *DAO) ResolveComment(email, storyID, commentID string, resolved bool) error {
+	key := map[string]types.AttributeValue{
+		"comment_id": &types.AttributeValueMemberS{Value: commentID},
+		"story_id":   &types.AttributeValueMemberS{Value: storyID},
+	}
+	updateExpression := "set resolved=:r REMOVE resolved_at"
+	values := map[string]types.AttributeValue{
+		":r":   &types.AttributeValueMemberBOOL{Value: resolved},
+		":eml": &types.AttributeValueMemberS{Value: email},
+	}
+	if resolved {
+		updateExpression = "set resolved=:r, resolved_at=:t"
+		values[":t"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Unix(), 10)}
+	}
+	_, err := d.DynamoClient.UpdateItem(context.Background(), &dynamodb.UpdateItemInput{
+		TableName:                 aws.String("comments" + GetTableSuffix()),
+		Key:                       key,
+		UpdateExpression:          aws.String(updateExpression),
+		ConditionExpression:       aws.String("author=:eml AND attribute_not_exists(parent_id)"),
+		ExpressionAttributeValues: values,
+	})
+	return err
+}
+
+// DeleteComment removes a comment along with any replies to it
+func (d *DAO) DeleteComment(email, storyID, commentID string) error {
+	comment, err := d.getComment(storyID, commentID)
+	if err != nil {
+		return err
+	}
+	ids := []string{commentID}
+	if comment.ParentID == "" {
+		siblings, err := d.scanChapterComments(email, storyID, comment.ChapterID)
+		if err != nil {
+			return err
+		}
+		for _, sibling := range siblings {
+			if sibling.ParentID == commentID {
+				ids = append(ids, sibling.ID)
+			}
+		}
+	}
+	for i := 0; i < len(ids); i += d.writeBatchSize {
+		end := i + d.writeBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		writeItemsInput := &dynamodb.TransactWriteItemsInput{
+			ClientRequestToken: nil,
+			TransactItems:      make([]types.TransactWriteItem, 0, end-i),
+		}
+		for _, id := range ids[i:end] {
+			writeItemsInput.TransactItems = append(writeItemsInput.TransactItems, types.TransactWriteItem{
+				Delete: &types.Delete{
+					TableName: aws.String("comments" + GetTableSuffix()),
+					Key: map[string]types.AttributeValue{
+						"comment_id": &types.AttributeValueMemberS{Value: id},
+						"story_id":   &types.AttributeValueMemberS{Value: storyID},
+					},
+					ConditionExpression: aws.String("author=:eml"),
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":eml": &types.AttributeValueMemberS{Value: email},
+					},
+				},
+			})
+		}
+		err, awsErr := d.awsWriteTransaction(writeItemsInput)
+		if err != nil {
+			return err
+		}
+		if !awsErr.IsNil() {
+			return fmt.Errorf("--AWSERROR-- Code:%s, Type: %s, Message: %s", awsErr.Code, awsErr.ErrorType, awsErr.Text)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package daos
+
+import (
+	"RichDocter/models"
+	"testing"
+)
+
+func TestThreadComments(t *testing.T) {
+	newComments := func() []*models.Comment {
+		return []*models.Comment{
+			{ID: "reply1", ParentID: "open", CreatedAt: 3},
+			{ID: "open", KeyID: "block1", CreatedAt: 1},
+			{ID: "resolved", KeyID: "block2", Resolved: true, CreatedAt: 2},
+			{ID: "reply2", ParentID: "open", CreatedAt: 4},
+			{ID: "orphan", ParentID: "missing", CreatedAt: 5},
+		}
+	}
+	testCases := []struct {
+		name            string
+		includeResolved bool
+		wantThreads     []string
+	}{
+		{name: "OpenOnly", includeResolved: false, wantThreads: []string{"open"}},
+		{name: "IncludeResolved", includeResolved: true, wantThreads: []string{"open", "resolved"}},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			threads := threadComments(newComments(), tc.includeResolved)
+			if len(threads) != len(tc.wantThreads) {
+				t.Fatalf("expected %d threads, got %d", len(tc.wantThreads), len(threads))
+			}
+			for i, id := range tc.wantThreads {
+				if threads[i].ID != id {
+					t.Errorf("expected thread %d to be %q, got %q", i, id, threads[i].ID)
+				}
+			}
+			replies := threads[0].Replies
+			if len(replies) != 2 || replies[0].ID != "reply1" || replies[1].ID != "reply2" {
+				t.Errorf("expected replies reply1, reply2 in order, got %v", replies)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package daos
+
+import (
+	"RichDocter/models"
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+)
+
+// DuplicateStory copies a story, its chapters, their blocks and its associations into a new story
+// owned by the same user. A volume copied into its own series shares the series' associations and
+// keeps the original volume's overrides; copied out of it, the copy gets the series' associations
+// as the volume saw them.
+func (d *DAO) DuplicateStory(email, storyID string, duplicate models.StoryDuplicate) (*models.Story, error) {
+	source, err := d.GetStoryByID(email, storyID)
+	if err != nil {
+		return nil, err
+	}
+	storyCopy := models.Story{
+		ID:          uuid.New().String(),
+		Title:       duplicateTitle(source.Title, duplicate.Title),
+		Description: source.Description,
+		ImageURL:    source.ImageURL,
+	}
+	if duplicate.SameSeries && source.SeriesID != "" {
+		volumes, err := d.GetSeriesVolumes(email, source.SeriesID)
+		if err != nil {
+			return nil, err
+		}
+		storyCopy.SeriesID = source.SeriesID
+		storyCopy.Place = len(volumes) + 1
+	}
+	if err = d.duplicateStory(email, source, storyCopy); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case source.SeriesID == "":
+		if _, err = d.transferAssociationsFrom(email, source.ID, storyCopy.ID, TRANSFER_MODE_COPY); err != nil {
+			return nil, err
+		}
+	case storyCopy.SeriesID == "":
+		sources, err := d.getStoredAssociations(email, source.SeriesID)
+		if err != nil {
+			return nil, err
+		}
+		for i, assoc := range sources {
+			override, err := d.GetAssociationOverride(email, source.ID, assoc.ID)
+			if err != nil {
+				return nil, err
+			}
+			if override != nil {
+				sources[i] = applyAssociationOverride(assoc, override)
+				sources[i].OverriddenFields = nil
+			}
+		}
+		if _, err = d.transferAssociations(email, models.AssociationTransfer{
+			SourceID:   source.SeriesID,
+			TargetID:   storyCopy.ID,
+			Mode:       TRANSFER_MODE_COPY,
+			OnConflict: CONFLICT_MERGE,
+		}, sources); err != nil {
+			return nil, err
+		}
+	default:
+		associations, err := d.getStoredAssociations(email, source.SeriesID)
+		if err != nil {
+			return nil, err
+		}
+		sameIDs := make(map[string]string, len(associations))
+		for _, assoc := range associations {
+			sameIDs[assoc.ID] = assoc.ID
+		}
+		if err = d.copyAssociationOverrides(email, source.ID, storyCopy.ID, source.SeriesID, sameIDs); err != nil {
+			return nil, err
+		}
+	}
+	return d.GetStoryByID(email, storyCopy.ID)
+}
+
+// DuplicateSeries copies a series, its associations and every volume into a new series owned by the
+// same user
+func (d *DAO) DuplicateSeries(email, seriesID string, duplicate models.StoryDuplicate) (*models.Series, error) {
+	source, err := d.GetSeriesByID(email, seriesID)
+	if err != nil {
+		return nil, err
+	}
+	seriesCopy := models.Series{
+		ID:          uuid.New().String(),
+		Title:       duplicateTitle(source.Title, duplicate.Title),
+		Description: source.Description,
+		ImageURL:    source.ImageURL,
+	}
+	if err = d.putSeriesRow(email, seriesCopy); err != nil {
+		return nil, err
+	}
+	transfer, err := d.transferAssociationsFrom(email, source.ID, seriesCopy.ID, TRANSFER_MODE_COPY)
+	if err != nil {
+		return nil, err
+	}
+	for i, volume := range source.Stories {
+		volumeCopy := models.Story{
+			ID:          uuid.New().String(),
+			Title:       volume.Title,
+			Description: volume.Description,
+			ImageURL:    volume.ImageURL,
+			SeriesID:    seriesCopy.ID,
+			Place:       i + 1,
+		}
+		if err = d.duplicateStory(email, volume, volumeCopy); err != nil {
+			return nil, err
+		}
+		if err = d.copyAssociationOverrides(email, volume.ID, volumeCopy.ID, seriesCopy.ID, transfer.IDMap); err != nil {
+			return nil, err
+		}
+	}
+	return d.GetSeriesByID(email, seriesCopy.ID)
+}
+
+// duplicateStory stores the copy's story row, then copies every chapter and its blocks across under new IDs
+func (d *DAO) duplicateStory(email string, source *models.Story, storyCopy models.Story) error {
+	if err := d.putStoryRow(email, storyCopy); err != nil {
+		return err
+	}
+
+	for _, chapter := range source.Chapters {
+		items, err := d.chapterBlockItems(source.ID, chapter.ID)
+		if err != nil {
+			return err
+		}
+		chapterCopy := models.Chapter{
+			ID:      uuid.New().String(),
+			StoryID: storyCopy.ID,
+			Title:   chapter.Title,
+			Place:   chapter.Place,
+		}
+		if _, err = d.CreateChapter(storyCopy.ID, chapterCopy, email); err != nil {
+			return err
+		}
+		// blocks keep their keys, which only need to be unique within their chapter's table
+		for _, item := range items {
+			item["story_id"] = &types.AttributeValueMemberS{Value: storyCopy.ID}
+		}
+		if err = d.copyBlockItems(storyCopy.ID, chapterCopy.ID, items); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyAssociationOverrides gives a volume's copy the original volume's overrides, moved onto the
+// series associations idMap says they were copied to
+func (d *DAO) copyAssociationOverrides(email, sourceStoryID, storyID, seriesID string, idMap map[string]string) error {
+	for sourceID, targetID := range idMap {
+		override, err := d.GetAssociationOverride(email, sourceStoryID, sourceID)
+		if err != nil {
+			return err
+		}
+		if override == nil {
+			continue
+		}
+		override.AssociationID = targetID
+		override.StoryID = storyID
+		override.SeriesID = seriesID
+		if err = d.WriteAssociationOverride(email, *override); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *DAO) putSeriesRow(email string, series models.Series) error {
+	_, err := d.DynamoClient.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String("series" + GetTableSuffix()),
+		Item: map[string]types.AttributeValue{
+			"series_id":   &types.AttributeValueMemberS{Value: series.ID},
+			"author":      &types.AttributeValueMemberS{Value: email},
+			"title":       &types.AttributeValueMemberS{Value: series.Title},
+			"description": &types.AttributeValueMemberS{Value: series.Description},
+			"image_url":   &types.AttributeValueMemberS{Value: series.ImageURL},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(series_id)"),
+	})
+	return err
+}
+
+// putStoryRow writes a new story row, placed in its series when it has one
+func (d *DAO) putStoryRow(email string, story models.Story) error {
+	attributes := map[string]types.AttributeValue{
+		"story_id":    &types.AttributeValueMemberS{Value: story.ID},
+		"author":      &types.AttributeValueMemberS{Value: email},
+		"title":       &types.AttributeValueMemberS{Value: story.Title},
+		"description": &types.AttributeValueMemberS{Value: story.Description},
+		"created_at":  &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Unix(), 10)},
+		"image_url":   &types.AttributeValueMemberS{Value: story.ImageURL},
+	}
+	if story.SeriesID != "" {
+		attributes["series_id"] = &types.AttributeValueMemberS{Value: story.SeriesID}
+		attributes["place"] = &types.AttributeValueMemberN{Value: strconv.Itoa(story.Place)}
+	}
+	_, err := d.DynamoClient.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName:           aws.String("stories" + GetTableSuffix()),
+		Item:                attributes,
+		ConditionExpression: aws.String("attribute_not_exists(story_id)"),
+	})
+	return err
+}
+
+func duplicateTitle(original, requested string) string {
+	if title := strings.TrimSpace(requested); title != "" {
+		return title
+	}
+	return original + " (copy)"
+}
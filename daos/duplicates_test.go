@@ -0,0 +1,143 @@
+package daos
+
+import (
+	"RichDocter/models"
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestDuplicateStory(t *testing.T) {
+	testCases := []struct {
+		name       string
+		seriesID   string
+		sameSeries bool
+		wantPlace  string
+	}{
+		{name: "Standalone"},
+		{name: "VolumeOutOfSeries", seriesID: "series1"},
+		{name: "VolumeInSameSeries", seriesID: "series1", sameSeries: true, wantPlace: "3"},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			mockDao := NewMockDAO()
+			mockClient := mockDao.DynamoClient.(*MockDynamoClient)
+			mockClient.MockScan = func(ctx context.Context, input *dynamodb.ScanInput, opts ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+				switch *input.TableName {
+				case "users" + GetTableSuffix():
+					return &dynamodb.ScanOutput{Items: []map[string]types.AttributeValue{{
+						"email": &types.AttributeValueMemberS{Value: "owner@example.com"},
+					}}}, nil
+				case "stories" + GetTableSuffix():
+					story := map[string]types.AttributeValue{
+						"story_id": input.ExpressionAttributeValues[":s"],
+						"author":   &types.AttributeValueMemberS{Value: "owner@example.com"},
+						"title":    &types.AttributeValueMemberS{Value: "Original"},
+					}
+					if tc.seriesID != "" {
+						story["series_id"] = &types.AttributeValueMemberS{Value: tc.seriesID}
+					}
+					return &dynamodb.ScanOutput{Items: []map[string]types.AttributeValue{story}}, nil
+				case "series" + GetTableSuffix():
+					return &dynamodb.ScanOutput{Items: []map[string]types.AttributeValue{{
+						"series_id": &types.AttributeValueMemberS{Value: tc.seriesID},
+						"title":     &types.AttributeValueMemberS{Value: "Saga"},
+					}}}, nil
+				case "chapters" + GetTableSuffix():
+					if input.ExpressionAttributeValues[":sid"].(*types.AttributeValueMemberS).Value != "story1" {
+						return &dynamodb.ScanOutput{}, nil
+					}
+					return &dynamodb.ScanOutput{Items: []map[string]types.AttributeValue{{
+						"story_id":    &types.AttributeValueMemberS{Value: "story1"},
+						"chapter_id":  &types.AttributeValueMemberS{Value: "c1"},
+						"chapter_num": &types.AttributeValueMemberN{Value: "1"},
+						"title":       &types.AttributeValueMemberS{Value: "Chapter One"},
+					}}}, nil
+				}
+				return &dynamodb.ScanOutput{}, nil
+			}
+			mockClient.MockQuery = func(ctx context.Context, input *dynamodb.QueryInput, opts ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+				switch *input.TableName {
+				case "story1_c1_blocks" + GetTableSuffix():
+					return &dynamodb.QueryOutput{Items: []map[string]types.AttributeValue{{
+						"key_id":   &types.AttributeValueMemberS{Value: "a"},
+						"story_id": &types.AttributeValueMemberS{Value: "story1"},
+						"chunk":    &types.AttributeValueMemberS{Value: `{}`},
+						"rank":     &types.AttributeValueMemberS{Value: "000000V"},
+					}}}, nil
+				case "stories" + GetTableSuffix():
+					return &dynamodb.QueryOutput{Items: []map[string]types.AttributeValue{
+						{"story_id": &types.AttributeValueMemberS{Value: "story1"}, "place": &types.AttributeValueMemberN{Value: "1"}},
+						{"story_id": &types.AttributeValueMemberS{Value: "story2"}, "place": &types.AttributeValueMemberN{Value: "2"}},
+					}}, nil
+				}
+				return &dynamodb.QueryOutput{}, nil
+			}
+			mockClient.MockDescribeTable = func(ctx context.Context, input *dynamodb.DescribeTableInput, opts ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+				return &dynamodb.DescribeTableOutput{Table: &types.TableDescription{TableStatus: types.TableStatusActive}}, nil
+			}
+			var storyRow map[string]types.AttributeValue
+			mockClient.MockPutItem = func(ctx context.Context, input *dynamodb.PutItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+				if *input.TableName == "stories"+GetTableSuffix() {
+					storyRow = input.Item
+				}
+				return &dynamodb.PutItemOutput{}, nil
+			}
+			chapterRows := []map[string]types.AttributeValue{}
+			blockRows := map[string][]map[string]types.AttributeValue{}
+			mockClient.MockTransactWriteItems = func(ctx context.Context, input *dynamodb.TransactWriteItemsInput, opts ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+				for _, item := range input.TransactItems {
+					if item.Put == nil {
+						continue
+					}
+					if *item.Put.TableName == "chapters"+GetTableSuffix() {
+						chapterRows = append(chapterRows, item.Put.Item)
+					} else {
+						blockRows[*item.Put.TableName] = append(blockRows[*item.Put.TableName], item.Put.Item)
+					}
+				}
+				return &dynamodb.TransactWriteItemsOutput{}, nil
+			}
+
+			_, err := mockDao.DuplicateStory("owner@example.com", "story1", models.StoryDuplicate{SameSeries: tc.sameSeries})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if storyRow == nil {
+				t.Fatalf("expected the copy's story row written")
+			}
+			copyID := storyRow["story_id"].(*types.AttributeValueMemberS).Value
+			if copyID == "story1" {
+				t.Errorf("expected the copy to get a new id")
+			}
+			if title := storyRow["title"].(*types.AttributeValueMemberS).Value; title != "Original (copy)" {
+				t.Errorf("expected the copy titled after the original, got %q", title)
+			}
+			if tc.wantPlace == "" {
+				if _, ok := storyRow["series_id"]; ok {
+					t.Errorf("expected the copy to stand alone, got series %v", storyRow["series_id"])
+				}
+			} else if place, ok := storyRow["place"].(*types.AttributeValueMemberN); !ok || place.Value != tc.wantPlace {
+				t.Errorf("expected the copy at place %s in its series, got %v", tc.wantPlace, storyRow["place"])
+			}
+			if len(chapterRows) != 1 {
+				t.Fatalf("expected 1 chapter copied, got %d", len(chapterRows))
+			}
+			chapterID := chapterRows[0]["chapter_id"].(*types.AttributeValueMemberS).Value
+			if chapterID == "c1" || chapterRows[0]["story_id"].(*types.AttributeValueMemberS).Value != copyID {
+				t.Errorf("expected the chapter copied under a new id into the copy, got %v", chapterRows[0])
+			}
+			blocks := blockRows[copyID+"_"+chapterID+"_blocks"+GetTableSuffix()]
+			if len(blocks) != 1 || blocks[0]["story_id"].(*types.AttributeValueMemberS).Value != copyID {
+				t.Errorf("expected the block copied into the copy's chapter, got %v", blockRows)
+			}
+		})
+	}
+}
@@ -45,6 +45,7 @@ type dynamoDBClient interface {
 	DeleteTable(context.Context, *dynamodb.DeleteTableInput, ...func(*dynamodb.Options)) (*dynamodb.DeleteTableOutput, error)
 	UpdateContinuousBackups(context.Context, *dynamodb.UpdateContinuousBackupsInput, ...func(*dynamodb.Options)) (*dynamodb.UpdateContinuousBackupsOutput, error)
 	RestoreTableFromBackup(context.Context, *dynamodb.RestoreTableFromBackupInput, ...func(*dynamodb.Options)) (*dynamodb.RestoreTableFromBackupOutput, error)
+	DeleteBackup(context.Context, *dynamodb.DeleteBackupInput, ...func(*dynamodb.Options)) (*dynamodb.DeleteBackupOutput, error)
 }
 
 type dynamoClient struct {
@@ -104,6 +105,10 @@ func (d *dynamoClient) RestoreTableFromBackup(ctx context.Context, input *dynamo
 	return d.client.RestoreTableFromBackup(ctx, input, optFns...)
 }
 
+func (d *dynamoClient) DeleteBackup(ctx context.Context, input *dynamodb.DeleteBackupInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteBackupOutput, error) {
+	return d.client.DeleteBackup(ctx, input, optFns...)
+}
+
 func NewDynamoClient(client *dynamodb.Client) *dynamoClient {
 	return &dynamoClient{client: client}
 }
@@ -114,6 +119,8 @@ type DAO struct {
 	maxRetries     int
 	capacity       int
 	writeBatchSize int
+	// set on DAOs handed to requests sent with an Idempotency-Key
+	idempotency *idempotencyScope
 }
 
 var _ DaoInterface = (*DAO)(nil)
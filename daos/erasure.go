@@ -0,0 +1,460 @@
+package daos
+
+import (
+	"RichDocter/models"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+)
+
+// erasures run this long after they're requested, so a user can change their mind
+const accountErasureGracePeriod = 14 * 24 * time.Hour
+
+var (
+	ErrErasurePending   = errors.New("account erasure is already scheduled")
+	ErrNoErasurePending = errors.New("no account erasure is scheduled")
+)
+
+// RequestAccountErasure schedules everything the user has to be erased once the grace period is up,
+// recording the request in the erasure audit table
+func (d *DAO) RequestAccountErasure(email string) (*models.AccountErasure, error) {
+	now := time.Now()
+	erasure := &models.AccountErasure{
+		ID:          uuid.New().String(),
+		SubjectHash: erasureSubject(email),
+		Status:      models.ErasurePending,
+		RequestedAt: now.Unix(),
+		DueAt:       now.Add(accountErasureGracePeriod).Unix(),
+	}
+	item, err := attributevalue.MarshalMap(erasure)
+	if err != nil {
+		return nil, err
+	}
+	input := &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Put: &types.Put{
+					TableName:           aws.String("account_erasures" + GetTableSuffix()),
+					Item:                item,
+					ConditionExpression: aws.String("attribute_not_exists(erasure_id)"),
+				},
+			},
+			{
+				Update: &types.Update{
+					TableName: aws.String("users" + GetTableSuffix()),
+					Key: map[string]types.AttributeValue{
+						"email": &types.AttributeValueMemberS{Value: email},
+					},
+					UpdateExpression:    aws.String("set erasure_id=:id, erasure_due_at=:due"),
+					ConditionExpression: aws.String("attribute_exists(email) AND attribute_not_exists(erasure_id)"),
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":id":  &types.AttributeValueMemberS{Value: erasure.ID},
+						":due": &types.AttributeValueMemberN{Value: strconv.FormatInt(erasure.DueAt, 10)},
+					},
+				},
+			},
+		},
+	}
+	err, awsErr := d.awsWriteTransaction(input)
+	if err != nil {
+		return nil, err
+	}
+	if !awsErr.IsNil() {
+		if awsErr.ErrorType == "ConditionalCheckFailed" {
+			return nil, ErrErasurePending
+		}
+		return nil, fmt.Errorf("--AWSERROR-- Code:%s, Type: %s, Message: %s", awsErr.Code, awsErr.ErrorType, awsErr.Text)
+	}
+	return erasure, nil
+}
+
+// GetAccountErasure finds the erasure scheduled for the user's account
+func (d *DAO) GetAccountErasure(email string) (*models.AccountErasure, error) {
+	user, err := d.GetUserDetails(email)
+	if err != nil {
+		return nil, err
+	}
+	if user.ErasureID == "" {
+		return nil, ErrNoErasurePending
+	}
+	return d.getAccountErasure(user.ErasureID)
+}
+
+// CancelAccountErasure calls off a scheduled erasure. The audit record is kept, marked cancelled.
+func (d *DAO) CancelAccountErasure(email string) (*models.AccountErasure, error) {
+	erasure, err := d.GetAccountErasure(email)
+	if err != nil {
+		return nil, err
+	}
+	erasure.Status = models.ErasureCancelled
+	erasure.CancelledAt = time.Now().Unix()
+	input := &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Update: &types.Update{
+					TableName: aws.String("account_erasures" + GetTableSuffix()),
+					Key: map[string]types.AttributeValue{
+						"erasure_id": &types.AttributeValueMemberS{Value: erasure.ID},
+					},
+					UpdateExpression:    aws.String("set #st=:cancelled, cancelled_at=:t"),
+					ConditionExpression: aws.String("#st=:pending"),
+					ExpressionAttributeNames: map[string]string{
+						"#st": "status",
+					},
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":cancelled": &types.AttributeValueMemberS{Value: models.ErasureCancelled},
+						":pending":   &types.AttributeValueMemberS{Value: models.ErasurePending},
+						":t":         &types.AttributeValueMemberN{Value: strconv.FormatInt(erasure.CancelledAt, 10)},
+					},
+				},
+			},
+			{
+				Update: &types.Update{
+					TableName: aws.String("users" + GetTableSuffix()),
+					Key: map[string]types.AttributeValue{
+						"email": &types.AttributeValueMemberS{Value: email},
+					},
+					UpdateExpression:    aws.String("remove erasure_id, erasure_due_at"),
+					ConditionExpression: aws.String("erasure_id=:id"),
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":id": &types.AttributeValueMemberS{Value: erasure.ID},
+					},
+				},
+			},
+		},
+	}
+	err, awsErr := d.awsWriteTransaction(input)
+	if err != nil {
+		return nil, err
+	}
+	if !awsErr.IsNil() {
+		if awsErr.ErrorType == "ConditionalCheckFailed" {
+			return nil, ErrNoErasurePending
+		}
+		return nil, fmt.Errorf("--AWSERROR-- Code:%s, Type: %s, Message: %s", awsErr.Code, awsErr.ErrorType, awsErr.Text)
+	}
+	return erasure, nil
+}
+
+// GetDueAccountErasures lists the users whose erasure grace period had run out by now
+func (d *DAO) GetDueAccountErasures(now int64) ([]*models.UserInfo, error) {
+	items, err := d.scanItems(&dynamodb.ScanInput{
+		TableName:        aws.String("users" + GetTableSuffix()),
+		FilterExpression: aws.String("attribute_exists(erasure_id) AND erasure_due_at <= :now"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(now, 10)},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	users := []*models.UserInfo{}
+	if err = attributevalue.UnmarshalListOfMaps(items, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// GetAccountImages lists the image urls of everything the user owns, deleted or not, so uploaded
+// images can be removed before the rows pointing at them are erased
+func (d *DAO) GetAccountImages(email string) ([]string, error) {
+	owned := map[string]types.AttributeValue{":eml": &types.AttributeValueMemberS{Value: email}}
+	images := []string{}
+	for table, attr := range map[string]string{
+		"stories":               "image_url",
+		"series":                "image_url",
+		"associations":          "portrait",
+		"association_overrides": "portrait",
+	} {
+		items, err := d.scanItems(&dynamodb.ScanInput{
+			TableName:                 aws.String(table + GetTableSuffix()),
+			FilterExpression:          aws.String("author=:eml"),
+			ExpressionAttributeValues: owned,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			if imageURL, ok := item[attr].(*types.AttributeValueMemberS); ok && imageURL.Value != "" {
+				images = append(images, imageURL.Value)
+			}
+		}
+	}
+	return images, nil
+}
+
+// EraseAccount permanently deletes everything the user has once their erasure is due: stories and
+// series including those in the trash, chapters with their block tables and backups, associations,
+// templates, share links, comments, collaborations, change history and finally the user. The audit
+// record is completed along with what was erased, adding any counts the caller already erased
+// elsewhere. Safe to run again if it fails part way through.
+func (d *DAO) EraseAccount(email string, erased map[string]int) (*models.AccountErasure, error) {
+	user, err := d.GetUserDetails(email)
+	if err != nil {
+		return nil, err
+	}
+	if user.ErasureID == "" || user.ErasureDueAt > time.Now().Unix() {
+		return nil, ErrNoErasurePending
+	}
+	erasure, err := d.getAccountErasure(user.ErasureID)
+	if err != nil {
+		return nil, err
+	}
+	if erasure.Erased = erased; erasure.Erased == nil {
+		erasure.Erased = map[string]int{}
+	}
+
+	owned := map[string]types.AttributeValue{":eml": &types.AttributeValueMemberS{Value: email}}
+	stories, err := d.scanRows("stories", "author=:eml", owned)
+	if err != nil {
+		return nil, err
+	}
+	series, err := d.scanRows("series", "author=:eml", owned)
+	if err != nil {
+		return nil, err
+	}
+	for _, story := range stories {
+		chapters, err := d.scanRows("chapters", "story_id=:sid", map[string]types.AttributeValue{
+			":sid": &types.AttributeValueMemberS{Value: story.StoryID},
+		})
+		if err != nil {
+			return nil, err
+		}
+		deletes := make([]*types.Delete, 0, len(chapters))
+		for _, chapter := range chapters {
+			// deleted chapters were backed up and their block tables dropped when they were deleted
+			if chapter.DeletedAt != 0 {
+				err = d.deleteChapterBackup(chapter.BackupARN)
+			} else {
+				err = d.deleteBlockTable(story.StoryID + "_" + chapter.ChapterID + "_blocks" + GetTableSuffix())
+			}
+			if err != nil {
+				return nil, err
+			}
+			deletes = append(deletes, &types.Delete{
+				TableName: aws.String("chapters" + GetTableSuffix()),
+				Key:       chapterRowKey(chapter),
+			})
+		}
+		if err = d.deleteRows(deletes); err != nil {
+			return nil, err
+		}
+		erasure.Erased["chapters"] += len(chapters)
+	}
+
+	changesKey := []string{"story_id", "seq"}
+	for _, story := range stories {
+		count, err := d.eraseRows("story_changes", "story_id=:id", map[string]types.AttributeValue{
+			":id": &types.AttributeValueMemberS{Value: story.StoryID},
+		}, changesKey)
+		if err != nil {
+			return nil, err
+		}
+		erasure.Erased["changes"] += count
+	}
+	for _, s := range series {
+		count, err := d.eraseRows("story_changes", "story_id=:id", map[string]types.AttributeValue{
+			":id": &types.AttributeValueMemberS{Value: s.SeriesID},
+		}, changesKey)
+		if err != nil {
+			return nil, err
+		}
+		erasure.Erased["changes"] += count
+	}
+
+	// what the user authored on stories they own, then what they left on stories others own
+	for _, t := range authoredTables {
+		count, err := d.eraseRows(t.table, "author=:eml", owned, t.keyAttrs)
+		if err != nil {
+			return nil, err
+		}
+		erasure.Erased[t.table] += count
+	}
+	for _, t := range []struct {
+		table    string
+		filter   string
+		keyAttrs []string
+	}{
+		{"story_templates", "author=:eml", []string{"template_id", "author"}},
+		{"collaborators", "email=:eml", []string{"story_or_series_id", "email"}},
+		{"comments", "commenter_email=:eml", []string{"comment_id", "story_id"}},
+		{"story_changes", "author=:eml", changesKey},
+	} {
+		count, err := d.eraseRows(t.table, t.filter, owned, t.keyAttrs)
+		if err != nil {
+			return nil, err
+		}
+		erasure.Erased[t.table] += count
+	}
+	count, err := d.eraseRows("idempotency_keys", "begins_with(id, :prefix)", map[string]types.AttributeValue{
+		":prefix": &types.AttributeValueMemberS{Value: email + " "},
+	}, []string{"id"})
+	if err != nil {
+		return nil, err
+	}
+	erasure.Erased["idempotency_keys"] += count
+
+	deletes := make([]*types.Delete, 0, len(stories)+len(series))
+	for _, story := range stories {
+		deletes = append(deletes, &types.Delete{
+			TableName: aws.String("stories" + GetTableSuffix()),
+			Key:       storyRowKey(email, story.StoryID),
+		})
+	}
+	for _, s := range series {
+		deletes = append(deletes, &types.Delete{
+			TableName: aws.String("series" + GetTableSuffix()),
+			Key:       seriesRowKey(email, s.SeriesID),
+		})
+	}
+	if err = d.deleteRows(deletes); err != nil {
+		return nil, err
+	}
+	erasure.Erased["stories"] += len(stories)
+	erasure.Erased["series"] += len(series)
+
+	// the user goes in the same transaction that completes the audit record, so a run that fails
+	// here is picked up again by the next one
+	erasure.Status = models.ErasureCompleted
+	erasure.CompletedAt = time.Now().Unix()
+	erasedCounts, err := attributevalue.Marshal(erasure.Erased)
+	if err != nil {
+		return nil, err
+	}
+	input := &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Update: &types.Update{
+					TableName: aws.String("account_erasures" + GetTableSuffix()),
+					Key: map[string]types.AttributeValue{
+						"erasure_id": &types.AttributeValueMemberS{Value: erasure.ID},
+					},
+					UpdateExpression:    aws.String("set #st=:completed, completed_at=:t, erased=:erased"),
+					ConditionExpression: aws.String("#st=:pending"),
+					ExpressionAttributeNames: map[string]string{
+						"#st": "status",
+					},
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":completed": &types.AttributeValueMemberS{Value: models.ErasureCompleted},
+						":pending":   &types.AttributeValueMemberS{Value: models.ErasurePending},
+						":t":         &types.AttributeValueMemberN{Value: strconv.FormatInt(erasure.CompletedAt, 10)},
+						":erased":    erasedCounts,
+					},
+				},
+			},
+			{
+				Delete: &types.Delete{
+					TableName: aws.String("users" + GetTableSuffix()),
+					Key: map[string]types.AttributeValue{
+						"email": &types.AttributeValueMemberS{Value: email},
+					},
+					ConditionExpression: aws.String("erasure_id=:id"),
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":id": &types.AttributeValueMemberS{Value: erasure.ID},
+					},
+				},
+			},
+		},
+	}
+	err, awsErr := d.awsWriteTransaction(input)
+	if err != nil {
+		return nil, err
+	}
+	if !awsErr.IsNil() {
+		if awsErr.ErrorType == "ConditionalCheckFailed" {
+			return nil, ErrNoErasurePending
+		}
+		return nil, fmt.Errorf("--AWSERROR-- Code:%s, Type: %s, Message: %s", awsErr.Code, awsErr.ErrorType, awsErr.Text)
+	}
+	return erasure, nil
+}
+
+func (d *DAO) getAccountErasure(erasureID string) (*models.AccountErasure, error) {
+	out, err := d.DynamoClient.Query(context.TODO(), &dynamodb.QueryInput{
+		TableName:              aws.String("account_erasures" + GetTableSuffix()),
+		KeyConditionExpression: aws.String("erasure_id = :id"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":id": &types.AttributeValueMemberS{Value: erasureID},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(out.Items) == 0 {
+		return nil, ErrNoErasurePending
+	}
+	erasure := models.AccountErasure{}
+	if err = attributevalue.UnmarshalMap(out.Items[0], &erasure); err != nil {
+		return nil, err
+	}
+	return &erasure, nil
+}
+
+// eraseRows permanently deletes every row of a table matching the filter, returning how many went
+func (d *DAO) eraseRows(table, filter string, values map[string]types.AttributeValue, keyAttrs []string) (int, error) {
+	items, err := d.scanItems(&dynamodb.ScanInput{
+		TableName:                 aws.String(table + GetTableSuffix()),
+		FilterExpression:          aws.String(filter),
+		ExpressionAttributeValues: values,
+	})
+	if err != nil {
+		return 0, err
+	}
+	deletes := make([]*types.Delete, 0, len(items))
+	for _, item := range items {
+		key := make(map[string]types.AttributeValue, len(keyAttrs))
+		for _, attr := range keyAttrs {
+			key[attr] = item[attr]
+		}
+		deletes = append(deletes, &types.Delete{
+			TableName: aws.String(table + GetTableSuffix()),
+			Key:       key,
+		})
+	}
+	return len(deletes), d.deleteRows(deletes)
+}
+
+// deleteBlockTable drops a chapter's block table, which may already be gone
+func (d *DAO) deleteBlockTable(tableName string) error {
+	_, err := d.DynamoClient.DeleteTable(context.Background(), &dynamodb.DeleteTableInput{
+		TableName: aws.String(tableName),
+	})
+	var notFound *types.ResourceNotFoundException
+	if err != nil && !errors.As(err, &notFound) {
+		return err
+	}
+	return nil
+}
+
+// scanItems reads every page of a scan
+func (d *DAO) scanItems(scanInput *dynamodb.ScanInput) ([]map[string]types.AttributeValue, error) {
+	items := []map[string]types.AttributeValue{}
+	for {
+		out, err := d.DynamoClient.Scan(context.TODO(), scanInput)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, out.Items...)
+		if len(out.LastEvaluatedKey) == 0 {
+			return items, nil
+		}
+		scanInput.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+}
+
+// erasureSubject identifies a user in the erasure audit table without keeping their email
+func erasureSubject(email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(email)))
+	return hex.EncodeToString(sum[:])
+}
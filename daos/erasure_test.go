@@ -0,0 +1,214 @@
+package daos
+
+import (
+	"RichDocter/models"
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+)
+
+func TestRequestAccountErasure(t *testing.T) {
+	testCases := []struct {
+		name           string
+		alreadyPending bool
+		wantErr        error
+	}{
+		{name: "Scheduled"},
+		{name: "AlreadyScheduled", alreadyPending: true, wantErr: ErrErasurePending},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			mockDao := NewMockDAO()
+			mockClient := mockDao.DynamoClient.(*MockDynamoClient)
+			var written *dynamodb.TransactWriteItemsInput
+			mockClient.MockTransactWriteItems = func(ctx context.Context, input *dynamodb.TransactWriteItemsInput, opts ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+				written = input
+				if tc.alreadyPending {
+					return nil, &smithy.OperationError{
+						OperationName: "TransactWriteItems",
+						Err: &types.TransactionCanceledException{
+							Message: aws.String("Transaction cancelled"),
+							CancellationReasons: []types.CancellationReason{
+								{Code: aws.String("None"), Message: aws.String("")},
+								{Code: aws.String("ConditionalCheckFailed"), Message: aws.String("The conditional request failed")},
+							},
+						},
+					}
+				}
+				return &dynamodb.TransactWriteItemsOutput{}, nil
+			}
+
+			before := time.Now()
+			erasure, err := mockDao.RequestAccountErasure("owner@example.com")
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if tc.wantErr != nil {
+				return
+			}
+			if erasure.Status != models.ErasurePending {
+				t.Errorf("expected a pending erasure, got %q", erasure.Status)
+			}
+			if erasure.DueAt < before.Add(accountErasureGracePeriod).Unix() {
+				t.Errorf("expected the erasure to be due after the grace period, got %d", erasure.DueAt)
+			}
+			if len(written.TransactItems) != 2 {
+				t.Fatalf("expected the audit record and user to be written together, got %d writes", len(written.TransactItems))
+			}
+			audit := written.TransactItems[0].Put
+			if audit == nil || *audit.TableName != "account_erasures"+GetTableSuffix() {
+				t.Fatalf("expected the audit record to be put first")
+			}
+			if _, ok := audit.Item["email"]; ok {
+				t.Errorf("expected the audit record not to hold the email")
+			}
+			if subject := audit.Item["subject_hash"].(*types.AttributeValueMemberS).Value; subject != erasureSubject("Owner@Example.com") {
+				t.Errorf("expected the audit record to identify the user by hash, got %q", subject)
+			}
+			user := written.TransactItems[1].Update
+			if user == nil || user.ExpressionAttributeValues[":id"].(*types.AttributeValueMemberS).Value != erasure.ID {
+				t.Errorf("expected the user to be marked with erasure %s", erasure.ID)
+			}
+		})
+	}
+}
+
+func TestEraseAccount(t *testing.T) {
+	now := time.Now().Unix()
+	testCases := []struct {
+		name         string
+		erasureID    string
+		dueAt        int64
+		wantErr      error
+		wantTables   []string
+		wantBackups  []string
+		wantErased   map[string]int
+		wantUserGone bool
+	}{
+		{
+			name:    "NotScheduled",
+			wantErr: ErrNoErasurePending,
+		},
+		{
+			name:      "GracePeriodRunning",
+			erasureID: "e1",
+			dueAt:     now + 3600,
+			wantErr:   ErrNoErasurePending,
+		},
+		{
+			name:         "Due",
+			erasureID:    "e1",
+			dueAt:        now - 60,
+			wantTables:   []string{"s1_c1_blocks" + GetTableSuffix()},
+			wantBackups:  []string{"arn:c2"},
+			wantErased:   map[string]int{"chapters": 2, "stories": 2, "series": 1, "images": 3, "associations": 1},
+			wantUserGone: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			mockDao := NewMockDAO()
+			mockClient := mockDao.DynamoClient.(*MockDynamoClient)
+			user := map[string]types.AttributeValue{
+				"email": &types.AttributeValueMemberS{Value: "owner@example.com"},
+			}
+			if tc.erasureID != "" {
+				user["erasure_id"] = &types.AttributeValueMemberS{Value: tc.erasureID}
+				user["erasure_due_at"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(tc.dueAt, 10)}
+			}
+			tables := map[string][]map[string]types.AttributeValue{
+				"users" + GetTableSuffix(): {user},
+				"stories" + GetTableSuffix(): {
+					trashTestRow(map[string]string{"story_id": "s1", "title": "Live"}, ""),
+					trashTestRow(map[string]string{"story_id": "s2", "series_id": "sr1", "title": "Trashed"}, "100"),
+				},
+				"series" + GetTableSuffix(): {
+					trashTestRow(map[string]string{"series_id": "sr1", "title": "Saga"}, "100"),
+				},
+				"associations" + GetTableSuffix(): {
+					trashTestRow(map[string]string{"association_id": "a1", "story_or_series_id": "s1"}, ""),
+				},
+			}
+			mockClient.MockScan = func(ctx context.Context, input *dynamodb.ScanInput, opts ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+				if *input.TableName == "chapters"+GetTableSuffix() {
+					if input.ExpressionAttributeValues[":sid"].(*types.AttributeValueMemberS).Value != "s1" {
+						return &dynamodb.ScanOutput{}, nil
+					}
+					deleted := trashTestRow(map[string]string{"story_id": "s1", "chapter_id": "c2", "bup_arn": "arn:c2"}, "100")
+					return &dynamodb.ScanOutput{Items: []map[string]types.AttributeValue{
+						trashTestRow(map[string]string{"story_id": "s1", "chapter_id": "c1"}, ""),
+						deleted,
+					}}, nil
+				}
+				return &dynamodb.ScanOutput{Items: tables[*input.TableName]}, nil
+			}
+			mockClient.MockQuery = func(ctx context.Context, input *dynamodb.QueryInput, opts ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+				return &dynamodb.QueryOutput{Items: []map[string]types.AttributeValue{{
+					"erasure_id": &types.AttributeValueMemberS{Value: tc.erasureID},
+					"status":     &types.AttributeValueMemberS{Value: models.ErasurePending},
+				}}}, nil
+			}
+			droppedTables := []string{}
+			mockClient.MockDeleteTable = func(ctx context.Context, input *dynamodb.DeleteTableInput, opts ...func(*dynamodb.Options)) (*dynamodb.DeleteTableOutput, error) {
+				droppedTables = append(droppedTables, *input.TableName)
+				return &dynamodb.DeleteTableOutput{}, nil
+			}
+			deletedBackups := []string{}
+			mockClient.MockDeleteBackup = func(ctx context.Context, input *dynamodb.DeleteBackupInput, opts ...func(*dynamodb.Options)) (*dynamodb.DeleteBackupOutput, error) {
+				deletedBackups = append(deletedBackups, *input.BackupArn)
+				return &dynamodb.DeleteBackupOutput{}, nil
+			}
+			userGone := false
+			mockClient.MockTransactWriteItems = func(ctx context.Context, input *dynamodb.TransactWriteItemsInput, opts ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+				for _, item := range input.TransactItems {
+					if item.Delete != nil && *item.Delete.TableName == "users"+GetTableSuffix() {
+						userGone = true
+					}
+				}
+				return &dynamodb.TransactWriteItemsOutput{}, nil
+			}
+
+			erasure, err := mockDao.EraseAccount("owner@example.com", map[string]int{"images": 3})
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if userGone != tc.wantUserGone {
+				t.Errorf("expected user deleted to be %v, got %v", tc.wantUserGone, userGone)
+			}
+			if tc.wantErr != nil {
+				return
+			}
+			if erasure.Status != models.ErasureCompleted || erasure.CompletedAt == 0 {
+				t.Errorf("expected a completed erasure, got %q at %d", erasure.Status, erasure.CompletedAt)
+			}
+			if len(droppedTables) != len(tc.wantTables) || droppedTables[0] != tc.wantTables[0] {
+				t.Errorf("expected block tables %v dropped, got %v", tc.wantTables, droppedTables)
+			}
+			if len(deletedBackups) != len(tc.wantBackups) || deletedBackups[0] != tc.wantBackups[0] {
+				t.Errorf("expected backups %v deleted, got %v", tc.wantBackups, deletedBackups)
+			}
+			for kind, want := range tc.wantErased {
+				if erasure.Erased[kind] != want {
+					t.Errorf("expected %d %s erased, got %d", want, kind, erasure.Erased[kind])
+				}
+			}
+		})
+	}
+}
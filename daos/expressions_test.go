@@ -0,0 +1,167 @@
+package daos
+
+import (
+	"RichDocter/models"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// dynamoReservedWords are the words DynamoDB refuses as attribute names in an expression unless
+// they are aliased with ExpressionAttributeNames
+var dynamoReservedWords = func() map[string]bool {
+	words := map[string]bool{}
+	for _, word := range strings.Fields(`
+		ABORT ABSOLUTE ACTION ADD AFTER AGENT AGGREGATE ALL ALLOCATE ALTER ANALYZE AND ANY ARCHIVE ARE
+		ARRAY AS ASC ASCII ASENSITIVE ASSERTION ASYMMETRIC AT ATOMIC ATTACH ATTRIBUTE AUTH AUTHORIZATION
+		AUTHORIZE AUTO AVG BACK BACKUP BASE BATCH BEFORE BEGIN BETWEEN BIGINT BINARY BIT BLOB BLOCK
+		BOOLEAN BOTH BREADTH BUCKET BULK BY BYTE CALL CALLED CALLING CAPACITY CASCADE CASCADED CASE CAST
+		CATALOG CHAR CHARACTER CHECK CLASS CLOB CLOSE CLUSTER CLUSTERED CLUSTERING CLUSTERS COALESCE
+		COLLATE COLLATION COLLECTION COLUMN COLUMNS COMBINE COMMENT COMMIT COMPACT COMPILE COMPRESS
+		CONDITION CONFLICT CONNECT CONNECTION CONSISTENCY CONSISTENT CONSTRAINT CONSTRAINTS CONSTRUCTOR
+		CONSUMED CONTINUE CONVERT COPY CORRESPONDING COUNT COUNTER CREATE CROSS CUBE CURRENT CURSOR
+		CYCLE DATA DATABASE DATE DATETIME DAY DEALLOCATE DEC DECIMAL DECLARE DEFAULT DEFERRABLE DEFERRED
+		DEFINE DEFINED DEFINITION DELETE DELIMITED DEPTH DEREF DESC DESCRIBE DESCRIPTOR DETACH
+		DETERMINISTIC DIAGNOSTICS DIRECTORIES DISABLE DISCONNECT DISTINCT DISTRIBUTE DO DOMAIN DOUBLE
+		DROP DUMP DURATION DYNAMIC EACH ELEMENT ELSE ELSEIF EMPTY ENABLE END EQUAL EQUALS ERROR ESCAPE
+		ESCAPED EVAL EVALUATE EXCEEDED EXCEPT EXCEPTION EXCEPTIONS EXCLUSIVE EXEC EXECUTE EXISTS EXIT
+		EXPLAIN EXPLODE EXPORT EXPRESSION EXTENDED EXTERNAL EXTRACT FAIL FALSE FAMILY FETCH FIELDS FILE
+		FILTER FILTERING FINAL FINISH FIRST FIXED FLATTERN FLOAT FOR FORCE FOREIGN FORMAT FORWARD FOUND
+		FREE FROM FULL FUNCTION FUNCTIONS GENERAL GENERATE GET GLOB GLOBAL GO GOTO GRANT GREATER GROUP
+		GROUPING HANDLER HASH HAVE HAVING HEAP HIDDEN HOLD HOUR IDENTIFIED IDENTITY IF IGNORE IMMEDIATE
+		IMPORT IN INCLUDING INCLUSIVE INCREMENT INCREMENTAL INDEX INDEXED INDEXES INDICATOR INFINITE
+		INITIALLY INLINE INNER INNTER INOUT INPUT INSENSITIVE INSERT INSTEAD INT INTEGER INTERSECT
+		INTERVAL INTO INVALIDATE IS ISOLATION ITEM ITEMS ITERATE JOIN KEY KEYS LAG LANGUAGE LARGE LAST
+		LATERAL LEAD LEADING LEAVE LEFT LENGTH LESS LEVEL LIKE LIMIT LIMITED LINES LIST LOAD LOCAL
+		LOCALTIME LOCALTIMESTAMP LOCATION LOCATOR LOCK LOCKS LOG LOGED LONG LOOP LOWER MAP MATCH
+		MATERIALIZED MAX MAXLEN MEMBER MERGE METHOD METRICS MIN MINUS MINUTE MISSING MOD MODE MODIFIES
+		MODIFY MODULE MONTH MULTI MULTISET NAME NAMES NATIONAL NATURAL NCHAR NCLOB NEW NEXT NO NONE NOT
+		NULL NULLIF NUMBER NUMERIC OBJECT OF OFFLINE OFFSET OLD ON ONLINE ONLY OPAQUE OPEN OPERATOR
+		OPTION OR ORDER ORDINALITY OTHER OTHERS OUT OUTER OUTPUT OVER OVERLAPS OVERRIDE OWNER PAD
+		PARALLEL PARAMETER PARAMETERS PARTIAL PARTITION PARTITIONED PARTITIONS PATH PERCENT PERCENTILE
+		PERMISSION PERMISSIONS PIPE PIPELINED PLAN POOL POSITION PRECISION PREPARE PRESERVE PRIMARY
+		PRIOR PRIVATE PRIVILEGES PROCEDURE PROCESSED PROJECT PROJECTION PROPERTY PROVISIONING PUBLIC
+		PUT QUERY QUIT QUORUM RAISE RANDOM RANGE RANK RAW READ READS REAL REBUILD RECORD RECURSIVE
+		REDUCE REF REFERENCE REFERENCES REFERENCING REGEXP REGION REINDEX RELATIVE RELEASE REMAINDER
+		RENAME REPEAT REPLACE REQUEST RESET RESIGNAL RESOURCE RESPONSE RESTORE RESTRICT RESULT RETURN
+		RETURNING RETURNS REVERSE REVOKE RIGHT ROLE ROLES ROLLBACK ROLLUP ROUTINE ROW ROWS RULE RULES
+		SAMPLE SATISFIES SAVE SAVEPOINT SCAN SCHEMA SCOPE SCROLL SEARCH SECOND SECTION SEGMENT SEGMENTS
+		SELECT SELF SEMI SENSITIVE SEPARATE SEQUENCE SERIALIZABLE SESSION SET SETS SHARD SHARE SHARED
+		SHORT SHOW SIGNAL SIMILAR SIZE SKEWED SMALLINT SNAPSHOT SOME SOURCE SPACE SPACES SPARSE SPECIFIC
+		SPECIFICTYPE SPLIT SQL SQLCODE SQLERROR SQLEXCEPTION SQLSTATE SQLWARNING START STATE STATIC
+		STATUS STORAGE STORE STORED STREAM STRING STRUCT STYLE SUB SUBMULTISET SUBPARTITION SUBSTRING
+		SUBTYPE SUM SUPER SYMMETRIC SYNONYM SYSTEM TABLE TABLESAMPLE TEMP TEMPORARY TERMINATED TEXT THAN
+		THEN THROUGHPUT TIME TIMESTAMP TIMEZONE TINYINT TO TOKEN TOTAL TOUCH TRAILING TRANSACTION
+		TRANSFORM TRANSLATE TRANSLATION TREAT TRIGGER TRIM TRUE TRUNCATE TTL TUPLE TYPE UNDER UNDO UNION
+		UNIQUE UNIT UNKNOWN UNLOGGED UNNEST UNPROCESSED UNSIGNED UNTIL UPDATE UPPER URL USAGE USE USER
+		USERS USING UUID VACUUM VALUE VALUED VALUES VARCHAR VARIABLE VARIANCE VARINT VARYING VIEW VIEWS
+		VIRTUAL VOID WAIT WHEN WHENEVER WHERE WHILE WINDOW WITH WITHIN WITHOUT WORK WRAPPED WRITE YEAR
+		ZONE`) {
+		words[word] = true
+	}
+	return words
+}()
+
+// expressionKeywords are the reserved words that belong to the expression syntax itself
+var expressionKeywords = map[string]bool{
+	"SET": true, "REMOVE": true, "ADD": true, "DELETE": true,
+	"AND": true, "OR": true, "NOT": true, "BETWEEN": true, "IN": true,
+}
+
+var expressionToken = regexp.MustCompile(`[#:]?[A-Za-z_][A-Za-z0-9_]*\s*\(?`)
+
+// unaliasedReservedWords lists the reserved words an expression uses as attribute names. Aliases,
+// values, keywords and function calls are left out.
+func unaliasedReservedWords(expression string) []string {
+	var found []string
+	for _, token := range expressionToken.FindAllString(expression, -1) {
+		if strings.HasPrefix(token, "#") || strings.HasPrefix(token, ":") || strings.HasSuffix(token, "(") {
+			continue
+		}
+		word := strings.ToUpper(strings.TrimSpace(token))
+		if dynamoReservedWords[word] && !expressionKeywords[word] {
+			found = append(found, token)
+		}
+	}
+	return found
+}
+
+// assertAliased fails the test for any reserved word used without an alias, and for any alias the
+// expression uses but doesn't define
+func assertAliased(t *testing.T, expression *string, names map[string]string) {
+	t.Helper()
+	if expression == nil {
+		return
+	}
+	if words := unaliasedReservedWords(*expression); len(words) > 0 {
+		t.Errorf("expression %q uses reserved words %v without an alias", *expression, words)
+	}
+	for _, alias := range regexp.MustCompile(`#[A-Za-z0-9_]+`).FindAllString(*expression, -1) {
+		if _, ok := names[alias]; !ok {
+			t.Errorf("expression %q uses alias %s without defining it", *expression, alias)
+		}
+	}
+}
+
+func TestBlockUpdateExpressions(t *testing.T) {
+	testCases := []struct {
+		name   string
+		block  models.StoryBlock
+		reRank bool
+	}{
+		{name: "Update", block: models.StoryBlock{KeyID: "b1", Chunk: []byte(`{}`), Rank: "000001a", Version: 2}},
+		{name: "UpdateWithPlace", block: models.StoryBlock{KeyID: "b1", Chunk: []byte(`{}`), Rank: "000001a", Place: "3"}},
+		{name: "Reorder", block: models.StoryBlock{KeyID: "b1", Rank: "000001a"}, reRank: true},
+		{name: "ReorderWithPlace", block: models.StoryBlock{KeyID: "b1", Rank: "000001a", Place: "3"}, reRank: true},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			update := blockUpdate("story1_chapter1_blocks", "story1", tc.block)
+			if tc.reRank {
+				update = blockRankUpdate("story1_chapter1_blocks", tc.block)
+			}
+			assertAliased(t, update.UpdateExpression, update.ExpressionAttributeNames)
+			assertAliased(t, update.ConditionExpression, update.ExpressionAttributeNames)
+			if !contains(aws.ToString(update.UpdateExpression), "place=:p") {
+				t.Fatalf("expected the place to be written alongside the rank, got %q", aws.ToString(update.UpdateExpression))
+			}
+			if place, ok := update.ExpressionAttributeValues[":p"].(*types.AttributeValueMemberN); !ok || place.Value != models.PlaceFromRank(tc.block.Rank) {
+				t.Errorf("expected place %s from rank %s, got %v", models.PlaceFromRank(tc.block.Rank), tc.block.Rank, update.ExpressionAttributeValues[":p"])
+			}
+		})
+	}
+}
+
+func TestUnaliasedReservedWords(t *testing.T) {
+	testCases := []struct {
+		name       string
+		expression string
+		want       []string
+	}{
+		{name: "Unaliased", expression: "set chunk=:c, rank=:r", want: []string{"rank"}},
+		{name: "Aliased", expression: "set chunk=:c, #rank=:r", want: nil},
+		{name: "Functions", expression: "attribute_exists(key_id) AND size(#fields) > :n", want: nil},
+		{name: "NestedPath", expression: "set details.status=:s", want: []string{"status"}},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			got := unaliasedReservedWords(tc.expression)
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("expected %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
@@ -3,6 +3,8 @@ package daos
 import (
 	"RichDocter/models"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net/url"
@@ -14,7 +16,6 @@ import (
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
@@ -34,6 +35,9 @@ func (d *DAO) awsWriteTransaction(writeItemsInput *dynamodb.TransactWriteItemsIn
 		return fmt.Errorf("writeItemsInput is nil"), awsError
 	}
 	maxItemsPerSecond := d.capacity / 2
+	if writeItemsInput.ClientRequestToken == nil && d.idempotency != nil {
+		writeItemsInput.ClientRequestToken = d.idempotency.nextToken()
+	}
 
 	for numRetries := 0; numRetries < d.maxRetries; numRetries++ {
 		if _, err := d.DynamoClient.TransactWriteItems(context.Background(), writeItemsInput); err == nil {
@@ -139,10 +143,19 @@ func (d *DAO) CheckForSuspendedStories(email string) (bool, error) {
 	return false, nil
 }
 
+// waitForBlockTable blocks until a chapter's block table can be written to. createBlockTable returns
+// while the table is still being created, so anything writing blocks into a new chapter waits here.
+func (d *DAO) waitForBlockTable(tableName string) error {
+	waiter := dynamodb.NewTableExistsWaiter(d.DynamoClient)
+	return waiter.Wait(context.TODO(), &dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	}, blockTableReadyTimeout)
+}
+
 func (d *DAO) createBlockTable(tableName string, tags *[]types.Tag) error {
 	partitionKey := aws.String("key_id")
 	gsiPartKey := aws.String("story_id")
-	gsiSortKey := aws.String("place")
+	gsiSortKey := aws.String("rank")
 
 	tableSchema := []types.KeySchemaElement{
 		{
@@ -172,13 +185,13 @@ func (d *DAO) createBlockTable(tableName string, tags *[]types.Tag) error {
 		},
 		{
 			AttributeName: gsiSortKey,
-			AttributeType: types.ScalarAttributeTypeN,
+			AttributeType: types.ScalarAttributeTypeS,
 		},
 	}
 
 	gsiSettings := []types.GlobalSecondaryIndex{
 		{
-			IndexName: aws.String("story_id-place-index"),
+			IndexName: aws.String("story_id-rank-index"),
 			KeySchema: gsiSchema,
 			Projection: &types.Projection{
 				ProjectionType: types.ProjectionTypeAll,
@@ -357,138 +370,20 @@ func (d *DAO) CheckTableStatus(tableName string) (string, error) {
 // 	return nil
 // }
 
+// RestoreAutomaticallyDeletedStories brings back the stories suspended when a user's subscription lapsed
 func (d *DAO) RestoreAutomaticallyDeletedStories(email string) error {
-	out, err := d.DynamoClient.Scan(context.TODO(), &dynamodb.ScanInput{
-		TableName:        aws.String("stories"),
-		FilterExpression: aws.String("author=:eml AND attribute_exists(deleted_at) AND automated_deletion=:a"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":eml": &types.AttributeValueMemberS{Value: email},
-			":a":   &types.AttributeValueMemberBOOL{Value: true},
-		},
+	stories, err := d.scanRows("stories", "author=:eml AND attribute_exists(deleted_at) AND automated_deletion=:a", map[string]types.AttributeValue{
+		":eml": &types.AttributeValueMemberS{Value: email},
+		":a":   &types.AttributeValueMemberBOOL{Value: true},
 	})
 	if err != nil {
 		return err
 	}
-
-	var stories []models.Story
-	if err = attributevalue.UnmarshalListOfMaps(out.Items, &stories); err != nil {
-		return err
-	}
 	for _, story := range stories {
-		chapterScanInput := &dynamodb.ScanInput{
-			TableName:        aws.String("chapters"),
-			FilterExpression: aws.String("attribute_exists(deleted_at) AND story_id = :sid AND attribute_exists(bup_arn)"),
-			ExpressionAttributeValues: map[string]types.AttributeValue{
-				":sid": &types.AttributeValueMemberS{Value: story.ID},
-			},
-			Select: types.SelectAllAttributes,
-		}
-		chapterOut, err := d.DynamoClient.Scan(context.TODO(), chapterScanInput)
-		if err != nil {
-			return err
-		}
-		var chapters []models.Chapter
-		if err = attributevalue.UnmarshalListOfMaps(chapterOut.Items, &chapters); err != nil {
+		if err = d.restoreStory(email, story); err != nil {
 			return err
 		}
-		for _, chapter := range chapters {
-			oldTableName := story.ID + "_" + chapter.ID + "_blocks"
-			_, err := d.DynamoClient.RestoreTableFromBackup(context.TODO(), &dynamodb.RestoreTableFromBackupInput{
-				BackupArn:       aws.String(chapter.BackupARN),
-				TargetTableName: aws.String(oldTableName),
-			})
-			if err != nil {
-				return err
-			}
-			chapterKey := map[string]types.AttributeValue{
-				"chapter_id": &types.AttributeValueMemberS{Value: chapter.ID},
-				"story_id":   &types.AttributeValueMemberS{Value: story.ID},
-			}
-			chapterUpdateInput := &dynamodb.UpdateItemInput{
-				TableName:        aws.String("chapters"),
-				Key:              chapterKey,
-				UpdateExpression: aws.String("REMOVE deleted_at, automated_deletion"),
-			}
-			_, err = d.DynamoClient.UpdateItem(context.Background(), chapterUpdateInput)
-			if err != nil {
-				return err
-			}
-		}
-		storyKey := map[string]types.AttributeValue{
-			"story_id": &types.AttributeValueMemberS{Value: story.ID},
-			"author":   &types.AttributeValueMemberS{Value: email},
-		}
-		storyUpdateInput := &dynamodb.UpdateItemInput{
-			TableName:        aws.String("stories"),
-			Key:              storyKey,
-			UpdateExpression: aws.String("REMOVE deleted_at, automated_deletion"),
-		}
-		_, err = d.DynamoClient.UpdateItem(context.Background(), storyUpdateInput)
-		if err != nil {
-			return err
-		}
-
-		storyOrSeriesID := story.ID
-		if story.SeriesID != "" {
-			storyOrSeriesID = story.SeriesID
-			seriesKey := map[string]types.AttributeValue{
-				"series_id": &types.AttributeValueMemberS{Value: story.SeriesID},
-				"author":    &types.AttributeValueMemberS{Value: email},
-			}
-			seriesUpdateInput := &dynamodb.UpdateItemInput{
-				TableName:        aws.String("series"),
-				Key:              seriesKey,
-				UpdateExpression: aws.String("REMOVE deleted_at, automated_deletion"),
-			}
-			_, err = d.DynamoClient.UpdateItem(context.Background(), seriesUpdateInput)
-			if err != nil {
-				return err
-			}
-		}
-
-		associationScanInput := &dynamodb.ScanInput{
-			TableName:        aws.String("associations"),
-			FilterExpression: aws.String("author = :eml AND attribute_exists(deleted_at) AND automated_deletion = :a AND story_or_series_id = :sid"),
-			ExpressionAttributeValues: map[string]types.AttributeValue{
-				":eml": &types.AttributeValueMemberS{Value: email},
-				":a":   &types.AttributeValueMemberBOOL{Value: true},
-				":sid": &types.AttributeValueMemberS{Value: storyOrSeriesID},
-			},
-			Select: types.SelectAllAttributes,
-		}
-		associationOut, err := d.DynamoClient.Scan(context.TODO(), associationScanInput)
-		if err != nil {
-			return err
-		}
-
-		for _, item := range associationOut.Items {
-			assocID := item["association_id"].(*types.AttributeValueMemberS).Value
-			associationKey := map[string]types.AttributeValue{
-				"association_id":     &types.AttributeValueMemberS{Value: assocID},
-				"story_or_series_id": &types.AttributeValueMemberS{Value: storyOrSeriesID},
-			}
-			associationUpdateInput := &dynamodb.UpdateItemInput{
-				TableName:        aws.String("associations"),
-				Key:              associationKey,
-				UpdateExpression: aws.String("REMOVE deleted_at, automated_deletion"),
-			}
-			_, err = d.DynamoClient.UpdateItem(context.Background(), associationUpdateInput)
-			if err != nil {
-				return err
-			}
-
-			associationDetailsUpdateInput := &dynamodb.UpdateItemInput{
-				TableName:        aws.String("association_details"),
-				Key:              associationKey,
-				UpdateExpression: aws.String("REMOVE deleted_at, automated_deletion"),
-			}
-			_, err = d.DynamoClient.UpdateItem(context.Background(), associationDetailsUpdateInput)
-			if err != nil {
-				return err
-			}
-		}
 	}
-
 	return nil
 }
 
@@ -528,70 +423,9 @@ func (d *DAO) SoftDeleteStory(email, storyID string, automated bool) error {
 			return errors.New("chapter_id missing or not a string")
 		}
 		chapterID := chapterIDAttr.Value
-		oldTableName := storyID + "_" + chapterID + "_blocks" + GetTableSuffix()
-
-		// Create the BackupTableInput
-		input := &dynamodb.CreateBackupInput{
-			TableName:  aws.String(oldTableName),
-			BackupName: aws.String(oldTableName + "-backup-" + time.Now().Format("2006-01-02-15-04-05")),
-		}
-
-		// Create the backup
-		buResponse, err := d.DynamoClient.CreateBackup(context.TODO(), input)
-		tableNotFound := false
+		backupARN, err := d.backupBlockTable(storyID, chapterID)
 		if err != nil {
-			if opErr, ok := err.(*smithy.OperationError); ok {
-				var txnErr *types.TableNotFoundException
-				if errors.As(opErr.Unwrap(), &txnErr) {
-					tableNotFound = true
-					fmt.Printf("Table %s not found, skipping", oldTableName)
-				}
-			}
-			if !tableNotFound {
-				fmt.Printf("Failed to create backup for table %s, %v", oldTableName, err)
-				return err
-			}
-		}
-
-		backupARN := ""
-		if !tableNotFound {
-			backupARN = *buResponse.BackupDetails.BackupArn
-			err = d.checkBackupStatus(*buResponse.BackupDetails.BackupArn)
-			if err != nil {
-				return err
-			}
-			chapterStatus, err := d.CheckTableStatus(oldTableName)
-			if err != nil {
-				return err
-			}
-			if chapterStatus != "ACTIVE" {
-				time.Sleep(1 * time.Second)
-				go d.SoftDeleteStory(email, storyID, automated)
-				return nil
-			}
-			deleteTableInput := &dynamodb.DeleteTableInput{
-				TableName: aws.String(oldTableName),
-			}
-
-			for numRetries := 0; numRetries < d.maxRetries; numRetries++ {
-
-				if _, err = d.DynamoClient.DeleteTable(context.Background(), deleteTableInput); err != nil {
-					if opErr, ok := err.(*smithy.OperationError); ok {
-						var useErr *types.ResourceInUseException
-						if errors.As(opErr.Unwrap(), &useErr) {
-							delay := time.Duration((1 << uint(numRetries)) * (2 * time.Second))
-							if numRetries < d.maxRetries-1 {
-								fmt.Println("retrying block table deletion in", delay)
-								time.Sleep(delay)
-								continue
-							} else {
-								return err
-							}
-						}
-					}
-				}
-				break
-			}
+			return err
 		}
 
 		chapterKey := map[string]types.AttributeValue{
@@ -601,11 +435,12 @@ func (d *DAO) SoftDeleteStory(email, storyID string, automated bool) error {
 		updateChapter := &types.Update{
 			TableName:        aws.String("chapters" + GetTableSuffix()),
 			Key:              chapterKey,
-			UpdateExpression: aws.String("set deleted_at = :n, automated_deletion = :a, bup_arn = :barn"),
+			UpdateExpression: aws.String("set deleted_at = :n, automated_deletion = :a, bup_arn = :barn, author = :eml"),
 			ExpressionAttributeValues: map[string]types.AttributeValue{
 				":n":    &types.AttributeValueMemberN{Value: now},
 				":a":    &types.AttributeValueMemberBOOL{Value: automated},
 				":barn": &types.AttributeValueMemberS{Value: backupARN},
+				":eml":  &types.AttributeValueMemberS{Value: email},
 			},
 		}
 		transactItems = append(transactItems, types.TransactWriteItem{
@@ -739,6 +574,60 @@ func sanitizeTagValue(input string) string {
 	return tagValueSanitizer.ReplaceAllString(input, "")
 }
 
+// backupBlockTable backs up a chapter's block table and then drops it, returning the backup's ARN or
+// blank if the chapter has no block table
+func (d *DAO) backupBlockTable(storyID, chapterID string) (string, error) {
+	oldTableName := storyID + "_" + chapterID + "_blocks" + GetTableSuffix()
+
+	// Create the BackupTableInput
+	input := &dynamodb.CreateBackupInput{
+		TableName:  aws.String(oldTableName),
+		BackupName: aws.String(oldTableName + "-backup-" + time.Now().Format("2006-01-02-15-04-05")),
+	}
+
+	// Create the backup
+	buResponse, err := d.DynamoClient.CreateBackup(context.TODO(), input)
+	if err != nil {
+		if opErr, ok := err.(*smithy.OperationError); ok {
+			var txnErr *types.TableNotFoundException
+			if errors.As(opErr.Unwrap(), &txnErr) {
+				fmt.Printf("Table %s not found, skipping", oldTableName)
+				return "", nil
+			}
+		}
+		fmt.Printf("Failed to create backup for table %s, %v", oldTableName, err)
+		return "", err
+	}
+
+	backupARN := *buResponse.BackupDetails.BackupArn
+	if err = d.checkBackupStatus(backupARN); err != nil {
+		return "", err
+	}
+	deleteTableInput := &dynamodb.DeleteTableInput{
+		TableName: aws.String(oldTableName),
+	}
+	// the table can't be dropped while it is still being created or updated
+	for numRetries := 0; numRetries < d.maxRetries; numRetries++ {
+		if _, err = d.DynamoClient.DeleteTable(context.Background(), deleteTableInput); err != nil {
+			if opErr, ok := err.(*smithy.OperationError); ok {
+				var useErr *types.ResourceInUseException
+				if errors.As(opErr.Unwrap(), &useErr) {
+					delay := time.Duration((1 << uint(numRetries)) * (2 * time.Second))
+					if numRetries < d.maxRetries-1 {
+						fmt.Println("retrying block table deletion in", delay)
+						time.Sleep(delay)
+						continue
+					} else {
+						return "", err
+					}
+				}
+			}
+		}
+		break
+	}
+	return backupARN, nil
+}
+
 func (d *DAO) hardDeleteStory(email, storyID string) error {
 	originalStory, err := d.GetStoryByID(email, storyID)
 	if err != nil {
@@ -935,3 +824,42 @@ func (d *DAO) AddStripeData(email, subscriptionID, customerID *string) error {
 	}
 	return nil
 }
+
+// ErrVersionConflict is returned when a write carries a version older than the stored copy
+var ErrVersionConflict = errors.New("this was changed by someone else, reload and try again")
+
+var ErrInvalidBlockRank = errors.New("invalid block rank")
+
+// versionCondition builds a condition matching items still at the expected version. Items written
+// before versioning was introduced have no version attribute and count as version 0.
+func versionCondition(expected int64) (string, map[string]types.AttributeValue) {
+	if expected == 0 {
+		return "attribute_not_exists(#ver)", map[string]types.AttributeValue{}
+	}
+	return "#ver = :ver", map[string]types.AttributeValue{
+		":ver": &types.AttributeValueMemberN{Value: strconv.FormatInt(expected, 10)},
+	}
+}
+
+func isConditionalCheckFailure(err error) bool {
+	var conditionErr *types.ConditionalCheckFailedException
+	return errors.As(err, &conditionErr)
+}
+
+// nilIfEmpty drops an empty value map, which DynamoDB rejects outright
+func nilIfEmpty(values map[string]types.AttributeValue) map[string]types.AttributeValue {
+	if len(values) == 0 {
+		return nil
+	}
+	return values
+}
+
+// clientRequestToken derives the token for one transaction of an attempt at an idempotent request.
+// DynamoDB caps tokens at 36 characters and remembers them for ten minutes.
+func clientRequestToken(idempotencyKey string, part int) *string {
+	if idempotencyKey == "" {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(idempotencyKey + "/" + strconv.Itoa(part)))
+	return aws.String(hex.EncodeToString(sum[:])[:36])
+}
@@ -0,0 +1,118 @@
+package daos
+
+import (
+	"RichDocter/models"
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+var ErrIdempotencyKeyInUse = errors.New("a request with this Idempotency-Key is still in progress")
+
+// idempotencyScope hands out the client request tokens for the transactions of one attempt at an
+// idempotent request. The SDK resends a transaction's token when it retries it, so a transaction that
+// went through but whose response was lost isn't applied twice. A later attempt, after the key was
+// released or taken over, gets tokens of its own: its transactions carry new timestamps, and DynamoDB
+// refuses a token it has seen with different parameters.
+type idempotencyScope struct {
+	mu   sync.Mutex
+	key  string
+	part int
+}
+
+func (s *idempotencyScope) nextToken() *string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.part++
+	return clientRequestToken(s.key, s.part)
+}
+
+// WithIdempotencyKey returns a DAO whose transactions carry client request tokens derived from key,
+// which should identify a single attempt at the request
+func (d *DAO) WithIdempotencyKey(key string) DaoInterface {
+	scoped := *d
+	scoped.idempotency = &idempotencyScope{key: key}
+	return &scoped
+}
+
+// ClaimIdempotencyKey marks a request as started. When the key has been used before, the stored
+// record is returned instead, or ErrIdempotencyKeyInUse while the first request is still running.
+func (d *DAO) ClaimIdempotencyKey(record models.IdempotencyRecord) (*models.IdempotencyRecord, error) {
+	record.Status = models.IdempotencyPending
+	item, err := attributevalue.MarshalMap(record)
+	if err != nil {
+		return nil, err
+	}
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	_, err = d.DynamoClient.PutItem(context.Background(), &dynamodb.PutItemInput{
+		TableName:                aws.String("idempotency_keys" + GetTableSuffix()),
+		Item:                     item,
+		ConditionExpression:      aws.String("attribute_not_exists(id) OR expires_at < :now OR (#st = :pending AND locked_until < :now)"),
+		ExpressionAttributeNames: map[string]string{"#st": "status"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now":     &types.AttributeValueMemberN{Value: now},
+			":pending": &types.AttributeValueMemberS{Value: models.IdempotencyPending},
+		},
+	})
+	if err == nil {
+		return nil, nil
+	}
+	if !isConditionalCheckFailure(err) {
+		return nil, err
+	}
+	out, err := d.DynamoClient.Query(context.TODO(), &dynamodb.QueryInput{
+		TableName:              aws.String("idempotency_keys" + GetTableSuffix()),
+		KeyConditionExpression: aws.String("id=:id"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":id": &types.AttributeValueMemberS{Value: record.ID},
+		},
+		Limit: aws.Int32(1),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(out.Items) == 0 {
+		// released between our put and the read, the client can try again
+		return nil, ErrIdempotencyKeyInUse
+	}
+	existing := models.IdempotencyRecord{}
+	if err = attributevalue.UnmarshalMap(out.Items[0], &existing); err != nil {
+		return nil, err
+	}
+	if existing.Status != models.IdempotencyComplete {
+		return nil, ErrIdempotencyKeyInUse
+	}
+	return &existing, nil
+}
+
+// SaveIdempotentResponse stores the response a claimed request finished with
+func (d *DAO) SaveIdempotentResponse(record models.IdempotencyRecord) error {
+	record.Status = models.IdempotencyComplete
+	item, err := attributevalue.MarshalMap(record)
+	if err != nil {
+		return err
+	}
+	_, err = d.DynamoClient.PutItem(context.Background(), &dynamodb.PutItemInput{
+		TableName: aws.String("idempotency_keys" + GetTableSuffix()),
+		Item:      item,
+	})
+	return err
+}
+
+// ReleaseIdempotencyKey forgets a claimed key so the request can be tried again
+func (d *DAO) ReleaseIdempotencyKey(id string) error {
+	_, err := d.DynamoClient.DeleteItem(context.Background(), &dynamodb.DeleteItemInput{
+		TableName: aws.String("idempotency_keys" + GetTableSuffix()),
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
+		},
+	})
+	return err
+}
@@ -0,0 +1,122 @@
+package daos
+
+import (
+	"RichDocter/models"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+)
+
+func TestClaimIdempotencyKey(t *testing.T) {
+	mockDao := NewMockDAO()
+	testCases := []struct {
+		name       string
+		stored     *models.IdempotencyRecord
+		wantErr    error
+		wantReplay bool
+	}{
+		{name: "FirstUse"},
+		{
+			name:       "CompletedBefore",
+			stored:     &models.IdempotencyRecord{ID: "user@example.com key1", Status: models.IdempotencyComplete, StatusCode: 200, Body: []byte(`{}`)},
+			wantReplay: true,
+		},
+		{
+			name:    "StillRunning",
+			stored:  &models.IdempotencyRecord{ID: "user@example.com key1", Status: models.IdempotencyPending},
+			wantErr: ErrIdempotencyKeyInUse,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			mockClient := mockDao.DynamoClient.(*MockDynamoClient)
+			mockClient.MockPutItem = func(ctx context.Context, input *dynamodb.PutItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+				if input.ConditionExpression == nil {
+					t.Errorf("claim written without a condition")
+				}
+				if tc.stored != nil {
+					return nil, &smithy.OperationError{
+						ServiceID:     "DynamoDB",
+						OperationName: "PutItem",
+						Err:           &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")},
+					}
+				}
+				return &dynamodb.PutItemOutput{}, nil
+			}
+			mockClient.MockQuery = func(ctx context.Context, input *dynamodb.QueryInput, opts ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+				item, err := attributevalue.MarshalMap(tc.stored)
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				return &dynamodb.QueryOutput{Items: []map[string]types.AttributeValue{item}}, nil
+			}
+
+			previous, err := mockDao.ClaimIdempotencyKey(models.IdempotencyRecord{ID: "user@example.com key1"})
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if (previous != nil) != tc.wantReplay {
+				t.Fatalf("expected replay=%v, got %+v", tc.wantReplay, previous)
+			}
+			if previous != nil && previous.StatusCode != tc.stored.StatusCode {
+				t.Errorf("expected status %d, got %d", tc.stored.StatusCode, previous.StatusCode)
+			}
+		})
+	}
+}
+
+func TestIdempotentTransactionTokens(t *testing.T) {
+	mockDao := NewMockDAO()
+	mockClient := mockDao.DynamoClient.(*MockDynamoClient)
+	tokens := []string{}
+	mockClient.MockTransactWriteItems = func(ctx context.Context, input *dynamodb.TransactWriteItemsInput, opts ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+		if input.ClientRequestToken == nil {
+			tokens = append(tokens, "")
+		} else {
+			tokens = append(tokens, *input.ClientRequestToken)
+		}
+		return &dynamodb.TransactWriteItemsOutput{}, nil
+	}
+	write := func(dao DaoInterface) {
+		for i := 0; i < 2; i++ {
+			if err, _ := dao.awsWriteTransaction(&dynamodb.TransactWriteItemsInput{}); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		}
+	}
+
+	write(mockDao.WithIdempotencyKey("user@example.com key1 attempt1"))
+	write(mockDao.WithIdempotencyKey("user@example.com key1 attempt1"))
+	write(mockDao.WithIdempotencyKey("user@example.com key1 attempt2"))
+	write(mockDao)
+
+	if tokens[0] == "" || tokens[0] == tokens[1] {
+		t.Errorf("expected distinct tokens per transaction, got %q and %q", tokens[0], tokens[1])
+	}
+	if tokens[0] != tokens[2] || tokens[1] != tokens[3] {
+		t.Errorf("expected the same attempt to derive the same tokens, got %v", tokens[:4])
+	}
+	if tokens[4] == tokens[0] || tokens[5] == tokens[1] {
+		t.Errorf("expected a new attempt after the key was released to get new tokens, got %v", tokens[:6])
+	}
+	if tokens[6] != "" || tokens[7] != "" {
+		t.Errorf("expected no tokens without a key, got %v", tokens[6:])
+	}
+}
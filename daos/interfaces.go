@@ -2,6 +2,7 @@ package daos
 
 import (
 	"RichDocter/models"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
@@ -22,36 +23,104 @@ type DaoInterface interface {
 	GetSeriesVolumes(email string, seriesID string) ([]*models.Story, error)
 	GetUserDetails(email string) (*models.UserInfo, error)
 	GetChapterByID(chapterID string) (*models.Chapter, error)
+	GetAssociationRelationships(email, storyOrSeriesID string) ([]*models.AssociationRelationship, error)
+	GetRelationshipGraph(email, storyOrSeriesID string) (*models.RelationshipGraph, error)
+	GetAssociationTypes(email, storyOrSeriesID string) ([]*models.AssociationType, error)
+	GetAssociationOverride(email, storyID, associationID string) (*models.AssociationOverride, error)
+	GetAssociationEvolution(email, seriesID, associationID string) ([]*models.AssociationVolumeState, error)
+	GetAllAssociations(email, storyOrSeriesID string) ([]*models.Association, error)
+	GetShareLinksByStory(email, storyID string) ([]*models.ShareLink, error)
+	GetSharedStory(token, password string) (*models.SharedStory, error)
+	GetChapterComments(email, storyID, chapterID string, includeResolved bool) ([]*models.Comment, error)
+	GetSharedChapterComments(token, password, chapterID string) ([]*models.Comment, error)
+	GetBlocksByKeys(storyID, chapterID string, keyIDs []string) ([]models.StoryBlock, error)
+	GetChanges(storyOrSeriesID string, since int64) (*models.ChangeFeed, error)
+	GetResourceAccess(email, storyOrSeriesID string) (*models.ResourceAccess, error)
+	GetCollaborators(owner, storyOrSeriesID string) ([]*models.Collaborator, error)
+	GetCollaborations(email string) ([]*models.Collaborator, error)
+	GetTrash(email string) ([]*models.TrashItem, error)
+	GetStoryTemplates(email string) ([]*models.StoryTemplate, error)
+	GetStoryTemplate(email, templateID string) (*models.StoryTemplate, error)
+	ExportAccount(email string) (*models.AccountArchive, error)
+	GetAccountErasure(email string) (*models.AccountErasure, error)
+	GetDueAccountErasures(now int64) ([]*models.UserInfo, error)
+	GetAccountImages(email string) ([]string, error)
+	GetUserByCustomerID(customerID string) (*models.UserInfo, error)
 
 	// PUTs
 	UpsertUser(email string) error
 	UpdateUser(user models.UserInfo) error
+	UpdateSubscriptionState(email string, state models.SubscriptionState) error
+	RecordAICall(email, month string, limit int) error
+	RefundAICall(email, month string) error
+	InitStorageBytes(email string, total int64) error
+	AddStorageBytes(email string, delta int64) error
 	RestoreAutomaticallyDeletedStories(email string) error
 	ResetBlockOrder(storyID string, storyBlocks *models.StoryBlocks) error
 	WriteBlocks(storyID string, storyBlocks *models.StoryBlocks) error
+	PatchBlocks(storyID, chapterID string, patch *models.BlockPatch) error
 	WriteAssociations(email, storyOrSeriesID string, associations []*models.Association) error
 	UpdateAssociationPortraitEntryInDB(email, storyOrSeriesID, associationID, url string) error
+	WriteAssociationRelationships(email, storyOrSeriesID string, relationships []*models.AssociationRelationship) error
+	WriteAssociationType(email, storyOrSeriesID string, associationType models.AssociationType) error
+	WriteAssociationOverride(email string, override models.AssociationOverride) error
 	AddCustomerID(email, customerID *string) error
 	AddStripeData(email, subscriptionID, customerID *string) error
 	EditStory(email string, story models.Story) (models.Story, error)
 	EditSeries(email string, series models.Series) (models.Series, error)
 	EditChapter(storyID string, chapter models.Chapter) (models.Chapter, error)
 	RemoveStoryFromSeries(email, storyID string, series models.Series) (models.Series, error)
+	ImportAssociations(email, storyOrSeriesID string, associations []*models.Association, dryRun bool) (*models.AssociationImportReport, error)
+	ResolveComment(email, storyID, commentID string, resolved bool) error
+	TransferAssociations(email string, transfer models.AssociationTransfer) (*models.AssociationTransferResult, error)
+	RespondToInvitation(email, storyOrSeriesID string, accept bool) error
+	TransferOwnership(owner, storyOrSeriesID, newOwner string) error
 
 	// POSTs
 	CreateChapter(storyID string, chapter models.Chapter, email string) (models.Chapter, error)
 	CreateStory(email string, story models.Story, newSeriesTitle string) (storyID string, err error)
 	CreateUser(email string) error
+	CreateShareLink(email string, link models.ShareLink) (*models.ShareLink, error)
+	CreateComment(owner, email string, comment models.Comment) (*models.Comment, error)
+	CreateSharedComment(token, password string, comment models.Comment) (*models.Comment, error)
+	RecordChanges(storyOrSeriesID string, changes []*models.StoryChange) error
+	ClaimIdempotencyKey(record models.IdempotencyRecord) (*models.IdempotencyRecord, error)
+	SaveIdempotentResponse(record models.IdempotencyRecord) error
+	ClaimLiveRoom(chapterID, host string, lease time.Duration) error
+	InviteCollaborator(owner string, collaborator models.Collaborator) (*models.Collaborator, error)
+	SplitChapter(owner, storyID, chapterID, atKeyID, title string) (*models.ChapterRestructure, error)
+	MergeChapters(owner, storyID, chapterID, nextChapterID string) (*models.ChapterRestructure, error)
+	MoveChapter(owner, storyID, chapterID, targetStoryID string) (*models.ChapterRestructure, error)
+	RestoreFromTrash(email, itemType, id string) error
+	DuplicateStory(email, storyID string, duplicate models.StoryDuplicate) (*models.Story, error)
+	DuplicateSeries(email, seriesID string, duplicate models.StoryDuplicate) (*models.Series, error)
+	SaveStoryTemplate(email, storyID string, template models.StoryTemplate) (*models.StoryTemplate, error)
+	ApplyStoryTemplate(email string, story models.Story, template *models.StoryTemplate) ([]models.Chapter, error)
+	ImportAccount(email string, archive *models.AccountArchive) (*models.AccountImportReport, error)
+	RequestAccountErasure(email string) (*models.AccountErasure, error)
 
 	// DELETEs
 	DeleteChapterParagraphs(storyID string, storyBlocks *models.StoryBlocks) error
 	DeleteAssociations(email, storyID string, associations []*models.Association) error
-	DeleteChapters(storyID string, chapters []models.Chapter) error
+	DeleteAssociationRelationships(email, storyOrSeriesID string, relationships []*models.AssociationRelationship) error
+	DeleteAssociationType(email, storyOrSeriesID, typeName string) error
+	DeleteAssociationOverride(email, storyID, associationID string) error
+	DeleteShareLink(email, token string) error
+	DeleteComment(email, storyID, commentID string) error
+	RemoveCollaborator(owner, storyOrSeriesID, email string) error
+	DeleteChapters(email, storyID string, chapters []models.Chapter) error
+	ReleaseIdempotencyKey(id string) error
+	ReleaseLiveRoom(chapterID, host string) error
 	SoftDeleteStory(email, storyID string, isAutomated bool) error
 	hardDeleteStory(email, storyID string) error
 	DeleteSeries(email string, series models.Series) error
+	PurgeFromTrash(email, itemType, id string) error
+	DeleteStoryTemplate(email, templateID string) error
+	CancelAccountErasure(email string) (*models.AccountErasure, error)
+	EraseAccount(email string, erased map[string]int) (*models.AccountErasure, error)
 
 	// HELPERS
+	WithIdempotencyKey(key string) DaoInterface
 	WasStoryDeleted(email string, storyID string) (bool, error)
 	IsStoryInASeries(email string, storyID string) (string, error)
 	IsUserSubscribed(email string) (string, error)
@@ -0,0 +1,59 @@
+package daos
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+var ErrLiveRoomElsewhere = errors.New("chapter is open for live editing on another server")
+
+// ClaimLiveRoom makes host the one server sequencing a chapter's live edits until the lease runs
+// out. A host renews its own lease by claiming it again; a lease still held by another host gives
+// ErrLiveRoomElsewhere.
+func (d *DAO) ClaimLiveRoom(chapterID, host string, lease time.Duration) error {
+	now := time.Now()
+	_, err := d.DynamoClient.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName: aws.String("live_rooms" + GetTableSuffix()),
+		Item: map[string]types.AttributeValue{
+			"chapter_id": &types.AttributeValueMemberS{Value: chapterID},
+			"host":       &types.AttributeValueMemberS{Value: host},
+			"expires_at": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(lease).Unix(), 10)},
+		},
+		ConditionExpression:      aws.String("attribute_not_exists(chapter_id) OR #host = :host OR expires_at < :now"),
+		ExpressionAttributeNames: map[string]string{"#host": "host"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":host": &types.AttributeValueMemberS{Value: host},
+			":now":  &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+		},
+	})
+	if isConditionalCheckFailure(err) {
+		return ErrLiveRoomElsewhere
+	}
+	return err
+}
+
+// ReleaseLiveRoom gives up host's lease on a chapter once its last editor has left. A lease that has
+// since passed to another host is left alone.
+func (d *DAO) ReleaseLiveRoom(chapterID, host string) error {
+	_, err := d.DynamoClient.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String("live_rooms" + GetTableSuffix()),
+		Key: map[string]types.AttributeValue{
+			"chapter_id": &types.AttributeValueMemberS{Value: chapterID},
+		},
+		ConditionExpression:      aws.String("#host = :host"),
+		ExpressionAttributeNames: map[string]string{"#host": "host"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":host": &types.AttributeValueMemberS{Value: host},
+		},
+	})
+	if isConditionalCheckFailure(err) {
+		return nil
+	}
+	return err
+}
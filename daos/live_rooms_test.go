@@ -0,0 +1,88 @@
+package daos
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// fakeLiveRoomLease answers claims and releases the way the live_rooms condition expressions would
+type fakeLiveRoomLease struct {
+	host      string
+	expiresAt int64
+}
+
+func (l *fakeLiveRoomLease) put(ctx context.Context, input *dynamodb.PutItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	host := input.Item["host"].(*types.AttributeValueMemberS).Value
+	now, _ := strconv.ParseInt(input.ExpressionAttributeValues[":now"].(*types.AttributeValueMemberN).Value, 10, 64)
+	if l.host != "" && l.host != host && l.expiresAt >= now {
+		return nil, &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
+	}
+	l.host = host
+	l.expiresAt, _ = strconv.ParseInt(input.Item["expires_at"].(*types.AttributeValueMemberN).Value, 10, 64)
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (l *fakeLiveRoomLease) delete(ctx context.Context, input *dynamodb.DeleteItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	if l.host != input.ExpressionAttributeValues[":host"].(*types.AttributeValueMemberS).Value {
+		return nil, &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
+	}
+	l.host = ""
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func TestLiveRoomLease(t *testing.T) {
+	testCases := []struct {
+		name      string
+		held      *fakeLiveRoomLease
+		wantErr   error
+		wantHost  string
+		releaseBy string
+	}{
+		{name: "Unclaimed", wantHost: "host-a", releaseBy: "host-a"},
+		{name: "Renewed", held: &fakeLiveRoomLease{host: "host-a", expiresAt: time.Now().Add(time.Minute).Unix()}, wantHost: "host-a", releaseBy: "host-a"},
+		{name: "HeldElsewhere", held: &fakeLiveRoomLease{host: "host-b", expiresAt: time.Now().Add(time.Minute).Unix()}, wantErr: ErrLiveRoomElsewhere, wantHost: "host-b", releaseBy: "host-a"},
+		{name: "TakenOverAfterLapse", held: &fakeLiveRoomLease{host: "host-b", expiresAt: time.Now().Add(-time.Minute).Unix()}, wantHost: "host-a", releaseBy: "host-b"},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			mockDao := NewMockDAO()
+			mockClient := mockDao.DynamoClient.(*MockDynamoClient)
+			lease := tc.held
+			if lease == nil {
+				lease = &fakeLiveRoomLease{}
+			}
+			mockClient.MockPutItem = lease.put
+			mockClient.MockDeleteItem = lease.delete
+
+			if err := mockDao.ClaimLiveRoom("chapter1", "host-a", 30*time.Second); !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if lease.host != tc.wantHost {
+				t.Errorf("expected %s to hold the room, got %s", tc.wantHost, lease.host)
+			}
+			// releasing a lease that passed to another host leaves it with them
+			if err := mockDao.ReleaseLiveRoom("chapter1", tc.releaseBy); err != nil {
+				t.Fatal(err)
+			}
+			wantAfterRelease := ""
+			if tc.releaseBy != tc.wantHost {
+				wantAfterRelease = tc.wantHost
+			}
+			if lease.host != wantAfterRelease {
+				t.Errorf("expected %q to hold the room after release, got %q", wantAfterRelease, lease.host)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package daos
+
+import (
+	"RichDocter/models"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+var ErrInvalidRelationship = errors.New("invalid relationship")
+
+func (d *DAO) GetAssociationRelationships(email, storyOrSeriesID string) (relationships []*models.AssociationRelationship, err error) {
+	out, err := d.DynamoClient.Scan(context.TODO(), &dynamodb.ScanInput{
+		TableName:        aws.String("association_relationships" + GetTableSuffix()),
+		FilterExpression: aws.String("author=:eml AND story_or_series_id=:s AND attribute_not_exists(deleted_at)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":eml": &types.AttributeValueMemberS{Value: email},
+			":s":   &types.AttributeValueMemberS{Value: storyOrSeriesID},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	relationships = []*models.AssociationRelationship{}
+	if err = attributevalue.UnmarshalListOfMaps(out.Items, &relationships); err != nil {
+		return nil, err
+	}
+	return relationships, nil
+}
+
+func (d *DAO) GetRelationshipGraph(email, storyOrSeriesID string) (*models.RelationshipGraph, error) {
+	relationships, err := d.GetAssociationRelationships(email, storyOrSeriesID)
+	if err != nil {
+		return nil, err
+	}
+	out, err := d.DynamoClient.Scan(context.TODO(), &dynamodb.ScanInput{
+		TableName:        aws.String("associations" + GetTableSuffix()),
+		FilterExpression: aws.String("author=:eml AND story_or_series_id=:s AND attribute_not_exists(deleted_at)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":eml": &types.AttributeValueMemberS{Value: email},
+			":s":   &types.AttributeValueMemberS{Value: storyOrSeriesID},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	associations := []models.SimplifiedAssociation{}
+	if err = attributevalue.UnmarshalListOfMaps(out.Items, &associations); err != nil {
+		return nil, err
+	}
+	graph := models.RelationshipGraph{
+		Nodes: []models.RelationshipNode{},
+		Edges: []models.AssociationRelationship{},
+	}
+	known := make(map[string]bool, len(associations))
+	for _, assoc := range associations {
+		known[assoc.ID] = true
+		graph.Nodes = append(graph.Nodes, models.RelationshipNode{
+			ID:       assoc.ID,
+			Name:     assoc.Name,
+			Type:     assoc.Type,
+			Portrait: assoc.Portrait,
+		})
+	}
+	for _, rel := range relationships {
+		// skip edges pointing at associations which no longer exist
+		if !known[rel.SourceID] || !known[rel.TargetID] {
+			continue
+		}
+		graph.Edges = append(graph.Edges, *rel)
+	}
+	return &graph, nil
+}
+
+func (d *DAO) WriteAssociationRelationships(email, storyOrSeriesID string, relationships []*models.AssociationRelationship) (err error) {
+	if len(relationships) == 0 {
+		return fmt.Errorf("empty relationships array")
+	}
+	for _, rel := range relationships {
+		if rel.SourceID == "" || rel.TargetID == "" || rel.Type == "" {
+			return fmt.Errorf("%w: relationships require a source_id, target_id and relationship_type", ErrInvalidRelationship)
+		}
+		if rel.SourceID == rel.TargetID {
+			return fmt.Errorf("%w: an association cannot be related to itself", ErrInvalidRelationship)
+		}
+	}
+	// both ends must be live associations of the story or series, not ones that are trashed or
+	// belong somewhere else
+	live := map[string]bool{}
+	if err = d.loadAssociationIDs(email, storyOrSeriesID, live); err != nil {
+		return err
+	}
+	for _, rel := range relationships {
+		for _, id := range []string{rel.SourceID, rel.TargetID} {
+			if !live[id] {
+				return fmt.Errorf("%w: association %s not found", ErrInvalidRelationship, id)
+			}
+		}
+	}
+	batches := make([][]*models.AssociationRelationship, 0, (len(relationships)+(d.writeBatchSize-1))/d.writeBatchSize)
+	for i := 0; i < len(relationships); i += d.writeBatchSize {
+		end := i + d.writeBatchSize
+		if end > len(relationships) {
+			end = len(relationships)
+		}
+		batches = append(batches, relationships[i:end])
+	}
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	for _, batch := range batches {
+		writeItemsInput := &dynamodb.TransactWriteItemsInput{
+			ClientRequestToken: nil,
+			TransactItems:      make([]types.TransactWriteItem, len(batch)),
+		}
+		for i, item := range batch {
+			item.StoryOrSeriesID = storyOrSeriesID
+			key := map[string]types.AttributeValue{
+				"relationship_id":    &types.AttributeValueMemberS{Value: item.ID},
+				"story_or_series_id": &types.AttributeValueMemberS{Value: storyOrSeriesID},
+			}
+			writeItemsInput.TransactItems[i] = types.TransactWriteItem{
+				Update: &types.Update{
+					TableName:           aws.String("association_relationships" + GetTableSuffix()),
+					Key:                 key,
+					UpdateExpression:    aws.String("set author=:eml, source_id=:src, target_id=:tgt, relationship_type=:rt, directional=:dir, description=:desc, created_at=if_not_exists(created_at,:t), last_updated=:t"),
+					ConditionExpression: aws.String("attribute_not_exists(author) OR author=:eml"),
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":eml":  &types.AttributeValueMemberS{Value: email},
+						":src":  &types.AttributeValueMemberS{Value: item.SourceID},
+						":tgt":  &types.AttributeValueMemberS{Value: item.TargetID},
+						":rt":   &types.AttributeValueMemberS{Value: item.Type},
+						":dir":  &types.AttributeValueMemberBOOL{Value: item.Directional},
+						":desc": &types.AttributeValueMemberS{Value: item.Description},
+						":t":    &types.AttributeValueMemberN{Value: now},
+					},
+				},
+			}
+		}
+		err, awsErr := d.awsWriteTransaction(writeItemsInput)
+		if err != nil {
+			return err
+		}
+		if !awsErr.IsNil() {
+			return fmt.Errorf("--AWSERROR-- Code:%s, Type: %s, Message: %s", awsErr.Code, awsErr.ErrorType, awsErr.Text)
+		}
+	}
+	return
+}
+
+func (d *DAO) DeleteAssociationRelationships(email, storyOrSeriesID string, relationships []*models.AssociationRelationship) (err error) {
+	if len(relationships) == 0 {
+		return fmt.Errorf("no relationships provided")
+	}
+	batches := make([][]*models.AssociationRelationship, 0, (len(relationships)+(d.writeBatchSize-1))/d.writeBatchSize)
+	for i := 0; i < len(relationships); i += d.writeBatchSize {
+		end := i + d.writeBatchSize
+		if end > len(relationships) {
+			end = len(relationships)
+		}
+		batches = append(batches, relationships[i:end])
+	}
+
+	for _, batch := range batches {
+		writeItemsInput := &dynamodb.TransactWriteItemsInput{
+			ClientRequestToken: nil,
+			TransactItems:      make([]types.TransactWriteItem, len(batch)),
+		}
+		for i, item := range batch {
+			key := map[string]types.AttributeValue{
+				"relationship_id":    &types.AttributeValueMemberS{Value: item.ID},
+				"story_or_series_id": &types.AttributeValueMemberS{Value: storyOrSeriesID},
+			}
+			writeItemsInput.TransactItems[i] = types.TransactWriteItem{
+				Delete: &types.Delete{
+					Key:                 key,
+					TableName:           aws.String("association_relationships" + GetTableSuffix()),
+					ConditionExpression: aws.String("author=:eml"),
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":eml": &types.AttributeValueMemberS{Value: email},
+					},
+				},
+			}
+		}
+		err, awsErr := d.awsWriteTransaction(writeItemsInput)
+		if err != nil {
+			return err
+		}
+		if !awsErr.IsNil() {
+			return fmt.Errorf("--AWSERROR-- Code:%s, Type: %s, Message: %s", awsErr.Code, awsErr.ErrorType, awsErr.Text)
+		}
+	}
+	return
+}
@@ -0,0 +1,106 @@
+package daos
+
+import (
+	"RichDocter/models"
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestWriteAssociationRelationships(t *testing.T) {
+	mockDao := NewMockDAO()
+	mockClient := mockDao.DynamoClient.(*MockDynamoClient)
+	// the story's live associations, assoc5 having been trashed
+	mockClient.MockScan = func(ctx context.Context, input *dynamodb.ScanInput, opts ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+		if *input.TableName != "associations"+GetTableSuffix() {
+			return &dynamodb.ScanOutput{}, nil
+		}
+		items := []map[string]types.AttributeValue{}
+		for _, id := range []string{"assoc1", "assoc2", "assoc3", "assoc4"} {
+			items = append(items, map[string]types.AttributeValue{"association_id": &types.AttributeValueMemberS{Value: id}})
+		}
+		return &dynamodb.ScanOutput{Items: items}, nil
+	}
+	testCases := []struct {
+		name                string
+		relationships       []*models.AssociationRelationship
+		wantErr             bool
+		expectedErrContains string
+	}{
+		{
+			name:                "EmptyRelationships",
+			relationships:       []*models.AssociationRelationship{},
+			wantErr:             true,
+			expectedErrContains: "empty relationships array",
+		},
+		{
+			name: "MissingTarget",
+			relationships: []*models.AssociationRelationship{
+				{ID: "rel1", SourceID: "assoc1", Type: "sibling"},
+			},
+			wantErr:             true,
+			expectedErrContains: "require a source_id, target_id and relationship_type",
+		},
+		{
+			name: "SelfRelationship",
+			relationships: []*models.AssociationRelationship{
+				{ID: "rel1", SourceID: "assoc1", TargetID: "assoc1", Type: "rival"},
+			},
+			wantErr:             true,
+			expectedErrContains: "cannot be related to itself",
+		},
+		{
+			name: "UnknownTarget",
+			relationships: []*models.AssociationRelationship{
+				{ID: "rel1", SourceID: "assoc1", TargetID: "other-story-assoc", Type: "rival"},
+			},
+			wantErr:             true,
+			expectedErrContains: "association other-story-assoc not found",
+		},
+		{
+			name: "TrashedSource",
+			relationships: []*models.AssociationRelationship{
+				{ID: "rel1", SourceID: "assoc1", TargetID: "assoc2", Type: "mentor"},
+				{ID: "rel2", SourceID: "assoc5", TargetID: "assoc2", Type: "rival"},
+			},
+			wantErr:             true,
+			expectedErrContains: "association assoc5 not found",
+		},
+		{
+			name: "SuccessfulWrite",
+			relationships: []*models.AssociationRelationship{
+				{ID: "rel1", SourceID: "assoc1", TargetID: "assoc2", Type: "mentor", Directional: true},
+				{ID: "rel2", SourceID: "assoc1", TargetID: "assoc3", Type: "lives in", Directional: true},
+				{ID: "rel3", SourceID: "assoc2", TargetID: "assoc4", Type: "sibling"},
+			},
+			wantErr: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+			err := mockDao.WriteAssociationRelationships("author@example.com", "story1", tc.relationships)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("Expected error but got nil")
+				} else if !contains(err.Error(), tc.expectedErrContains) {
+					t.Errorf("Error %q does not contain %q", err.Error(), tc.expectedErrContains)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			for _, rel := range tc.relationships {
+				if rel.StoryOrSeriesID != "story1" {
+					t.Errorf("expected story_or_series_id to be stamped, got %q", rel.StoryOrSeriesID)
+				}
+			}
+		})
+	}
+}
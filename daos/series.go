@@ -133,8 +133,16 @@ func (d *DAO) EditSeries(email string, series models.Series) (updatedSeries mode
 		"description": &types.AttributeValueMemberS{Value: series.Description},
 		"image_url":   &types.AttributeValueMemberS{Value: series.ImageURL},
 		"modified_at": &types.AttributeValueMemberN{Value: modifiedAtStr},
+		"version":     &types.AttributeValueMemberN{Value: strconv.FormatInt(series.Version+1, 10)},
 	}
 	updatedSeries = series
+	storedSeries, err := d.GetSeriesByID(email, series.ID)
+	if err != nil {
+		return updatedSeries, err
+	}
+	if storedSeries.Version != series.Version {
+		return updatedSeries, ErrVersionConflict
+	}
 
 	for _, story := range series.Stories {
 		// all we can change is the placement of stories
@@ -160,14 +168,22 @@ func (d *DAO) EditSeries(email string, series models.Series) (updatedSeries mode
 		}
 	}
 
+	condition, values := versionCondition(series.Version)
 	seriesUpdateInput := &dynamodb.PutItemInput{
-		TableName: aws.String("series" + GetTableSuffix()),
-		Item:      item,
+		TableName:                 aws.String("series" + GetTableSuffix()),
+		Item:                      item,
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeNames:  map[string]string{"#ver": "version"},
+		ExpressionAttributeValues: nilIfEmpty(values),
 	}
 	_, err = d.DynamoClient.PutItem(context.Background(), seriesUpdateInput)
 	if err != nil {
+		if isConditionalCheckFailure(err) {
+			return updatedSeries, ErrVersionConflict
+		}
 		return updatedSeries, err
 	}
+	updatedSeries.Version = series.Version + 1
 	return updatedSeries, nil
 }
 
@@ -190,6 +206,9 @@ func (d *DAO) RemoveStoryFromSeries(email, storyID string, series models.Series)
 	if err != nil {
 		return updatedSeries, err
 	}
+	if err = d.syncAssociationsOnSeriesChange(email, storyID, series.ID, ""); err != nil {
+		return updatedSeries, err
+	}
 	updatedSeries = series
 	var newStories []*models.Story
 	for _, seriesStory := range series.Stories {
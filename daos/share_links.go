@@ -0,0 +1,218 @@
+package daos
+
+import (
+	"RichDocter/models"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrShareLinkNotFound    = errors.New("share link not found")
+	ErrShareLinkExpired     = errors.New("share link has expired")
+	ErrShareLinkBadPassword = errors.New("share link password is incorrect")
+	// ErrShareLinkCommentsDisabled is returned when a reader tries to comment through a read-only link
+	ErrShareLinkCommentsDisabled = errors.New("comments are not enabled for this share link")
+)
+
+func (d *DAO) CreateShareLink(email string, link models.ShareLink) (*models.ShareLink, error) {
+	story, err := d.GetStoryByID(email, link.StoryID)
+	if err != nil {
+		return nil, err
+	}
+	for _, chapterID := range link.ChapterIDs {
+		found := false
+		for _, chapter := range story.Chapters {
+			if chapter.ID == chapterID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("chapter %s does not belong to story %s", chapterID, link.StoryID)
+		}
+	}
+	now := time.Now().Unix()
+	if link.ExpiresAt != 0 && link.ExpiresAt <= now {
+		return nil, fmt.Errorf("expires_at must be in the future")
+	}
+	if link.Token, err = generateShareToken(); err != nil {
+		return nil, err
+	}
+	if link.ChapterIDs == nil {
+		link.ChapterIDs = []string{}
+	}
+	link.Author = email
+	link.CreatedAt = now
+	link.PasswordHash = ""
+	if link.Password != "" {
+		if link.PasswordHash, err = hashSharePassword(link.Password); err != nil {
+			return nil, err
+		}
+	}
+	link.Password = ""
+	link.HasPassword = link.PasswordHash != ""
+
+	item, err := attributevalue.MarshalMap(link)
+	if err != nil {
+		return nil, err
+	}
+	_, err = d.DynamoClient.PutItem(context.Background(), &dynamodb.PutItemInput{
+		TableName:           aws.String("share_links" + GetTableSuffix()),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(share_token)"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+func (d *DAO) GetShareLinksByStory(email, storyID string) ([]*models.ShareLink, error) {
+	out, err := d.DynamoClient.Scan(context.TODO(), &dynamodb.ScanInput{
+		TableName:        aws.String("share_links" + GetTableSuffix()),
+		FilterExpression: aws.String("author=:eml AND story_id=:s"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":eml": &types.AttributeValueMemberS{Value: email},
+			":s":   &types.AttributeValueMemberS{Value: storyID},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	links := []*models.ShareLink{}
+	if err = attributevalue.UnmarshalListOfMaps(out.Items, &links); err != nil {
+		return nil, err
+	}
+	for _, link := range links {
+		link.HasPassword = link.PasswordHash != ""
+	}
+	return links, nil
+}
+
+func (d *DAO) DeleteShareLink(email, token string) error {
+	_, err := d.DynamoClient.DeleteItem(context.Background(), &dynamodb.DeleteItemInput{
+		TableName:           aws.String("share_links" + GetTableSuffix()),
+		Key:                 map[string]types.AttributeValue{"share_token": &types.AttributeValueMemberS{Value: token}},
+		ConditionExpression: aws.String("author=:eml"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":eml": &types.AttributeValueMemberS{Value: email},
+		},
+	})
+	return err
+}
+
+// GetSharedStory resolves a share token to the story content it grants access to. It serves
+// unauthenticated readers, so problems with the link itself are reported as sentinel errors.
+func (d *DAO) GetSharedStory(token, password string) (*models.SharedStory, error) {
+	link, err := d.resolveShareLink(token, password)
+	if err != nil {
+		return nil, err
+	}
+	story, err := d.GetStoryByID(link.Author, link.StoryID)
+	if err != nil {
+		// the story was deleted after the link was handed out
+		return nil, ErrShareLinkNotFound
+	}
+	shared := &models.SharedStory{
+		Title:       story.Title,
+		Description: story.Description,
+		ImageURL:    story.ImageURL,
+		Chapters:    []models.SharedChapter{},
+	}
+	for _, chapter := range story.Chapters {
+		if !linkSharesChapter(link, chapter.ID) {
+			continue
+		}
+		sharedChapter := models.SharedChapter{
+			ID:     chapter.ID,
+			Title:  chapter.Title,
+			Place:  chapter.Place,
+			Chunks: []json.RawMessage{},
+		}
+		blocks, err := d.GetChapterParagraphs(story.ID, chapter.ID, nil)
+		if err != nil {
+			return nil, err
+		}
+		if blocks != nil {
+			for _, item := range blocks.Items {
+				if chunk, ok := item["chunk"].(*types.AttributeValueMemberS); ok {
+					sharedChapter.Chunks = append(sharedChapter.Chunks, json.RawMessage(chunk.Value))
+				}
+			}
+		}
+		shared.Chapters = append(shared.Chapters, sharedChapter)
+	}
+	return shared, nil
+}
+
+// resolveShareLink loads a share link and checks it is still valid for the supplied password
+func (d *DAO) resolveShareLink(token, password string) (*models.ShareLink, error) {
+	out, err := d.DynamoClient.Query(context.TODO(), &dynamodb.QueryInput{
+		TableName:              aws.String("share_links" + GetTableSuffix()),
+		KeyConditionExpression: aws.String("share_token=:t"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":t": &types.AttributeValueMemberS{Value: token},
+		},
+		Limit: aws.Int32(1),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(out.Items) == 0 {
+		return nil, ErrShareLinkNotFound
+	}
+	link := models.ShareLink{}
+	if err = attributevalue.UnmarshalMap(out.Items[0], &link); err != nil {
+		return nil, err
+	}
+	if link.ExpiresAt != 0 && time.Now().Unix() >= link.ExpiresAt {
+		return nil, ErrShareLinkExpired
+	}
+	if link.PasswordHash != "" && !checkSharePassword(link.PasswordHash, password) {
+		return nil, ErrShareLinkBadPassword
+	}
+	return &link, nil
+}
+
+// linkSharesChapter reports whether the link grants access to the chapter
+func linkSharesChapter(link *models.ShareLink, chapterID string) bool {
+	return len(link.ChapterIDs) == 0 || containsString(link.ChapterIDs, chapterID)
+}
+
+func generateShareToken() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// share link passwords guard unauthenticated routes, so they are hashed with bcrypt to keep guessing
+// them from a leaked hash slow
+const maxSharePasswordBytes = 72
+
+func hashSharePassword(password string) (string, error) {
+	if len(password) > maxSharePasswordBytes {
+		return "", fmt.Errorf("share link passwords must be at most %d bytes", maxSharePasswordBytes)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func checkSharePassword(stored, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil
+}
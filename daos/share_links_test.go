@@ -0,0 +1,47 @@
+package daos
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSharePasswordHashing(t *testing.T) {
+	testCases := []struct {
+		name      string
+		password  string
+		attempt   string
+		wantMatch bool
+	}{
+		{name: "CorrectPassword", password: "beta-readers", attempt: "beta-readers", wantMatch: true},
+		{name: "WrongPassword", password: "beta-readers", attempt: "Beta-readers", wantMatch: false},
+		{name: "EmptyAttempt", password: "beta-readers", attempt: "", wantMatch: false},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			hash, err := hashSharePassword(tc.password)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if contains(hash, tc.password) {
+				t.Errorf("hash %q contains the plain password", hash)
+			}
+			if !strings.HasPrefix(hash, "$2") {
+				t.Errorf("expected a bcrypt hash, got %q", hash)
+			}
+			if got := checkSharePassword(hash, tc.attempt); got != tc.wantMatch {
+				t.Errorf("expected match=%v, got %v", tc.wantMatch, got)
+			}
+		})
+	}
+
+	t.Run("TooLong", func(t *testing.T) {
+		if _, err := hashSharePassword(strings.Repeat("a", maxSharePasswordBytes+1)); err == nil {
+			t.Errorf("expected passwords bcrypt would truncate to be refused")
+		}
+	})
+}
@@ -141,20 +141,13 @@ func (d *DAO) ResetBlockOrder(storyID string, storyBlocks *models.StoryBlocks) (
 			ClientRequestToken: nil,
 			TransactItems:      make([]types.TransactWriteItem, len(batch)),
 		}
-		for i, item := range batch {
-			// Create a key for the item.
-			key := map[string]types.AttributeValue{
-				"key_id": &types.AttributeValueMemberS{Value: item.KeyID},
+		for i := range batch {
+			item := &batch[i]
+			if err = rankBlock(item); err != nil {
+				return err
 			}
 			// Create an update input for the item.
-			updateInput := &types.Update{
-				TableName:        aws.String(tableName),
-				Key:              key,
-				UpdateExpression: aws.String("set place=:p"),
-				ExpressionAttributeValues: map[string]types.AttributeValue{
-					":p": &types.AttributeValueMemberN{Value: item.Place},
-				},
-			}
+			updateInput := blockRankUpdate(tableName, *item)
 
 			// Create a transaction write item for the update operation.
 			writeItem := types.TransactWriteItem{
@@ -191,26 +184,13 @@ func (d *DAO) WriteBlocks(storyID string, storyBlocks *models.StoryBlocks) (err
 			ClientRequestToken: nil,
 			TransactItems:      make([]types.TransactWriteItem, len(batch)),
 		}
-		for i, item := range batch {
-			// Create a key for the item.
-			key := map[string]types.AttributeValue{
-				"key_id": &types.AttributeValueMemberS{Value: item.KeyID},
-			}
-			// Create an update input for the item.
-			updateInput := &types.Update{
-				TableName:        aws.String(tableName),
-				Key:              key,
-				UpdateExpression: aws.String("set chunk=:c, story_id=:s, place=:p"),
-				ExpressionAttributeValues: map[string]types.AttributeValue{
-					":c": &types.AttributeValueMemberS{Value: string(item.Chunk)},
-					":s": &types.AttributeValueMemberS{Value: storyID},
-					":p": &types.AttributeValueMemberN{Value: item.Place},
-				},
+		for i := range batch {
+			if err = rankBlock(&batch[i]); err != nil {
+				return err
 			}
-
 			// Create a transaction write item for the update operation.
 			writeItem := types.TransactWriteItem{
-				Update: updateInput,
+				Update: blockUpdate(tableName, storyID, batch[i]),
 			}
 
 			// Add the transaction write item to the list of transaction write items.
@@ -220,13 +200,73 @@ func (d *DAO) WriteBlocks(storyID string, storyBlocks *models.StoryBlocks) (err
 		if err != nil {
 			return err
 		}
+		if awsErr.ErrorType == "ConditionalCheckFailed" {
+			return ErrVersionConflict
+		}
 		if !awsErr.IsNil() {
 			return fmt.Errorf("--AWSERROR-- Code:%s, Type: %s, Message: %s", awsErr.Code, awsErr.ErrorType, awsErr.Text)
 		}
+		for i := range batch {
+			batch[i].Version++
+		}
 	}
 	return
 }
 
+// blockUpdate writes a block's content and rank, only over the version of the block the client last saw
+func blockUpdate(tableName, storyID string, block models.StoryBlock) *types.Update {
+	condition, values := versionCondition(block.Version)
+	values[":c"] = &types.AttributeValueMemberS{Value: string(block.Chunk)}
+	values[":s"] = &types.AttributeValueMemberS{Value: storyID}
+	values[":r"] = &types.AttributeValueMemberS{Value: block.Rank}
+	values[":p"] = &types.AttributeValueMemberN{Value: models.PlaceFromRank(block.Rank)}
+	values[":nv"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(block.Version+1, 10)}
+	// the place follows the rank so tables without the rank index still read back in order
+	expression := "set chunk=:c, story_id=:s, #rank=:r, place=:p, #ver=:nv"
+	return &types.Update{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"key_id": &types.AttributeValueMemberS{Value: block.KeyID},
+		},
+		UpdateExpression:          aws.String(expression),
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeNames:  map[string]string{"#ver": "version", "#rank": "rank"},
+		ExpressionAttributeValues: values,
+	}
+}
+
+// blockRankUpdate moves a block without touching its content or version
+func blockRankUpdate(tableName string, block models.StoryBlock) *types.Update {
+	return &types.Update{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"key_id": &types.AttributeValueMemberS{Value: block.KeyID},
+		},
+		UpdateExpression:         aws.String("set #rank=:r, place=:p"),
+		ExpressionAttributeNames: map[string]string{"#rank": "rank"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":r": &types.AttributeValueMemberS{Value: block.Rank},
+			":p": &types.AttributeValueMemberN{Value: models.PlaceFromRank(block.Rank)},
+		},
+	}
+}
+
+// rankBlock checks a block's rank, working it out from its place for clients that only send places
+func rankBlock(block *models.StoryBlock) error {
+	if block.Rank == "" {
+		rank, err := models.RankFromPlace(block.Place)
+		if err != nil {
+			return fmt.Errorf("%w: block %s: %v", ErrInvalidBlockRank, block.KeyID, err)
+		}
+		block.Rank = rank
+		return nil
+	}
+	if !models.ValidRank(block.Rank) {
+		return fmt.Errorf("%w: block %s has rank %q", ErrInvalidBlockRank, block.KeyID, block.Rank)
+	}
+	return nil
+}
+
 func (d *DAO) EditStory(email string, story models.Story) (updatedStory models.Story, err error) {
 	modifiedAtStr := strconv.FormatInt(time.Now().Unix(), 10)
 	item := map[string]types.AttributeValue{
@@ -236,6 +276,7 @@ func (d *DAO) EditStory(email string, story models.Story) (updatedStory models.S
 		"description": &types.AttributeValueMemberS{Value: story.Description},
 		"image_url":   &types.AttributeValueMemberS{Value: story.ImageURL},
 		"modified_at": &types.AttributeValueMemberN{Value: modifiedAtStr},
+		"version":     &types.AttributeValueMemberN{Value: strconv.FormatInt(story.Version+1, 10)},
 	}
 	if story.SeriesID != "" {
 		intPlace := strconv.Itoa(story.Place)
@@ -247,6 +288,10 @@ func (d *DAO) EditStory(email string, story models.Story) (updatedStory models.S
 	if err != nil {
 		return updatedStory, err
 	}
+	// catch a stale edit before any series changes are made on its behalf
+	if storedStory.Version != story.Version {
+		return updatedStory, ErrVersionConflict
+	}
 	if story.SeriesID != storedStory.SeriesID {
 		// a change in series
 		if story.SeriesID != "" {
@@ -335,12 +380,23 @@ func (d *DAO) EditStory(email string, story models.Story) (updatedStory models.S
 		}
 	}
 
+	condition, values := versionCondition(story.Version)
 	storyUpdateInput := &dynamodb.PutItemInput{
-		TableName: aws.String("stories" + GetTableSuffix()),
-		Item:      item,
+		TableName:                 aws.String("stories" + GetTableSuffix()),
+		Item:                      item,
+		ConditionExpression:       aws.String(condition),
+		ExpressionAttributeNames:  map[string]string{"#ver": "version"},
+		ExpressionAttributeValues: nilIfEmpty(values),
 	}
 	_, err = d.DynamoClient.PutItem(context.Background(), storyUpdateInput)
 	if err != nil {
+		if isConditionalCheckFailure(err) {
+			return updatedStory, ErrVersionConflict
+		}
+		return updatedStory, err
+	}
+	updatedStory.Version = story.Version + 1
+	if err = d.syncAssociationsOnSeriesChange(email, story.ID, storedStory.SeriesID, updatedStory.SeriesID); err != nil {
 		return updatedStory, err
 	}
 	return updatedStory, nil
@@ -0,0 +1,289 @@
+package daos
+
+import (
+	"RichDocter/models"
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+)
+
+// ExportAccount gathers every series and story the user owns, with chapters, blocks, associations,
+// association types, relationships and volume overrides. Images are left for the caller to fetch.
+func (d *DAO) ExportAccount(email string) (*models.AccountArchive, error) {
+	archive := &models.AccountArchive{
+		Manifest: models.AccountArchiveManifest{
+			Version:    models.AccountArchiveVersion,
+			ExportedAt: time.Now().Unix(),
+			Email:      email,
+		},
+		Series:  []*models.ArchivedSeries{},
+		Stories: []*models.ArchivedStory{},
+		Images:  map[string][]byte{},
+	}
+
+	allSeries, err := d.GetAllSeriesWithStories(email, false)
+	if err != nil {
+		return nil, err
+	}
+	places := make(map[string]int)
+	for _, series := range allSeries {
+		for i, volume := range series.Stories {
+			places[volume.ID] = i + 1
+		}
+		series.Stories = nil
+		archived := &models.ArchivedSeries{Series: series}
+		if archived.Associations, archived.AssociationTypes, archived.Relationships, err = d.exportAssociations(email, series.ID); err != nil {
+			return nil, err
+		}
+		archive.Series = append(archive.Series, archived)
+	}
+
+	stories, err := d.GetAllStories(email)
+	if err != nil {
+		return nil, err
+	}
+	for _, story := range stories {
+		archived := &models.ArchivedStory{
+			Story:  *story,
+			Blocks: make(map[string][]models.StoryBlock, len(story.Chapters)),
+		}
+		if story.SeriesID != "" {
+			archived.Story.Place = places[story.ID]
+			if archived.Overrides, err = d.getStoryAssociationOverrides(email, story.ID); err != nil {
+				return nil, err
+			}
+		} else if archived.Associations, archived.AssociationTypes, archived.Relationships, err = d.exportAssociations(email, story.ID); err != nil {
+			return nil, err
+		}
+		for _, chapter := range story.Chapters {
+			items, err := d.chapterBlockItems(story.ID, chapter.ID)
+			if err != nil {
+				return nil, err
+			}
+			blocks := make([]models.StoryBlock, 0, len(items))
+			for _, item := range items {
+				block, err := archivedBlock(item)
+				if err != nil {
+					return nil, err
+				}
+				blocks = append(blocks, block)
+			}
+			sort.Slice(blocks, func(i, j int) bool {
+				return blocks[i].Rank < blocks[j].Rank
+			})
+			archived.Blocks[chapter.ID] = blocks
+		}
+		archive.Stories = append(archive.Stories, archived)
+	}
+	return archive, nil
+}
+
+// ImportAccount recreates an exported account under the user, giving everything new ids so an
+// archive can be imported alongside what the user already has, even more than once
+func (d *DAO) ImportAccount(email string, archive *models.AccountArchive) (*models.AccountImportReport, error) {
+	report := &models.AccountImportReport{
+		Series:  map[string]string{},
+		Stories: map[string]string{},
+	}
+	associationIDs := make(map[string]map[string]string)
+	for _, archived := range archive.Series {
+		series := archived.Series
+		series.ID = uuid.New().String()
+		if err := d.putSeriesRow(email, series); err != nil {
+			return nil, err
+		}
+		idMap, err := d.importAssociations(email, series.ID, archived.Associations, archived.AssociationTypes, archived.Relationships)
+		if err != nil {
+			return nil, err
+		}
+		report.Series[archived.Series.ID] = series.ID
+		associationIDs[archived.Series.ID] = idMap
+		report.Associations += len(idMap)
+	}
+
+	// volumes go in by their place, renumbered in case the archive skips any
+	stories := append([]*models.ArchivedStory{}, archive.Stories...)
+	sort.SliceStable(stories, func(i, j int) bool {
+		return stories[i].Story.Place < stories[j].Story.Place
+	})
+	volumes := make(map[string]int)
+	for _, archived := range stories {
+		story := archived.Story
+		story.ID = uuid.New().String()
+		story.SeriesID = report.Series[archived.Story.SeriesID]
+		if story.SeriesID != "" {
+			volumes[story.SeriesID]++
+			story.Place = volumes[story.SeriesID]
+		}
+		if err := d.putStoryRow(email, story); err != nil {
+			return nil, err
+		}
+		report.Stories[archived.Story.ID] = story.ID
+
+		for _, chapter := range archived.Story.Chapters {
+			newChapter := models.Chapter{
+				ID:      uuid.New().String(),
+				StoryID: story.ID,
+				Title:   chapter.Title,
+				Place:   chapter.Place,
+			}
+			if _, err := d.CreateChapter(story.ID, newChapter, email); err != nil {
+				return nil, err
+			}
+			report.Chapters++
+			blocks := archived.Blocks[chapter.ID]
+			if len(blocks) == 0 {
+				continue
+			}
+			for i := range blocks {
+				blocks[i].Version = 0
+			}
+			// the chapter's block table has only just been created
+			if err := d.waitForBlockTable(story.ID + "_" + newChapter.ID + "_blocks" + GetTableSuffix()); err != nil {
+				return nil, err
+			}
+			if err := d.WriteBlocks(story.ID, &models.StoryBlocks{StoryID: story.ID, ChapterID: newChapter.ID, Blocks: blocks}); err != nil {
+				return nil, err
+			}
+			report.Blocks += len(blocks)
+		}
+
+		if story.SeriesID == "" {
+			idMap, err := d.importAssociations(email, story.ID, archived.Associations, archived.AssociationTypes, archived.Relationships)
+			if err != nil {
+				return nil, err
+			}
+			report.Associations += len(idMap)
+			continue
+		}
+		idMap := associationIDs[archived.Story.SeriesID]
+		for _, override := range archived.Overrides {
+			associationID, ok := idMap[override.AssociationID]
+			if !ok {
+				continue
+			}
+			imported := *override
+			imported.AssociationID = associationID
+			imported.StoryID = story.ID
+			imported.SeriesID = story.SeriesID
+			remapReferenceFields(imported.CustomFields, idMap)
+			if err := d.WriteAssociationOverride(email, imported); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return report, nil
+}
+
+func (d *DAO) exportAssociations(email, storyOrSeriesID string) ([]*models.Association, []*models.AssociationType, []*models.AssociationRelationship, error) {
+	associations, err := d.GetAllAssociations(email, storyOrSeriesID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	associationTypes, err := d.GetAssociationTypes(email, storyOrSeriesID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	relationships, err := d.GetAssociationRelationships(email, storyOrSeriesID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if relationships == nil {
+		relationships = []*models.AssociationRelationship{}
+	}
+	return associations, associationTypes, relationships, nil
+}
+
+// importAssociations writes archived associations, their types and relationships to a story or
+// series under new ids, returning the archived id each one was given
+func (d *DAO) importAssociations(email, storyOrSeriesID string, associations []*models.Association, associationTypes []*models.AssociationType, relationships []*models.AssociationRelationship) (map[string]string, error) {
+	// types first, so the associations validate against them
+	for _, associationType := range associationTypes {
+		if associationType.BuiltIn && len(associationType.Fields) == 0 && associationType.DefaultPortrait == "" {
+			continue
+		}
+		if err := d.WriteAssociationType(email, storyOrSeriesID, *associationType); err != nil {
+			return nil, err
+		}
+	}
+	writes, idMap, _ := planAssociationTransfer(associations, nil, TRANSFER_MODE_COPY, CONFLICT_MERGE)
+	if len(writes) == 0 {
+		return idMap, nil
+	}
+	for _, assoc := range writes {
+		remapReferenceFields(assoc.Details.CustomFields, idMap)
+	}
+	if err := d.WriteAssociations(email, storyOrSeriesID, writes); err != nil {
+		return nil, err
+	}
+	carried := []*models.AssociationRelationship{}
+	for _, rel := range relationships {
+		source, srcOK := idMap[rel.SourceID]
+		target, tgtOK := idMap[rel.TargetID]
+		if !srcOK || !tgtOK || source == target {
+			continue
+		}
+		imported := *rel
+		imported.ID = uuid.New().String()
+		imported.SourceID = source
+		imported.TargetID = target
+		carried = append(carried, &imported)
+	}
+	if len(carried) > 0 {
+		if err := d.WriteAssociationRelationships(email, storyOrSeriesID, carried); err != nil {
+			return nil, err
+		}
+	}
+	return idMap, nil
+}
+
+func (d *DAO) getStoryAssociationOverrides(email, storyID string) ([]*models.AssociationOverride, error) {
+	out, err := d.DynamoClient.Scan(context.TODO(), &dynamodb.ScanInput{
+		TableName:        aws.String("association_overrides" + GetTableSuffix()),
+		FilterExpression: aws.String("author=:eml AND story_id=:sid"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":eml": &types.AttributeValueMemberS{Value: email},
+			":sid": &types.AttributeValueMemberS{Value: storyID},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	overrides := []*models.AssociationOverride{}
+	if err = attributevalue.UnmarshalListOfMaps(out.Items, &overrides); err != nil {
+		return nil, err
+	}
+	return overrides, nil
+}
+
+// archivedBlock reads a stored block as it goes into an archive, ranked even if its table hasn't been migrated
+func archivedBlock(item map[string]types.AttributeValue) (models.StoryBlock, error) {
+	block := models.StoryBlock{}
+	if keyID, ok := item["key_id"].(*types.AttributeValueMemberS); ok {
+		block.KeyID = keyID.Value
+	}
+	if chunk, ok := item["chunk"].(*types.AttributeValueMemberS); ok {
+		block.Chunk = []byte(chunk.Value)
+		// keep anything that isn't JSON as a string rather than failing the whole export
+		if !json.Valid(block.Chunk) {
+			block.Chunk, _ = json.Marshal(chunk.Value)
+		}
+	}
+	if version, ok := item["version"].(*types.AttributeValueMemberN); ok {
+		block.Version, _ = strconv.ParseInt(version.Value, 10, 64)
+	}
+	rank, err := itemRank(item)
+	if err != nil {
+		return block, err
+	}
+	block.Rank = rank
+	return block, nil
+}
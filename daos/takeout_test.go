@@ -0,0 +1,197 @@
+package daos
+
+import (
+	"RichDocter/models"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestImportAccount(t *testing.T) {
+	archive := func() *models.AccountArchive {
+		return &models.AccountArchive{
+			Series: []*models.ArchivedSeries{{
+				Series: models.Series{ID: "series1", Title: "Saga"},
+				Associations: []*models.Association{
+					{ID: "a1", Name: "Hero", Type: "character"},
+					{ID: "a2", Name: "Villain", Type: "character"},
+				},
+				Relationships: []*models.AssociationRelationship{
+					{ID: "r1", SourceID: "a1", TargetID: "a2", Type: "enemy"},
+				},
+			}},
+			Stories: []*models.ArchivedStory{
+				{
+					Story:     models.Story{ID: "vol2", Title: "Two", SeriesID: "series1", Place: 2},
+					Overrides: []*models.AssociationOverride{{AssociationID: "a1", StoryID: "vol2", SeriesID: "series1", Name: "Older Hero"}},
+				},
+				{
+					Story: models.Story{ID: "vol1", Title: "One", SeriesID: "series1", Place: 1, Chapters: []models.Chapter{{ID: "c1", Title: "Start", Place: 1}}},
+					Blocks: map[string][]models.StoryBlock{
+						"c1": {{KeyID: "k1", Chunk: []byte(`{}`), Rank: "000000V", Version: 4}, {KeyID: "k2", Chunk: []byte(`{}`), Rank: "000001V", Version: 2}},
+					},
+				},
+				{Story: models.Story{ID: "solo", Title: "Alone"}},
+			},
+		}
+	}
+
+	testCases := []struct {
+		name    string
+		archive *models.AccountArchive
+	}{
+		{name: "SeriesAndStandalone", archive: archive()},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			mockDao := NewMockDAO()
+			mockClient := mockDao.DynamoClient.(*MockDynamoClient)
+			associationNames := map[string]string{}
+			mockClient.MockScan = func(ctx context.Context, input *dynamodb.ScanInput, opts ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+				switch *input.TableName {
+				case "users" + GetTableSuffix():
+					return &dynamodb.ScanOutput{Items: []map[string]types.AttributeValue{{
+						"email": &types.AttributeValueMemberS{Value: "new@example.com"},
+					}}}, nil
+				case "stories" + GetTableSuffix():
+					return &dynamodb.ScanOutput{Items: []map[string]types.AttributeValue{{
+						"story_id": input.ExpressionAttributeValues[":s"],
+						"title":    &types.AttributeValueMemberS{Value: "Imported"},
+					}}}, nil
+				case "associations" + GetTableSuffix():
+					items := []map[string]types.AttributeValue{}
+					for id := range associationNames {
+						items = append(items, map[string]types.AttributeValue{"association_id": &types.AttributeValueMemberS{Value: id}})
+					}
+					return &dynamodb.ScanOutput{Items: items}, nil
+				}
+				return &dynamodb.ScanOutput{}, nil
+			}
+			readyTables := map[string]bool{}
+			mockClient.MockDescribeTable = func(ctx context.Context, input *dynamodb.DescribeTableInput, opts ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+				readyTables[*input.TableName] = true
+				return &dynamodb.DescribeTableOutput{Table: &types.TableDescription{TableStatus: types.TableStatusActive}}, nil
+			}
+			storyRows := map[string]map[string]types.AttributeValue{}
+			overrides := []map[string]types.AttributeValue{}
+			mockClient.MockPutItem = func(ctx context.Context, input *dynamodb.PutItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+				switch *input.TableName {
+				case "stories" + GetTableSuffix():
+					storyRows[input.Item["title"].(*types.AttributeValueMemberS).Value] = input.Item
+				case "association_overrides" + GetTableSuffix():
+					overrides = append(overrides, input.Item)
+				}
+				return &dynamodb.PutItemOutput{}, nil
+			}
+			relationships := 0
+			blocks := []*types.Update{}
+			mockClient.MockTransactWriteItems = func(ctx context.Context, input *dynamodb.TransactWriteItemsInput, opts ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+				for _, item := range input.TransactItems {
+					if item.Update == nil {
+						continue
+					}
+					switch table := *item.Update.TableName; {
+					case table == "associations"+GetTableSuffix():
+						id := item.Update.Key["association_id"].(*types.AttributeValueMemberS).Value
+						associationNames[id] = item.Update.ExpressionAttributeValues[":nm"].(*types.AttributeValueMemberS).Value
+					case table == "association_relationships"+GetTableSuffix():
+						relationships++
+					case strings.HasSuffix(table, "_blocks"+GetTableSuffix()):
+						if !readyTables[table] {
+							t.Errorf("expected %s to be waited on before its blocks were written", table)
+						}
+						blocks = append(blocks, item.Update)
+					}
+				}
+				return &dynamodb.TransactWriteItemsOutput{}, nil
+			}
+
+			// overrides are only written for associations the import has already put back
+			mockClient.MockQuery = func(ctx context.Context, input *dynamodb.QueryInput, opts ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+				if *input.TableName != "associations"+GetTableSuffix() {
+					return &dynamodb.QueryOutput{}, nil
+				}
+				id := input.ExpressionAttributeValues[":aid"].(*types.AttributeValueMemberS).Value
+				name, ok := associationNames[id]
+				if !ok {
+					return &dynamodb.QueryOutput{}, nil
+				}
+				return &dynamodb.QueryOutput{Items: []map[string]types.AttributeValue{{
+					"association_id":     &types.AttributeValueMemberS{Value: id},
+					"story_or_series_id": input.ExpressionAttributeValues[":s"],
+					"association_name":   &types.AttributeValueMemberS{Value: name},
+				}}}, nil
+			}
+
+			report, err := mockDao.ImportAccount("new@example.com", tc.archive)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			seriesID := report.Series["series1"]
+			if seriesID == "" || seriesID == "series1" {
+				t.Fatalf("expected the series imported under a new id, got %q", seriesID)
+			}
+			for title, wantPlace := range map[string]string{"One": "1", "Two": "2"} {
+				row := storyRows[title]
+				if row == nil {
+					t.Fatalf("expected volume %s imported", title)
+				}
+				if row["series_id"].(*types.AttributeValueMemberS).Value != seriesID || row["place"].(*types.AttributeValueMemberN).Value != wantPlace {
+					t.Errorf("expected volume %s at place %s of %s, got %v", title, wantPlace, seriesID, row)
+				}
+			}
+			if _, ok := storyRows["Alone"]["series_id"]; ok || storyRows["Alone"] == nil {
+				t.Errorf("expected the standalone story imported on its own, got %v", storyRows["Alone"])
+			}
+			for oldID, newID := range report.Stories {
+				if oldID == newID {
+					t.Errorf("expected story %s to get a new id", oldID)
+				}
+			}
+
+			if len(associationNames) != 2 {
+				t.Fatalf("expected 2 associations imported, got %v", associationNames)
+			}
+			heroID := ""
+			for id, name := range associationNames {
+				if id == "a1" || id == "a2" {
+					t.Errorf("expected associations to get new ids, got %s", id)
+				}
+				if name == "Hero" {
+					heroID = id
+				}
+			}
+			if relationships != 1 {
+				t.Errorf("expected the relationship carried over, got %d", relationships)
+			}
+			if len(overrides) != 1 || overrides[0]["association_id"].(*types.AttributeValueMemberS).Value != heroID ||
+				overrides[0]["story_id"].(*types.AttributeValueMemberS).Value != report.Stories["vol2"] {
+				t.Errorf("expected the override moved onto the imported hero and volume, got %v", overrides)
+			}
+
+			if len(blocks) != 2 || report.Blocks != 2 || report.Chapters != 1 {
+				t.Fatalf("expected 2 blocks in 1 chapter, got %d writes, report %+v", len(blocks), report)
+			}
+			for i, wantRank := range []string{"000000V", "000001V"} {
+				update := blocks[i]
+				if !strings.HasPrefix(*update.TableName, report.Stories["vol1"]+"_") {
+					t.Errorf("expected blocks written to the imported volume, got %s", *update.TableName)
+				}
+				if rank := update.ExpressionAttributeValues[":r"].(*types.AttributeValueMemberS).Value; rank != wantRank {
+					t.Errorf("expected rank %s kept, got %s", wantRank, rank)
+				}
+				if _, versioned := update.ExpressionAttributeValues[":ver"]; versioned {
+					t.Errorf("expected blocks written as new, got a version condition")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package daos
+
+import (
+	"RichDocter/converters"
+	"RichDocter/models"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrTemplateNotFound = errors.New("story template not found")
+	ErrBuiltInTemplate  = errors.New("built-in templates cannot be changed")
+)
+
+var builtInStoryTemplates = []models.StoryTemplate{
+	{
+		ID:          "three-act",
+		Name:        "Three-Act Structure",
+		Description: "Setup, confrontation and resolution, turning on two plot points.",
+		Chapters: []models.TemplateChapter{
+			{Title: "Act I: Setup", Synopsis: []string{"Introduce the protagonist, their world and what they want.", "The inciting incident disrupts the status quo."}},
+			{Title: "Plot Point One", Synopsis: []string{"The protagonist commits to the journey and there is no going back."}},
+			{Title: "Act II: Confrontation", Synopsis: []string{"Rising obstacles test the protagonist.", "The midpoint raises the stakes."}},
+			{Title: "Plot Point Two", Synopsis: []string{"All seems lost; the protagonist must change to go on."}},
+			{Title: "Act III: Resolution", Synopsis: []string{"The climax settles the central conflict.", "Show the new normal."}},
+		},
+		AssociationTypes: []models.AssociationType{},
+	},
+	{
+		ID:          "save-the-cat",
+		Name:        "Save the Cat",
+		Description: "Blake Snyder's fifteen story beats.",
+		Chapters: []models.TemplateChapter{
+			{Title: "Opening Image", Synopsis: []string{"A snapshot of the protagonist's life before the story begins."}},
+			{Title: "Theme Stated", Synopsis: []string{"Someone hints at the lesson the protagonist will learn."}},
+			{Title: "Set-Up", Synopsis: []string{"The protagonist's world, flaws and what is missing."}},
+			{Title: "Catalyst", Synopsis: []string{"The event that changes everything."}},
+			{Title: "Debate", Synopsis: []string{"The protagonist hesitates over what to do."}},
+			{Title: "Break into Two", Synopsis: []string{"The protagonist chooses to act and enters a new world."}},
+			{Title: "B Story", Synopsis: []string{"A secondary story, often a relationship, carries the theme."}},
+			{Title: "Fun and Games", Synopsis: []string{"The promise of the premise."}},
+			{Title: "Midpoint", Synopsis: []string{"A false victory or false defeat raises the stakes."}},
+			{Title: "Bad Guys Close In", Synopsis: []string{"Pressure mounts from outside and within."}},
+			{Title: "All Is Lost", Synopsis: []string{"The lowest point."}},
+			{Title: "Dark Night of the Soul", Synopsis: []string{"The protagonist reflects and finds the answer."}},
+			{Title: "Break into Three", Synopsis: []string{"Armed with the lesson, the protagonist has a plan."}},
+			{Title: "Finale", Synopsis: []string{"The plan is carried out and the protagonist is changed."}},
+			{Title: "Final Image", Synopsis: []string{"A mirror of the opening image showing how things have changed."}},
+		},
+		AssociationTypes: []models.AssociationType{},
+	},
+	{
+		ID:          "heros-journey",
+		Name:        "Hero's Journey",
+		Description: "The monomyth in twelve stages, with types for mentors and threshold guardians.",
+		Chapters: []models.TemplateChapter{
+			{Title: "The Ordinary World", Synopsis: []string{"The hero at home, before the adventure."}},
+			{Title: "The Call to Adventure", Synopsis: []string{"A challenge or quest is presented."}},
+			{Title: "Refusal of the Call", Synopsis: []string{"Fear or duty holds the hero back."}},
+			{Title: "Meeting the Mentor", Synopsis: []string{"Guidance, training or a gift prepares the hero."}},
+			{Title: "Crossing the Threshold", Synopsis: []string{"The hero leaves the ordinary world."}},
+			{Title: "Tests, Allies and Enemies", Synopsis: []string{"The hero learns the rules of the special world."}},
+			{Title: "Approach to the Inmost Cave", Synopsis: []string{"Preparations for the central ordeal."}},
+			{Title: "The Ordeal", Synopsis: []string{"The hero faces their greatest fear."}},
+			{Title: "Reward", Synopsis: []string{"Having survived, the hero claims the prize."}},
+			{Title: "The Road Back", Synopsis: []string{"The hero sets out for home, pursued."}},
+			{Title: "Resurrection", Synopsis: []string{"A final test where everything is at stake."}},
+			{Title: "Return with the Elixir", Synopsis: []string{"The hero comes home transformed."}},
+		},
+		AssociationTypes: []models.AssociationType{
+			{Name: "mentor", Fields: []models.AssociationFieldDefinition{{Key: "gift", Label: "Gift to the hero", Type: FIELD_TYPE_TEXT}}},
+			{Name: "guardian", Fields: []models.AssociationFieldDefinition{{Key: "threshold", Label: "Threshold guarded", Type: FIELD_TYPE_TEXT}}},
+		},
+	},
+}
+
+func builtInStoryTemplate(templateID string) *models.StoryTemplate {
+	for i := range builtInStoryTemplates {
+		if builtInStoryTemplates[i].ID == templateID {
+			template := builtInStoryTemplates[i]
+			template.BuiltIn = true
+			return &template
+		}
+	}
+	return nil
+}
+
+// GetStoryTemplates lists the built-in templates followed by the user's own, newest first
+func (d *DAO) GetStoryTemplates(email string) ([]*models.StoryTemplate, error) {
+	templates := []*models.StoryTemplate{}
+	for _, builtIn := range builtInStoryTemplates {
+		templates = append(templates, builtInStoryTemplate(builtIn.ID))
+	}
+	out, err := d.DynamoClient.Scan(context.TODO(), &dynamodb.ScanInput{
+		TableName:        aws.String("story_templates" + GetTableSuffix()),
+		FilterExpression: aws.String("author=:eml"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":eml": &types.AttributeValueMemberS{Value: email},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	saved := []*models.StoryTemplate{}
+	if err = attributevalue.UnmarshalListOfMaps(out.Items, &saved); err != nil {
+		return nil, err
+	}
+	sort.Slice(saved, func(i, j int) bool {
+		return saved[i].CreatedAt > saved[j].CreatedAt
+	})
+	return append(templates, saved...), nil
+}
+
+// GetStoryTemplate finds a built-in template or one the user saved
+func (d *DAO) GetStoryTemplate(email, templateID string) (*models.StoryTemplate, error) {
+	if builtIn := builtInStoryTemplate(templateID); builtIn != nil {
+		return builtIn, nil
+	}
+	out, err := d.DynamoClient.Query(context.TODO(), &dynamodb.QueryInput{
+		TableName:              aws.String("story_templates" + GetTableSuffix()),
+		KeyConditionExpression: aws.String("template_id = :tid AND author = :eml"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":tid": &types.AttributeValueMemberS{Value: templateID},
+			":eml": &types.AttributeValueMemberS{Value: email},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(out.Items) == 0 {
+		return nil, ErrTemplateNotFound
+	}
+	template := models.StoryTemplate{}
+	if err = attributevalue.UnmarshalMap(out.Items[0], &template); err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
+
+// SaveStoryTemplate saves a story's chapter titles and its custom association types as a new template.
+// Chapter content isn't kept, so the template only carries the story's shape.
+func (d *DAO) SaveStoryTemplate(email, storyID string, template models.StoryTemplate) (*models.StoryTemplate, error) {
+	template.Name = strings.TrimSpace(template.Name)
+	if template.Name == "" {
+		return nil, fmt.Errorf("templates require a name")
+	}
+	story, err := d.GetStoryByID(email, storyID)
+	if err != nil {
+		return nil, err
+	}
+	template.ID = uuid.New().String()
+	template.CreatedAt = time.Now().Unix()
+	template.Chapters = make([]models.TemplateChapter, 0, len(story.Chapters))
+	for _, chapter := range story.Chapters {
+		template.Chapters = append(template.Chapters, models.TemplateChapter{Title: chapter.Title})
+	}
+	storyOrSeriesID := story.ID
+	if story.SeriesID != "" {
+		storyOrSeriesID = story.SeriesID
+	}
+	associationTypes, err := d.GetAssociationTypes(email, storyOrSeriesID)
+	if err != nil {
+		return nil, err
+	}
+	template.AssociationTypes = []models.AssociationType{}
+	for _, associationType := range associationTypes {
+		// untouched built-in types come with every story anyway
+		if associationType.BuiltIn && len(associationType.Fields) == 0 && associationType.DefaultPortrait == "" {
+			continue
+		}
+		associationType.StoryOrSeriesID = ""
+		template.AssociationTypes = append(template.AssociationTypes, *associationType)
+	}
+
+	item, err := attributevalue.MarshalMap(template)
+	if err != nil {
+		return nil, err
+	}
+	item["author"] = &types.AttributeValueMemberS{Value: email}
+	if _, err = d.DynamoClient.PutItem(context.TODO(), &dynamodb.PutItemInput{
+		TableName:           aws.String("story_templates" + GetTableSuffix()),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(template_id)"),
+	}); err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
+
+func (d *DAO) DeleteStoryTemplate(email, templateID string) error {
+	if builtInStoryTemplate(templateID) != nil {
+		return ErrBuiltInTemplate
+	}
+	_, err := d.DynamoClient.DeleteItem(context.TODO(), &dynamodb.DeleteItemInput{
+		TableName: aws.String("story_templates" + GetTableSuffix()),
+		Key: map[string]types.AttributeValue{
+			"template_id": &types.AttributeValueMemberS{Value: templateID},
+			"author":      &types.AttributeValueMemberS{Value: email},
+		},
+		ConditionExpression: aws.String("attribute_exists(template_id)"),
+	})
+	if isConditionalCheckFailure(err) {
+		return ErrTemplateNotFound
+	}
+	return err
+}
+
+// ApplyStoryTemplate creates a newly created story's chapters from the template, each holding its
+// synopsis as italic placeholder paragraphs, and adds the template's association types to the story
+// or, for a volume, its series
+func (d *DAO) ApplyStoryTemplate(email string, story models.Story, template *models.StoryTemplate) ([]models.Chapter, error) {
+	chapters := make([]models.Chapter, 0, len(template.Chapters))
+	for i, templateChapter := range template.Chapters {
+		chapter, err := d.CreateChapter(story.ID, models.Chapter{
+			ID:    uuid.New().String(),
+			Title: templateChapter.Title,
+			Place: i + 1,
+		}, email)
+		if err != nil {
+			return nil, err
+		}
+		chapter.StoryID = story.ID
+		if len(templateChapter.Synopsis) > 0 {
+			blocks := &models.StoryBlocks{StoryID: story.ID, ChapterID: chapter.ID}
+			for place, line := range templateChapter.Synopsis {
+				keyID := uuid.New().String()
+				blocks.Blocks = append(blocks.Blocks, models.StoryBlock{
+					KeyID: keyID,
+					Chunk: converters.LexicalParagraphChunk(keyID, line, converters.LEXICAL_FORMAT_ITALIC),
+					Place: strconv.Itoa(place),
+				})
+			}
+			// the chapter's block table has only just been created
+			if err = d.waitForBlockTable(story.ID + "_" + chapter.ID + "_blocks" + GetTableSuffix()); err != nil {
+				return nil, err
+			}
+			if err = d.WriteBlocks(story.ID, blocks); err != nil {
+				return nil, err
+			}
+		}
+		chapters = append(chapters, chapter)
+	}
+	storyOrSeriesID := story.ID
+	if story.SeriesID != "" {
+		storyOrSeriesID = story.SeriesID
+	}
+	for _, associationType := range template.AssociationTypes {
+		if err := d.WriteAssociationType(email, storyOrSeriesID, associationType); err != nil {
+			return nil, err
+		}
+	}
+	return chapters, nil
+}
@@ -0,0 +1,162 @@
+package daos
+
+import (
+	"RichDocter/models"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestGetStoryTemplate(t *testing.T) {
+	testCases := []struct {
+		name        string
+		templateID  string
+		saved       bool
+		wantBuiltIn bool
+		wantErr     error
+	}{
+		{name: "BuiltIn", templateID: "three-act", wantBuiltIn: true},
+		{name: "Saved", templateID: "tmpl1", saved: true},
+		{name: "Missing", templateID: "tmpl1", wantErr: ErrTemplateNotFound},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			mockDao := NewMockDAO()
+			mockClient := mockDao.DynamoClient.(*MockDynamoClient)
+			queried := false
+			mockClient.MockQuery = func(ctx context.Context, input *dynamodb.QueryInput, opts ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+				queried = true
+				if !tc.saved {
+					return &dynamodb.QueryOutput{}, nil
+				}
+				return &dynamodb.QueryOutput{Items: []map[string]types.AttributeValue{{
+					"template_id": &types.AttributeValueMemberS{Value: "tmpl1"},
+					"author":      &types.AttributeValueMemberS{Value: "owner@example.com"},
+					"name":        &types.AttributeValueMemberS{Value: "Mine"},
+				}}}, nil
+			}
+
+			template, err := mockDao.GetStoryTemplate("owner@example.com", tc.templateID)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if template.ID != tc.templateID || template.BuiltIn != tc.wantBuiltIn {
+				t.Errorf("expected template %s built in %v, got %+v", tc.templateID, tc.wantBuiltIn, template)
+			}
+			if tc.wantBuiltIn && queried {
+				t.Errorf("expected built-in templates to be found without a lookup")
+			}
+		})
+	}
+}
+
+func TestApplyStoryTemplate(t *testing.T) {
+	testCases := []struct {
+		name            string
+		templateID      string
+		seriesID        string
+		wantTypesUnder  string
+		wantTypeWrites  int
+		wantBlockTables int
+	}{
+		{name: "ThreeAct", templateID: "three-act", wantBlockTables: 5},
+		{name: "HerosJourneyInSeries", templateID: "heros-journey", seriesID: "series1", wantTypesUnder: "series1", wantTypeWrites: 2, wantBlockTables: 12},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			mockDao := NewMockDAO()
+			mockClient := mockDao.DynamoClient.(*MockDynamoClient)
+			mockClient.MockScan = func(ctx context.Context, input *dynamodb.ScanInput, opts ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+				switch *input.TableName {
+				case "users" + GetTableSuffix():
+					return &dynamodb.ScanOutput{Items: []map[string]types.AttributeValue{{
+						"email": &types.AttributeValueMemberS{Value: "owner@example.com"},
+					}}}, nil
+				case "stories" + GetTableSuffix():
+					return &dynamodb.ScanOutput{Items: []map[string]types.AttributeValue{{
+						"story_id": &types.AttributeValueMemberS{Value: "story1"},
+						"title":    &types.AttributeValueMemberS{Value: "Story"},
+					}}}, nil
+				}
+				return &dynamodb.ScanOutput{}, nil
+			}
+			readyTables := map[string]bool{}
+			mockClient.MockDescribeTable = func(ctx context.Context, input *dynamodb.DescribeTableInput, opts ...func(*dynamodb.Options)) (*dynamodb.DescribeTableOutput, error) {
+				readyTables[*input.TableName] = true
+				return &dynamodb.DescribeTableOutput{Table: &types.TableDescription{TableStatus: types.TableStatusActive}}, nil
+			}
+			chapterPlaces := []string{}
+			blockTables := map[string]int{}
+			mockClient.MockTransactWriteItems = func(ctx context.Context, input *dynamodb.TransactWriteItemsInput, opts ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+				for _, item := range input.TransactItems {
+					if item.Update != nil && strings.HasSuffix(*item.Update.TableName, "_blocks"+GetTableSuffix()) && !readyTables[*item.Update.TableName] {
+						t.Errorf("expected %s to be waited on before its blocks were written", *item.Update.TableName)
+					}
+					if item.Put != nil && *item.Put.TableName == "chapters"+GetTableSuffix() {
+						chapterPlaces = append(chapterPlaces, item.Put.Item["chapter_num"].(*types.AttributeValueMemberN).Value)
+					}
+					if item.Update != nil && strings.HasSuffix(*item.Update.TableName, "_blocks"+GetTableSuffix()) {
+						chunk := item.Update.ExpressionAttributeValues[":c"].(*types.AttributeValueMemberS).Value
+						if !strings.Contains(chunk, `"format":2`) {
+							t.Errorf("expected synopsis placeholders in italics, got %s", chunk)
+						}
+						blockTables[*item.Update.TableName]++
+					}
+				}
+				return &dynamodb.TransactWriteItemsOutput{}, nil
+			}
+			typesUnder := []string{}
+			mockClient.MockUpdateItem = func(ctx context.Context, input *dynamodb.UpdateItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+				if *input.TableName == "association_types"+GetTableSuffix() {
+					typesUnder = append(typesUnder, input.Key["story_or_series_id"].(*types.AttributeValueMemberS).Value)
+				}
+				return &dynamodb.UpdateItemOutput{}, nil
+			}
+
+			template := builtInStoryTemplate(tc.templateID)
+			chapters, err := mockDao.ApplyStoryTemplate("owner@example.com", models.Story{ID: "story1", SeriesID: tc.seriesID}, template)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(chapters) != len(template.Chapters) || len(chapterPlaces) != len(template.Chapters) {
+				t.Fatalf("expected %d chapters, got %d returned and %d written", len(template.Chapters), len(chapters), len(chapterPlaces))
+			}
+			for i, chapter := range chapters {
+				if chapter.Title != template.Chapters[i].Title || chapter.Place != i+1 || chapter.StoryID != "story1" {
+					t.Errorf("expected chapter %d to be %q, got %+v", i+1, template.Chapters[i].Title, chapter)
+				}
+			}
+			if len(blockTables) != tc.wantBlockTables {
+				t.Errorf("expected placeholders in %d chapters, got %d", tc.wantBlockTables, len(blockTables))
+			}
+			if len(typesUnder) != tc.wantTypeWrites {
+				t.Fatalf("expected %d association types written, got %d", tc.wantTypeWrites, len(typesUnder))
+			}
+			for _, id := range typesUnder {
+				if id != tc.wantTypesUnder {
+					t.Errorf("expected association types added to %s, got %s", tc.wantTypesUnder, id)
+				}
+			}
+		})
+	}
+}
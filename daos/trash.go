@@ -0,0 +1,610 @@
+package daos
+
+import (
+	"RichDocter/models"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// the cleanup lambda purges deleted rows this long after they were deleted
+const trashRetention = 35 * 24 * time.Hour
+
+var (
+	ErrNotInTrash       = errors.New("item not found in trash")
+	ErrParentInTrash    = errors.New("the story or series this belongs to is deleted, restore that instead")
+	ErrUnknownTrashType = errors.New("unknown trash item type")
+	ErrTypeNotDefined   = errors.New("the association type has been deleted, recreate it to restore")
+)
+
+// trashedRow holds what the trash needs from a row of any of the soft deleted tables
+type trashedRow struct {
+	StoryID         string `dynamodbav:"story_id"`
+	SeriesID        string `dynamodbav:"series_id"`
+	ChapterID       string `dynamodbav:"chapter_id"`
+	AssociationID   string `dynamodbav:"association_id"`
+	StoryOrSeriesID string `dynamodbav:"story_or_series_id"`
+	Title           string `dynamodbav:"title"`
+	Name            string `dynamodbav:"association_name"`
+	Type            string `dynamodbav:"association_type"`
+	BackupARN       string `dynamodbav:"bup_arn"`
+	DeletedAt       int64  `dynamodbav:"deleted_at"`
+	Automated       bool   `dynamodbav:"automated_deletion"`
+}
+
+// GetTrash lists what a user has deleted and can still restore, most recently deleted first. Stories
+// suspended when a subscription lapsed aren't listed, they come back on resubscribing. Chapters and
+// associations are only listed while their story or series is live, otherwise they come back with it.
+func (d *DAO) GetTrash(email string) ([]*models.TrashItem, error) {
+	owned := map[string]types.AttributeValue{":eml": &types.AttributeValueMemberS{Value: email}}
+	stories, err := d.scanRows("stories", "author=:eml", owned)
+	if err != nil {
+		return nil, err
+	}
+	series, err := d.scanRows("series", "author=:eml", owned)
+	if err != nil {
+		return nil, err
+	}
+	liveStories, deletedParents := map[string]bool{}, map[string]bool{}
+	for _, story := range stories {
+		if story.DeletedAt == 0 {
+			liveStories[story.StoryID] = true
+		} else {
+			deletedParents[story.StoryID] = true
+		}
+	}
+	for _, s := range series {
+		if s.DeletedAt != 0 {
+			deletedParents[s.SeriesID] = true
+		}
+	}
+
+	trash := []*models.TrashItem{}
+	for _, s := range series {
+		if s.DeletedAt != 0 && !s.Automated {
+			trash = append(trash, trashItem(models.TrashSeries, s.SeriesID, "", s.Title, s.DeletedAt))
+		}
+	}
+	for _, story := range stories {
+		if story.DeletedAt != 0 && !story.Automated && !deletedParents[story.SeriesID] {
+			trash = append(trash, trashItem(models.TrashStory, story.StoryID, story.SeriesID, story.Title, story.DeletedAt))
+		}
+	}
+	// chapters record their author once deleted, those deleted before they did are matched through
+	// the user's live stories
+	chapters, err := d.scanRows("chapters", "attribute_exists(deleted_at) AND (author=:eml OR attribute_not_exists(author))", owned)
+	if err != nil {
+		return nil, err
+	}
+	for _, chapter := range chapters {
+		if liveStories[chapter.StoryID] && !chapter.Automated {
+			trash = append(trash, trashItem(models.TrashChapter, chapter.ChapterID, chapter.StoryID, chapter.Title, chapter.DeletedAt))
+		}
+	}
+	associations, err := d.scanRows("associations", "author=:eml AND attribute_exists(deleted_at)", owned)
+	if err != nil {
+		return nil, err
+	}
+	for _, association := range associations {
+		if !deletedParents[association.StoryOrSeriesID] && !association.Automated {
+			trash = append(trash, trashItem(models.TrashAssociation, association.AssociationID, association.StoryOrSeriesID, association.Name, association.DeletedAt))
+		}
+	}
+
+	sort.SliceStable(trash, func(i, j int) bool {
+		return trash[i].DeletedAt > trash[j].DeletedAt
+	})
+	return trash, nil
+}
+
+func trashItem(itemType, id, parentID, title string, deletedAt int64) *models.TrashItem {
+	return &models.TrashItem{
+		Type:      itemType,
+		ID:        id,
+		ParentID:  parentID,
+		Title:     title,
+		DeletedAt: deletedAt,
+		PurgeAt:   deletedAt + int64(trashRetention/time.Second),
+	}
+}
+
+// RestoreFromTrash brings back a deleted item along with everything deleted with it. Block tables
+// are restored from the backups taken when their chapters were deleted. A restored chapter is added
+// after the story's current last chapter.
+func (d *DAO) RestoreFromTrash(email, itemType, id string) error {
+	switch itemType {
+	case models.TrashStory:
+		story, err := d.trashedStory(email, id)
+		if err != nil {
+			return err
+		}
+		return d.restoreStory(email, story)
+	case models.TrashSeries:
+		series, err := d.trashedSeries(email, id)
+		if err != nil {
+			return err
+		}
+		return d.restoreSeries(email, series)
+	case models.TrashChapter:
+		chapter, err := d.trashedChapter(email, id)
+		if err != nil {
+			return err
+		}
+		chapters, err := d.GetChaptersByStoryID(chapter.StoryID)
+		if err != nil {
+			return err
+		}
+		if err = d.restoreChapterTable(chapter); err != nil {
+			return err
+		}
+		return d.restoreRow("chapters", chapterRowKey(chapter), "SET chapter_num = :p", map[string]types.AttributeValue{
+			":p": &types.AttributeValueMemberN{Value: fmt.Sprint(len(chapters) + 1)},
+		})
+	case models.TrashAssociation:
+		association, err := d.trashedAssociation(email, id)
+		if err != nil {
+			return err
+		}
+		if err = d.checkAssociationTypes(email, association.StoryOrSeriesID, []trashedRow{association}); err != nil {
+			return err
+		}
+		return d.restoreAssociation(association)
+	}
+	return ErrUnknownTrashType
+}
+
+// PurgeFromTrash permanently deletes an item from the trash along with everything that belongs to it,
+// including the backups of its chapters' blocks
+func (d *DAO) PurgeFromTrash(email, itemType, id string) error {
+	switch itemType {
+	case models.TrashStory:
+		story, err := d.trashedStory(email, id)
+		if err != nil {
+			return err
+		}
+		return d.purgeStory(email, story)
+	case models.TrashSeries:
+		series, err := d.trashedSeries(email, id)
+		if err != nil {
+			return err
+		}
+		stories, err := d.scanRows("stories", "author=:eml AND series_id=:s AND attribute_exists(deleted_at)", map[string]types.AttributeValue{
+			":eml": &types.AttributeValueMemberS{Value: email},
+			":s":   &types.AttributeValueMemberS{Value: series.SeriesID},
+		})
+		if err != nil {
+			return err
+		}
+		for _, story := range stories {
+			if err = d.purgeStory(email, story); err != nil {
+				return err
+			}
+		}
+		deletes, err := d.associationDeletes(email, series.SeriesID)
+		if err != nil {
+			return err
+		}
+		deletes = append(deletes, &types.Delete{
+			TableName: aws.String("series" + GetTableSuffix()),
+			Key:       seriesRowKey(email, series.SeriesID),
+		})
+		return d.deleteRows(deletes)
+	case models.TrashChapter:
+		chapter, err := d.trashedChapter(email, id)
+		if err != nil && !errors.Is(err, ErrParentInTrash) {
+			return err
+		}
+		if err = d.deleteChapterBackup(chapter.BackupARN); err != nil {
+			return err
+		}
+		return d.deleteRows([]*types.Delete{{
+			TableName: aws.String("chapters" + GetTableSuffix()),
+			Key:       chapterRowKey(chapter),
+		}})
+	case models.TrashAssociation:
+		association, err := d.trashedAssociation(email, id)
+		if err != nil && !errors.Is(err, ErrParentInTrash) {
+			return err
+		}
+		key := associationRowKey(association.AssociationID, association.StoryOrSeriesID)
+		return d.deleteRows([]*types.Delete{
+			{TableName: aws.String("associations" + GetTableSuffix()), Key: key},
+			{TableName: aws.String("association_details" + GetTableSuffix()), Key: key},
+		})
+	}
+	return ErrUnknownTrashType
+}
+
+func (d *DAO) trashedStory(email, storyID string) (trashedRow, error) {
+	return d.trashedRow("stories", "author=:eml AND story_id=:id AND attribute_exists(deleted_at)", email, storyID)
+}
+
+func (d *DAO) trashedSeries(email, seriesID string) (trashedRow, error) {
+	return d.trashedRow("series", "author=:eml AND series_id=:id AND attribute_exists(deleted_at)", email, seriesID)
+}
+
+// trashedChapter finds a deleted chapter of one of the user's stories. The chapter is returned along
+// with ErrParentInTrash if its story is deleted too.
+func (d *DAO) trashedChapter(email, chapterID string) (trashedRow, error) {
+	chapters, err := d.scanRows("chapters", "chapter_id=:id AND attribute_exists(deleted_at)", map[string]types.AttributeValue{
+		":id": &types.AttributeValueMemberS{Value: chapterID},
+	})
+	if err != nil {
+		return trashedRow{}, err
+	}
+	if len(chapters) == 0 {
+		return trashedRow{}, ErrNotInTrash
+	}
+	return chapters[0], d.checkTrashParent(email, chapters[0].StoryID)
+}
+
+// trashedAssociation finds one of the user's deleted associations. The association is returned along
+// with ErrParentInTrash if its story or series is deleted too.
+func (d *DAO) trashedAssociation(email, associationID string) (trashedRow, error) {
+	association, err := d.trashedRow("associations", "author=:eml AND association_id=:id AND attribute_exists(deleted_at)", email, associationID)
+	if err != nil {
+		return association, err
+	}
+	return association, d.checkTrashParent(email, association.StoryOrSeriesID)
+}
+
+func (d *DAO) trashedRow(table, filter, email, id string) (trashedRow, error) {
+	rows, err := d.scanRows(table, filter, map[string]types.AttributeValue{
+		":eml": &types.AttributeValueMemberS{Value: email},
+		":id":  &types.AttributeValueMemberS{Value: id},
+	})
+	if err != nil {
+		return trashedRow{}, err
+	}
+	if len(rows) == 0 {
+		return trashedRow{}, ErrNotInTrash
+	}
+	return rows[0], nil
+}
+
+// checkTrashParent makes sure the user owns the story or series an item belongs to and that it is live
+func (d *DAO) checkTrashParent(email, storyOrSeriesID string) error {
+	values := map[string]types.AttributeValue{
+		":eml": &types.AttributeValueMemberS{Value: email},
+		":id":  &types.AttributeValueMemberS{Value: storyOrSeriesID},
+	}
+	parents, err := d.scanRows("stories", "author=:eml AND story_id=:id", values)
+	if err != nil {
+		return err
+	}
+	series, err := d.scanRows("series", "author=:eml AND series_id=:id", values)
+	if err != nil {
+		return err
+	}
+	parents = append(parents, series...)
+	if len(parents) == 0 {
+		return ErrNotInTrash
+	}
+	if parents[0].DeletedAt != 0 {
+		return ErrParentInTrash
+	}
+	return nil
+}
+
+// restoreStory brings back a story with the chapters and associations deleted along with it, and its
+// series if deleting the story took the series with it
+func (d *DAO) restoreStory(email string, story trashedRow) error {
+	storyOrSeriesID := story.StoryID
+	if story.SeriesID != "" {
+		storyOrSeriesID = story.SeriesID
+	}
+	associations, err := d.deletedAssociations(email, storyOrSeriesID, story.DeletedAt)
+	if err != nil {
+		return err
+	}
+	deletedAt := &types.AttributeValueMemberN{Value: fmt.Sprint(story.DeletedAt)}
+	chapters, err := d.scanRows("chapters", "story_id=:sid AND deleted_at=:d", map[string]types.AttributeValue{
+		":sid": &types.AttributeValueMemberS{Value: story.StoryID},
+		":d":   deletedAt,
+	})
+	if err != nil {
+		return err
+	}
+	for _, chapter := range chapters {
+		if err = d.restoreChapterTable(chapter); err != nil {
+			return err
+		}
+		if err = d.restoreRow("chapters", chapterRowKey(chapter), "", nil); err != nil {
+			return err
+		}
+	}
+
+	if story.SeriesID != "" {
+		series, err := d.scanRows("series", "author=:eml AND series_id=:s AND deleted_at=:d", map[string]types.AttributeValue{
+			":eml": &types.AttributeValueMemberS{Value: email},
+			":s":   &types.AttributeValueMemberS{Value: story.SeriesID},
+			":d":   deletedAt,
+		})
+		if err != nil {
+			return err
+		}
+		for range series {
+			if err = d.restoreRow("series", seriesRowKey(email, story.SeriesID), "", nil); err != nil {
+				return err
+			}
+		}
+	}
+	if err = d.restoreAssociations(associations); err != nil {
+		return err
+	}
+	return d.restoreRow("stories", storyRowKey(email, story.StoryID), "", nil)
+}
+
+// restoreSeries brings back a series with the stories and associations deleted along with it
+func (d *DAO) restoreSeries(email string, series trashedRow) error {
+	associations, err := d.deletedAssociations(email, series.SeriesID, series.DeletedAt)
+	if err != nil {
+		return err
+	}
+	if err = d.restoreRow("series", seriesRowKey(email, series.SeriesID), "", nil); err != nil {
+		return err
+	}
+	if err = d.restoreAssociations(associations); err != nil {
+		return err
+	}
+	stories, err := d.scanRows("stories", "author=:eml AND series_id=:s AND deleted_at=:d", map[string]types.AttributeValue{
+		":eml": &types.AttributeValueMemberS{Value: email},
+		":s":   &types.AttributeValueMemberS{Value: series.SeriesID},
+		":d":   &types.AttributeValueMemberN{Value: fmt.Sprint(series.DeletedAt)},
+	})
+	if err != nil {
+		return err
+	}
+	for _, story := range stories {
+		if err = d.restoreStory(email, story); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deletedAssociations finds the associations of a story or series deleted at the given time, checking
+// their types can still be restored before anything is
+func (d *DAO) deletedAssociations(email, storyOrSeriesID string, deletedAt int64) ([]trashedRow, error) {
+	associations, err := d.scanRows("associations", "author=:eml AND story_or_series_id=:sid AND deleted_at=:d", map[string]types.AttributeValue{
+		":eml": &types.AttributeValueMemberS{Value: email},
+		":sid": &types.AttributeValueMemberS{Value: storyOrSeriesID},
+		":d":   &types.AttributeValueMemberN{Value: fmt.Sprint(deletedAt)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return associations, d.checkAssociationTypes(email, storyOrSeriesID, associations)
+}
+
+// checkAssociationTypes returns ErrTypeNotDefined if a custom type was deleted while associations of it
+// were in the trash
+func (d *DAO) checkAssociationTypes(email, storyOrSeriesID string, associations []trashedRow) error {
+	custom := false
+	for _, association := range associations {
+		custom = custom || (association.Type != "" && !isBuiltInAssociationType(association.Type))
+	}
+	if !custom {
+		return nil
+	}
+	associationTypes, err := d.GetAssociationTypes(email, storyOrSeriesID)
+	if err != nil {
+		return err
+	}
+	defined := make(map[string]bool, len(associationTypes))
+	for _, t := range associationTypes {
+		defined[t.Name] = true
+	}
+	for _, association := range associations {
+		if association.Type != "" && !defined[association.Type] {
+			return fmt.Errorf("%w: %s", ErrTypeNotDefined, association.Type)
+		}
+	}
+	return nil
+}
+
+func (d *DAO) restoreAssociations(associations []trashedRow) error {
+	for _, association := range associations {
+		if err := d.restoreAssociation(association); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *DAO) restoreAssociation(association trashedRow) error {
+	key := associationRowKey(association.AssociationID, association.StoryOrSeriesID)
+	if err := d.restoreRow("associations", key, "", nil); err != nil {
+		return err
+	}
+	return d.restoreRow("association_details", key, "", nil)
+}
+
+// restoreChapterTable recreates a chapter's block table from the backup taken when it was deleted
+func (d *DAO) restoreChapterTable(chapter trashedRow) error {
+	if chapter.BackupARN == "" {
+		// the chapter never had any blocks
+		return nil
+	}
+	_, err := d.DynamoClient.RestoreTableFromBackup(context.TODO(), &dynamodb.RestoreTableFromBackupInput{
+		BackupArn:       aws.String(chapter.BackupARN),
+		TargetTableName: aws.String(chapter.StoryID + "_" + chapter.ChapterID + "_blocks" + GetTableSuffix()),
+	})
+	// an earlier attempt may have restored the table already
+	var exists *types.TableAlreadyExistsException
+	if err != nil && !errors.As(err, &exists) {
+		return err
+	}
+	return nil
+}
+
+// restoreRow clears a row's deletion marker, applying any further set expression alongside
+func (d *DAO) restoreRow(table string, key map[string]types.AttributeValue, set string, values map[string]types.AttributeValue) error {
+	update := "REMOVE deleted_at, automated_deletion"
+	if set != "" {
+		update = set + " " + update
+	}
+	_, err := d.DynamoClient.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(table + GetTableSuffix()),
+		Key:                       key,
+		UpdateExpression:          aws.String(update),
+		ConditionExpression:       aws.String("attribute_exists(deleted_at)"),
+		ExpressionAttributeValues: values,
+	})
+	// already restored
+	var conditionErr *types.ConditionalCheckFailedException
+	if err != nil && !errors.As(err, &conditionErr) {
+		return err
+	}
+	return nil
+}
+
+// purgeStory permanently deletes a story, its chapters and the associations of a standalone story
+func (d *DAO) purgeStory(email string, story trashedRow) error {
+	chapters, err := d.scanRows("chapters", "story_id=:sid", map[string]types.AttributeValue{
+		":sid": &types.AttributeValueMemberS{Value: story.StoryID},
+	})
+	if err != nil {
+		return err
+	}
+	deletes := []*types.Delete{}
+	for _, chapter := range chapters {
+		if err = d.deleteChapterBackup(chapter.BackupARN); err != nil {
+			return err
+		}
+		deletes = append(deletes, &types.Delete{
+			TableName: aws.String("chapters" + GetTableSuffix()),
+			Key:       chapterRowKey(chapter),
+		})
+	}
+	if story.SeriesID == "" {
+		associations, err := d.associationDeletes(email, story.StoryID)
+		if err != nil {
+			return err
+		}
+		deletes = append(deletes, associations...)
+	}
+	deletes = append(deletes, &types.Delete{
+		TableName: aws.String("stories" + GetTableSuffix()),
+		Key:       storyRowKey(email, story.StoryID),
+	})
+	return d.deleteRows(deletes)
+}
+
+// associationDeletes lists the rows to remove to delete all of a story or series' associations
+func (d *DAO) associationDeletes(email, storyOrSeriesID string) ([]*types.Delete, error) {
+	associations, err := d.scanRows("associations", "author=:eml AND story_or_series_id=:sid", map[string]types.AttributeValue{
+		":eml": &types.AttributeValueMemberS{Value: email},
+		":sid": &types.AttributeValueMemberS{Value: storyOrSeriesID},
+	})
+	if err != nil {
+		return nil, err
+	}
+	deletes := make([]*types.Delete, 0, len(associations)*2)
+	for _, association := range associations {
+		key := associationRowKey(association.AssociationID, storyOrSeriesID)
+		deletes = append(deletes,
+			&types.Delete{TableName: aws.String("associations" + GetTableSuffix()), Key: key},
+			&types.Delete{TableName: aws.String("association_details" + GetTableSuffix()), Key: key},
+		)
+	}
+	return deletes, nil
+}
+
+func (d *DAO) deleteChapterBackup(arn string) error {
+	if arn == "" {
+		return nil
+	}
+	_, err := d.DynamoClient.DeleteBackup(context.TODO(), &dynamodb.DeleteBackupInput{
+		BackupArn: aws.String(arn),
+	})
+	var notFound *types.BackupNotFoundException
+	if err != nil && !errors.As(err, &notFound) {
+		return err
+	}
+	return nil
+}
+
+// deleteRows permanently deletes rows in transactions of up to writeBatchSize
+func (d *DAO) deleteRows(deletes []*types.Delete) error {
+	for i := 0; i < len(deletes); i += d.writeBatchSize {
+		end := i + d.writeBatchSize
+		if end > len(deletes) {
+			end = len(deletes)
+		}
+		writeItemsInput := &dynamodb.TransactWriteItemsInput{
+			TransactItems: make([]types.TransactWriteItem, 0, end-i),
+		}
+		for _, del := range deletes[i:end] {
+			writeItemsInput.TransactItems = append(writeItemsInput.TransactItems, types.TransactWriteItem{Delete: del})
+		}
+		err, awsErr := d.awsWriteTransaction(writeItemsInput)
+		if err != nil {
+			return err
+		}
+		if !awsErr.IsNil() {
+			return fmt.Errorf("--AWSERROR-- Code:%s, Type: %s, Message: %s", awsErr.Code, awsErr.ErrorType, awsErr.Text)
+		}
+	}
+	return nil
+}
+
+// scanRows reads every page of a filtered scan of one of the soft deleted tables
+func (d *DAO) scanRows(table, filter string, values map[string]types.AttributeValue) ([]trashedRow, error) {
+	rows := []trashedRow{}
+	scanInput := &dynamodb.ScanInput{
+		TableName:                 aws.String(table + GetTableSuffix()),
+		FilterExpression:          aws.String(filter),
+		ExpressionAttributeValues: values,
+	}
+	for {
+		out, err := d.DynamoClient.Scan(context.TODO(), scanInput)
+		if err != nil {
+			return nil, err
+		}
+		page := []trashedRow{}
+		if err = attributevalue.UnmarshalListOfMaps(out.Items, &page); err != nil {
+			return nil, err
+		}
+		rows = append(rows, page...)
+		if len(out.LastEvaluatedKey) == 0 {
+			return rows, nil
+		}
+		scanInput.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+}
+
+func storyRowKey(email, storyID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"story_id": &types.AttributeValueMemberS{Value: storyID},
+		"author":   &types.AttributeValueMemberS{Value: email},
+	}
+}
+
+func seriesRowKey(email, seriesID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"series_id": &types.AttributeValueMemberS{Value: seriesID},
+		"author":    &types.AttributeValueMemberS{Value: email},
+	}
+}
+
+func chapterRowKey(chapter trashedRow) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"chapter_id": &types.AttributeValueMemberS{Value: chapter.ChapterID},
+		"story_id":   &types.AttributeValueMemberS{Value: chapter.StoryID},
+	}
+}
+
+func associationRowKey(associationID, storyOrSeriesID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"association_id":     &types.AttributeValueMemberS{Value: associationID},
+		"story_or_series_id": &types.AttributeValueMemberS{Value: storyOrSeriesID},
+	}
+}
@@ -0,0 +1,256 @@
+package daos
+
+import (
+	"RichDocter/models"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func trashTestRow(attrs map[string]string, deletedAt string) map[string]types.AttributeValue {
+	item := map[string]types.AttributeValue{}
+	for name, value := range attrs {
+		item[name] = &types.AttributeValueMemberS{Value: value}
+	}
+	if deletedAt != "" {
+		item["deleted_at"] = &types.AttributeValueMemberN{Value: deletedAt}
+	}
+	return item
+}
+
+func TestGetTrash(t *testing.T) {
+	testCases := []struct {
+		name         string
+		stories      []map[string]types.AttributeValue
+		series       []map[string]types.AttributeValue
+		chapters     []map[string]types.AttributeValue
+		associations []map[string]types.AttributeValue
+		wantIDs      []string
+	}{
+		{
+			name:    "NothingDeleted",
+			stories: []map[string]types.AttributeValue{trashTestRow(map[string]string{"story_id": "s1", "title": "Live"}, "")},
+			wantIDs: []string{},
+		},
+		{
+			name: "NewestFirst",
+			stories: []map[string]types.AttributeValue{
+				trashTestRow(map[string]string{"story_id": "s1", "title": "Live"}, ""),
+				trashTestRow(map[string]string{"story_id": "s2", "title": "Old"}, "100"),
+			},
+			chapters: []map[string]types.AttributeValue{
+				trashTestRow(map[string]string{"story_id": "s1", "chapter_id": "c1", "title": "Cut"}, "300"),
+			},
+			associations: []map[string]types.AttributeValue{
+				trashTestRow(map[string]string{"story_or_series_id": "s1", "association_id": "a1", "association_name": "Bob"}, "200"),
+			},
+			wantIDs: []string{"c1", "a1", "s2"},
+		},
+		{
+			name: "ChildrenOfDeletedParentsHidden",
+			stories: []map[string]types.AttributeValue{
+				trashTestRow(map[string]string{"story_id": "s2", "title": "Gone"}, "100"),
+				trashTestRow(map[string]string{"story_id": "s3", "series_id": "sr1", "title": "Volume"}, "150"),
+			},
+			series: []map[string]types.AttributeValue{
+				trashTestRow(map[string]string{"series_id": "sr1", "title": "Saga"}, "150"),
+			},
+			chapters: []map[string]types.AttributeValue{
+				trashTestRow(map[string]string{"story_id": "s2", "chapter_id": "c2", "title": "Gone too"}, "100"),
+				trashTestRow(map[string]string{"story_id": "someone-elses", "chapter_id": "c3", "title": "Not mine"}, "100"),
+			},
+			associations: []map[string]types.AttributeValue{
+				trashTestRow(map[string]string{"story_or_series_id": "sr1", "association_id": "a2", "association_name": "Alice"}, "150"),
+			},
+			wantIDs: []string{"sr1", "s2"},
+		},
+		{
+			name: "SuspendedStoriesHidden",
+			stories: []map[string]types.AttributeValue{
+				func() map[string]types.AttributeValue {
+					item := trashTestRow(map[string]string{"story_id": "s4", "title": "Suspended"}, "100")
+					item["automated_deletion"] = &types.AttributeValueMemberBOOL{Value: true}
+					return item
+				}(),
+			},
+			wantIDs: []string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			mockDao := NewMockDAO()
+			mockClient := mockDao.DynamoClient.(*MockDynamoClient)
+			tables := map[string][]map[string]types.AttributeValue{
+				"stories" + GetTableSuffix():      tc.stories,
+				"series" + GetTableSuffix():       tc.series,
+				"chapters" + GetTableSuffix():     tc.chapters,
+				"associations" + GetTableSuffix(): tc.associations,
+			}
+			mockClient.MockScan = func(ctx context.Context, input *dynamodb.ScanInput, opts ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+				// every table is narrowed to the user's rows rather than read whole
+				if eml, ok := input.ExpressionAttributeValues[":eml"].(*types.AttributeValueMemberS); !ok || eml.Value != "owner@example.com" || !contains(*input.FilterExpression, "author=:eml") {
+					t.Errorf("expected %s scanned for the owner's rows, got filter %q", *input.TableName, *input.FilterExpression)
+				}
+				return &dynamodb.ScanOutput{Items: tables[*input.TableName]}, nil
+			}
+
+			trash, err := mockDao.GetTrash("owner@example.com")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(trash) != len(tc.wantIDs) {
+				t.Fatalf("expected %d items in the trash, got %d", len(tc.wantIDs), len(trash))
+			}
+			for i, item := range trash {
+				if item.ID != tc.wantIDs[i] {
+					t.Errorf("item %d: expected %q, got %q", i, tc.wantIDs[i], item.ID)
+				}
+				if item.PurgeAt <= item.DeletedAt {
+					t.Errorf("item %d: expected a purge time after %d, got %d", i, item.DeletedAt, item.PurgeAt)
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreFromTrash(t *testing.T) {
+	testCases := []struct {
+		name          string
+		itemType      string
+		id            string
+		wantErr       error
+		wantTables    []string
+		wantRestored  []string
+		parentDeleted bool
+		assocType     string
+		storedTypes   []string
+	}{
+		{
+			name:         "Story",
+			itemType:     models.TrashStory,
+			id:           "s1",
+			wantTables:   []string{"s1_c1_blocks" + GetTableSuffix()},
+			wantRestored: []string{"chapters", "associations", "association_details", "stories"},
+		},
+		{
+			name:          "ChapterOfDeletedStory",
+			itemType:      models.TrashChapter,
+			id:            "c1",
+			parentDeleted: true,
+			wantErr:       ErrParentInTrash,
+		},
+		{
+			name:         "Chapter",
+			itemType:     models.TrashChapter,
+			id:           "c1",
+			wantTables:   []string{"s1_c1_blocks" + GetTableSuffix()},
+			wantRestored: []string{"chapters"},
+		},
+		{
+			name:         "AssociationOfCustomType",
+			itemType:     models.TrashAssociation,
+			id:           "a1",
+			assocType:    "faction",
+			storedTypes:  []string{"faction"},
+			wantRestored: []string{"associations", "association_details"},
+		},
+		{
+			name:      "AssociationOfDeletedType",
+			itemType:  models.TrashAssociation,
+			id:        "a1",
+			assocType: "faction",
+			wantErr:   ErrTypeNotDefined,
+		},
+		{
+			name:      "StoryWithAssociationOfDeletedType",
+			itemType:  models.TrashStory,
+			id:        "s1",
+			assocType: "faction",
+			wantErr:   ErrTypeNotDefined,
+		},
+		{
+			name:     "UnknownType",
+			itemType: "comment",
+			id:       "x1",
+			wantErr:  ErrUnknownTrashType,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			mockDao := NewMockDAO()
+			mockClient := mockDao.DynamoClient.(*MockDynamoClient)
+			storyDeletedAt := ""
+			if tc.itemType == models.TrashStory || tc.parentDeleted {
+				storyDeletedAt = "100"
+			}
+			chapter := trashTestRow(map[string]string{"story_id": "s1", "chapter_id": "c1", "bup_arn": "arn:backup/c1"}, "100")
+			association := map[string]string{"story_or_series_id": "s1", "association_id": "a1"}
+			if tc.assocType != "" {
+				association["association_type"] = tc.assocType
+			}
+			tables := map[string][]map[string]types.AttributeValue{
+				"stories" + GetTableSuffix():      {trashTestRow(map[string]string{"story_id": "s1", "title": "Story"}, storyDeletedAt)},
+				"chapters" + GetTableSuffix():     {chapter},
+				"associations" + GetTableSuffix(): {trashTestRow(association, "100")},
+			}
+			for _, name := range tc.storedTypes {
+				tables["association_types"+GetTableSuffix()] = append(tables["association_types"+GetTableSuffix()],
+					trashTestRow(map[string]string{"type_name": name, "story_or_series_id": "s1", "author": "owner@example.com"}, ""))
+			}
+			mockClient.MockScan = func(ctx context.Context, input *dynamodb.ScanInput, opts ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+				return &dynamodb.ScanOutput{Items: tables[*input.TableName]}, nil
+			}
+			restoredTables := []string{}
+			mockClient.MockRestoreTableFromBackup = func(ctx context.Context, input *dynamodb.RestoreTableFromBackupInput, opts ...func(*dynamodb.Options)) (*dynamodb.RestoreTableFromBackupOutput, error) {
+				if *input.BackupArn != "arn:backup/c1" {
+					t.Errorf("expected the chapter's backup restored, got %q", *input.BackupArn)
+				}
+				restoredTables = append(restoredTables, *input.TargetTableName)
+				return &dynamodb.RestoreTableFromBackupOutput{}, nil
+			}
+			restoredRows := []string{}
+			mockClient.MockUpdateItem = func(ctx context.Context, input *dynamodb.UpdateItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+				restoredRows = append(restoredRows, (*input.TableName)[:len(*input.TableName)-len(GetTableSuffix())])
+				return &dynamodb.UpdateItemOutput{}, nil
+			}
+
+			err := mockDao.RestoreFromTrash("owner@example.com", tc.itemType, tc.id)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+				}
+				if len(restoredRows) != 0 || len(restoredTables) != 0 {
+					t.Errorf("expected nothing restored, got rows %v and tables %v", restoredRows, restoredTables)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(restoredTables) != len(tc.wantTables) || (len(tc.wantTables) > 0 && restoredTables[0] != tc.wantTables[0]) {
+				t.Errorf("expected tables %v restored, got %v", tc.wantTables, restoredTables)
+			}
+			if len(restoredRows) != len(tc.wantRestored) {
+				t.Fatalf("expected rows %v restored, got %v", tc.wantRestored, restoredRows)
+			}
+			for i, table := range tc.wantRestored {
+				if restoredRows[i] != table {
+					t.Errorf("restore %d: expected %s, got %s", i, table, restoredRows[i])
+				}
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package daos
 import (
 	"RichDocter/models"
 	"context"
+	"errors"
 	"fmt"
 	"strconv"
 	"time"
@@ -13,6 +14,12 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 )
 
+var (
+	ErrUnknownCustomer        = errors.New("no user found for stripe customer")
+	ErrStaleSubscriptionEvent = errors.New("a newer subscription event has already been applied")
+	ErrAICallLimitReached     = errors.New("monthly AI call limit reached")
+)
+
 func (d *DAO) CreateUser(email string) error {
 	twii := &dynamodb.TransactWriteItemsInput{}
 	now := strconv.FormatInt(time.Now().Unix(), 10)
@@ -97,10 +104,11 @@ func (d *DAO) UpdateUser(user models.UserInfo) (err error) {
 			"email": &types.AttributeValueMemberS{Value: user.Email},
 		},
 		ReturnValues:     types.ReturnValueUpdatedNew,
-		UpdateExpression: aws.String("set last_accessed=:t, customer_id=:cid, subscription_id=:sid, expired=:e, renewing=:r"),
+		UpdateExpression: aws.String("set last_accessed=:t, customer_id=:cid, subscription_id=:sid, price_id=:pid, expired=:e, renewing=:r"),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
 			":t":   &types.AttributeValueMemberN{Value: now},
 			":sid": &types.AttributeValueMemberS{Value: user.SubscriptionID},
+			":pid": &types.AttributeValueMemberS{Value: user.PriceID},
 			":cid": &types.AttributeValueMemberS{Value: user.CustomerID},
 			":r":   &types.AttributeValueMemberBOOL{Value: user.Renewing},
 			":e":   &types.AttributeValueMemberBOOL{Value: user.Expired},
@@ -147,3 +155,165 @@ func (d *DAO) AddCustomerID(email, customerID *string) error {
 	}
 	return nil
 }
+
+// GetUserByCustomerID finds the user a Stripe customer belongs to
+func (d *DAO) GetUserByCustomerID(customerID string) (*models.UserInfo, error) {
+	items, err := d.scanItems(&dynamodb.ScanInput{
+		TableName:        aws.String("users" + GetTableSuffix()),
+		FilterExpression: aws.String("customer_id=:cid"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":cid": &types.AttributeValueMemberS{Value: customerID},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	users := []models.UserInfo{}
+	if err = attributevalue.UnmarshalListOfMaps(items, &users); err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, ErrUnknownCustomer
+	}
+	return &users[0], nil
+}
+
+// UpdateSubscriptionState records the subscription Stripe reported for the user, unless an event
+// Stripe created later has already been recorded
+func (d *DAO) UpdateSubscriptionState(email string, state models.SubscriptionState) error {
+	eventAt := strconv.FormatInt(state.EventAt, 10)
+	update := "set subscription_id=:sid, expired=:e, renewing=:r, subscription_event_at=:at"
+	values := map[string]types.AttributeValue{
+		":sid": &types.AttributeValueMemberS{Value: state.SubscriptionID},
+		":e":   &types.AttributeValueMemberBOOL{Value: state.Expired},
+		":r":   &types.AttributeValueMemberBOOL{Value: state.Renewing},
+		":at":  &types.AttributeValueMemberN{Value: eventAt},
+	}
+	// not every event names the price, those that don't leave the plan as it was
+	if state.PriceID != "" {
+		update += ", price_id=:pid"
+		values[":pid"] = &types.AttributeValueMemberS{Value: state.PriceID}
+	}
+	_, err := d.DynamoClient.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String("users" + GetTableSuffix()),
+		Key: map[string]types.AttributeValue{
+			"email": &types.AttributeValueMemberS{Value: email},
+		},
+		UpdateExpression:          aws.String(update),
+		ConditionExpression:       aws.String("attribute_exists(email) AND (attribute_not_exists(subscription_event_at) OR subscription_event_at <= :at)"),
+		ExpressionAttributeValues: values,
+	})
+	if isConditionalCheckFailure(err) {
+		return ErrStaleSubscriptionEvent
+	}
+	return err
+}
+
+// RecordAICall counts an AI call against the user's allowance for the month, given as YYYY-MM, and
+// refuses it once the limit is reached. The count starts over with each month.
+func (d *DAO) RecordAICall(email, month string, limit int) error {
+	if limit == 0 {
+		return ErrAICallLimitReached
+	}
+	key := map[string]types.AttributeValue{
+		"email": &types.AttributeValueMemberS{Value: email},
+	}
+	countCondition := "ai_calls_month = :m"
+	countValues := map[string]types.AttributeValue{
+		":m":   &types.AttributeValueMemberS{Value: month},
+		":one": &types.AttributeValueMemberN{Value: "1"},
+	}
+	if limit != models.Unlimited {
+		countCondition += " AND ai_calls < :limit"
+		countValues[":limit"] = &types.AttributeValueMemberN{Value: strconv.Itoa(limit)}
+	}
+	// a second pass covers a concurrent first call of the month starting the count
+	for attempt := 0; attempt < 2; attempt++ {
+		_, err := d.DynamoClient.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+			TableName:                 aws.String("users" + GetTableSuffix()),
+			Key:                       key,
+			UpdateExpression:          aws.String("set ai_calls = ai_calls + :one"),
+			ConditionExpression:       aws.String(countCondition),
+			ExpressionAttributeValues: countValues,
+		})
+		if !isConditionalCheckFailure(err) {
+			return err
+		}
+		_, err = d.DynamoClient.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+			TableName:           aws.String("users" + GetTableSuffix()),
+			Key:                 key,
+			UpdateExpression:    aws.String("set ai_calls_month = :m, ai_calls = :one"),
+			ConditionExpression: aws.String("attribute_exists(email) AND (attribute_not_exists(ai_calls_month) OR ai_calls_month <> :m)"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":m":   &types.AttributeValueMemberS{Value: month},
+				":one": &types.AttributeValueMemberN{Value: "1"},
+			},
+		})
+		if !isConditionalCheckFailure(err) {
+			return err
+		}
+	}
+	return ErrAICallLimitReached
+}
+
+// RefundAICall hands back a call counted by RecordAICall that didn't go through. A count that has
+// since started over for a new month is left alone.
+func (d *DAO) RefundAICall(email, month string) error {
+	_, err := d.DynamoClient.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String("users" + GetTableSuffix()),
+		Key: map[string]types.AttributeValue{
+			"email": &types.AttributeValueMemberS{Value: email},
+		},
+		UpdateExpression:    aws.String("set ai_calls = ai_calls - :one"),
+		ConditionExpression: aws.String("ai_calls_month = :m AND ai_calls > :zero"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":m":    &types.AttributeValueMemberS{Value: month},
+			":one":  &types.AttributeValueMemberN{Value: "1"},
+			":zero": &types.AttributeValueMemberN{Value: "0"},
+		},
+	})
+	if isConditionalCheckFailure(err) {
+		return nil
+	}
+	return err
+}
+
+// InitStorageBytes starts a user's running total of uploaded image bytes, counted from what they
+// uploaded before the total was kept. A total already started is left alone.
+func (d *DAO) InitStorageBytes(email string, total int64) error {
+	_, err := d.DynamoClient.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String("users" + GetTableSuffix()),
+		Key: map[string]types.AttributeValue{
+			"email": &types.AttributeValueMemberS{Value: email},
+		},
+		UpdateExpression:    aws.String("set storage_bytes = :b"),
+		ConditionExpression: aws.String("attribute_exists(email) AND attribute_not_exists(storage_bytes)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":b": &types.AttributeValueMemberN{Value: strconv.FormatInt(total, 10)},
+		},
+	})
+	if isConditionalCheckFailure(err) {
+		return nil
+	}
+	return err
+}
+
+// AddStorageBytes adjusts a user's running total of uploaded image bytes, negative deltas release
+// space when images are replaced or deleted
+func (d *DAO) AddStorageBytes(email string, delta int64) error {
+	if delta == 0 {
+		return nil
+	}
+	_, err := d.DynamoClient.UpdateItem(context.TODO(), &dynamodb.UpdateItemInput{
+		TableName: aws.String("users" + GetTableSuffix()),
+		Key: map[string]types.AttributeValue{
+			"email": &types.AttributeValueMemberS{Value: email},
+		},
+		UpdateExpression:    aws.String("ADD storage_bytes :d"),
+		ConditionExpression: aws.String("attribute_exists(email)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":d": &types.AttributeValueMemberN{Value: strconv.FormatInt(delta, 10)},
+		},
+	})
+	return err
+}
@@ -0,0 +1,284 @@
+package daos
+
+import (
+	"RichDocter/models"
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+func TestUpdateSubscriptionState(t *testing.T) {
+	testCases := []struct {
+		name    string
+		state   models.SubscriptionState
+		stale   bool
+		wantErr error
+	}{
+		{
+			name:  "Renewed",
+			state: models.SubscriptionState{SubscriptionID: "sub_1", Renewing: true, EventAt: 200},
+		},
+		{
+			name:  "Lapsed",
+			state: models.SubscriptionState{Expired: true, EventAt: 300},
+		},
+		{
+			name:    "OlderThanLastApplied",
+			state:   models.SubscriptionState{Expired: true, EventAt: 100},
+			stale:   true,
+			wantErr: ErrStaleSubscriptionEvent,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			mockDao := NewMockDAO()
+			mockClient := mockDao.DynamoClient.(*MockDynamoClient)
+			var update *dynamodb.UpdateItemInput
+			mockClient.MockUpdateItem = func(ctx context.Context, input *dynamodb.UpdateItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+				update = input
+				if tc.stale {
+					return nil, &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
+				}
+				return &dynamodb.UpdateItemOutput{}, nil
+			}
+
+			err := mockDao.UpdateSubscriptionState("owner@example.com", tc.state)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if update.ConditionExpression == nil {
+				t.Fatalf("expected the update to be conditioned on the last applied event")
+			}
+			values := update.ExpressionAttributeValues
+			if got := values[":sid"].(*types.AttributeValueMemberS).Value; got != tc.state.SubscriptionID {
+				t.Errorf("expected subscription %q, got %q", tc.state.SubscriptionID, got)
+			}
+			if got := values[":e"].(*types.AttributeValueMemberBOOL).Value; got != tc.state.Expired {
+				t.Errorf("expected expired %v, got %v", tc.state.Expired, got)
+			}
+			if got := values[":r"].(*types.AttributeValueMemberBOOL).Value; got != tc.state.Renewing {
+				t.Errorf("expected renewing %v, got %v", tc.state.Renewing, got)
+			}
+		})
+	}
+}
+
+func TestGetUserByCustomerID(t *testing.T) {
+	testCases := []struct {
+		name      string
+		users     []map[string]types.AttributeValue
+		wantEmail string
+		wantErr   error
+	}{
+		{
+			name: "Found",
+			users: []map[string]types.AttributeValue{{
+				"email":       &types.AttributeValueMemberS{Value: "owner@example.com"},
+				"customer_id": &types.AttributeValueMemberS{Value: "cus_1"},
+			}},
+			wantEmail: "owner@example.com",
+		},
+		{
+			name:    "Unknown",
+			wantErr: ErrUnknownCustomer,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			mockDao := NewMockDAO()
+			mockClient := mockDao.DynamoClient.(*MockDynamoClient)
+			mockClient.MockScan = func(ctx context.Context, input *dynamodb.ScanInput, opts ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+				if got := input.ExpressionAttributeValues[":cid"].(*types.AttributeValueMemberS).Value; got != "cus_1" {
+					t.Errorf("expected a scan for cus_1, got %q", got)
+				}
+				return &dynamodb.ScanOutput{Items: tc.users}, nil
+			}
+
+			user, err := mockDao.GetUserByCustomerID("cus_1")
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if tc.wantErr == nil && user.Email != tc.wantEmail {
+				t.Errorf("expected %q, got %q", tc.wantEmail, user.Email)
+			}
+		})
+	}
+}
+
+func TestRecordAICall(t *testing.T) {
+	testCases := []struct {
+		name        string
+		limit       int
+		storedMonth string
+		storedCalls int
+		wantCalls   int
+		wantUpdates int
+		wantErr     error
+	}{
+		{name: "WithinAllowance", limit: 3, storedMonth: "2026-10", storedCalls: 1, wantCalls: 2, wantUpdates: 1},
+		{name: "FirstCallOfTheMonth", limit: 3, storedMonth: "2026-09", storedCalls: 3, wantCalls: 1, wantUpdates: 2},
+		{name: "AllowanceUsed", limit: 3, storedMonth: "2026-10", storedCalls: 3, wantCalls: 3, wantUpdates: 4, wantErr: ErrAICallLimitReached},
+		{name: "Unlimited", limit: models.Unlimited, storedMonth: "2026-10", storedCalls: 500, wantCalls: 501, wantUpdates: 1},
+		{name: "NoAllowance", limit: 0, wantUpdates: 0, wantErr: ErrAICallLimitReached},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			mockDao := NewMockDAO()
+			mockClient := mockDao.DynamoClient.(*MockDynamoClient)
+			month, calls, updates := tc.storedMonth, tc.storedCalls, 0
+			conditionFailed := &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
+			mockClient.MockUpdateItem = func(ctx context.Context, input *dynamodb.UpdateItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+				updates++
+				values := input.ExpressionAttributeValues
+				requested := values[":m"].(*types.AttributeValueMemberS).Value
+				if _, counting := values[":one"]; counting && *input.UpdateExpression == "set ai_calls = ai_calls + :one" {
+					if month != requested {
+						return nil, conditionFailed
+					}
+					if _, limited := values[":limit"]; limited && calls >= tc.limit {
+						return nil, conditionFailed
+					}
+					calls++
+					return &dynamodb.UpdateItemOutput{}, nil
+				}
+				if month == requested {
+					return nil, conditionFailed
+				}
+				month, calls = requested, 1
+				return &dynamodb.UpdateItemOutput{}, nil
+			}
+
+			err := mockDao.RecordAICall("owner@example.com", "2026-10", tc.limit)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if calls != tc.wantCalls {
+				t.Errorf("expected %d calls counted, got %d", tc.wantCalls, calls)
+			}
+			if updates != tc.wantUpdates {
+				t.Errorf("expected %d updates, got %d", tc.wantUpdates, updates)
+			}
+		})
+	}
+}
+
+func TestRefundAICall(t *testing.T) {
+	testCases := []struct {
+		name        string
+		storedMonth string
+		storedCalls int
+		wantCalls   int
+	}{
+		{name: "Refunded", storedMonth: "2026-10", storedCalls: 2, wantCalls: 1},
+		{name: "MonthStartedOver", storedMonth: "2026-11", storedCalls: 2, wantCalls: 2},
+		{name: "NothingCounted", storedMonth: "2026-10", storedCalls: 0, wantCalls: 0},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			mockDao := NewMockDAO()
+			mockClient := mockDao.DynamoClient.(*MockDynamoClient)
+			calls := tc.storedCalls
+			mockClient.MockUpdateItem = func(ctx context.Context, input *dynamodb.UpdateItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+				if input.ExpressionAttributeValues[":m"].(*types.AttributeValueMemberS).Value != tc.storedMonth || calls <= 0 {
+					return nil, &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
+				}
+				calls--
+				return &dynamodb.UpdateItemOutput{}, nil
+			}
+
+			if err := mockDao.RefundAICall("owner@example.com", "2026-10"); err != nil {
+				t.Fatal(err)
+			}
+			if calls != tc.wantCalls {
+				t.Errorf("expected %d calls counted, got %d", tc.wantCalls, calls)
+			}
+		})
+	}
+}
+
+func TestStorageBytes(t *testing.T) {
+	testCases := []struct {
+		name        string
+		stored      *int64
+		init        int64
+		deltas      []int64
+		wantStored  int64
+		wantUpdates int
+	}{
+		{name: "FirstCount", init: 500, wantStored: 500, wantUpdates: 1},
+		{name: "AlreadyCounted", stored: aws.Int64(200), init: 500, wantStored: 200, wantUpdates: 1},
+		{name: "UploadAndRelease", stored: aws.Int64(200), deltas: []int64{300, -100}, wantStored: 400, wantUpdates: 2},
+		{name: "NothingChanged", stored: aws.Int64(200), deltas: []int64{0}, wantStored: 200},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			teardown := setupTest(t, tc.name)
+			defer teardown()
+
+			mockDao := NewMockDAO()
+			mockClient := mockDao.DynamoClient.(*MockDynamoClient)
+			stored, updates := tc.stored, 0
+			mockClient.MockUpdateItem = func(ctx context.Context, input *dynamodb.UpdateItemInput, opts ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+				updates++
+				if input.Key["email"].(*types.AttributeValueMemberS).Value != "owner@example.com" {
+					t.Errorf("expected the owner's row updated, got %v", input.Key["email"])
+				}
+				if value, starting := input.ExpressionAttributeValues[":b"]; starting {
+					if stored != nil {
+						return nil, &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
+					}
+					total, _ := strconv.ParseInt(value.(*types.AttributeValueMemberN).Value, 10, 64)
+					stored = &total
+					return &dynamodb.UpdateItemOutput{}, nil
+				}
+				delta, _ := strconv.ParseInt(input.ExpressionAttributeValues[":d"].(*types.AttributeValueMemberN).Value, 10, 64)
+				*stored += delta
+				return &dynamodb.UpdateItemOutput{}, nil
+			}
+
+			if tc.deltas == nil {
+				if err := mockDao.InitStorageBytes("owner@example.com", tc.init); err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+			}
+			for _, delta := range tc.deltas {
+				if err := mockDao.AddStorageBytes("owner@example.com", delta); err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+			}
+			if stored == nil || *stored != tc.wantStored {
+				t.Errorf("expected %d bytes stored, got %v", tc.wantStored, stored)
+			}
+			if updates != tc.wantUpdates {
+				t.Errorf("expected %d updates, got %d", tc.wantUpdates, updates)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+// build: GOOS=linux GOARCH=arm64 go build -tags lambda.norpc -o bootstrap main.go
+// zip: zip blockRanks.zip bootstrap
+
+// Gives every block in the per-chapter block tables a rank worked out from its place, then adds the
+// story_id-rank-index the API reads chapters through. Run it after the API that writes ranks is
+// deployed; until a table has the index the API keeps reading it by place. Safe to run again.
+package main
+
+import (
+	"RichDocter/models"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const rankIndex = "story_id-rank-index"
+
+func handler(ctx context.Context) (string, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return "", fmt.Errorf("unable to load AWS SDK config: %w", err)
+	}
+	client := ddb.NewFromConfig(cfg)
+
+	// List all DynamoDB tables.
+	var tables []string
+	var lastEvaluatedTableName *string
+	for {
+		out, err := client.ListTables(ctx, &ddb.ListTablesInput{
+			ExclusiveStartTableName: lastEvaluatedTableName,
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to list tables: %w", err)
+		}
+		tables = append(tables, out.TableNames...)
+		if out.LastEvaluatedTableName == nil {
+			break
+		}
+		lastEvaluatedTableName = out.LastEvaluatedTableName
+	}
+
+	migrated := 0
+	for _, tableName := range tables {
+		if !strings.HasSuffix(tableName, "_blocks") && !strings.HasSuffix(tableName, "_blocks_staging") {
+			continue
+		}
+		if err := rankBlocks(ctx, client, tableName); err != nil {
+			log.Printf("Failed to rank blocks in %q: %v", tableName, err)
+			continue
+		}
+		if err := addRankIndex(ctx, client, tableName); err != nil {
+			log.Printf("Failed to add %s to %q: %v", rankIndex, tableName, err)
+			continue
+		}
+		migrated++
+	}
+	return fmt.Sprintf("Migrated %d block tables", migrated), nil
+}
+
+// rankBlocks sets a rank on every block that doesn't have one yet
+func rankBlocks(ctx context.Context, client *ddb.Client, tableName string) error {
+	paginator := ddb.NewScanPaginator(client, &ddb.ScanInput{
+		TableName:        aws.String(tableName),
+		FilterExpression: aws.String("attribute_not_exists(#rank)"),
+		ExpressionAttributeNames: map[string]string{
+			"#rank": "rank",
+		},
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, item := range page.Items {
+			keyID, ok := item["key_id"].(*types.AttributeValueMemberS)
+			if !ok {
+				log.Printf("Skipping item with no key_id in %q: %v", tableName, item)
+				continue
+			}
+			place, ok := item["place"].(*types.AttributeValueMemberN)
+			if !ok {
+				log.Printf("Skipping block %s in %q, it has no place", keyID.Value, tableName)
+				continue
+			}
+			rank, err := models.RankFromPlace(place.Value)
+			if err != nil {
+				log.Printf("Skipping block %s in %q: %v", keyID.Value, tableName, err)
+				continue
+			}
+			_, err = client.UpdateItem(ctx, &ddb.UpdateItemInput{
+				TableName: aws.String(tableName),
+				Key: map[string]types.AttributeValue{
+					"key_id": keyID,
+				},
+				UpdateExpression: aws.String("SET #rank = :r"),
+				// a client may have moved the block since the scan
+				ConditionExpression: aws.String("attribute_exists(key_id) AND attribute_not_exists(#rank)"),
+				ExpressionAttributeNames: map[string]string{
+					"#rank": "rank",
+				},
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":r": &types.AttributeValueMemberS{Value: rank},
+				},
+			})
+			var conditionErr *types.ConditionalCheckFailedException
+			if err != nil && !errors.As(err, &conditionErr) {
+				return fmt.Errorf("failed to rank block %s: %w", keyID.Value, err)
+			}
+		}
+	}
+	return nil
+}
+
+func addRankIndex(ctx context.Context, client *ddb.Client, tableName string) error {
+	table, err := client.DescribeTable(ctx, &ddb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	})
+	if err != nil {
+		return err
+	}
+	for _, index := range table.Table.GlobalSecondaryIndexes {
+		if aws.ToString(index.IndexName) == rankIndex {
+			log.Printf("%q already has %s", tableName, rankIndex)
+			return nil
+		}
+	}
+	update := &ddb.UpdateTableInput{
+		TableName: aws.String(tableName),
+		AttributeDefinitions: []types.AttributeDefinition{
+			{AttributeName: aws.String("story_id"), AttributeType: types.ScalarAttributeTypeS},
+			{AttributeName: aws.String("rank"), AttributeType: types.ScalarAttributeTypeS},
+		},
+		GlobalSecondaryIndexUpdates: []types.GlobalSecondaryIndexUpdate{
+			{
+				Create: &types.CreateGlobalSecondaryIndexAction{
+					IndexName: aws.String(rankIndex),
+					KeySchema: []types.KeySchemaElement{
+						{AttributeName: aws.String("story_id"), KeyType: types.KeyTypeHash},
+						{AttributeName: aws.String("rank"), KeyType: types.KeyTypeRange},
+					},
+					Projection: &types.Projection{
+						ProjectionType: types.ProjectionTypeAll,
+					},
+				},
+			},
+		},
+	}
+	// provisioned tables need throughput for the new index too
+	if table.Table.BillingModeSummary == nil || table.Table.BillingModeSummary.BillingMode != types.BillingModePayPerRequest {
+		if throughput := table.Table.ProvisionedThroughput; throughput != nil {
+			update.GlobalSecondaryIndexUpdates[0].Create.ProvisionedThroughput = &types.ProvisionedThroughput{
+				ReadCapacityUnits:  throughput.ReadCapacityUnits,
+				WriteCapacityUnits: throughput.WriteCapacityUnits,
+			}
+		}
+	}
+	if _, err = client.UpdateTable(ctx, update); err != nil {
+		return err
+	}
+	log.Printf("Adding %s to %q", rankIndex, tableName)
+	return nil
+}
+
+func main() {
+	lambda.Start(handler)
+}
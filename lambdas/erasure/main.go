@@ -0,0 +1,44 @@
+// build: GOOS=linux GOARCH=arm64 go build -tags lambda.norpc -o bootstrap main.go
+// zip: zip accountErasure.zip bootstrap
+
+// Erases the accounts whose erasure grace period has run out, cancelling their Stripe subscription and
+// customer, removing their uploads and deleting everything stored about them. Schedule it daily; an
+// account that fails part way through stays scheduled and is picked up by the next run.
+package main
+
+import (
+	"RichDocter/api"
+	"RichDocter/daos"
+	"RichDocter/payments"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+func handler(ctx context.Context) (string, error) {
+	dao := daos.NewDAO()
+	billingClient := payments.NewStripeClient(os.Getenv("STRIPE_SECRET"), "")
+	users, err := dao.GetDueAccountErasures(time.Now().Unix())
+	if err != nil {
+		return "", fmt.Errorf("failed to list due erasures: %w", err)
+	}
+	erased := 0
+	for _, user := range users {
+		erasure, err := api.EraseAccount(dao, billingClient, user)
+		if err != nil {
+			log.Printf("Failed to carry out erasure %s: %v", user.ErasureID, err)
+			continue
+		}
+		log.Printf("Completed erasure %s: %v", erasure.ID, erasure.Erased)
+		erased++
+	}
+	return fmt.Sprintf("Erased %d of %d due accounts", erased, len(users)), nil
+}
+
+func main() {
+	lambda.Start(handler)
+}
@@ -25,7 +25,10 @@ type Chunk struct {
 type StoryBlock struct {
 	KeyID string          `json:"key_id" dynamodbav:"key_id"`
 	Chunk json.RawMessage `json:"chunk" dynamodbav:"chunk"`
-	Place string          `json:"place" dynamodbav:"place"`
+	Place string          `json:"place,omitempty" dynamodbav:"place,omitempty"`
+	// Rank orders blocks within a chapter, see RankBetween. Blocks sent with only a place are ranked from it.
+	Rank    string `json:"rank,omitempty" dynamodbav:"rank,omitempty"`
+	Version int64  `json:"version" dynamodbav:"version"`
 }
 type StoryBlocks struct {
 	StoryID   string       `json:"story_id" dynamodbav:"story_id"`
@@ -33,10 +36,36 @@ type StoryBlocks struct {
 	Blocks    []StoryBlock `json:"blocks" dynamodbav:"blocks"`
 }
 
+// BlockPatch carries everything one save changes in a chapter. Deletes only need key_id and
+// version, reorders only key_id and rank.
+type BlockPatch struct {
+	Inserts  []StoryBlock `json:"inserts"`
+	Updates  []StoryBlock `json:"updates"`
+	Deletes  []StoryBlock `json:"deletes"`
+	Reorders []StoryBlock `json:"reorders"`
+}
+
 type AssociationDetails struct {
-	ExtendedDescription string `json:"extended_description" dynamodbav:"extended_description"`
-	CaseSensitive       bool   `json:"case_sensitive" dynamodbav:"case_sensitive"`
-	Aliases             string `json:"aliases" dynamodbav:"aliases"`
+	ExtendedDescription string                 `json:"extended_description" dynamodbav:"extended_description"`
+	CaseSensitive       bool                   `json:"case_sensitive" dynamodbav:"case_sensitive"`
+	Aliases             string                 `json:"aliases" dynamodbav:"aliases"`
+	CustomFields        map[string]interface{} `json:"custom_fields,omitempty" dynamodbav:"custom_fields,omitempty"`
+}
+
+type AssociationFieldDefinition struct {
+	Key      string   `json:"key" dynamodbav:"key"`
+	Label    string   `json:"label" dynamodbav:"label"`
+	Type     string   `json:"field_type" dynamodbav:"field_type"`
+	Required bool     `json:"required" dynamodbav:"required"`
+	Options  []string `json:"options,omitempty" dynamodbav:"options,omitempty"`
+}
+
+type AssociationType struct {
+	Name            string                       `json:"type_name" dynamodbav:"type_name"`
+	StoryOrSeriesID string                       `json:"story_or_series_id" dynamodbav:"story_or_series_id"`
+	DefaultPortrait string                       `json:"default_portrait" dynamodbav:"default_portrait"`
+	BuiltIn         bool                         `json:"built_in" dynamodbav:"-"`
+	Fields          []AssociationFieldDefinition `json:"fields" dynamodbav:"fields"`
 }
 
 type Association struct {
@@ -46,6 +75,64 @@ type Association struct {
 	Portrait         string             `json:"portrait" dynamodbav:"portrait"`
 	ShortDescription string             `json:"short_description" dynamodbav:"short_description"`
 	Details          AssociationDetails `json:"details"`
+	OverriddenFields []string           `json:"overridden_fields,omitempty" dynamodbav:"-"`
+}
+
+// AssociationOverride holds the per-volume state of a series association; blank values inherit from the series bible
+type AssociationOverride struct {
+	AssociationID       string                 `json:"association_id" dynamodbav:"association_id"`
+	StoryID             string                 `json:"story_id" dynamodbav:"story_id"`
+	SeriesID            string                 `json:"series_id" dynamodbav:"series_id"`
+	Name                string                 `json:"association_name,omitempty" dynamodbav:"association_name,omitempty"`
+	Portrait            string                 `json:"portrait,omitempty" dynamodbav:"portrait,omitempty"`
+	ShortDescription    string                 `json:"short_description,omitempty" dynamodbav:"short_description,omitempty"`
+	ExtendedDescription string                 `json:"extended_description,omitempty" dynamodbav:"extended_description,omitempty"`
+	Aliases             string                 `json:"aliases,omitempty" dynamodbav:"aliases,omitempty"`
+	CustomFields        map[string]interface{} `json:"custom_fields,omitempty" dynamodbav:"custom_fields,omitempty"`
+}
+
+type AssociationVolumeState struct {
+	StoryID     string       `json:"story_id"`
+	Title       string       `json:"title"`
+	Place       int          `json:"place"`
+	Association *Association `json:"association"`
+}
+
+// AssociationTransfer moves or copies associations from one story or series to another
+type AssociationTransfer struct {
+	SourceID       string   `json:"source_id"`
+	TargetID       string   `json:"target_id"`
+	Mode           string   `json:"mode"`
+	OnConflict     string   `json:"on_conflict"`
+	AssociationIDs []string `json:"association_ids,omitempty"`
+}
+
+type AssociationConflict struct {
+	SourceID   string `json:"source_id"`
+	TargetID   string `json:"target_id"`
+	Name       string `json:"association_name"`
+	Type       string `json:"association_type"`
+	Resolution string `json:"resolution"`
+}
+
+type AssociationTransferResult struct {
+	Transferred []*Association        `json:"transferred"`
+	Conflicts   []AssociationConflict `json:"conflicts"`
+	// IDMap maps each source association id onto the id it has in the target
+	IDMap map[string]string `json:"id_map"`
+}
+
+// AssociationImportReport describes what an association import changed, or would change on a dry run
+type AssociationImportReport struct {
+	DryRun    bool                      `json:"dry_run"`
+	Created   []*Association            `json:"created"`
+	Updated   []AssociationImportChange `json:"updated"`
+	Unchanged []string                  `json:"unchanged"`
+}
+
+type AssociationImportChange struct {
+	Association   *Association `json:"association"`
+	ChangedFields []string     `json:"changed_fields"`
 }
 
 type SimplifiedAssociation struct {
@@ -58,12 +145,51 @@ type SimplifiedAssociation struct {
 	Aliases          string `json:"aliases" dynamodbav:"aliases"`
 }
 
+type AssociationRelationship struct {
+	ID              string `json:"relationship_id" dynamodbav:"relationship_id"`
+	StoryOrSeriesID string `json:"story_or_series_id" dynamodbav:"story_or_series_id"`
+	SourceID        string `json:"source_id" dynamodbav:"source_id"`
+	TargetID        string `json:"target_id" dynamodbav:"target_id"`
+	Type            string `json:"relationship_type" dynamodbav:"relationship_type"`
+	Directional     bool   `json:"directional" dynamodbav:"directional"`
+	Description     string `json:"description" dynamodbav:"description"`
+}
+
+type RelationshipNode struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Portrait string `json:"portrait"`
+}
+
+type RelationshipGraph struct {
+	Nodes []RelationshipNode        `json:"nodes"`
+	Edges []AssociationRelationship `json:"edges"`
+}
+
 type Chapter struct {
 	ID        string `json:"id" dynamodbav:"chapter_id"`
 	StoryID   string `json:"story_id" dynamodbav:"story_id"`
 	Place     int    `json:"place" dynamodbav:"chapter_num"`
 	Title     string `json:"title" dynamodbav:"title"`
 	BackupARN string `dynamodbav:"bup_arn"`
+	Version   int64  `json:"version" dynamodbav:"version"`
+}
+
+// ChapterRestructure reports the outcome of splitting, merging or moving a chapter. Chapters lists the
+// story's chapters afterwards and Blocks the blocks that changed chapter, as they are now stored.
+type ChapterRestructure struct {
+	Chapters []Chapter    `json:"chapters"`
+	Created  *Chapter     `json:"created,omitempty"`
+	Removed  *Chapter     `json:"removed,omitempty"`
+	Blocks   []StoryBlock `json:"blocks"`
+}
+
+// StoryDuplicate asks for a copy of a story or series. A blank title names the copy after the original.
+// SameSeries puts the copy of a volume at the end of its series instead of making it standalone.
+type StoryDuplicate struct {
+	Title      string `json:"title"`
+	SameSeries bool   `json:"same_series"`
 }
 
 type ChapterWithContents struct {
@@ -80,7 +206,59 @@ type Story struct {
 	Chapters    []Chapter `json:"chapters"`
 	Place       int       `json:"place"`
 	ImageURL    string    `json:"image_url" dynamodbav:"image_url"`
+	Version     int64     `json:"version" dynamodbav:"version"`
 }
+
+// ShareLink grants read-only access to a story, or a subset of its chapters, without an account
+type ShareLink struct {
+	Token         string   `json:"share_token" dynamodbav:"share_token"`
+	StoryID       string   `json:"story_id" dynamodbav:"story_id"`
+	Author        string   `json:"-" dynamodbav:"author"`
+	ChapterIDs    []string `json:"chapter_ids" dynamodbav:"chapter_ids"`
+	CreatedAt     int64    `json:"created_at" dynamodbav:"created_at"`
+	ExpiresAt     int64    `json:"expires_at,omitempty" dynamodbav:"expires_at,omitempty"`
+	Password      string   `json:"password,omitempty" dynamodbav:"-"`
+	PasswordHash  string   `json:"-" dynamodbav:"password_hash,omitempty"`
+	HasPassword   bool     `json:"has_password" dynamodbav:"-"`
+	AllowComments bool     `json:"allow_comments" dynamodbav:"allow_comments"`
+}
+
+type SharedChapter struct {
+	ID     string            `json:"id"`
+	Title  string            `json:"title"`
+	Place  int               `json:"place"`
+	HTML   string            `json:"html"`
+	Chunks []json.RawMessage `json:"-"`
+}
+
+type SharedStory struct {
+	Title       string          `json:"title"`
+	Description string          `json:"description"`
+	ImageURL    string          `json:"image_url"`
+	Chapters    []SharedChapter `json:"chapters"`
+}
+
+// Comment is anchored to a block key and character range so it follows the block through reordering.
+// Replies carry a ParentID and inherit the anchor of the comment that started the thread.
+type Comment struct {
+	ID             string     `json:"comment_id" dynamodbav:"comment_id"`
+	StoryID        string     `json:"story_id" dynamodbav:"story_id"`
+	ChapterID      string     `json:"chapter_id" dynamodbav:"chapter_id"`
+	KeyID          string     `json:"key_id" dynamodbav:"key_id"`
+	Start          int        `json:"start" dynamodbav:"start"`
+	End            int        `json:"end" dynamodbav:"end"`
+	ParentID       string     `json:"parent_id,omitempty" dynamodbav:"parent_id,omitempty"`
+	Body           string     `json:"body" dynamodbav:"body"`
+	Owner          string     `json:"-" dynamodbav:"author"`
+	CommenterName  string     `json:"commenter_name" dynamodbav:"commenter_name"`
+	CommenterEmail string     `json:"commenter_email,omitempty" dynamodbav:"commenter_email,omitempty"`
+	ShareToken     string     `json:"-" dynamodbav:"share_token,omitempty"`
+	Resolved       bool       `json:"resolved" dynamodbav:"resolved"`
+	ResolvedAt     int64      `json:"resolved_at,omitempty" dynamodbav:"resolved_at,omitempty"`
+	CreatedAt      int64      `json:"created_at" dynamodbav:"created_at"`
+	Replies        []*Comment `json:"replies,omitempty" dynamodbav:"-"`
+}
+
 type BlocksData struct {
 	LastEvaluated map[string]types.AttributeValue   `json:"last_evaluated_key"`
 	ScannedCount  int32                             `json:"scanned_count"`
@@ -99,6 +277,7 @@ type Series struct {
 	Stories     []*Story  `json:"stories"`
 	CreatedAt   time.Time `json:"created_at" dynamodbav:"created_at"`
 	ImageURL    string    `json:"image_url" dynamodbav:"image_url"`
+	Version     int64     `json:"version" dynamodbav:"version"`
 }
 
 type UserInfo struct {
@@ -107,9 +286,16 @@ type UserInfo struct {
 	Admin          bool   `json:"admin" dynamodbav:"admin"`
 	SubscriptionID string `json:"subscription_id" dynamodbav:"subscription_id"`
 	CustomerID     string `json:"customer_id" dynamodbav:"customer_id"`
+	PriceID        string `json:"price_id,omitempty" dynamodbav:"price_id,omitempty"`
 	Expired        bool   `json:"expired" dynamodbav:"expired"`
 	Renewing       bool   `json:"renewing" dynamodbav:"renewing"`
 	AuthType       string `json:"auth_type"`
+	ErasureID      string `json:"erasure_id,omitempty" dynamodbav:"erasure_id,omitempty"`
+	ErasureDueAt   int64  `json:"erasure_due_at,omitempty" dynamodbav:"erasure_due_at,omitempty"`
+	AICallsMonth   string `json:"-" dynamodbav:"ai_calls_month,omitempty"`
+	AICalls        int    `json:"-" dynamodbav:"ai_calls,omitempty"`
+	// StorageBytes is the running total of the images the user has uploaded, nil until first counted
+	StorageBytes *int64 `json:"-" dynamodbav:"storage_bytes,omitempty"`
 }
 
 type Answer struct {
@@ -29,6 +29,8 @@ type Product struct {
 	Description      string `json:"description"`
 	BillingAmount    string `json:"billing_amount"`
 	BillingFrequency string `json:"billing_frequency"`
+	Tier             string `json:"tier"`
+	Limits           Limits `json:"limits"`
 }
 
 type SubscriptionResults struct {
@@ -36,3 +38,104 @@ type SubscriptionResults struct {
 	PeriodStart    time.Time `json:"period_start"`
 	PeriodEnd      time.Time `json:"period_end"`
 }
+
+// SubscriptionState is a user's subscription as last reported by Stripe. Webhook deliveries can
+// arrive out of order, so EventAt, when Stripe created the event, decides which one wins.
+type SubscriptionState struct {
+	SubscriptionID string
+	PriceID        string
+	Expired        bool
+	Renewing       bool
+	EventAt        int64
+}
+
+const (
+	TierFree   = "free"
+	TierWriter = "writer"
+	TierStudio = "studio"
+)
+
+// Unlimited marks a limit a plan doesn't impose
+const Unlimited = -1
+
+// Limits are what a plan allows. Associations and collaborators are counted per story or series,
+// AI calls per calendar month and storage across every image the account has uploaded.
+type Limits struct {
+	Stories       int      `json:"stories"`
+	Associations  int      `json:"associations"`
+	AICalls       int      `json:"ai_calls"`
+	ExportFormats []string `json:"export_formats"`
+	Collaborators int      `json:"collaborators"`
+	StorageBytes  int64    `json:"storage_bytes"`
+}
+
+// Entitlement is what a user's plan lets them do, as consulted when authorizing requests
+type Entitlement struct {
+	Tier       string `json:"tier"`
+	Subscribed bool   `json:"subscribed"`
+	Expired    bool   `json:"expired"`
+	Renewing   bool   `json:"renewing"`
+	Limits     Limits `json:"limits"`
+}
+
+type EntitlementUsage struct {
+	Stories      int   `json:"stories"`
+	AICalls      int   `json:"ai_calls"`
+	StorageBytes int64 `json:"storage_bytes"`
+}
+
+type EntitlementReport struct {
+	Entitlement
+	Usage EntitlementUsage `json:"usage"`
+}
+
+// Invoice is a bill as shown to the customer, with links to Stripe's hosted page and PDF receipt.
+// Amounts are in the currency's smallest unit.
+type Invoice struct {
+	ID          string `json:"id"`
+	Number      string `json:"number"`
+	Status      string `json:"status"`
+	AmountDue   int64  `json:"amount_due"`
+	AmountPaid  int64  `json:"amount_paid"`
+	Currency    string `json:"currency"`
+	Created     int64  `json:"created"`
+	PeriodStart int64  `json:"period_start"`
+	PeriodEnd   int64  `json:"period_end"`
+	HostedURL   string `json:"hosted_url"`
+	PDFURL      string `json:"pdf_url"`
+}
+
+// PlanChange asks to move a subscription to another price. Passing back the proration date of a
+// recent preview bills the change exactly as previewed.
+type PlanChange struct {
+	PriceID       string `json:"price_id"`
+	ProrationDate int64  `json:"proration_date,omitempty"`
+}
+
+// PlanChangePreview is what moving to another price would cost. Proration is the charge for the rest
+// of the period on the new price less the credit for the unused time on the old one, and AmountDue
+// is the next invoice including it.
+type PlanChangePreview struct {
+	PriceID       string `json:"price_id"`
+	Tier          string `json:"tier"`
+	Proration     int64  `json:"proration"`
+	AmountDue     int64  `json:"amount_due"`
+	Currency      string `json:"currency"`
+	ProrationDate int64  `json:"proration_date"`
+	NextPaymentAt int64  `json:"next_payment_at"`
+}
+
+// Discount is a coupon applied to a customer or their subscription through a promotion code
+type Discount struct {
+	Code       string  `json:"code"`
+	CouponID   string  `json:"coupon_id"`
+	Name       string  `json:"name"`
+	PercentOff float64 `json:"percent_off,omitempty"`
+	AmountOff  int64   `json:"amount_off,omitempty"`
+	Currency   string  `json:"currency,omitempty"`
+	Duration   string  `json:"duration"`
+}
+
+type PortalSession struct {
+	URL string `json:"url"`
+}
@@ -0,0 +1,71 @@
+package models
+
+import "encoding/json"
+
+const (
+	ChangeEntityBlock       = "block"
+	ChangeEntityChapter     = "chapter"
+	ChangeEntityAssociation = "association"
+
+	ChangeUpsert  = "upsert"
+	ChangeDelete  = "delete"
+	ChangeReorder = "reorder"
+)
+
+// StoryChange is one entry in a story's change log. Seq increases monotonically per story, so
+// clients can ask for everything after the last entry they have seen.
+type StoryChange struct {
+	StoryID   string          `json:"story_id" dynamodbav:"story_id"`
+	Seq       int64           `json:"seq" dynamodbav:"seq"`
+	Entity    string          `json:"entity" dynamodbav:"entity"`
+	Action    string          `json:"action" dynamodbav:"action"`
+	EntityID  string          `json:"entity_id" dynamodbav:"entity_id"`
+	ChapterID string          `json:"chapter_id,omitempty" dynamodbav:"chapter_id,omitempty"`
+	Version   int64           `json:"version,omitempty" dynamodbav:"version,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty" dynamodbav:"data,omitempty"`
+	Author    string          `json:"author" dynamodbav:"author"`
+	OpID      string          `json:"op_id,omitempty" dynamodbav:"op_id,omitempty"`
+	CreatedAt int64           `json:"created_at" dynamodbav:"created_at"`
+}
+
+type ChangeFeed struct {
+	Changes []*StoryChange `json:"changes"`
+	// Seq is the cursor to pass as since on the next request
+	Seq     int64 `json:"seq"`
+	HasMore bool  `json:"has_more"`
+}
+
+// SyncOperation is a change a client queued while offline. Block operations name the chapter
+// they belong to; chapter operations carry the whole chapter.
+type SyncOperation struct {
+	OpID      string      `json:"op_id"`
+	Entity    string      `json:"entity"`
+	Action    string      `json:"action"`
+	ChapterID string      `json:"chapter_id,omitempty"`
+	Block     *StoryBlock `json:"block,omitempty"`
+	Chapter   *Chapter    `json:"chapter,omitempty"`
+}
+
+type SyncRequest struct {
+	Since      int64           `json:"since"`
+	Operations []SyncOperation `json:"operations"`
+}
+
+type SyncApplied struct {
+	OpID    string `json:"op_id"`
+	Version int64  `json:"version,omitempty"`
+}
+
+// SyncConflict reports a queued operation that could not be applied, with the stored copy when
+// it was rejected for being out of date
+type SyncConflict struct {
+	OpID    string      `json:"op_id"`
+	Error   string      `json:"error"`
+	Current interface{} `json:"current,omitempty"`
+}
+
+type SyncResult struct {
+	Applied   []SyncApplied  `json:"applied"`
+	Conflicts []SyncConflict `json:"conflicts"`
+	ChangeFeed
+}
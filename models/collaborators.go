@@ -0,0 +1,54 @@
+package models
+
+type CollaboratorRole string
+
+const (
+	RoleOwner     CollaboratorRole = "owner"
+	RoleEditor    CollaboratorRole = "editor"
+	RoleCommenter CollaboratorRole = "commenter"
+	RoleViewer    CollaboratorRole = "viewer"
+)
+
+// roleRanks orders roles so that each one includes the permissions of those below it
+var roleRanks = map[CollaboratorRole]int{
+	RoleViewer:    1,
+	RoleCommenter: 2,
+	RoleEditor:    3,
+	RoleOwner:     4,
+}
+
+func (r CollaboratorRole) IsValid() bool {
+	_, ok := roleRanks[r]
+	return ok
+}
+
+// Allows reports whether a user holding this role may do something requiring the given role
+func (r CollaboratorRole) Allows(required CollaboratorRole) bool {
+	return roleRanks[r] > 0 && roleRanks[r] >= roleRanks[required]
+}
+
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+)
+
+type Collaborator struct {
+	StoryOrSeriesID string           `json:"story_or_series_id" dynamodbav:"story_or_series_id"`
+	Email           string           `json:"email" dynamodbav:"email"`
+	Role            CollaboratorRole `json:"role" dynamodbav:"role"`
+	Owner           string           `json:"owner" dynamodbav:"author"`
+	InvitedBy       string           `json:"invited_by" dynamodbav:"invited_by"`
+	Status          string           `json:"status" dynamodbav:"status"`
+	IsSeries        bool             `json:"is_series" dynamodbav:"is_series"`
+	Title           string           `json:"title" dynamodbav:"title"`
+	CreatedAt       int64            `json:"created_at" dynamodbav:"created_at"`
+	AcceptedAt      int64            `json:"accepted_at,omitempty" dynamodbav:"accepted_at,omitempty"`
+}
+
+// ResourceAccess is the outcome of resolving a user's access to a story or series
+type ResourceAccess struct {
+	Owner    string
+	Role     CollaboratorRole
+	SeriesID string
+	IsSeries bool
+}
@@ -0,0 +1,25 @@
+package models
+
+const (
+	ErasurePending   = "pending"
+	ErasureCancelled = "cancelled"
+	ErasureCompleted = "completed"
+)
+
+// AccountErasure is the audit record of a request to erase an account. It outlives the account, so
+// the user is only identified by a hash of their email. Erased counts what was removed, by kind.
+type AccountErasure struct {
+	ID          string         `json:"erasure_id" dynamodbav:"erasure_id"`
+	SubjectHash string         `json:"-" dynamodbav:"subject_hash"`
+	Status      string         `json:"status" dynamodbav:"status"`
+	RequestedAt int64          `json:"requested_at" dynamodbav:"requested_at"`
+	DueAt       int64          `json:"due_at" dynamodbav:"due_at"`
+	CancelledAt int64          `json:"cancelled_at,omitempty" dynamodbav:"cancelled_at,omitempty"`
+	CompletedAt int64          `json:"completed_at,omitempty" dynamodbav:"completed_at,omitempty"`
+	Erased      map[string]int `json:"erased,omitempty" dynamodbav:"erased,omitempty"`
+}
+
+// AccountErasureRequest confirms an erasure by repeating the account's email
+type AccountErasureRequest struct {
+	Confirm string `json:"confirm"`
+}
@@ -0,0 +1,23 @@
+package models
+
+const (
+	IdempotencyPending  = "pending"
+	IdempotencyComplete = "complete"
+)
+
+// IdempotencyRecord remembers the response to a request sent with an Idempotency-Key, so a retry
+// gets the same answer instead of repeating the write. ID scopes the key to the user who sent it.
+type IdempotencyRecord struct {
+	ID          string `dynamodbav:"id"`
+	Fingerprint string `dynamodbav:"fingerprint"`
+	Status      string `dynamodbav:"status"`
+	StatusCode  int    `dynamodbav:"status_code,omitempty"`
+	ContentType string `dynamodbav:"content_type,omitempty"`
+	Body        []byte `dynamodbav:"body,omitempty"`
+	// Attempt is new each time the key is claimed, so each attempt at the request gets its own
+	// transaction tokens
+	Attempt string `dynamodbav:"attempt,omitempty"`
+	// a pending request that hasn't finished by then is presumed dead and may be retried
+	LockedUntil int64 `dynamodbav:"locked_until"`
+	ExpiresAt   int64 `dynamodbav:"expires_at"`
+}
@@ -0,0 +1,34 @@
+package models
+
+const (
+	LiveWrite    = "write"
+	LiveDelete   = "delete"
+	LiveCursor   = "cursor"
+	LiveHello    = "hello"
+	LiveOp       = "op"
+	LivePresence = "presence"
+	LiveResync   = "resync"
+	LiveError    = "error"
+)
+
+// LiveMessage is the envelope for every frame exchanged over a chapter's editing socket.
+// Clients send write, delete and cursor messages; the server answers with hello, op, presence,
+// resync and error messages. An op carries the write or delete it records in Action.
+type LiveMessage struct {
+	Type    string       `json:"type"`
+	Action  string       `json:"action,omitempty"`
+	OpID    string       `json:"op_id,omitempty"`
+	Seq     int64        `json:"seq,omitempty"`
+	Author  string       `json:"author,omitempty"`
+	KeyID   string       `json:"key_id,omitempty"`
+	Blocks  []StoryBlock `json:"blocks,omitempty"`
+	Editors []LiveEditor `json:"editors,omitempty"`
+	Error   string       `json:"error,omitempty"`
+}
+
+// LiveEditor describes someone connected to a chapter and the block their cursor is in
+type LiveEditor struct {
+	Email string           `json:"email"`
+	Role  CollaboratorRole `json:"role"`
+	KeyID string           `json:"key_id,omitempty"`
+}
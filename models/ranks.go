@@ -0,0 +1,153 @@
+package models
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Blocks are ordered by rank, a fractional index compared as a plain string. A rank can always be
+// found between any two others, so moving or inserting a block only ever rewrites that one block.
+// Ranks never end in the lowest digit, which would leave no room directly below them.
+const rankDigits = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// ranks derived from the old numeric places are this wide, enough for any chapter
+const placeRankWidth = 6
+
+// places worked out from ranks keep this many decimals, which with the whole part stays inside the
+// 38 digits a DynamoDB number can hold
+const placeFractionDigits = 26
+
+// ValidRank reports whether rank can be stored and ranked against
+func ValidRank(rank string) bool {
+	if rank == "" || rank[len(rank)-1] == rankDigits[0] {
+		return false
+	}
+	for i := 0; i < len(rank); i++ {
+		if strings.IndexByte(rankDigits, rank[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// RankBetween returns a rank sorting after before and ahead of after. Leave before empty to rank
+// ahead of everything and after empty to rank behind everything.
+func RankBetween(before, after string) (string, error) {
+	if (before != "" && !ValidRank(before)) || (after != "" && !ValidRank(after)) {
+		return "", fmt.Errorf("invalid rank")
+	}
+	if after != "" && before >= after {
+		return "", fmt.Errorf("rank %q does not sort before %q", before, after)
+	}
+	return rankMidpoint(before, after), nil
+}
+
+func rankMidpoint(before, after string) string {
+	if after != "" {
+		// skip the digits both share, treating missing digits in before as the lowest
+		n := 0
+		for n < len(after) {
+			digit := rankDigits[0]
+			if n < len(before) {
+				digit = before[n]
+			}
+			if digit != after[n] {
+				break
+			}
+			n++
+		}
+		if n > 0 {
+			rest := ""
+			if n < len(before) {
+				rest = before[n:]
+			}
+			return after[:n] + rankMidpoint(rest, after[n:])
+		}
+	}
+	low := 0
+	if before != "" {
+		low = strings.IndexByte(rankDigits, before[0])
+	}
+	high := len(rankDigits)
+	if after != "" {
+		high = strings.IndexByte(rankDigits, after[0])
+	}
+	if high-low > 1 {
+		return string(rankDigits[(low+high+1)/2])
+	}
+	// the first digits are adjacent
+	if len(after) > 1 {
+		return after[:1]
+	}
+	rest := ""
+	if before != "" {
+		rest = before[1:]
+	}
+	return string(rankDigits[low]) + rankMidpoint(rest, "")
+}
+
+// RankFromPlace converts one of the numeric places blocks were ordered by before ranks, keeping the
+// same order. Blocks saved by clients that still send places are ranked this way too.
+func RankFromPlace(place string) (string, error) {
+	n, err := strconv.ParseUint(place, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid place %q", place)
+	}
+	encoded := ""
+	for ; n > 0; n /= uint64(len(rankDigits)) {
+		encoded = string(rankDigits[n%uint64(len(rankDigits))]) + encoded
+	}
+	if len(encoded) > placeRankWidth {
+		return "", fmt.Errorf("place %s is too large", place)
+	}
+	// the trailing middle digit leaves room to insert ahead of the block
+	return strings.Repeat(string(rankDigits[0]), placeRankWidth-len(encoded)) + encoded + string(rankDigits[len(rankDigits)/2]), nil
+}
+
+// PlaceFromRank works out a numeric place that sorts the same way as rank, so chapters still read
+// through their place index keep every block in order. Ranks made by RankFromPlace get their place
+// back; ranks too long to tell apart within a DynamoDB number can end up sharing a place.
+func PlaceFromRank(rank string) string {
+	base := big.NewRat(int64(len(rankDigits)), 1)
+	// the first placeRankWidth digits are the whole part of the place
+	scale := big.NewRat(1, 1)
+	for i := 0; i < placeRankWidth; i++ {
+		scale.Mul(scale, base)
+	}
+	value := new(big.Rat)
+	for i := 0; i < len(rank); i++ {
+		scale.Quo(scale, base)
+		digit := big.NewRat(int64(strings.IndexByte(rankDigits, rank[i])), 1)
+		value.Add(value, digit.Mul(digit, scale))
+	}
+	// less the half RankFromPlace adds with its trailing middle digit
+	value.Sub(value, big.NewRat(1, 2))
+	place := strings.TrimSuffix(strings.TrimRight(value.FloatString(placeFractionDigits), "0"), ".")
+	if place == "-0" {
+		return "0"
+	}
+	return place
+}
+
+// RanksBetween returns n ranks in order between before and after, kept short by always splitting the
+// remaining gap in half rather than appending one after another
+func RanksBetween(before, after string, n int) ([]string, error) {
+	if n <= 0 {
+		return []string{}, nil
+	}
+	mid, err := RankBetween(before, after)
+	if err != nil {
+		return nil, err
+	}
+	left, err := RanksBetween(before, mid, n/2)
+	if err != nil {
+		return nil, err
+	}
+	right, err := RanksBetween(mid, after, n-n/2-1)
+	if err != nil {
+		return nil, err
+	}
+	return append(append(left, mid), right...), nil
+}
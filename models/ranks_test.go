@@ -0,0 +1,201 @@
+package models
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestValidRank(t *testing.T) {
+	testCases := []struct {
+		name string
+		rank string
+		want bool
+	}{
+		{name: "Empty", rank: "", want: false},
+		{name: "SingleDigit", rank: "V", want: true},
+		{name: "FromPlace", rank: "000001V", want: true},
+		{name: "EndsInLowestDigit", rank: "V0", want: false},
+		{name: "OutsideAlphabet", rank: "a-b", want: false},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ValidRank(tc.rank); got != tc.want {
+				t.Errorf("expected ValidRank(%q) to be %v, got %v", tc.rank, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestRankBetween(t *testing.T) {
+	testCases := []struct {
+		name    string
+		before  string
+		after   string
+		wantErr bool
+	}{
+		{name: "EmptyChapter"},
+		{name: "First", after: "V"},
+		{name: "Last", before: "V"},
+		{name: "Between", before: "A", after: "B"},
+		{name: "AdjacentDigits", before: "A1", after: "A2"},
+		{name: "Prefix", before: "A", after: "A1"},
+		{name: "BelowLowest", after: "0001"},
+		{name: "FromPlaces", before: "000001V", after: "000002V"},
+		{name: "OutOfOrder", before: "B", after: "A", wantErr: true},
+		{name: "Equal", before: "A", after: "A", wantErr: true},
+		{name: "InvalidBefore", before: "A0", after: "B", wantErr: true},
+		{name: "InvalidAfter", before: "A", after: "B!", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := RankBetween(tc.before, tc.after)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got rank %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ValidRank(got) {
+				t.Errorf("expected a valid rank, got %q", got)
+			}
+			if got <= tc.before || (tc.after != "" && got >= tc.after) {
+				t.Errorf("expected a rank between %q and %q, got %q", tc.before, tc.after, got)
+			}
+		})
+	}
+}
+
+func TestRanksBetween(t *testing.T) {
+	testCases := []struct {
+		name   string
+		before string
+		after  string
+		n      int
+	}{
+		{name: "None", n: 0},
+		{name: "One", n: 1},
+		{name: "ManyInEmptyChapter", n: 500},
+		{name: "ManyBetween", before: "A", after: "A1", n: 100},
+		{name: "ManyAfterPlaces", before: "00000aV", n: 50},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := RanksBetween(tc.before, tc.after, tc.n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != tc.n {
+				t.Fatalf("expected %d ranks, got %d", tc.n, len(got))
+			}
+			previous := tc.before
+			for _, rank := range got {
+				if !ValidRank(rank) {
+					t.Errorf("expected a valid rank, got %q", rank)
+				}
+				if rank <= previous || (tc.after != "" && rank >= tc.after) {
+					t.Fatalf("expected %q to sort after %q and before %q", rank, previous, tc.after)
+				}
+				previous = rank
+			}
+		})
+	}
+}
+
+func TestRankFromPlace(t *testing.T) {
+	testCases := []struct {
+		name    string
+		place   string
+		want    string
+		wantErr bool
+	}{
+		{name: "Zero", place: "0", want: "000000V"},
+		{name: "One", place: "1", want: "000001V"},
+		{name: "Base", place: "62", want: "000010V"},
+		{name: "Widest", place: "56800235583", want: "zzzzzzV"},
+		{name: "TooLarge", place: "56800235584", wantErr: true},
+		{name: "Negative", place: "-1", wantErr: true},
+		{name: "Fraction", place: "1.5", wantErr: true},
+		{name: "NotANumber", place: "first", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := RankFromPlace(tc.place)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got rank %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("expected rank %q, got %q", tc.want, got)
+			}
+			if !ValidRank(got) {
+				t.Errorf("expected a valid rank, got %q", got)
+			}
+		})
+	}
+
+	// ranks keep the order of the places they came from
+	previous := ""
+	for _, place := range []string{"0", "1", "9", "10", "61", "62", "100", "3843", "3844", "1000000"} {
+		rank, err := RankFromPlace(place)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rank <= previous {
+			t.Errorf("expected the rank of place %s to sort after %q, got %q", place, previous, rank)
+		}
+		previous = rank
+	}
+}
+
+func TestPlaceFromRank(t *testing.T) {
+	// places come back unchanged from the ranks made out of them
+	for _, place := range []string{"0", "1", "7", "62", "1000", "56800235583"} {
+		rank, err := RankFromPlace(place)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := PlaceFromRank(rank); got != place {
+			t.Errorf("expected rank %q to give back place %s, got %s", rank, place, got)
+		}
+	}
+
+	// and ranks between them sort the same way by place
+	ranks, err := RanksBetween("000001V", "000002V", 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ranks = append([]string{"000000F", "000000V", "000000V1", "000000a", "000001V"}, ranks...)
+	ranks = append(ranks, "000002V", "0001", "V", "V00001", "zzzzzzz")
+	previous := new(big.Rat).SetInt64(-1)
+	for _, rank := range ranks {
+		got := PlaceFromRank(rank)
+		place, ok := new(big.Rat).SetString(got)
+		if !ok {
+			t.Fatalf("expected a number for rank %q, got %q", rank, got)
+		}
+		if place.Cmp(previous) <= 0 {
+			t.Errorf("expected the place of %q to sort after %s, got %s", rank, previous.FloatString(6), got)
+		}
+		if digits := len(strings.Trim(strings.NewReplacer("-", "", ".", "").Replace(got), "0")); digits > 38 {
+			t.Errorf("expected at most 38 digits, got %d in %s", digits, got)
+		}
+		previous = place
+	}
+}
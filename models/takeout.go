@@ -0,0 +1,53 @@
+package models
+
+// AccountArchiveVersion is bumped whenever the layout of an account archive changes. Archives newer
+// than this can't be imported.
+const AccountArchiveVersion = 1
+
+// AccountArchive is everything a user owns, as written to or read from an account export zip
+type AccountArchive struct {
+	Manifest AccountArchiveManifest
+	Series   []*ArchivedSeries
+	Stories  []*ArchivedStory
+	// Images holds the uploaded images carried in the archive, keyed by the URL they were stored at
+	Images map[string][]byte
+}
+
+// AccountArchiveManifest is manifest.json at the root of the archive, listing where everything else is
+type AccountArchiveManifest struct {
+	Version    int      `json:"version"`
+	ExportedAt int64    `json:"exported_at"`
+	Email      string   `json:"email"`
+	Series     []string `json:"series"`
+	Stories    []string `json:"stories"`
+	// Images maps each image's original URL onto its path in the archive
+	Images map[string]string `json:"images"`
+}
+
+type ArchivedSeries struct {
+	Series           Series                     `json:"series"`
+	Associations     []*Association             `json:"associations"`
+	AssociationTypes []*AssociationType         `json:"association_types"`
+	Relationships    []*AssociationRelationship `json:"relationships"`
+}
+
+// ArchivedStory is a story and its chapters. A volume's associations belong to its series, so only
+// the volume's overrides are kept with it.
+type ArchivedStory struct {
+	Story            Story                      `json:"story"`
+	Associations     []*Association             `json:"associations,omitempty"`
+	AssociationTypes []*AssociationType         `json:"association_types,omitempty"`
+	Relationships    []*AssociationRelationship `json:"relationships,omitempty"`
+	Overrides        []*AssociationOverride     `json:"overrides,omitempty"`
+	// Blocks holds each chapter's blocks keyed by chapter id, archived as a file per chapter
+	Blocks map[string][]StoryBlock `json:"-"`
+}
+
+// AccountImportReport maps the ids in an imported archive onto the ids they were given
+type AccountImportReport struct {
+	Series       map[string]string `json:"series"`
+	Stories      map[string]string `json:"stories"`
+	Chapters     int               `json:"chapters"`
+	Blocks       int               `json:"blocks"`
+	Associations int               `json:"associations"`
+}
@@ -0,0 +1,20 @@
+package models
+
+// StoryTemplate seeds a new story with a chapter skeleton and starter association types. Built-in
+// templates are available to everyone, the rest are saved by a user from one of their stories.
+type StoryTemplate struct {
+	ID               string            `json:"template_id" dynamodbav:"template_id"`
+	Name             string            `json:"name" dynamodbav:"name"`
+	Description      string            `json:"description" dynamodbav:"description"`
+	BuiltIn          bool              `json:"built_in" dynamodbav:"-"`
+	Chapters         []TemplateChapter `json:"chapters" dynamodbav:"chapters"`
+	AssociationTypes []AssociationType `json:"association_types" dynamodbav:"association_types"`
+	CreatedAt        int64             `json:"created_at,omitempty" dynamodbav:"created_at,omitempty"`
+}
+
+// TemplateChapter is one chapter of a template's skeleton. Each synopsis line becomes a placeholder
+// paragraph in the new chapter.
+type TemplateChapter struct {
+	Title    string   `json:"title" dynamodbav:"title"`
+	Synopsis []string `json:"synopsis,omitempty" dynamodbav:"synopsis,omitempty"`
+}
@@ -0,0 +1,19 @@
+package models
+
+const (
+	TrashStory       = "story"
+	TrashSeries      = "series"
+	TrashChapter     = "chapter"
+	TrashAssociation = "association"
+)
+
+// TrashItem is something a user deleted that can still be restored. ParentID is the story a chapter
+// belongs to or the story or series an association belongs to. Items are purged for good at PurgeAt.
+type TrashItem struct {
+	Type      string `json:"type"`
+	ID        string `json:"id"`
+	ParentID  string `json:"parent_id,omitempty"`
+	Title     string `json:"title"`
+	DeletedAt int64  `json:"deleted_at"`
+	PurgeAt   int64  `json:"purge_at"`
+}
@@ -0,0 +1,59 @@
+package payments
+
+import (
+	"RichDocter/models"
+	"errors"
+
+	stripe "github.com/stripe/stripe-go/v72"
+)
+
+var (
+	ErrMissingSecret = errors.New("missing stripe secret")
+	ErrNotFound      = errors.New("not found by the billing provider")
+	ErrInvalidCode   = errors.New("invalid or expired promotion code")
+)
+
+// Client is everything the app asks of its billing provider. StripeClient talks to Stripe while
+// FakeClient keeps customers and subscriptions in memory, so billing can be exercised offline.
+type Client interface {
+	CreateCustomer(email string) (string, error)
+	GetCustomer(customerID string) (*stripe.Customer, error)
+	DeleteCustomer(customerID string) error
+	CreateCardIntent(customerID string) (string, error)
+	PaymentMethods(customerID string) ([]models.PaymentMethod, error)
+	SetDefaultPaymentMethod(customerID, paymentMethodID string) error
+	Products() ([]models.Product, error)
+	CreateSubscription(customerID, priceID, paymentMethodID string) (*stripe.Subscription, error)
+	GetSubscription(subscriptionID string) (*stripe.Subscription, error)
+	// CancelSubscription ends a subscription now, or when the period already paid for runs out
+	CancelSubscription(subscriptionID string, atPeriodEnd bool) (*stripe.Subscription, error)
+	// ResumeSubscription keeps a subscription cancelled at period end renewing after all
+	ResumeSubscription(subscriptionID string) (*stripe.Subscription, error)
+	HasActiveSubscription(customerID string) (bool, error)
+	// Invoices lists a customer's invoices, newest first
+	Invoices(customerID string) ([]models.Invoice, error)
+	// GetInvoice looks up an invoice, along with the subscription it bills if any
+	GetInvoice(invoiceID string) (*stripe.Invoice, error)
+	// PreviewPlanChange prices moving a subscription to another price as of the proration date
+	PreviewPlanChange(subscriptionID, priceID string, prorationDate int64) (*models.PlanChangePreview, error)
+	// ChangePlan moves a subscription to another price, prorating as of the proration date. The
+	// proration is billed with the next invoice.
+	ChangePlan(subscriptionID, priceID string, prorationDate int64) (*stripe.Subscription, error)
+	// ApplyPromotionCode applies a promotion code to the subscription or, without one, to the
+	// customer's next subscription. Codes that can't be redeemed give ErrInvalidCode.
+	ApplyPromotionCode(customerID, subscriptionID, code string) (*models.Discount, error)
+	// CreatePortalSession opens a customer portal session, returning the URL to send the customer to
+	CreatePortalSession(customerID, returnURL string) (string, error)
+	// ParseWebhook checks a webhook delivery was sent by the provider and reads its event
+	ParseWebhook(payload []byte, signature string) (stripe.Event, error)
+}
+
+// IsLive reports whether a subscription still grants access. Past due subscriptions keep access
+// while payment is retried.
+func IsLive(subscription *stripe.Subscription) bool {
+	switch subscription.Status {
+	case stripe.SubscriptionStatusActive, stripe.SubscriptionStatusTrialing, stripe.SubscriptionStatusPastDue:
+		return true
+	}
+	return false
+}
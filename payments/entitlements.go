@@ -0,0 +1,57 @@
+package payments
+
+import (
+	"RichDocter/models"
+	"sync"
+	"time"
+)
+
+type cachedEntitlement struct {
+	entitlement models.Entitlement
+	expiresAt   time.Time
+}
+
+// EntitlementCache holds what each user's plan entitles them to, so authorizing a request doesn't
+// have to read the user's record every time. Entries are dropped after the TTL, and as soon as the
+// subscription changes through Invalidate.
+type EntitlementCache struct {
+	plans   *Plans
+	ttl     time.Duration
+	now     func() time.Time
+	mu      sync.Mutex
+	entries map[string]cachedEntitlement
+}
+
+func NewEntitlementCache(plans *Plans, ttl time.Duration) *EntitlementCache {
+	return &EntitlementCache{
+		plans:   plans,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: map[string]cachedEntitlement{},
+	}
+}
+
+// Get returns the cached entitlement for a user, loading their record when there isn't a fresh one
+func (c *EntitlementCache) Get(email string, load func() (*models.UserInfo, error)) (models.Entitlement, error) {
+	c.mu.Lock()
+	cached, ok := c.entries[email]
+	c.mu.Unlock()
+	if ok && c.now().Before(cached.expiresAt) {
+		return cached.entitlement, nil
+	}
+	user, err := load()
+	if err != nil {
+		return models.Entitlement{}, err
+	}
+	entitlement := c.plans.Entitlement(user)
+	c.mu.Lock()
+	c.entries[email] = cachedEntitlement{entitlement: entitlement, expiresAt: c.now().Add(c.ttl)}
+	c.mu.Unlock()
+	return entitlement, nil
+}
+
+func (c *EntitlementCache) Invalidate(email string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, email)
+}
@@ -0,0 +1,137 @@
+package payments
+
+import (
+	"RichDocter/models"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEntitlementCache(t *testing.T) {
+	testCases := []struct {
+		name       string
+		elapsed    time.Duration
+		invalidate bool
+		wantLoads  int
+	}{
+		{name: "Fresh", elapsed: time.Minute, wantLoads: 1},
+		{name: "Expired", elapsed: 6 * time.Minute, wantLoads: 2},
+		{name: "Invalidated", elapsed: time.Minute, invalidate: true, wantLoads: 2},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			now := time.Unix(1700000000, 0)
+			cache := NewEntitlementCache(NewPlans(NewFakeClient(now), time.Hour), 5*time.Minute)
+			cache.now = func() time.Time { return now }
+			loads := 0
+			load := func() (*models.UserInfo, error) {
+				loads++
+				user := &models.UserInfo{Email: "owner@example.com"}
+				if loads == 1 {
+					user.SubscriptionID = "sub_1"
+				}
+				return user, nil
+			}
+
+			if _, err := cache.Get("owner@example.com", load); err != nil {
+				t.Fatal(err)
+			}
+			now = now.Add(tc.elapsed)
+			if tc.invalidate {
+				cache.Invalidate("owner@example.com")
+			}
+			got, err := cache.Get("owner@example.com", load)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if loads != tc.wantLoads {
+				t.Errorf("expected %d loads, got %d", tc.wantLoads, loads)
+			}
+			if got.Subscribed != (tc.wantLoads == 1) {
+				t.Errorf("expected the entitlement from load %d, got %+v", tc.wantLoads, got)
+			}
+		})
+	}
+
+	t.Run("LoadFailure", func(t *testing.T) {
+		cache := NewEntitlementCache(NewPlans(NewFakeClient(time.Now()), time.Hour), 5*time.Minute)
+		failure := errors.New("unavailable")
+		if _, err := cache.Get("owner@example.com", func() (*models.UserInfo, error) {
+			return nil, failure
+		}); !errors.Is(err, failure) {
+			t.Fatalf("expected %v, got %v", failure, err)
+		}
+		if _, ok := cache.entries["owner@example.com"]; ok {
+			t.Errorf("expected a failed load not to be cached")
+		}
+	})
+}
+
+func TestPlansEntitlement(t *testing.T) {
+	testCases := []struct {
+		name     string
+		user     models.UserInfo
+		wantTier string
+	}{
+		{name: "NeverSubscribed", wantTier: models.TierFree},
+		{name: "Studio", user: models.UserInfo{SubscriptionID: "sub_1", PriceID: "price_studio"}, wantTier: models.TierStudio},
+		{name: "ProductWithoutTier", user: models.UserInfo{SubscriptionID: "sub_1", PriceID: "price_legacy"}, wantTier: models.TierWriter},
+		{name: "PriceNoLongerOffered", user: models.UserInfo{SubscriptionID: "sub_1", PriceID: "price_retired"}, wantTier: models.TierWriter},
+		{name: "Lapsed", user: models.UserInfo{Expired: true, PriceID: "price_studio"}, wantTier: models.TierFree},
+	}
+
+	client := NewFakeClient(time.Now())
+	client.ProductList = []models.Product{
+		{PriceID: "price_studio", Tier: models.TierStudio},
+		{PriceID: "price_legacy"},
+	}
+	plans := NewPlans(client, time.Hour)
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			got := plans.Entitlement(&tc.user)
+			if got.Tier != tc.wantTier {
+				t.Errorf("expected tier %s, got %s", tc.wantTier, got.Tier)
+			}
+			if got.Limits.AICalls != tierLimits[tc.wantTier].AICalls {
+				t.Errorf("expected the %s plan's limits, got %+v", tc.wantTier, got.Limits)
+			}
+		})
+	}
+}
+
+func TestPlansTierWhenProductsUnavailable(t *testing.T) {
+	testCases := []struct {
+		name         string
+		fetchedFirst bool
+		wantTier     string
+	}{
+		{name: "KeepsLastTiers", fetchedFirst: true, wantTier: models.TierStudio},
+		{name: "NeverFetched", wantTier: models.TierWriter},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			now := time.Now()
+			client := NewFakeClient(now)
+			client.ProductList = []models.Product{{PriceID: "price_studio", Tier: models.TierStudio}}
+			plans := NewPlans(client, time.Hour)
+			plans.now = func() time.Time { return now }
+			if tc.fetchedFirst {
+				plans.Tier("price_studio")
+				now = now.Add(2 * time.Hour)
+			}
+			client.ProductsErr = errors.New("stripe is down")
+			if got := plans.Tier("price_studio"); got != tc.wantTier {
+				t.Errorf("expected tier %s, got %s", tc.wantTier, got)
+			}
+			client.ProductsErr = nil
+			if got := plans.Tier("price_studio"); got != models.TierStudio {
+				t.Errorf("expected the refresh to recover the studio tier, got %s", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,577 @@
+package payments
+
+import (
+	"RichDocter/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	stripe "github.com/stripe/stripe-go/v72"
+)
+
+// FakeSignature is the only webhook signature a FakeClient accepts
+const FakeSignature = "fake-signature"
+
+const fakeBillingPeriod = 30 * 24 * time.Hour
+
+type fakeCustomer struct {
+	email                string
+	paymentMethods       []string
+	defaultPaymentMethod string
+	// discount is waiting for the customer's next subscription
+	discount *stripe.Discount
+}
+
+// FakeClient is an in-memory billing provider with its own clock. Moving the clock with Advance
+// renews or lapses subscriptions the way Stripe would, and every change is queued as the webhook
+// event Stripe would have sent. Invoices are priced from the BillingAmount of ProductList.
+type FakeClient struct {
+	// FailPayments makes every renewal payment fail with no retries left
+	FailPayments bool
+	ProductList  []models.Product
+	// ProductsErr makes listing products fail, as when Stripe can't be reached
+	ProductsErr error
+
+	mu             sync.Mutex
+	now            time.Time
+	nextID         int
+	customers      map[string]*fakeCustomer
+	subscriptions  map[string]*stripe.Subscription
+	invoices       map[string][]*stripe.Invoice
+	prorations     map[string]int64
+	promotionCodes map[string]*stripe.Coupon
+	events         []stripe.Event
+}
+
+var _ Client = (*FakeClient)(nil)
+
+func NewFakeClient(now time.Time) *FakeClient {
+	return &FakeClient{
+		now:            now,
+		customers:      map[string]*fakeCustomer{},
+		subscriptions:  map[string]*stripe.Subscription{},
+		invoices:       map[string][]*stripe.Invoice{},
+		prorations:     map[string]int64{},
+		promotionCodes: map[string]*stripe.Coupon{},
+	}
+}
+
+func (c *FakeClient) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock on, settling each subscription whose period ends along the way
+func (c *FakeClient) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	for _, subscription := range c.subscriptions {
+		for IsLive(subscription) && subscription.CurrentPeriodEnd <= c.now.Unix() {
+			switch {
+			case subscription.CancelAtPeriodEnd:
+				subscription.Status = stripe.SubscriptionStatusCanceled
+				subscription.CanceledAt = subscription.CurrentPeriodEnd
+				c.queue("customer.subscription.deleted", subscription)
+			case c.FailPayments:
+				subscription.Status = stripe.SubscriptionStatusUnpaid
+				c.queue("invoice.payment_failed", c.invoice(subscription, false))
+				c.queue("customer.subscription.updated", subscription)
+			default:
+				subscription.CurrentPeriodStart = subscription.CurrentPeriodEnd
+				subscription.CurrentPeriodEnd = time.Unix(subscription.CurrentPeriodStart, 0).Add(fakeBillingPeriod).Unix()
+				c.queue("invoice.paid", c.invoice(subscription, true))
+			}
+		}
+	}
+}
+
+// Deliveries drains the queued events, encoded as they would arrive at the webhook
+func (c *FakeClient) Deliveries() [][]byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var payloads [][]byte
+	for _, event := range c.events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			panic(err)
+		}
+		payloads = append(payloads, payload)
+	}
+	c.events = nil
+	return payloads
+}
+
+// AddPaymentMethod attaches a new card to a customer, as collecting a card intent would
+func (c *FakeClient) AddPaymentMethod(customerID string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	customer, ok := c.customers[customerID]
+	if !ok {
+		return "", ErrNotFound
+	}
+	id := c.id("pm")
+	customer.paymentMethods = append(customer.paymentMethods, id)
+	return id, nil
+}
+
+// RefundInvoice refunds the charge that paid an invoice in full, as done from the Stripe dashboard
+func (c *FakeClient) RefundInvoice(invoiceID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	refunded, ok := c.findInvoice(invoiceID)
+	if !ok || !refunded.Paid {
+		return ErrNotFound
+	}
+	c.queue("charge.refunded", &stripe.Charge{
+		ID:             c.id("ch"),
+		Customer:       &stripe.Customer{ID: refunded.Customer.ID},
+		Invoice:        &stripe.Invoice{ID: refunded.ID},
+		Amount:         refunded.AmountPaid,
+		AmountRefunded: refunded.AmountPaid,
+		Refunded:       true,
+	})
+	return nil
+}
+
+// AddPromotionCode offers a coupon under a customer-facing code
+func (c *FakeClient) AddPromotionCode(code string, coupon stripe.Coupon) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if coupon.ID == "" {
+		coupon.ID = c.id("coupon")
+	}
+	coupon.Valid = true
+	c.promotionCodes[code] = &coupon
+}
+
+func (c *FakeClient) CreateCustomer(email string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := c.id("cus")
+	c.customers[id] = &fakeCustomer{email: email}
+	return id, nil
+}
+
+func (c *FakeClient) GetCustomer(customerID string) (*stripe.Customer, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	customer, ok := c.customers[customerID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	result := &stripe.Customer{ID: customerID, Email: customer.email}
+	if customer.defaultPaymentMethod != "" {
+		result.InvoiceSettings = &stripe.CustomerInvoiceSettings{
+			DefaultPaymentMethod: &stripe.PaymentMethod{ID: customer.defaultPaymentMethod},
+		}
+	}
+	return result, nil
+}
+
+func (c *FakeClient) DeleteCustomer(customerID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.customers[customerID]; !ok {
+		return ErrNotFound
+	}
+	delete(c.customers, customerID)
+	for _, subscription := range c.subscriptions {
+		if subscription.Customer.ID == customerID && IsLive(subscription) {
+			subscription.Status = stripe.SubscriptionStatusCanceled
+			c.queue("customer.subscription.deleted", subscription)
+		}
+	}
+	return nil
+}
+
+func (c *FakeClient) CreateCardIntent(customerID string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.customers[customerID]; !ok {
+		return "", ErrNotFound
+	}
+	return c.id("seti") + "_secret", nil
+}
+
+func (c *FakeClient) PaymentMethods(customerID string) ([]models.PaymentMethod, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	customer, ok := c.customers[customerID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	var methods []models.PaymentMethod
+	for _, id := range customer.paymentMethods {
+		methods = append(methods, models.PaymentMethod{
+			Id:        id,
+			Brand:     stripe.PaymentMethodCardBrandVisa,
+			LastFour:  "4242",
+			IsDefault: id == customer.defaultPaymentMethod,
+		})
+	}
+	return methods, nil
+}
+
+func (c *FakeClient) SetDefaultPaymentMethod(customerID, paymentMethodID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	customer, ok := c.customers[customerID]
+	if !ok {
+		return ErrNotFound
+	}
+	if !customer.hasPaymentMethod(paymentMethodID) {
+		customer.paymentMethods = append(customer.paymentMethods, paymentMethodID)
+	}
+	customer.defaultPaymentMethod = paymentMethodID
+	return nil
+}
+
+func (c *FakeClient) Products() ([]models.Product, error) {
+	if c.ProductsErr != nil {
+		return nil, c.ProductsErr
+	}
+	return c.ProductList, nil
+}
+
+func (c *FakeClient) CreateSubscription(customerID, priceID, paymentMethodID string) (*stripe.Subscription, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	customer, ok := c.customers[customerID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if !customer.hasPaymentMethod(paymentMethodID) {
+		return nil, fmt.Errorf("payment method %s is not attached to customer %s", paymentMethodID, customerID)
+	}
+	subscription := &stripe.Subscription{
+		ID:                 c.id("sub"),
+		Customer:           &stripe.Customer{ID: customerID},
+		Status:             stripe.SubscriptionStatusActive,
+		Created:            c.now.Unix(),
+		CurrentPeriodStart: c.now.Unix(),
+		CurrentPeriodEnd:   c.now.Add(fakeBillingPeriod).Unix(),
+		Items: &stripe.SubscriptionItemList{Data: []*stripe.SubscriptionItem{{
+			Price: &stripe.Price{ID: priceID},
+		}}},
+	}
+	if c.FailPayments {
+		subscription.Status = stripe.SubscriptionStatusIncomplete
+	}
+	subscription.Discount, customer.discount = customer.discount, nil
+	c.subscriptions[subscription.ID] = subscription
+	c.queue("customer.subscription.created", subscription)
+	if IsLive(subscription) {
+		c.queue("invoice.paid", c.invoice(subscription, true))
+	}
+	copied := *subscription
+	return &copied, nil
+}
+
+func (c *FakeClient) GetSubscription(subscriptionID string) (*stripe.Subscription, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	subscription, ok := c.subscriptions[subscriptionID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *subscription
+	return &copied, nil
+}
+
+func (c *FakeClient) CancelSubscription(subscriptionID string, atPeriodEnd bool) (*stripe.Subscription, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	subscription, ok := c.subscriptions[subscriptionID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if !IsLive(subscription) {
+		// Stripe no longer finds a subscription once it has ended
+		return nil, ErrNotFound
+	}
+	if atPeriodEnd {
+		subscription.CancelAtPeriodEnd = true
+		c.queue("customer.subscription.updated", subscription)
+	} else {
+		subscription.Status = stripe.SubscriptionStatusCanceled
+		subscription.CanceledAt = c.now.Unix()
+		c.queue("customer.subscription.deleted", subscription)
+	}
+	copied := *subscription
+	return &copied, nil
+}
+
+func (c *FakeClient) ResumeSubscription(subscriptionID string) (*stripe.Subscription, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	subscription, ok := c.subscriptions[subscriptionID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if !IsLive(subscription) {
+		return nil, errors.New("subscription has already ended")
+	}
+	subscription.CancelAtPeriodEnd = false
+	c.queue("customer.subscription.updated", subscription)
+	copied := *subscription
+	return &copied, nil
+}
+
+func (c *FakeClient) HasActiveSubscription(customerID string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.customers[customerID]; !ok {
+		return false, ErrNotFound
+	}
+	for _, subscription := range c.subscriptions {
+		if subscription.Customer.ID == customerID && IsLive(subscription) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (c *FakeClient) Invoices(customerID string) ([]models.Invoice, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.customers[customerID]; !ok {
+		return nil, ErrNotFound
+	}
+	invoices := []models.Invoice{}
+	for i := len(c.invoices[customerID]) - 1; i >= 0; i-- {
+		invoices = append(invoices, invoice(c.invoices[customerID][i]))
+	}
+	return invoices, nil
+}
+
+func (c *FakeClient) GetInvoice(invoiceID string) (*stripe.Invoice, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	found, ok := c.findInvoice(invoiceID)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *found
+	return &copied, nil
+}
+
+func (c *FakeClient) PreviewPlanChange(subscriptionID, priceID string, prorationDate int64) (*models.PlanChangePreview, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	subscription, proration, err := c.planChange(subscriptionID, priceID, prorationDate)
+	if err != nil {
+		return nil, err
+	}
+	amount, _ := c.amount(priceID)
+	return &models.PlanChangePreview{
+		PriceID:       priceID,
+		Proration:     proration,
+		AmountDue:     discounted(amount+c.prorations[subscriptionID]+proration, subscription.Discount),
+		Currency:      string(stripe.CurrencyUSD),
+		ProrationDate: prorationDate,
+		NextPaymentAt: subscription.CurrentPeriodEnd,
+	}, nil
+}
+
+func (c *FakeClient) ChangePlan(subscriptionID, priceID string, prorationDate int64) (*stripe.Subscription, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	subscription, proration, err := c.planChange(subscriptionID, priceID, prorationDate)
+	if err != nil {
+		return nil, err
+	}
+	c.prorations[subscriptionID] += proration
+	subscription.Items = &stripe.SubscriptionItemList{Data: []*stripe.SubscriptionItem{{
+		Price: &stripe.Price{ID: priceID},
+	}}}
+	c.queue("customer.subscription.updated", subscription)
+	copied := *subscription
+	return &copied, nil
+}
+
+func (c *FakeClient) ApplyPromotionCode(customerID, subscriptionID, code string) (*models.Discount, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	customer, ok := c.customers[customerID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	coupon, ok := c.promotionCodes[code]
+	if !ok || !coupon.Valid {
+		return nil, ErrInvalidCode
+	}
+	applied := &stripe.Discount{ID: c.id("di"), Coupon: coupon, Customer: customerID}
+	if subscriptionID == "" {
+		customer.discount = applied
+		return discount(code, coupon), nil
+	}
+	subscription, ok := c.subscriptions[subscriptionID]
+	if !ok || !IsLive(subscription) {
+		return nil, ErrNotFound
+	}
+	subscription.Discount = applied
+	c.queue("customer.subscription.updated", subscription)
+	return discount(code, coupon), nil
+}
+
+func (c *FakeClient) CreatePortalSession(customerID, returnURL string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.customers[customerID]; !ok {
+		return "", ErrNotFound
+	}
+	return fmt.Sprintf("https://billing.example.com/p/session/%s?return_url=%s", c.id("bps"), url.QueryEscape(returnURL)), nil
+}
+
+func (c *FakeClient) ParseWebhook(payload []byte, signature string) (stripe.Event, error) {
+	event := stripe.Event{}
+	if signature != FakeSignature {
+		return event, errors.New("webhook has invalid signature")
+	}
+	err := json.Unmarshal(payload, &event)
+	return event, err
+}
+
+func (c *FakeClient) id(prefix string) string {
+	c.nextID++
+	return fmt.Sprintf("%s_fake%d", prefix, c.nextID)
+}
+
+// invoice bills a subscription's period along with any proration owed from plan changes, and keeps
+// the invoice for the customer's history
+func (c *FakeClient) invoice(subscription *stripe.Subscription, paid bool) *stripe.Invoice {
+	price := subscription.Items.Data[0].Price
+	amount, _ := c.amount(price.ID)
+	lines := []*stripe.InvoiceLine{{
+		Type:   stripe.InvoiceLineTypeSubscription,
+		Price:  price,
+		Amount: amount,
+	}}
+	if proration := c.prorations[subscription.ID]; proration != 0 {
+		lines = append(lines, &stripe.InvoiceLine{
+			Type:      stripe.InvoiceLineTypeInvoiceItem,
+			Amount:    proration,
+			Proration: true,
+		})
+		amount += proration
+		delete(c.prorations, subscription.ID)
+	}
+	amount = discounted(amount, subscription.Discount)
+	if subscription.Discount != nil && subscription.Discount.Coupon.Duration == stripe.CouponDurationOnce {
+		subscription.Discount = nil
+	}
+
+	id := c.id("in")
+	billed := &stripe.Invoice{
+		ID:               id,
+		Number:           fmt.Sprintf("FAKE-%04d", c.nextID),
+		Customer:         &stripe.Customer{ID: subscription.Customer.ID},
+		Subscription:     &stripe.Subscription{ID: subscription.ID},
+		Lines:            &stripe.InvoiceLineList{Data: lines},
+		Paid:             paid,
+		Status:           stripe.InvoiceStatusOpen,
+		AmountDue:        amount,
+		Currency:         stripe.CurrencyUSD,
+		Created:          c.now.Unix(),
+		PeriodStart:      subscription.CurrentPeriodStart,
+		PeriodEnd:        subscription.CurrentPeriodEnd,
+		HostedInvoiceURL: "https://invoice.example.com/i/" + id,
+		InvoicePDF:       "https://invoice.example.com/i/" + id + "/pdf",
+	}
+	if paid {
+		billed.Status = stripe.InvoiceStatusPaid
+		billed.AmountPaid = amount
+	}
+	c.invoices[subscription.Customer.ID] = append(c.invoices[subscription.Customer.ID], billed)
+	return billed
+}
+
+func (c *FakeClient) findInvoice(invoiceID string) (*stripe.Invoice, bool) {
+	for _, invoices := range c.invoices {
+		for _, billed := range invoices {
+			if billed.ID == invoiceID {
+				return billed, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// planChange checks a subscription can move to a price and works out the proration, the difference
+// between the prices for the part of the period left after the proration date
+func (c *FakeClient) planChange(subscriptionID, priceID string, prorationDate int64) (*stripe.Subscription, int64, error) {
+	subscription, ok := c.subscriptions[subscriptionID]
+	if !ok || !IsLive(subscription) {
+		return nil, 0, ErrNotFound
+	}
+	newAmount, ok := c.amount(priceID)
+	if !ok {
+		return nil, 0, ErrNotFound
+	}
+	if prorationDate < subscription.CurrentPeriodStart || prorationDate > subscription.CurrentPeriodEnd {
+		return nil, 0, fmt.Errorf("proration date %d is outside the current period", prorationDate)
+	}
+	oldAmount, _ := c.amount(subscription.Items.Data[0].Price.ID)
+	remaining := subscription.CurrentPeriodEnd - prorationDate
+	period := subscription.CurrentPeriodEnd - subscription.CurrentPeriodStart
+	return subscription, (newAmount - oldAmount) * remaining / period, nil
+}
+
+// amount is what a price bills each period, in cents
+func (c *FakeClient) amount(priceID string) (int64, bool) {
+	for _, product := range c.ProductList {
+		if product.PriceID == priceID {
+			amount, err := strconv.ParseFloat(product.BillingAmount, 64)
+			if err != nil {
+				return 0, true
+			}
+			return int64(math.Round(amount * 100)), true
+		}
+	}
+	return 0, false
+}
+
+// discounted takes a subscription's discount off an amount, never going below nothing
+func discounted(amount int64, applied *stripe.Discount) int64 {
+	if applied == nil || applied.Coupon == nil || amount <= 0 {
+		return amount
+	}
+	if applied.Coupon.PercentOff > 0 {
+		amount -= int64(math.Round(float64(amount) * applied.Coupon.PercentOff / 100))
+	}
+	amount -= applied.Coupon.AmountOff
+	if amount < 0 {
+		return 0
+	}
+	return amount
+}
+
+// queue records the event Stripe would send for an object as it is now
+func (c *FakeClient) queue(eventType string, object interface{}) {
+	raw, err := json.Marshal(object)
+	if err != nil {
+		panic(err)
+	}
+	c.events = append(c.events, stripe.Event{
+		ID:      c.id("evt"),
+		Type:    eventType,
+		Created: c.now.Unix(),
+		Data:    &stripe.EventData{Raw: raw},
+	})
+}
+
+func (customer *fakeCustomer) hasPaymentMethod(paymentMethodID string) bool {
+	for _, id := range customer.paymentMethods {
+		if id == paymentMethodID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package payments
+
+import (
+	"RichDocter/models"
+	"log"
+	"sync"
+	"time"
+)
+
+// tierLimits is the one place plan limits are set. Paid plans are told apart by a "tier" entry in
+// their Stripe product's metadata.
+var tierLimits = map[string]models.Limits{
+	models.TierFree: {
+		Stories:       1,
+		Associations:  10,
+		AICalls:       0,
+		ExportFormats: []string{},
+		Collaborators: 1,
+		StorageBytes:  25 << 20,
+	},
+	models.TierWriter: {
+		Stories:       models.Unlimited,
+		Associations:  models.Unlimited,
+		AICalls:       200,
+		ExportFormats: []string{"pdf", "docx"},
+		Collaborators: 5,
+		StorageBytes:  2 << 30,
+	},
+	models.TierStudio: {
+		Stories:       models.Unlimited,
+		Associations:  models.Unlimited,
+		AICalls:       2000,
+		ExportFormats: []string{"pdf", "docx"},
+		Collaborators: models.Unlimited,
+		StorageBytes:  20 << 30,
+	},
+}
+
+// subscribers to a product without a known tier, or from before tiers, get the writer plan
+const defaultPaidTier = models.TierWriter
+
+func LimitsFor(tier string) models.Limits {
+	if limits, ok := tierLimits[tier]; ok {
+		return limits
+	}
+	return tierLimits[defaultPaidTier]
+}
+
+// Plans works out which tier each price belongs to from the products on offer. The product list is
+// fetched at most once per TTL.
+type Plans struct {
+	client    Client
+	ttl       time.Duration
+	now       func() time.Time
+	mu        sync.Mutex
+	tiers     map[string]string
+	fetchedAt time.Time
+}
+
+func NewPlans(client Client, ttl time.Duration) *Plans {
+	return &Plans{
+		client: client,
+		ttl:    ttl,
+		now:    time.Now,
+	}
+}
+
+// Tier returns the tier of a price, falling back to the default paid tier for prices that aren't
+// on offer any more. The product list is fetched without holding the lock, and when Stripe can't be
+// reached the tiers from the last fetch keep being used until a refresh succeeds.
+func (p *Plans) Tier(priceID string) string {
+	p.mu.Lock()
+	tiers := p.tiers
+	stale := tiers == nil || p.now().Sub(p.fetchedAt) >= p.ttl
+	p.mu.Unlock()
+	if stale {
+		products, err := p.client.Products()
+		if err != nil {
+			log.Println("couldn't refresh plan tiers, using the last known ones:", err)
+		} else {
+			tiers = map[string]string{}
+			for _, product := range products {
+				tiers[product.PriceID] = product.Tier
+			}
+			p.mu.Lock()
+			p.tiers = tiers
+			p.fetchedAt = p.now()
+			p.mu.Unlock()
+		}
+	}
+	if tier, ok := tiers[priceID]; ok && tier != "" {
+		return tier
+	}
+	return defaultPaidTier
+}
+
+// Entitlement works out what a user's plan lets them do. Users without a live subscription are on
+// the free plan.
+func (p *Plans) Entitlement(user *models.UserInfo) models.Entitlement {
+	entitlement := models.Entitlement{
+		Tier:       models.TierFree,
+		Subscribed: user.SubscriptionID != "",
+		Expired:    user.Expired,
+		Renewing:   user.Renewing,
+	}
+	if entitlement.Subscribed && !entitlement.Expired {
+		entitlement.Tier = p.Tier(user.PriceID)
+	}
+	entitlement.Limits = LimitsFor(entitlement.Tier)
+	return entitlement
+}
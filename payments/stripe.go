@@ -0,0 +1,439 @@
+package payments
+
+import (
+	"RichDocter/models"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	stripe "github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/client"
+	"github.com/stripe/stripe-go/v72/webhook"
+)
+
+// StripeClient is the billing provider in production. It carries its own keys rather than setting
+// the Stripe SDK's global key.
+type StripeClient struct {
+	api           *client.API
+	webhookSecret string
+}
+
+var _ Client = (*StripeClient)(nil)
+
+// NewStripeClient makes a client for the given secret key. Without one every call fails with
+// ErrMissingSecret, and without a webhook secret no webhook delivery is accepted.
+func NewStripeClient(secret, webhookSecret string) *StripeClient {
+	c := &StripeClient{webhookSecret: webhookSecret}
+	if secret != "" {
+		c.api = client.New(secret, nil)
+	}
+	return c
+}
+
+func (c *StripeClient) CreateCustomer(email string) (string, error) {
+	if c.api == nil {
+		return "", ErrMissingSecret
+	}
+	customer, err := c.api.Customers.New(&stripe.CustomerParams{
+		Email: &email,
+	})
+	if err != nil {
+		return "", err
+	}
+	log.Println("created new customer", customer.ID)
+	return customer.ID, nil
+}
+
+func (c *StripeClient) GetCustomer(customerID string) (*stripe.Customer, error) {
+	if c.api == nil {
+		return nil, ErrMissingSecret
+	}
+	customer, err := c.api.Customers.Get(customerID, nil)
+	return customer, notFound(err)
+}
+
+func (c *StripeClient) DeleteCustomer(customerID string) error {
+	if c.api == nil {
+		return ErrMissingSecret
+	}
+	_, err := c.api.Customers.Del(customerID, nil)
+	return notFound(err)
+}
+
+func (c *StripeClient) CreateCardIntent(customerID string) (string, error) {
+	if c.api == nil {
+		return "", ErrMissingSecret
+	}
+	intent, err := c.api.SetupIntents.New(&stripe.SetupIntentParams{
+		PaymentMethodTypes: []*string{
+			stripe.String("card"),
+		},
+		Customer: &customerID,
+	})
+	if err != nil {
+		return "", err
+	}
+	return intent.ClientSecret, nil
+}
+
+func (c *StripeClient) PaymentMethods(customerID string) ([]models.PaymentMethod, error) {
+	customer, err := c.GetCustomer(customerID)
+	if err != nil {
+		return nil, err
+	}
+	var defaultPaymentMethodID string
+	if customer.InvoiceSettings != nil && customer.InvoiceSettings.DefaultPaymentMethod != nil {
+		defaultPaymentMethodID = customer.InvoiceSettings.DefaultPaymentMethod.ID
+	}
+	iter := c.api.PaymentMethods.List(&stripe.PaymentMethodListParams{
+		Customer: stripe.String(customerID),
+		Type:     stripe.String("card"),
+	})
+	var methods []models.PaymentMethod
+	for iter.Next() {
+		methods = append(methods, paymentMethod(iter.PaymentMethod(), defaultPaymentMethodID))
+	}
+	return methods, iter.Err()
+}
+
+// SetDefaultPaymentMethod attaches the payment method to the customer if it isn't already, then
+// makes it the one their invoices are charged to
+func (c *StripeClient) SetDefaultPaymentMethod(customerID, paymentMethodID string) error {
+	if c.api == nil {
+		return ErrMissingSecret
+	}
+	iter := c.api.PaymentMethods.List(&stripe.PaymentMethodListParams{
+		Customer: stripe.String(customerID),
+		Type:     stripe.String("card"),
+	})
+	attached := false
+	for iter.Next() {
+		if iter.PaymentMethod().ID == paymentMethodID {
+			attached = true
+			break
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if !attached {
+		if _, err := c.api.PaymentMethods.Attach(paymentMethodID, &stripe.PaymentMethodAttachParams{
+			Customer: stripe.String(customerID),
+		}); err != nil {
+			return err
+		}
+	}
+	_, err := c.api.Customers.Update(customerID, &stripe.CustomerParams{
+		InvoiceSettings: &stripe.CustomerInvoiceSettingsParams{
+			DefaultPaymentMethod: stripe.String(paymentMethodID),
+		},
+	})
+	return err
+}
+
+// Products lists the products that have a default price, which are the plans on offer
+func (c *StripeClient) Products() ([]models.Product, error) {
+	if c.api == nil {
+		return nil, ErrMissingSecret
+	}
+	var products []models.Product
+	iter := c.api.Products.List(&stripe.ProductListParams{})
+	for iter.Next() {
+		prod := iter.Product()
+		if prod.DefaultPrice == nil {
+			continue
+		}
+		p, err := c.api.Prices.Get(prod.DefaultPrice.ID, nil)
+		if err != nil {
+			return nil, err
+		}
+		tier := prod.Metadata["tier"]
+		if tier == "" {
+			tier = defaultPaidTier
+		}
+		product := models.Product{
+			ProductID:     prod.ID,
+			PriceID:       p.ID,
+			Name:          prod.Name,
+			Description:   prod.Description,
+			BillingAmount: fmt.Sprintf("%.2f", float64(p.UnitAmount)/100),
+			Tier:          tier,
+			Limits:        LimitsFor(tier),
+		}
+		if p.Recurring != nil {
+			product.BillingFrequency = string(p.Recurring.Interval)
+		}
+		products = append(products, product)
+	}
+	return products, iter.Err()
+}
+
+func (c *StripeClient) CreateSubscription(customerID, priceID, paymentMethodID string) (*stripe.Subscription, error) {
+	if c.api == nil {
+		return nil, ErrMissingSecret
+	}
+	return c.api.Subscriptions.New(&stripe.SubscriptionParams{
+		Customer: &customerID,
+		Items: []*stripe.SubscriptionItemsParams{
+			{
+				Plan: &priceID,
+			},
+		},
+		DefaultPaymentMethod: &paymentMethodID,
+	})
+}
+
+func (c *StripeClient) GetSubscription(subscriptionID string) (*stripe.Subscription, error) {
+	if c.api == nil {
+		return nil, ErrMissingSecret
+	}
+	subscription, err := c.api.Subscriptions.Get(subscriptionID, nil)
+	return subscription, notFound(err)
+}
+
+func (c *StripeClient) CancelSubscription(subscriptionID string, atPeriodEnd bool) (*stripe.Subscription, error) {
+	if c.api == nil {
+		return nil, ErrMissingSecret
+	}
+	if atPeriodEnd {
+		subscription, err := c.api.Subscriptions.Update(subscriptionID, &stripe.SubscriptionParams{
+			CancelAtPeriodEnd: stripe.Bool(true),
+		})
+		return subscription, notFound(err)
+	}
+	subscription, err := c.api.Subscriptions.Cancel(subscriptionID, nil)
+	return subscription, notFound(err)
+}
+
+func (c *StripeClient) ResumeSubscription(subscriptionID string) (*stripe.Subscription, error) {
+	if c.api == nil {
+		return nil, ErrMissingSecret
+	}
+	subscription, err := c.api.Subscriptions.Update(subscriptionID, &stripe.SubscriptionParams{
+		CancelAtPeriodEnd: stripe.Bool(false),
+	})
+	return subscription, notFound(err)
+}
+
+func (c *StripeClient) HasActiveSubscription(customerID string) (bool, error) {
+	if c.api == nil {
+		return false, ErrMissingSecret
+	}
+	iter := c.api.Subscriptions.List(&stripe.SubscriptionListParams{
+		Customer: customerID,
+	})
+	for iter.Next() {
+		if IsLive(iter.Subscription()) {
+			return true, nil
+		}
+	}
+	return false, notFound(iter.Err())
+}
+
+func (c *StripeClient) Invoices(customerID string) ([]models.Invoice, error) {
+	if c.api == nil {
+		return nil, ErrMissingSecret
+	}
+	iter := c.api.Invoices.List(&stripe.InvoiceListParams{
+		Customer: &customerID,
+	})
+	invoices := []models.Invoice{}
+	for iter.Next() {
+		invoices = append(invoices, invoice(iter.Invoice()))
+	}
+	return invoices, notFound(iter.Err())
+}
+
+func (c *StripeClient) GetInvoice(invoiceID string) (*stripe.Invoice, error) {
+	if c.api == nil {
+		return nil, ErrMissingSecret
+	}
+	found, err := c.api.Invoices.Get(invoiceID, nil)
+	return found, notFound(err)
+}
+
+func (c *StripeClient) PreviewPlanChange(subscriptionID, priceID string, prorationDate int64) (*models.PlanChangePreview, error) {
+	subscription, err := c.GetSubscription(subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	item, err := subscriptionItem(subscription)
+	if err != nil {
+		return nil, err
+	}
+	upcoming, err := c.api.Invoices.GetNext(&stripe.InvoiceParams{
+		Customer:     &subscription.Customer.ID,
+		Subscription: &subscriptionID,
+		SubscriptionItems: []*stripe.SubscriptionItemsParams{{
+			ID:    &item.ID,
+			Price: &priceID,
+		}},
+		SubscriptionProrationBehavior: stripe.String(string(stripe.SubscriptionProrationBehaviorCreateProrations)),
+		SubscriptionProrationDate:     &prorationDate,
+	})
+	if err != nil {
+		return nil, notFound(err)
+	}
+	preview := &models.PlanChangePreview{
+		PriceID:       priceID,
+		AmountDue:     upcoming.AmountDue,
+		Currency:      string(upcoming.Currency),
+		ProrationDate: prorationDate,
+		NextPaymentAt: subscription.CurrentPeriodEnd,
+	}
+	if upcoming.Lines != nil {
+		for _, line := range upcoming.Lines.Data {
+			if line.Proration {
+				preview.Proration += line.Amount
+			}
+		}
+	}
+	return preview, nil
+}
+
+func (c *StripeClient) ChangePlan(subscriptionID, priceID string, prorationDate int64) (*stripe.Subscription, error) {
+	subscription, err := c.GetSubscription(subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	item, err := subscriptionItem(subscription)
+	if err != nil {
+		return nil, err
+	}
+	subscription, err = c.api.Subscriptions.Update(subscriptionID, &stripe.SubscriptionParams{
+		Items: []*stripe.SubscriptionItemsParams{{
+			ID:    &item.ID,
+			Price: &priceID,
+		}},
+		ProrationBehavior: stripe.String(string(stripe.SubscriptionProrationBehaviorCreateProrations)),
+		ProrationDate:     &prorationDate,
+	})
+	return subscription, notFound(err)
+}
+
+// ApplyPromotionCode only redeems active promotion codes. Coupons that were never published as a
+// promotion code can't be redeemed by their ID.
+func (c *StripeClient) ApplyPromotionCode(customerID, subscriptionID, code string) (*models.Discount, error) {
+	if c.api == nil {
+		return nil, ErrMissingSecret
+	}
+	iter := c.api.PromotionCodes.List(&stripe.PromotionCodeListParams{
+		Active: stripe.Bool(true),
+		Code:   &code,
+	})
+	if !iter.Next() {
+		if err := iter.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidCode
+	}
+	promotionCode := iter.PromotionCode()
+
+	var err error
+	if subscriptionID != "" {
+		_, err = c.api.Subscriptions.Update(subscriptionID, &stripe.SubscriptionParams{
+			PromotionCode: &promotionCode.ID,
+		})
+	} else {
+		_, err = c.api.Customers.Update(customerID, &stripe.CustomerParams{
+			PromotionCode: &promotionCode.ID,
+		})
+	}
+	if err != nil {
+		return nil, invalidCode(notFound(err))
+	}
+	return discount(code, promotionCode.Coupon), nil
+}
+
+func (c *StripeClient) CreatePortalSession(customerID, returnURL string) (string, error) {
+	if c.api == nil {
+		return "", ErrMissingSecret
+	}
+	session, err := c.api.BillingPortalSessions.New(&stripe.BillingPortalSessionParams{
+		Customer:  &customerID,
+		ReturnURL: &returnURL,
+	})
+	if err != nil {
+		return "", notFound(err)
+	}
+	return session.URL, nil
+}
+
+func (c *StripeClient) ParseWebhook(payload []byte, signature string) (stripe.Event, error) {
+	if c.webhookSecret == "" {
+		return stripe.Event{}, errors.New("missing stripe webhook secret")
+	}
+	return webhook.ConstructEvent(payload, signature, c.webhookSecret)
+}
+
+// notFound swaps Stripe's missing resource error for ErrNotFound
+func notFound(err error) error {
+	var stripeErr *stripe.Error
+	if errors.As(err, &stripeErr) && stripeErr.Code == stripe.ErrorCodeResourceMissing {
+		return ErrNotFound
+	}
+	return err
+}
+
+// invalidCode swaps Stripe's refusal to redeem a code, such as a first time only code used again,
+// for ErrInvalidCode
+func invalidCode(err error) error {
+	var stripeErr *stripe.Error
+	if errors.As(err, &stripeErr) && stripeErr.Type == stripe.ErrorTypeInvalidRequest && stripeErr.HTTPStatusCode == http.StatusBadRequest {
+		return fmt.Errorf("%w: %s", ErrInvalidCode, stripeErr.Msg)
+	}
+	return err
+}
+
+// subscriptionItem is the item holding a subscription's price. Plans are single priced, so there is
+// only the one.
+func subscriptionItem(subscription *stripe.Subscription) (*stripe.SubscriptionItem, error) {
+	if subscription.Items == nil || len(subscription.Items.Data) == 0 {
+		return nil, fmt.Errorf("subscription %s has no price", subscription.ID)
+	}
+	return subscription.Items.Data[0], nil
+}
+
+func invoice(in *stripe.Invoice) models.Invoice {
+	return models.Invoice{
+		ID:          in.ID,
+		Number:      in.Number,
+		Status:      string(in.Status),
+		AmountDue:   in.AmountDue,
+		AmountPaid:  in.AmountPaid,
+		Currency:    string(in.Currency),
+		Created:     in.Created,
+		PeriodStart: in.PeriodStart,
+		PeriodEnd:   in.PeriodEnd,
+		HostedURL:   in.HostedInvoiceURL,
+		PDFURL:      in.InvoicePDF,
+	}
+}
+
+func discount(code string, coupon *stripe.Coupon) *models.Discount {
+	applied := &models.Discount{Code: code}
+	if coupon != nil {
+		applied.CouponID = coupon.ID
+		applied.Name = coupon.Name
+		applied.PercentOff = coupon.PercentOff
+		applied.AmountOff = coupon.AmountOff
+		applied.Currency = string(coupon.Currency)
+		applied.Duration = string(coupon.Duration)
+	}
+	return applied
+}
+
+func paymentMethod(pm *stripe.PaymentMethod, defaultPaymentMethodID string) models.PaymentMethod {
+	method := models.PaymentMethod{
+		Id:        pm.ID,
+		IsDefault: pm.ID == defaultPaymentMethodID,
+	}
+	if pm.Card != nil {
+		method.Brand = pm.Card.Brand
+		method.LastFour = pm.Card.Last4
+		method.ExpirationMonth = pm.Card.ExpMonth
+		method.ExpirationYear = pm.Card.ExpYear
+	}
+	return method
+}
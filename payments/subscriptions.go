@@ -0,0 +1,61 @@
+package payments
+
+import (
+	"RichDocter/models"
+	"errors"
+)
+
+// SetRenewing turns a user's auto renewal on or off. Turning it off cancels the subscription at the
+// end of the period already paid for. Turning it back on resumes that subscription if it hasn't run
+// out yet, otherwise the user is subscribed again to the same price with their default card.
+func SetRenewing(client Client, user *models.UserInfo, renewing bool) error {
+	if !renewing {
+		_, err := client.CancelSubscription(user.SubscriptionID, true)
+		return err
+	}
+	subscription, err := client.GetSubscription(user.SubscriptionID)
+	if err != nil {
+		return err
+	}
+	if IsLive(subscription) {
+		_, err = client.ResumeSubscription(subscription.ID)
+		return err
+	}
+	if subscription.Items == nil || len(subscription.Items.Data) == 0 || subscription.Items.Data[0].Price == nil {
+		return errors.New("error retrieving subscription details")
+	}
+	methods, err := client.PaymentMethods(user.CustomerID)
+	if err != nil {
+		return err
+	}
+	// TODO provide a way to update payment method
+	var defaultPaymentID string
+	for _, method := range methods {
+		if method.IsDefault {
+			defaultPaymentID = method.Id
+			break
+		}
+	}
+	renewed, err := client.CreateSubscription(user.CustomerID, subscription.Items.Data[0].Price.ID, defaultPaymentID)
+	if err != nil {
+		return err
+	}
+	user.SubscriptionID = renewed.ID
+	return nil
+}
+
+// EraseCustomer ends a subscription straight away rather than at the end of the period, then
+// deletes the customer along with their saved payment methods. Anything already gone is skipped.
+func EraseCustomer(client Client, subscriptionID, customerID string) error {
+	if subscriptionID != "" {
+		if _, err := client.CancelSubscription(subscriptionID, false); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	if customerID != "" {
+		if err := client.DeleteCustomer(customerID); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}